@@ -2,6 +2,7 @@ package goflume
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,6 +10,13 @@ import (
 	"testing"
 )
 
+// The subscription channel bits the tests use; the API does not document them.
+const (
+	channelPush  = 1
+	channelEmail = 2
+	channelSMS   = 4
+)
+
 func TestGetUser_withMockClient(t *testing.T) {
 	resp := &http.Response{
 		StatusCode: 200,
@@ -153,6 +161,27 @@ func TestUpdateLocation_withMockClient(t *testing.T) {
 	}
 }
 
+func TestUpdateNotification_withMockClient(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{"success":true}`)),
+		Header:     make(http.Header),
+	}
+	client := newMockClient(resp, nil, nil)
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	got, err := client.UpdateNotification(context.Background(), 7, NotificationPatch{Read: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Success {
+		t.Errorf("expected success true, got %+v", got)
+	}
+	if _, err := client.UpdateNotification(context.Background(), 0, NotificationPatch{Read: true}); err == nil {
+		t.Error("expected error for non-positive notificationID")
+	}
+}
+
 func TestGetBudgets_withMockClient(t *testing.T) {
 	resp := &http.Response{
 		StatusCode: 200,
@@ -1277,3 +1306,205 @@ func TestQueryUsage_urlParseError(t *testing.T) {
 		t.Errorf("expected url.Parse error, got: %v", err)
 	}
 }
+
+func TestGetSubscriptionByID_withMockClient(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{"data":{"id":7,"alert_type":"budget"}}`)),
+		Header:     make(http.Header),
+	}
+	var capturedPath string
+	client := newMockClient(resp, nil, func(req *http.Request) { capturedPath = req.URL.Path })
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	got, err := client.GetSubscriptionByID(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Data.ID != 7 || capturedPath != "/users/1/subscriptions/7" {
+		t.Errorf("unexpected subscription data: %+v (path %s)", got.Data, capturedPath)
+	}
+}
+
+func TestCreateSubscription_withMockClient(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{"success":true,"data":{"id":3,"alert_type":"low_battery","device_id":"d1","notification_types":3}}`)),
+		Header:     make(http.Header),
+	}
+	var gotMethod string
+	var gotBody map[string]any
+	client := newMockClient(resp, nil, func(req *http.Request) {
+		gotMethod = req.Method
+		_ = json.NewDecoder(req.Body).Decode(&gotBody)
+	})
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	got, err := client.CreateSubscription(context.Background(), SubscriptionParams{
+		AlertType:         "low_battery",
+		DeviceID:          "d1",
+		NotificationTypes: channelPush | channelEmail,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotMethod != "POST" || gotBody["alert_type"] != "low_battery" || gotBody["notification_types"] != float64(3) {
+		t.Errorf("unexpected request: %s %+v", gotMethod, gotBody)
+	}
+	if got.Data.ID != 3 || !NotificationChannel(got.Data.NotificationTypes).Has(channelEmail) {
+		t.Errorf("unexpected subscription data: %+v", got.Data)
+	}
+}
+
+func TestCreateSubscription_missingFields(t *testing.T) {
+	client := newMockClient(nil, nil, nil)
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	_, err := client.CreateSubscription(context.Background(), SubscriptionParams{DeviceID: "d1"})
+	if err == nil || !strings.Contains(err.Error(), "alertType cannot be empty") {
+		t.Errorf("expected error for empty alertType, got %v", err)
+	}
+	_, err = client.CreateSubscription(context.Background(), SubscriptionParams{AlertType: "budget"})
+	if err == nil || !strings.Contains(err.Error(), "deviceID cannot be empty") {
+		t.Errorf("expected error for empty deviceID, got %v", err)
+	}
+}
+
+func TestUpdateSubscription_withMockClient(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{"success":true,"data":{"id":3,"notification_types":4}}`)),
+		Header:     make(http.Header),
+	}
+	var gotMethod, gotPath string
+	var gotBody map[string]any
+	client := newMockClient(resp, nil, func(req *http.Request) {
+		gotMethod, gotPath = req.Method, req.URL.Path
+		_ = json.NewDecoder(req.Body).Decode(&gotBody)
+	})
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	channels := NotificationChannel(channelSMS)
+	got, err := client.UpdateSubscription(context.Background(), 3, SubscriptionPatch{NotificationTypes: &channels})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotMethod != "PATCH" || gotPath != "/users/1/subscriptions/3" {
+		t.Errorf("unexpected request: %s %s", gotMethod, gotPath)
+	}
+	if _, ok := gotBody["alert_info"]; ok || gotBody["notification_types"] != float64(4) {
+		t.Errorf("unexpected patch body: %+v", gotBody)
+	}
+	if got.Data.NotificationTypes != channelSMS {
+		t.Errorf("unexpected subscription data: %+v", got.Data)
+	}
+}
+
+func TestDeleteSubscription_withMockClient(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{"success":true}`)),
+		Header:     make(http.Header),
+	}
+	var gotMethod string
+	client := newMockClient(resp, nil, func(req *http.Request) { gotMethod = req.Method })
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	got, err := client.DeleteSubscription(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotMethod != "DELETE" || !got.Success {
+		t.Errorf("unexpected response: %s %+v", gotMethod, got)
+	}
+}
+
+func TestUpdateDeleteSubscription_invalidID(t *testing.T) {
+	client := newMockClient(nil, nil, nil)
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	if _, err := client.UpdateSubscription(context.Background(), 0, SubscriptionPatch{}); err == nil {
+		t.Error("expected error for invalid subscriptionID, got nil")
+	}
+	if _, err := client.DeleteSubscription(context.Background(), -1); err == nil {
+		t.Error("expected error for invalid subscriptionID, got nil")
+	}
+}
+
+type sequenceRoundTripper struct {
+	bodies   []string
+	requests []*http.Request
+	payloads []string
+}
+
+func (s *sequenceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var payload []byte
+	if req.Body != nil {
+		payload, _ = io.ReadAll(req.Body)
+	}
+	s.requests = append(s.requests, req)
+	s.payloads = append(s.payloads, string(payload))
+	if len(s.bodies) == 0 {
+		return nil, fmt.Errorf("unexpected request %s %s", req.Method, req.URL)
+	}
+	body := s.bodies[0]
+	s.bodies = s.bodies[1:]
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+}
+
+func TestEnsureDeviceSubscriptions(t *testing.T) {
+	rt := &sequenceRoundTripper{bodies: []string{
+		`{"data":[{"id":1,"alert_type":"usage_alert","device_id":"d1","notification_types":1},{"id":2,"alert_type":"budget","device_id":"d1","notification_types":3}]}`,
+		`{"data":{"id":1,"alert_type":"usage_alert","device_id":"d1","notification_types":3}}`,
+		`{"data":{"id":9,"alert_type":"low_battery","device_id":"d1","notification_types":3}}`,
+	}}
+	client := &Client{BaseURL: "http://x", HTTPClient: &http.Client{Transport: rt}, JWT: JWTPayload{UserID: 1}}
+	got, err := client.EnsureDeviceSubscriptions(context.Background(), "d1",
+		[]AlertType{"usage_alert", "budget", "low_battery"},
+		channelPush|channelEmail)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 3 || got[0].ID != 1 || got[1].ID != 2 || got[2].ID != 9 {
+		t.Errorf("unexpected subscriptions: %+v", got)
+	}
+	if len(rt.requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(rt.requests))
+	}
+	if rt.requests[0].Method != "GET" || rt.requests[0].URL.Query().Get("device_id") != "d1" {
+		t.Errorf("unexpected list request: %s %s", rt.requests[0].Method, rt.requests[0].URL)
+	}
+	if rt.requests[1].Method != "PATCH" || rt.requests[1].URL.Path != "/users/1/subscriptions/1" {
+		t.Errorf("unexpected update request: %s %s", rt.requests[1].Method, rt.requests[1].URL)
+	}
+	if rt.requests[2].Method != "POST" || !strings.Contains(rt.payloads[2], `"alert_type":"low_battery"`) {
+		t.Errorf("unexpected create request: %s %s", rt.requests[2].Method, rt.payloads[2])
+	}
+}
+
+func TestEnsureDeviceSubscriptions_deletesDuplicates(t *testing.T) {
+	rt := &sequenceRoundTripper{bodies: []string{
+		`{"data":[{"id":4,"alert_type":"budget","device_id":"d1","notification_types":1},{"id":2,"alert_type":"budget","device_id":"d1","notification_types":1},{"id":5,"alert_type":"low_battery","device_id":"d1","notification_types":1},{"id":6,"alert_type":"low_battery","device_id":"d1","notification_types":1}]}`,
+		`{"success":true}`,
+	}}
+	client := &Client{BaseURL: "http://x", HTTPClient: &http.Client{Transport: rt}, JWT: JWTPayload{UserID: 1}}
+	got, err := client.EnsureDeviceSubscriptions(context.Background(), "d1", []AlertType{"budget"}, channelPush)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("unexpected subscriptions: %+v", got)
+	}
+	// The low_battery duplicates are not wanted, so they are left alone.
+	if len(rt.requests) != 2 || rt.requests[1].Method != "DELETE" || rt.requests[1].URL.Path != "/users/1/subscriptions/4" {
+		t.Errorf("unexpected requests: %v", rt.requests)
+	}
+}
+
+func TestEnsureDeviceSubscriptions_emptyDeviceID(t *testing.T) {
+	client := newMockClient(nil, nil, nil)
+	_, err := client.EnsureDeviceSubscriptions(context.Background(), "", []AlertType{"budget"}, channelPush)
+	if err == nil || !strings.Contains(err.Error(), "deviceID cannot be empty") {
+		t.Errorf("expected error for empty deviceID, got %v", err)
+	}
+}
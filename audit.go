@@ -0,0 +1,163 @@
+package goflume
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry describes one mutating request made by the client.
+type AuditEntry struct {
+	Time        time.Time            `json:"time"`
+	Actor       string               `json:"actor,omitempty"`
+	Method      string               `json:"method"`
+	Endpoint    string               `json:"endpoint"`
+	RequestBody json.RawMessage      `json:"request_body,omitempty"`
+	Status      int                  `json:"status,omitempty"`
+	Envelope    *APIResponseEnvelope `json:"envelope,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	DryRun      bool                 `json:"dry_run,omitempty"`
+}
+
+type AuditSink interface {
+	Record(entry AuditEntry) error
+}
+
+type actorKey struct{}
+
+// WithActor labels every mutating request made with ctx in the audit log.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+const redacted = "[REDACTED]"
+
+var sensitiveKeys = []string{"password", "secret", "token"}
+
+// redactBody replaces credential values anywhere in a JSON request body.
+func redactBody(payload []byte) json.RawMessage {
+	if len(payload) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil
+	}
+	b, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if isSensitiveKey(k) {
+				t[k] = redacted
+			} else {
+				t[k] = redactValue(val)
+			}
+		}
+	case []any:
+		for i, val := range t {
+			t[i] = redactValue(val)
+		}
+	}
+	return v
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONLinesAuditSink appends one JSON object per line to a file.
+type JSONLinesAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewJSONLinesAuditSink(path string) (*JSONLinesAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesAuditSink{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *JSONLinesAuditSink) Record(entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(entry)
+}
+
+func (s *JSONLinesAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// RingAuditSink keeps the most recent entries in memory.
+type RingAuditSink struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	next    int
+	full    bool
+}
+
+func NewRingAuditSink(size int) *RingAuditSink {
+	if size <= 0 {
+		size = 100
+	}
+	return &RingAuditSink{entries: make([]AuditEntry, size)}
+}
+
+func (s *RingAuditSink) Record(entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[s.next] = entry
+	s.next = (s.next + 1) % len(s.entries)
+	if s.next == 0 {
+		s.full = true
+	}
+	return nil
+}
+
+// Entries returns the buffered entries, oldest first.
+func (s *RingAuditSink) Entries() []AuditEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		return append([]AuditEntry(nil), s.entries[:s.next]...)
+	}
+	out := make([]AuditEntry, 0, len(s.entries))
+	out = append(out, s.entries[s.next:]...)
+	return append(out, s.entries[:s.next]...)
+}
+
+func (c *Client) audit(ctx context.Context, entry AuditEntry, payload []byte) {
+	if c.Audit == nil {
+		return
+	}
+	entry.Time = time.Now()
+	entry.Actor = ActorFromContext(ctx)
+	entry.RequestBody = redactBody(payload)
+	// A failing sink must not turn a write that already happened into an error.
+	_ = c.Audit.Record(entry)
+}
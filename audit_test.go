@@ -0,0 +1,173 @@
+package goflume
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAudit_RecordsMutatingRequest(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader(`{"success":true,"code":602,"message":"Request OK"}`)),
+		Header:     make(http.Header),
+	}
+	client := newMockClient(resp, nil, nil)
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	sink := NewRingAuditSink(10)
+	client.Audit = sink
+
+	ctx := WithActor(context.Background(), "away-scheduler")
+	got, err := client.UpdateLocation(ctx, "7", LocationPatch{AwayMode: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Success {
+		t.Errorf("response was not decoded: %+v", got)
+	}
+	entries := sink.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 audit entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Actor != "away-scheduler" || e.Method != "PATCH" || e.Endpoint != "http://x/users/1/locations/7" || e.Status != 200 {
+		t.Errorf("unexpected audit entry: %+v", e)
+	}
+	if e.Envelope == nil || e.Envelope.Code != 602 || string(e.RequestBody) != `{"away_mode":true}` {
+		t.Errorf("unexpected audit envelope or body: %+v %s", e.Envelope, e.RequestBody)
+	}
+	if e.Time.IsZero() {
+		t.Error("expected audit time to be set")
+	}
+}
+
+func TestAudit_SkipsReads(t *testing.T) {
+	resp := &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"data":[]}`)), Header: make(http.Header)}
+	client := newMockClient(resp, nil, nil)
+	client.BaseURL = "http://x"
+	sink := NewRingAuditSink(10)
+	client.Audit = sink
+	if _, err := client.GetDevices(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sink.Entries()) != 0 {
+		t.Errorf("read request was audited: %+v", sink.Entries())
+	}
+}
+
+func TestAudit_RecordsFailures(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 400,
+		Body:       io.NopCloser(strings.NewReader(`{"success":false,"message":"bad"}`)),
+		Header:     make(http.Header),
+	}
+	client := newMockClient(resp, nil, nil)
+	client.BaseURL = "http://x"
+	sink := NewRingAuditSink(10)
+	client.Audit = sink
+	if _, err := client.DeleteSubscription(context.Background(), 1); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	client.HTTPClient = newMockClient(nil, errors.New("network down"), nil).HTTPClient
+	if _, err := client.DeleteSubscription(context.Background(), 1); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	entries := sink.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d", len(entries))
+	}
+	if entries[0].Status != 400 || entries[0].Envelope == nil || entries[0].Envelope.Message != "bad" || entries[0].Error == "" {
+		t.Errorf("unexpected entry for API error: %+v", entries[0])
+	}
+	if !strings.Contains(entries[1].Error, "network down") {
+		t.Errorf("unexpected entry for transport error: %+v", entries[1])
+	}
+}
+
+func TestAudit_DryRun(t *testing.T) {
+	client := newMockClient(nil, errors.New("should not be sent"), nil)
+	client.BaseURL = "http://x"
+	client.DryRun = true
+	sink := NewRingAuditSink(10)
+	client.Audit = sink
+	if _, err := client.UpdateLocation(context.Background(), "7", LocationPatch{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := sink.Entries()
+	if len(entries) != 1 || !entries[0].DryRun || entries[0].Envelope == nil || !entries[0].Envelope.Simulated {
+		t.Errorf("unexpected dry-run audit entries: %+v", entries)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	got := redactBody([]byte(`{"username":"me","password":"p","nested":{"client_secret":"s","list":[{"refresh_token":"t"}]}}`))
+	var m map[string]any
+	if err := json.Unmarshal(got, &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m["username"] != "me" || m["password"] != redacted {
+		t.Errorf("unexpected redaction: %s", got)
+	}
+	if strings.Contains(string(got), `"s"`) || strings.Contains(string(got), `"t"`) {
+		t.Errorf("nested secrets not redacted: %s", got)
+	}
+	if redactBody(nil) != nil || redactBody([]byte("not json")) != nil {
+		t.Error("expected nil for empty or invalid body")
+	}
+}
+
+func TestRingAuditSink_Wraps(t *testing.T) {
+	sink := NewRingAuditSink(3)
+	for _, m := range []string{"a", "b", "c", "d", "e"} {
+		_ = sink.Record(AuditEntry{Method: m})
+	}
+	var got []string
+	for _, e := range sink.Entries() {
+		got = append(got, e.Method)
+	}
+	if strings.Join(got, "") != "cde" {
+		t.Errorf("expected oldest-first cde, got %v", got)
+	}
+}
+
+func TestJSONLinesAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewJSONLinesAuditSink(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = sink.Record(AuditEntry{Method: "PATCH", Actor: "a"})
+	_ = sink.Record(AuditEntry{Method: "DELETE", Actor: "b"})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected 0600 permissions, got %v", info.Mode().Perm())
+	}
+	f, _ := os.Open(path)
+	defer func() { _ = f.Close() }()
+	var lines []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, e)
+	}
+	if len(lines) != 2 || lines[1].Method != "DELETE" || lines[1].Actor != "b" {
+		t.Errorf("unexpected lines: %+v", lines)
+	}
+}
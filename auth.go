@@ -57,6 +57,18 @@ func (c *Client) RefreshAccessToken(ctx context.Context) error {
 	return c.getToken(ctx, url, reqBody)
 }
 
+// SetToken installs a previously issued token, for example one read from a
+// cache, and decodes its JWT claims so user-scoped requests can be built.
+func (c *Client) SetToken(token Token) error {
+	jwt, err := extractJWTPayload(token.AccessToken)
+	if err != nil {
+		return err
+	}
+	c.Token = token
+	c.JWT = *jwt
+	return nil
+}
+
 func (c *Client) getToken(ctx context.Context, url string, reqBody map[string]string) error {
 	body, _ := json.Marshal(reqBody)
 	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
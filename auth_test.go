@@ -253,6 +253,22 @@ func TestGetToken_ExtractJWTPayloadError(t *testing.T) {
 	}
 }
 
+func TestSetToken(t *testing.T) {
+	client := NewClient("id", "secret", nil)
+	if err := client.SetToken(Token{AccessToken: validJWTToken(), RefreshToken: "def"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.JWT.UserID != 1 || client.Token.RefreshToken != "def" {
+		t.Errorf("unexpected client state: %+v %+v", client.JWT, client.Token)
+	}
+	if err := client.SetToken(Token{AccessToken: "notajwt"}); err == nil {
+		t.Error("expected error for invalid token")
+	}
+	if client.Token.RefreshToken != "def" {
+		t.Error("invalid token replaced the current one")
+	}
+}
+
 func validJWTToken() string {
 	// header: {"alg":"HS256","typ":"JWT"}
 	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
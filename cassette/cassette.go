@@ -0,0 +1,307 @@
+// Package cassette records HTTP interactions with the Flume API to a file and
+// replays them later, so tests can run deterministically without network
+// access or credentials.
+//
+//	rec, err := cassette.New("testdata/devices.json", cassette.ModeReplay, nil)
+//	client := goflume.NewClient(id, secret, &http.Client{Transport: rec})
+//	...
+//	err = rec.Close() // writes the cassette in record mode
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Mode int
+
+const (
+	// ModeReplay serves responses from the cassette and never touches the network.
+	ModeReplay Mode = iota
+	// ModeRecord forwards requests to the real transport and records them.
+	ModeRecord
+)
+
+const Scrubbed = "SCRUBBED"
+
+// DefaultIgnoredFields are volatile request fields left out when matching.
+var DefaultIgnoredFields = []string{"request_id", "envelope"}
+
+var secretFields = map[string]bool{
+	"password":      true,
+	"client_secret": true,
+	"client_id":     true,
+	"username":      true,
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+type Request struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Body   string `json:"body,omitempty"`
+}
+
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Recorder struct {
+	// IgnoredFields are dropped from query strings and top-level JSON bodies
+	// before matching. Defaults to DefaultIgnoredFields.
+	IgnoredFields []string
+
+	mode      Mode
+	path      string
+	transport http.RoundTripper
+	mu        sync.Mutex
+	cassette  Cassette
+	used      []bool
+}
+
+// New opens the cassette at path. In replay mode the file must exist; in
+// record mode it is (re)written by Close. A nil transport defaults to
+// http.DefaultTransport.
+func New(path string, mode Mode, transport http.RoundTripper) (*Recorder, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	r := &Recorder{IgnoredFields: DefaultIgnoredFields, mode: mode, path: path, transport: transport}
+	if mode == ModeReplay {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &r.cassette); err != nil {
+			return nil, fmt.Errorf("cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+	return r, nil
+}
+
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.cassette.Interactions...)
+}
+
+// Close writes the recorded interactions in record mode.
+func (r *Recorder) Close() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(b, '\n'), 0o644)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	key := r.normalize(req.Method, req.URL, body)
+	if r.mode == ModeRecord {
+		return r.record(req, key)
+	}
+	return r.replay(req, key)
+}
+
+func (r *Recorder) record(req *http.Request, key Request) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := http.Header{}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		header.Set("Content-Type", ct)
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request:  key,
+		Response: Response{Status: resp.StatusCode, Header: header, Body: string(scrubJSON(body, nil))},
+	})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, key Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || in.Request != key {
+			continue
+		}
+		r.used[i] = true
+		header := in.Response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+			StatusCode:    in.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, r.mismatch(key)
+}
+
+// mismatch explains why no recorded interaction matched.
+func (r *Recorder) mismatch(key Request) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cassette %s: no unused interaction matches %s %s", r.path, key.Method, key.Path)
+	var candidates []int
+	for i, in := range r.cassette.Interactions {
+		if in.Request.Method == key.Method && in.Request.Path == key.Path {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		b.WriteString("\nrecorded requests:")
+		for i, in := range r.cassette.Interactions {
+			fmt.Fprintf(&b, "\n  #%d %s %s", i, in.Request.Method, in.Request.Path)
+			if r.used[i] {
+				b.WriteString(" (used)")
+			}
+		}
+		return fmt.Errorf("%s", b.String())
+	}
+	for _, i := range candidates {
+		in := r.cassette.Interactions[i].Request
+		fmt.Fprintf(&b, "\ncandidate #%d", i)
+		if r.used[i] {
+			b.WriteString(" (already used)")
+		}
+		if in.Query != key.Query {
+			fmt.Fprintf(&b, "\n  query: recorded %q, got %q", in.Query, key.Query)
+		}
+		if in.Body != key.Body {
+			fmt.Fprintf(&b, "\n  body:  recorded %s\n         got      %s", in.Body, key.Body)
+		}
+	}
+	return fmt.Errorf("%s", b.String())
+}
+
+func (r *Recorder) normalize(method string, u *url.URL, body []byte) Request {
+	ignored := map[string]bool{}
+	for _, f := range r.IgnoredFields {
+		ignored[f] = true
+	}
+	q := u.Query()
+	for k := range q {
+		if ignored[k] {
+			q.Del(k)
+		}
+	}
+	return Request{
+		Method: method,
+		Path:   u.Path,
+		Query:  q.Encode(),
+		Body:   string(scrubJSON(body, ignored)),
+	}
+}
+
+// scrubJSON replaces secrets in a JSON document and drops ignored top-level
+// fields. Map keys are re-encoded in sorted order so equal documents compare
+// equal. Non-JSON bodies are returned unchanged.
+func scrubJSON(body []byte, ignored map[string]bool) []byte {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return body
+	}
+	if m, ok := v.(map[string]any); ok {
+		for k := range m {
+			if ignored[k] {
+				delete(m, k)
+			}
+		}
+	}
+	out, err := json.Marshal(scrubValue(v))
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func scrubValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !secretFields[k] {
+				t[k] = scrubValue(t[k])
+				continue
+			}
+			if s, ok := t[k].(string); ok && k == "access_token" {
+				t[k] = scrubJWT(s)
+			} else {
+				t[k] = Scrubbed
+			}
+		}
+	case []any:
+		for i := range t {
+			t[i] = scrubValue(t[i])
+		}
+	}
+	return v
+}
+
+// scrubJWT keeps the claims of an access token, which the client decodes for
+// the user ID, but drops the signature so the token is useless if leaked.
+func scrubJWT(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Scrubbed
+	}
+	return parts[0] + "." + parts[1] + "." + Scrubbed
+}
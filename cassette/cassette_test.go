@@ -0,0 +1,119 @@
+package cassette
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+const (
+	clientID     = "cid-123"
+	clientSecret = "csecret-456"
+	username     = "someone@example.com"
+	password     = "hunter2"
+)
+
+func record(t *testing.T, path string) {
+	t.Helper()
+	srv := flumetest.NewServer(flumetest.WithCredentials(clientID, clientSecret, username, password))
+	defer srv.Close()
+	rec, err := New(path, ModeRecord, srv.Server.Client().Transport)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := goflume.NewClient(clientID, clientSecret, &http.Client{Transport: rec})
+	c.BaseURL = srv.URL
+	ctx := context.Background()
+	if err := c.Authenticate(ctx, username, password); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if _, err := c.GetDevices(ctx, nil); err != nil {
+		t.Fatalf("get devices: %v", err)
+	}
+	device := srv.State().Devices[0].ID
+	if _, err := c.QueryUsage(ctx, device, goflume.QueryUsageRequestBody{RequestID: "abc", Bucket: "DAY", SinceDatetime: "2026-03-01 00:00:00", UntilDatetime: "2026-03-02 00:00:00"}); err != nil {
+		t.Fatalf("query usage: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	record(t, path)
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, secret := range []string{password, clientSecret, clientID, username, "Bearer"} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("cassette contains secret %q", secret)
+		}
+	}
+
+	rec, err := New(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := goflume.NewClient("other-id", "other-secret", &http.Client{Transport: rec})
+	c.BaseURL = "http://replay.invalid"
+	ctx := context.Background()
+	if err := c.Authenticate(ctx, "someone", "else"); err != nil {
+		t.Fatalf("replayed authenticate: %v", err)
+	}
+	if c.JWT.UserID != flumetest.UserID {
+		t.Errorf("expected user ID from scrubbed token, got %d", c.JWT.UserID)
+	}
+	devices, err := c.GetDevices(ctx, nil)
+	if err != nil || len(devices.Data) != 1 {
+		t.Fatalf("replayed devices: %+v %v", devices, err)
+	}
+	// request_id differs from the recording and must be ignored.
+	usage, err := c.QueryUsage(ctx, devices.Data[0].ID, goflume.QueryUsageRequestBody{RequestID: "xyz", Bucket: "DAY", SinceDatetime: "2026-03-01 00:00:00", UntilDatetime: "2026-03-02 00:00:00"})
+	if err != nil || len(usage.Data) != 2 {
+		t.Fatalf("replayed usage: %+v %v", usage, err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	record(t, path)
+	rec, err := New(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := goflume.NewClient("id", "secret", &http.Client{Transport: rec})
+	c.BaseURL = "http://replay.invalid"
+	c.JWT.UserID = flumetest.UserID
+	ctx := context.Background()
+
+	_, err = c.QueryUsage(ctx, flumetest.DefaultState(flumetest.UserID).Devices[0].ID, goflume.QueryUsageRequestBody{Bucket: "HR", SinceDatetime: "2026-03-01 00:00:00"})
+	if err == nil || !strings.Contains(err.Error(), "candidate #2") || !strings.Contains(err.Error(), `"bucket":"DAY"`) {
+		t.Errorf("expected body diff against candidate, got %v", err)
+	}
+
+	if _, err = c.GetLocations(ctx, nil); err == nil || !strings.Contains(err.Error(), "recorded requests:") {
+		t.Errorf("expected list of recorded requests, got %v", err)
+	}
+
+	if _, err := c.GetDevices(ctx, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.GetDevices(ctx, nil); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("expected used interaction error, got %v", err)
+	}
+}
+
+func TestNew_missingCassette(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing.json"), ModeReplay, nil); err == nil {
+		t.Error("expected error for missing cassette")
+	}
+}
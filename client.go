@@ -2,6 +2,8 @@ package goflume
 
 import (
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +16,31 @@ type Client struct {
 	HTTPClient   *http.Client
 	Token        Token
 	JWT          JWTPayload
+
+	// DryRun makes every mutating request get recorded instead of sent. The
+	// call returns a synthetic success whose envelope has Simulated set.
+	DryRun bool
+
+	// Audit, when set, receives an entry for every mutating request.
+	Audit AuditSink
+
+	// recorded holds the *operationLog, created on first use. An
+	// atomic.Value rather than a lock keeps Client values copyable; copies
+	// made after the first dry-run request share the log.
+	recorded atomic.Value
+}
+
+type operationLog struct {
+	mu  sync.Mutex
+	ops []Operation
+}
+
+func (c *Client) operationLog() *operationLog {
+	if l, ok := c.recorded.Load().(*operationLog); ok {
+		return l
+	}
+	c.recorded.CompareAndSwap(nil, &operationLog{})
+	return c.recorded.Load().(*operationLog)
 }
 
 func NewClient(clientID, clientSecret string, httpClient *http.Client) *Client {
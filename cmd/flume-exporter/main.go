@@ -0,0 +1,127 @@
+// Command flume-exporter serves the metrics of a Flume account to
+// Prometheus.
+//
+// It logs in with the credentials in FLUME_CLIENT_ID, FLUME_CLIENT_SECRET,
+// FLUME_USERNAME and FLUME_PASSWORD, refreshes its data in the background
+// and answers scrapes from that cache:
+//
+//	flume-exporter -listen :9724 -flow-interval 1m -slow-interval 15m
+//
+// Set FLUME_BASE_URL to use another API endpoint, for example a fake server.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/metrics"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Getenv, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "flume-exporter:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// config is the parsed command line.
+type config struct {
+	listen   string
+	path     string
+	exporter *metrics.Exporter
+}
+
+func run(ctx context.Context, args []string, getenv func(string) string, stderr io.Writer) error {
+	cfg, err := setup(ctx, args, getenv, stderr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", cfg.listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: cfg.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = cfg.exporter.Run(ctx) }()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	fmt.Fprintf(stderr, "flume-exporter: serving %s on %s\n", cfg.path, ln.Addr())
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// setup parses the flags and logs in.
+func setup(ctx context.Context, args []string, getenv func(string) string, stderr io.Writer) (*config, error) {
+	fs := flag.NewFlagSet("flume-exporter", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg := &config{}
+	fs.StringVar(&cfg.listen, "listen", ":9724", "address to serve metrics on")
+	fs.StringVar(&cfg.path, "path", "/metrics", "URL path of the metrics")
+	flowInterval := fs.Duration("flow-interval", time.Minute, "how often to refresh the current flow")
+	slowInterval := fs.Duration("slow-interval", 15*time.Minute, "how often to refresh devices, budgets and usage")
+	quota := fs.Int("quota", goflume.DefaultRequestLimit, "API requests allowed per hour")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	clientID, clientSecret := getenv("FLUME_CLIENT_ID"), getenv("FLUME_CLIENT_SECRET")
+	username, password := getenv("FLUME_USERNAME"), getenv("FLUME_PASSWORD")
+	if clientID == "" || clientSecret == "" || username == "" || password == "" {
+		return nil, errors.New("FLUME_CLIENT_ID, FLUME_CLIENT_SECRET, FLUME_USERNAME and FLUME_PASSWORD must be set")
+	}
+	c := goflume.NewClient(clientID, clientSecret, nil)
+	if u := getenv("FLUME_BASE_URL"); u != "" {
+		c.BaseURL = u
+	}
+	if err := c.Authenticate(ctx, username, password); err != nil {
+		return nil, fmt.Errorf("log in: %w", err)
+	}
+	cfg.exporter = &metrics.Exporter{
+		Client:       c,
+		FlowInterval: *flowInterval,
+		SlowInterval: *slowInterval,
+		Budget:       &goflume.RequestBudget{Limit: *quota},
+		Login: func(ctx context.Context, c *goflume.Client) error {
+			if err := c.RefreshAccessToken(ctx); err == nil {
+				return nil
+			}
+			return c.Authenticate(ctx, username, password)
+		},
+	}
+	return cfg, nil
+}
+
+func (cfg *config) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.path, cfg.exporter)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "<html><body><h1>Flume exporter</h1><p><a href=%q>Metrics</a></p></body></html>\n", cfg.path)
+	})
+	return mux
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+func TestSetup(t *testing.T) {
+	srv := flumetest.NewServer()
+	t.Cleanup(srv.Close)
+	env := map[string]string{
+		"FLUME_CLIENT_ID":     flumetest.ClientID,
+		"FLUME_CLIENT_SECRET": flumetest.ClientSecret,
+		"FLUME_USERNAME":      flumetest.Username,
+		"FLUME_PASSWORD":      flumetest.Password,
+		"FLUME_BASE_URL":      srv.URL,
+	}
+	ctx := context.Background()
+	cfg, err := setup(ctx, []string{"-path", "/probe"}, func(k string) string { return env[k] }, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.exporter.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/probe", nil))
+	if !strings.Contains(rec.Body.String(), `flume_device_connected{device="6248148189204194987"} 1`) {
+		t.Errorf("unexpected metrics:\n%s", rec.Body.String())
+	}
+
+	env["FLUME_PASSWORD"] = ""
+	if _, err := setup(ctx, nil, func(k string) string { return env[k] }, io.Discard); err == nil || !strings.Contains(err.Error(), "FLUME_PASSWORD") {
+		t.Errorf("expected a missing credentials error, got %v", err)
+	}
+	env["FLUME_PASSWORD"] = "wrong"
+	if _, err := setup(ctx, nil, func(k string) string { return env[k] }, io.Discard); err == nil {
+		t.Error("expected the login to fail")
+	}
+}
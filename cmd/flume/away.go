@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// awayState is persisted next to the session. The API does not say when
+// away mode changed, so the CLI remembers the changes it made itself.
+type awayState struct {
+	Schedules []awaySchedule       `json:"schedules"`
+	Changes   map[string]awayEvent `json:"changes,omitempty"` // keyed by location ID
+	NextID    int                  `json:"next_id"`
+}
+
+type awaySchedule struct {
+	ID         int       `json:"id"`
+	LocationID int       `json:"location_id"`
+	From       time.Time `json:"from"`
+	Until      time.Time `json:"until"`
+	Started    bool      `json:"started"`
+}
+
+type awayEvent struct {
+	Away bool      `json:"away"`
+	At   time.Time `json:"at"`
+}
+
+func (a *app) awayPath() string {
+	return filepath.Join(a.profileDir(), "away.json")
+}
+
+func (a *app) loadAway() (*awayState, error) {
+	s := &awayState{Changes: map[string]awayEvent{}, NextID: 1}
+	b, err := os.ReadFile(a.awayPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("read %s: %w", a.awayPath(), err)
+	}
+	if s.Changes == nil {
+		s.Changes = map[string]awayEvent{}
+	}
+	return s, nil
+}
+
+// saveAway replaces the state file in one rename, so a concurrent reader
+// never sees it half written.
+func (a *app) saveAway(s *awayState) error {
+	if err := os.MkdirAll(a.profileDir(), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(a.profileDir(), "away-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), a.awayPath())
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// updateAway reads the state again, applies fn and saves it. Commands call
+// it after their API requests rather than saving a copy loaded earlier, so
+// a schedule another flume process saved in the meantime is kept.
+func (a *app) updateAway(fn func(*awayState) error) error {
+	st, err := a.loadAway()
+	if err != nil {
+		return err
+	}
+	if err := fn(st); err != nil {
+		return err
+	}
+	return a.saveAway(st)
+}
+
+func runAway(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if err := a.parseDispatch("away", args); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: expected on, off, status, schedule or run", errUsage)
+	}
+	switch args[0] {
+	case "on", "off":
+		return runAwaySet(ctx, a, args[0] == "on", args[1:])
+	case "status":
+		return runAwayStatus(ctx, a, args[1:])
+	case "schedule":
+		return runAwaySchedule(ctx, a, args[1:])
+	case "run":
+		return runAwayRun(ctx, a, args[1:])
+	}
+	return fmt.Errorf("%w: unknown away command %q", errUsage, args[0])
+}
+
+// resolveLocation finds a location by ID or case-insensitive name, or the
+// only location when ref is empty.
+func resolveLocation(ctx context.Context, c *goflume.Client, ref string) (goflume.Location, error) {
+	resp, err := c.GetLocations(ctx, nil)
+	if err != nil {
+		return goflume.Location{}, err
+	}
+	if ref == "" {
+		if len(resp.Data) == 1 {
+			return resp.Data[0], nil
+		}
+		names := make([]string, len(resp.Data))
+		for i, l := range resp.Data {
+			names[i] = fmt.Sprintf("%d (%s)", l.ID, l.Name)
+		}
+		return goflume.Location{}, fmt.Errorf("%w: choose a location with -location: %s", errUsage, strings.Join(names, ", "))
+	}
+	for _, l := range resp.Data {
+		if strconv.Itoa(l.ID) == ref || strings.EqualFold(l.Name, ref) {
+			return l, nil
+		}
+	}
+	return goflume.Location{}, fmt.Errorf("location %q not found", ref)
+}
+
+func locationTZ(l goflume.Location) *time.Location {
+	if loc, err := time.LoadLocation(l.TZ); err == nil && l.TZ != "" {
+		return loc
+	}
+	return time.Local
+}
+
+// setAway updates the location and returns the change to record.
+func (a *app) setAway(ctx context.Context, c *goflume.Client, l goflume.Location, away bool) (awayEvent, error) {
+	if _, err := c.UpdateLocation(ctx, strconv.Itoa(l.ID), goflume.LocationPatch{AwayMode: away}); err != nil {
+		return awayEvent{}, err
+	}
+	return awayEvent{Away: away, At: a.now()}, nil
+}
+
+func runAwaySet(ctx context.Context, a *app, away bool, args []string) error {
+	fs := a.flagSet("away")
+	location := fs.String("location", "", "location ID or name (default the only location)")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	l, err := resolveLocation(ctx, c, *location)
+	if err != nil {
+		return err
+	}
+	ev, err := a.setAway(ctx, c, l, away)
+	if err != nil {
+		return err
+	}
+	err = a.updateAway(func(st *awayState) error {
+		st.Changes[strconv.Itoa(l.ID)] = ev
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	state := "off"
+	if away {
+		state = "on"
+	}
+	fmt.Fprintf(a.stdout, "Away mode %s for %s.\n", state, l.Name)
+	return nil
+}
+
+func runAwayStatus(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("away status")
+	format := outputFlag(fs)
+	location := fs.String("location", "", "only this location ID or name")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetLocations(ctx, nil)
+	if err != nil {
+		return err
+	}
+	st, err := a.loadAway()
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		LocationID int        `json:"location_id"`
+		Name       string     `json:"name"`
+		Away       bool       `json:"away"`
+		Since      *time.Time `json:"since,omitempty"`
+		Scheduled  []string   `json:"scheduled,omitempty"`
+	}
+	var rows []row
+	t := table{header: []string{"ID", "NAME", "AWAY", "SINCE", "SCHEDULED"}}
+	for _, l := range resp.Data {
+		if *location != "" && *location != strconv.Itoa(l.ID) && !strings.EqualFold(*location, l.Name) {
+			continue
+		}
+		tz := locationTZ(l)
+		r := row{LocationID: l.ID, Name: l.Name, Away: l.AwayMode}
+		since := ""
+		// Only trust the recorded change if it still matches the API.
+		if ev, ok := st.Changes[strconv.Itoa(l.ID)]; ok && ev.Away == l.AwayMode {
+			at := ev.At.In(tz)
+			r.Since = &at
+			since = at.Format(datetimeLayout)
+		}
+		for _, s := range st.Schedules {
+			if s.LocationID == l.ID {
+				r.Scheduled = append(r.Scheduled, formatWindow(s, tz))
+			}
+		}
+		rows = append(rows, r)
+		t.add(l.ID, l.Name, l.AwayMode, since, strings.Join(r.Scheduled, "; "))
+	}
+	return render(a.stdout, *format, rows, t)
+}
+
+func formatWindow(s awaySchedule, tz *time.Location) string {
+	return s.From.In(tz).Format("2006-01-02 15:04") + " - " + s.Until.In(tz).Format("2006-01-02 15:04")
+}
+
+func runAwaySchedule(ctx context.Context, a *app, args []string) error {
+	if len(args) > 0 && args[0] == "remove" {
+		return runAwayScheduleRemove(a, args[1:])
+	}
+	if len(args) > 0 && args[0] == "list" {
+		args = args[1:]
+	}
+	fs := a.flagSet("away schedule")
+	format := outputFlag(fs)
+	location := fs.String("location", "", "location ID or name (default the only location)")
+	from := fs.String("from", "", "start of the away period, in the location's time zone")
+	until := fs.String("until", "", "end of the away period, in the location's time zone")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	st, err := a.loadAway()
+	if err != nil {
+		return err
+	}
+
+	if *from == "" && *until == "" {
+		t := table{header: []string{"ID", "LOCATION", "FROM", "UNTIL", "STARTED"}}
+		for _, s := range st.Schedules {
+			t.add(s.ID, s.LocationID, s.From.Format(time.RFC3339), s.Until.Format(time.RFC3339), s.Started)
+		}
+		return render(a.stdout, *format, st.Schedules, t)
+	}
+	if *from == "" || *until == "" {
+		return fmt.Errorf("%w: -from and -until must be given together", errUsage)
+	}
+
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	l, err := resolveLocation(ctx, c, *location)
+	if err != nil {
+		return err
+	}
+	tz := locationTZ(l)
+	start, err := parseDatetime(*from, tz)
+	if err != nil {
+		return err
+	}
+	end, err := parseDatetime(*until, tz)
+	if err != nil {
+		return err
+	}
+	if !end.After(start) {
+		return fmt.Errorf("%w: -until must be after -from", errUsage)
+	}
+	if !end.After(a.now()) {
+		return fmt.Errorf("%w: the away period is already over", errUsage)
+	}
+	s := awaySchedule{LocationID: l.ID, From: start, Until: end}
+	err = a.updateAway(func(st *awayState) error {
+		s.ID = st.NextID
+		st.NextID++
+		st.Schedules = append(st.Schedules, s)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "Scheduled away mode %d for %s: %s (%s).\n", s.ID, l.Name, formatWindow(s, tz), tz)
+	return nil
+}
+
+func runAwayScheduleRemove(a *app, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: expected a schedule ID", errUsage)
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("%w: invalid schedule ID %q", errUsage, args[0])
+	}
+	err = a.updateAway(func(st *awayState) error {
+		for i, s := range st.Schedules {
+			if s.ID == id {
+				st.Schedules = append(st.Schedules[:i], st.Schedules[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("schedule %d not found", id)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "Removed schedule %d.\n", id)
+	return nil
+}
+
+func runAwayRun(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("away run")
+	daemon := fs.Bool("daemon", false, "keep running and apply schedules as they come due")
+	interval := fs.Duration("interval", time.Minute, "how often the daemon checks the schedules")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	for {
+		if err := a.applySchedules(ctx); err != nil {
+			if !*daemon {
+				return err
+			}
+			fmt.Fprintf(a.stderr, "flume away run: %v\n", err)
+		}
+		if !*daemon {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-a.after(*interval):
+		}
+	}
+}
+
+// applySchedules turns away mode on when a window opens and off when it
+// closes. Only those transitions touch the location, so a manual change in
+// the middle of a window is left alone until the window ends, and a window
+// that ends while another one for the same location is open leaves away
+// mode on. Runs with no transition due make no API requests.
+func (a *app) applySchedules(ctx context.Context) error {
+	st, err := a.loadAway()
+	if err != nil {
+		return err
+	}
+	now := a.now()
+	if !slices.ContainsFunc(st.Schedules, func(s awaySchedule) bool { return s.due(now) }) {
+		return nil
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetLocations(ctx, nil)
+	if err != nil {
+		return err
+	}
+	locations := map[int]goflume.Location{}
+	for _, l := range resp.Data {
+		locations[l.ID] = l
+	}
+
+	started := map[int]bool{}
+	ended := map[int]bool{}
+	changes := map[string]awayEvent{}
+	var errs []error
+	for _, s := range st.Schedules {
+		if !s.due(now) {
+			continue
+		}
+		l, ok := locations[s.LocationID]
+		if !ok {
+			errs = append(errs, fmt.Errorf("schedule %d: location %d not found", s.ID, s.LocationID))
+			continue
+		}
+		if s.ended(now) {
+			if s.Started {
+				if other, ok := st.openAt(s, now); ok {
+					fmt.Fprintf(a.stdout, "Schedule %d ended; away mode stays on for %s (schedule %d).\n", s.ID, l.Name, other.ID)
+				} else {
+					ev, err := a.setAway(ctx, c, l, false)
+					if err != nil {
+						errs = append(errs, err)
+						continue
+					}
+					changes[strconv.Itoa(l.ID)] = ev
+					fmt.Fprintf(a.stdout, "Away mode off for %s (schedule %d ended).\n", l.Name, s.ID)
+				}
+			}
+			ended[s.ID] = true
+			continue
+		}
+		ev, err := a.setAway(ctx, c, l, true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		changes[strconv.Itoa(l.ID)] = ev
+		started[s.ID] = true
+		fmt.Fprintf(a.stdout, "Away mode on for %s (schedule %d until %s).\n", l.Name, s.ID, s.Until.In(locationTZ(l)).Format(datetimeLayout))
+	}
+
+	// Only the schedules handled above are rewritten; ones added or removed
+	// by another flume process since loading are left as they are.
+	err = a.updateAway(func(cur *awayState) error {
+		cur.Schedules = slices.DeleteFunc(cur.Schedules, func(s awaySchedule) bool { return ended[s.ID] })
+		for i := range cur.Schedules {
+			if started[cur.Schedules[i].ID] {
+				cur.Schedules[i].Started = true
+			}
+		}
+		maps.Copy(cur.Changes, changes)
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ended reports whether the window is over at now.
+func (s awaySchedule) ended(now time.Time) bool {
+	return !now.Before(s.Until)
+}
+
+// due reports whether the schedule has a transition to apply at now.
+func (s awaySchedule) due(now time.Time) bool {
+	return s.ended(now) || (!s.Started && !now.Before(s.From))
+}
+
+// openAt returns another schedule for the same location whose window
+// contains now.
+func (st *awayState) openAt(s awaySchedule, now time.Time) (awaySchedule, bool) {
+	for _, o := range st.Schedules {
+		if o.ID != s.ID && o.LocationID == s.LocationID && !now.Before(o.From) && !o.ended(now) {
+			return o, true
+		}
+	}
+	return awaySchedule{}, false
+}
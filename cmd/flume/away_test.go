@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+func awayMode(ta *testApp, id int) bool {
+	for _, l := range ta.srv.State().Locations {
+		if l.ID == id {
+			return l.AwayMode
+		}
+	}
+	return false
+}
+
+func TestAway_onOffStatus(t *testing.T) {
+	ta, _ := newWatchApp(t)
+
+	if code, _ := ta.exec("away", "on"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if !awayMode(ta, 1) {
+		t.Fatal("expected away mode on")
+	}
+	code, out := ta.exec("away", "status")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	// 08:00 UTC is 01:00 in the location's time zone.
+	if !strings.Contains(out, "Home  true  2026-09-01 01:00:00") {
+		t.Errorf("unexpected status:\n%s", out)
+	}
+
+	if code, _ := ta.exec("away", "off", "-location", "home"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if awayMode(ta, 1) {
+		t.Error("expected away mode off")
+	}
+	if code, _ := ta.exec("away", "on", "-location", "cabin"); code != 1 {
+		t.Errorf("unknown location exited %d, want 1", code)
+	}
+}
+
+func TestAway_requiresLocationWhenAmbiguous(t *testing.T) {
+	ta, _ := newWatchApp(t)
+	ta.srv.Update(func(s *flumetest.State) {
+		s.Locations = append(s.Locations, goflume.Location{ID: 2, Name: "Cabin", TZ: "America/Denver"})
+	})
+	if code, _ := ta.exec("away", "on"); code != 2 || !strings.Contains(ta.stderr.String(), "2 (Cabin)") {
+		t.Errorf("exit %d, stderr %q", code, ta.stderr)
+	}
+}
+
+func TestAway_schedule(t *testing.T) {
+	ta, clock := newWatchApp(t)
+
+	// Times are in America/Los_Angeles, seven hours behind UTC in September.
+	code, out := ta.exec("away", "schedule", "-from", "2026-09-01 03:00:00", "-until", "2026-09-01 05:00:00")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if !strings.Contains(out, "Scheduled away mode 1 for Home: 2026-09-01 03:00 - 2026-09-01 05:00") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	steps := []struct {
+		at   time.Time
+		away bool
+	}{
+		{time.Date(2026, 9, 1, 9, 0, 0, 0, time.UTC), false},
+		{time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, 9, 1, 11, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC), false},
+	}
+	for _, step := range steps {
+		clock.mu.Lock()
+		clock.t = step.at
+		clock.mu.Unlock()
+		if code, _ := ta.exec("away", "run"); code != 0 {
+			t.Fatalf("%v: exit %d: %s", step.at, code, ta.stderr)
+		}
+		if got := awayMode(ta, 1); got != step.away {
+			t.Errorf("%v: away = %v, want %v", step.at, got, step.away)
+		}
+	}
+
+	st, err := ta.loadAway()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Schedules) != 0 {
+		t.Errorf("expected the finished schedule to be removed, got %+v", st.Schedules)
+	}
+}
+
+func TestAway_scheduleLeavesManualChanges(t *testing.T) {
+	ta, clock := newWatchApp(t)
+	if code, _ := ta.exec("away", "schedule", "-from", "2026-09-01", "-until", "2026-09-03"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if code, _ := ta.exec("away", "schedule", "list"); code != 0 || !strings.Contains(ta.stdout.String(), "2026-09-01T00:00:00-07:00") {
+		t.Fatalf("exit %d: %s", code, ta.stdout)
+	}
+
+	ta.exec("away", "run")
+	ta.exec("away", "off")
+	clock.mu.Lock()
+	clock.t = clock.t.Add(time.Hour)
+	clock.mu.Unlock()
+	ta.exec("away", "run")
+	if awayMode(ta, 1) {
+		t.Error("schedule overrode a manual change inside its window")
+	}
+
+	if code, _ := ta.exec("away", "schedule", "remove", "1"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if code, _ := ta.exec("away", "schedule", "remove", "1"); code != 1 {
+		t.Errorf("removing a missing schedule exited %d, want 1", code)
+	}
+}
+
+func TestAway_scheduleDaemon(t *testing.T) {
+	ta, clock := newWatchApp(t)
+	if code, _ := ta.exec("away", "schedule", "-from", "2026-09-01 01:30:00", "-until", "2026-09-01 02:00:00"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC)
+	ta.after = func(d time.Duration) <-chan time.Time {
+		ch := clock.after(d)
+		if !clock.now().Before(stop) {
+			cancel()
+		}
+		return ch
+	}
+	ta.stdout.Reset()
+	if code := ta.run(ctx, []string{"away", "run", "-daemon", "-interval", "10m"}); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	out := ta.stdout.String()
+	if !strings.Contains(out, "Away mode on for Home (schedule 1 until 2026-09-01 02:00:00)") || !strings.Contains(out, "Away mode off for Home (schedule 1 ended)") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if awayMode(ta, 1) {
+		t.Error("expected away mode off after the window")
+	}
+}
+
+func TestAway_scheduleOverlap(t *testing.T) {
+	ta, clock := newWatchApp(t)
+	for _, window := range [][2]string{{"2026-09-01 03:00:00", "2026-09-01 05:00:00"}, {"2026-09-01 04:00:00", "2026-09-01 06:00:00"}} {
+		if code, _ := ta.exec("away", "schedule", "-from", window[0], "-until", window[1]); code != 0 {
+			t.Fatalf("exit %d: %s", code, ta.stderr)
+		}
+	}
+	steps := []struct {
+		at   time.Time
+		away bool
+	}{
+		{time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, 9, 1, 11, 30, 0, 0, time.UTC), true},
+		{time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC), true},
+		{time.Date(2026, 9, 1, 13, 0, 0, 0, time.UTC), false},
+	}
+	for _, step := range steps {
+		clock.mu.Lock()
+		clock.t = step.at
+		clock.mu.Unlock()
+		if code, _ := ta.exec("away", "run"); code != 0 {
+			t.Fatalf("%v: exit %d: %s", step.at, code, ta.stderr)
+		}
+		if got := awayMode(ta, 1); got != step.away {
+			t.Errorf("%v: away = %v, want %v", step.at, got, step.away)
+		}
+	}
+}
+
+func TestAway_scheduleIdleAndConcurrent(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)}
+	var (
+		mu        sync.Mutex
+		locations int
+		onPatch   func()
+	)
+	ta := newTestApp(t, flumetest.WithClock(clock.now), flumetest.WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			if r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/locations") {
+				locations++
+			}
+			hook := onPatch
+			mu.Unlock()
+			if r.Method == "PATCH" && hook != nil {
+				hook()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	ta.now, ta.after = clock.now, clock.after
+	ta.login(t)
+
+	if code, _ := ta.exec("away", "schedule", "-from", "2026-09-01 03:00:00", "-until", "2026-09-01 05:00:00"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	mu.Lock()
+	locations = 0
+	mu.Unlock()
+	if code, _ := ta.exec("away", "run"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	mu.Lock()
+	if locations != 0 {
+		t.Errorf("run with nothing due listed locations %d times", locations)
+	}
+	// Another flume process adds a schedule while the run is updating the
+	// location.
+	onPatch = func() {
+		st, err := ta.loadAway()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		st.Schedules = append(st.Schedules, awaySchedule{ID: st.NextID, LocationID: 1,
+			From: time.Date(2026, 9, 2, 10, 0, 0, 0, time.UTC), Until: time.Date(2026, 9, 2, 12, 0, 0, 0, time.UTC)})
+		st.NextID++
+		if err := ta.saveAway(st); err != nil {
+			t.Error(err)
+		}
+	}
+	mu.Unlock()
+
+	clock.mu.Lock()
+	clock.t = time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC)
+	clock.mu.Unlock()
+	if code, _ := ta.exec("away", "run"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	st, err := ta.loadAway()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Schedules) != 2 || !st.Schedules[0].Started || st.Schedules[1].ID != 2 || st.Schedules[1].Started || st.NextID != 3 {
+		t.Errorf("unexpected state after a concurrent edit: %+v", st)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// meterDeviceType is the Device.Type of a Flume water sensor, as opposed to
+// its bridge.
+const meterDeviceType = 2
+
+// deviceFlag registers -device, defaulting to FLUME_DEVICE and then the
+// profile's device.
+func deviceFlag(fs *flag.FlagSet, a *app) *string {
+	def := a.getenv("FLUME_DEVICE")
+	if def == "" {
+		def = a.settings.Device
+	}
+	return fs.String("device", def, "device ID, defaults to the only water sensor (env FLUME_DEVICE)")
+}
+
+// resolveDevice returns id, or the account's water sensor when id is empty
+// and there is exactly one.
+func resolveDevice(ctx context.Context, c *goflume.Client, id string) (string, error) {
+	if id != "" {
+		return id, nil
+	}
+	resp, err := c.GetDevices(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	var meters []string
+	for _, d := range resp.Data {
+		if d.Type == meterDeviceType {
+			meters = append(meters, d.ID)
+		}
+	}
+	switch len(meters) {
+	case 0:
+		return "", errors.New("no water sensor found on this account")
+	case 1:
+		return meters[0], nil
+	default:
+		return "", errors.New("several water sensors found, choose one with -device: " + strings.Join(meters, ", "))
+	}
+}
+
+func runWhoami(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("whoami")
+	format := outputFlag(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetUser(ctx)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"ID", "EMAIL", "NAME", "STATUS"}}
+	for _, u := range resp.Data {
+		t.add(u.ID, u.EmailAddress, strings.TrimSpace(u.FirstName+" "+u.LastName), u.Status)
+	}
+	return render(a.stdout, *format, resp.Data, t)
+}
+
+func runDevices(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("devices")
+	format := outputFlag(fs)
+	list := addListFlags(fs)
+	var params goflume.DevicesParams
+	optInt32(fs, &params.LocationID, "location", "only devices at this location ID")
+	optInt32(fs, &params.Type, "type", "only devices of this type (1 bridge, 2 sensor)")
+	optBool(fs, &params.ListShared, "shared", "include devices shared with this account")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	params.Limit, params.Offset, params.SortField, params.SortDirection = list.Limit, list.Offset, list.SortField, list.SortDirection
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetDevices(ctx, &params)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"ID", "TYPE", "LOCATION", "CONNECTED", "BATTERY", "LAST SEEN"}}
+	for _, d := range resp.Data {
+		t.add(d.ID, d.Type, d.LocationID, d.Connected, d.BatteryLevel, d.LastSeen)
+	}
+	return render(a.stdout, *format, resp.Data, t)
+}
+
+func runLocations(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("locations")
+	format := outputFlag(fs)
+	list := addListFlags(fs)
+	var params goflume.GetLocationsParams
+	optBool(fs, &params.ListShared, "shared", "include locations shared with this account")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	params.Limit, params.Offset, params.SortField, params.SortDirection = list.Limit, list.Offset, list.SortField, list.SortDirection
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetLocations(ctx, &params)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"ID", "NAME", "CITY", "TZ", "AWAY"}}
+	for _, l := range resp.Data {
+		t.add(l.ID, l.Name, l.City, l.TZ, l.AwayMode)
+	}
+	return render(a.stdout, *format, resp.Data, t)
+}
+
+func runBudgets(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("budgets")
+	format := outputFlag(fs)
+	device := deviceFlag(fs, a)
+	list := addListFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	id, err := resolveDevice(ctx, c, *device)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetBudgets(ctx, id, &goflume.GetBudgetsParams{
+		Limit: list.Limit, Offset: list.Offset, SortField: list.SortField, SortDirection: list.SortDirection,
+	})
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"ID", "NAME", "TYPE", "VALUE", "ACTUAL", "THRESHOLDS"}}
+	for _, b := range resp.Data {
+		t.add(b.ID, b.Name, b.Type, b.Value, b.Actual, joinInts(b.Thresholds))
+	}
+	return render(a.stdout, *format, resp.Data, t)
+}
+
+func runAlerts(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("alerts")
+	format := outputFlag(fs)
+	list := addListFlags(fs)
+	var params goflume.GetUsageAlertsParams
+	optString(fs, &params.DeviceID, "device", "only alerts from this device ID")
+	optBool(fs, &params.FlumeLeak, "leak", "only alerts Flume classified as leaks")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	params.Limit, params.Offset, params.SortField, params.SortDirection = list.Limit, list.Offset, list.SortField, list.SortDirection
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetUsageAlerts(ctx, &params)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"ID", "DEVICE", "TRIGGERED", "RULE", "LEAK"}}
+	for _, u := range resp.Data {
+		t.add(u.ID, u.DeviceID, u.TriggeredDatetime, u.EventRuleName, u.FlumeLeak)
+	}
+	return render(a.stdout, *format, resp.Data, t)
+}
+
+func runNotifications(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("notifications")
+	format := outputFlag(fs)
+	list := addListFlags(fs)
+	var params goflume.GetNotificationsParams
+	optString(fs, &params.DeviceID, "device", "only notifications from this device ID")
+	optInt32(fs, &params.LocationID, "location", "only notifications for this location ID")
+	optInt32(fs, &params.Type, "type", "only notifications of this type")
+	unread := fs.Bool("unread", false, "only unread notifications")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	params.Limit, params.Offset, params.SortField, params.SortDirection = list.Limit, list.Offset, list.SortField, list.SortDirection
+	if *unread {
+		read := false
+		params.Read = &read
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetNotifications(ctx, &params)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"ID", "CREATED", "TYPE", "READ", "TITLE", "MESSAGE"}}
+	for _, n := range resp.Data {
+		t.add(n.ID, n.CreatedDatetime, n.Type, n.Read, n.Title, n.Message)
+	}
+	return render(a.stdout, *format, resp.Data, t)
+}
+
+// ruleRow is the JSON shape of "flume rules", which merges both rule kinds.
+type ruleRow struct {
+	Kind           string                  `json:"kind"`
+	EventRule      *goflume.EventRule      `json:"event_rule,omitempty"`
+	UsageAlertRule *goflume.UsageAlertRule `json:"usage_alert_rule,omitempty"`
+}
+
+func runRules(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("rules")
+	format := outputFlag(fs)
+	device := deviceFlag(fs, a)
+	kind := fs.String("kind", "all", "rule `kind`: event, usage or all")
+	rule := fs.String("rule", "", "show only the usage alert rule with this ID")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *kind != "all" && *kind != "event" && *kind != "usage" {
+		return fmt.Errorf("%w: -kind must be event, usage or all", errUsage)
+	}
+	if *rule != "" {
+		if *kind == "event" {
+			return fmt.Errorf("%w: -rule selects a usage alert rule, not an event rule", errUsage)
+		}
+		*kind = "usage"
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	id, err := resolveDevice(ctx, c, *device)
+	if err != nil {
+		return err
+	}
+
+	var rows []ruleRow
+	t := table{header: []string{"KIND", "ID", "NAME", "ENABLED", "CONDITION"}}
+	if *kind != "usage" {
+		resp, err := c.GetEventRules(ctx, id, nil)
+		if err != nil {
+			return err
+		}
+		for i, r := range resp.Data {
+			rows = append(rows, ruleRow{Kind: "event", EventRule: &resp.Data[i]})
+			t.add("event", r.ID, r.Name, r.Active, formatFlowCondition(r))
+		}
+	}
+	if *kind != "event" {
+		var resp *goflume.UsageAlertRulesResponse
+		if *rule != "" {
+			one, err := c.GetUsageAlertRule(ctx, id, *rule)
+			if err != nil {
+				return err
+			}
+			resp = &goflume.UsageAlertRulesResponse{APIResponseEnvelope: one.APIResponseEnvelope, Data: one.Data}
+		} else if resp, err = c.GetUsageAlertRules(ctx, id, nil); err != nil {
+			return err
+		}
+		for i, r := range resp.Data {
+			rows = append(rows, ruleRow{Kind: "usage", UsageAlertRule: &resp.Data[i]})
+			t.add("usage", r.ID, r.Name, r.Enabled, formatThreshold(r))
+		}
+	}
+	return render(a.stdout, *format, rows, t)
+}
+
+func runContacts(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("contacts")
+	format := outputFlag(fs)
+	list := addListFlags(fs)
+	var params goflume.GetContactsParams
+	optString(fs, &params.Type, "type", "only contacts of this type")
+	optString(fs, &params.Category, "category", "only contacts in this category")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	params.Limit, params.Offset, params.SortField, params.SortDirection = list.Limit, list.Offset, list.SortField, list.SortDirection
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetContacts(ctx, &params)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"ID", "CATEGORY", "TYPE", "DETAIL"}}
+	for _, ct := range resp.Data {
+		t.add(ct.ID, ct.Category, ct.Type, ct.Detail)
+	}
+	return render(a.stdout, *format, resp.Data, t)
+}
+
+func joinInts(ns []int) string {
+	parts := make([]string, len(ns))
+	for i, n := range ns {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatFlowCondition(r goflume.EventRule) string {
+	return fmt.Sprintf("> %g gpm for %d min", r.FlowRate, r.Duration)
+}
+
+func formatThreshold(r goflume.UsageAlertRule) string {
+	return fmt.Sprintf("> %g %s", r.Threshold, strings.ToLower(r.Unit))
+}
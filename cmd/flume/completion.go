@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// completionTTL is how long completions reuse cached API results. Override
+// with FLUME_COMPLETION_TTL.
+const completionTTL = 10 * time.Minute
+
+// completionTimeout bounds the API calls made while the user waits on Tab.
+const completionTimeout = 5 * time.Second
+
+// subcommands are offered after the commands that take one.
+var subcommands = map[string][]string{
+	"flow":       {"watch"},
+	"away":       {"on", "off", "status", "schedule", "run"},
+	"profiles":   {"list", "add", "remove", "use"},
+	"completion": {"bash", "zsh", "fish"},
+}
+
+// completionItem is one candidate with a description for shells that show
+// them.
+type completionItem struct {
+	Value       string `json:"value"`
+	Description string `json:"description"`
+	Sensor      bool   `json:"sensor,omitempty"` // a device that is a water sensor
+}
+
+type cachedItems struct {
+	FetchedAt time.Time        `json:"fetched_at"`
+	Items     []completionItem `json:"items"`
+}
+
+// completionCache is stored per profile so completing never mixes accounts.
+type completionCache struct {
+	Devices   *cachedItems            `json:"devices,omitempty"`
+	Locations *cachedItems            `json:"locations,omitempty"`
+	Rules     map[string]*cachedItems `json:"rules,omitempty"` // by device ID
+}
+
+func runCompletion(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if err := a.parseDispatch("completion", args); err != nil {
+			return err
+		}
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("%w: expected bash, zsh or fish", errUsage)
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("%w: unsupported shell %q, use bash, zsh or fish", errUsage, args[0])
+	}
+	fmt.Fprint(a.stdout, script)
+	return nil
+}
+
+// runComplete backs the completion scripts. It prints one "value<TAB>
+// description" line per candidate and never fails loudly: a completion that
+// cannot reach the API falls back to stale cached results or prints nothing.
+func runComplete(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	var items []completionItem
+	switch kind, rest := args[0], args[1:]; kind {
+	case "commands":
+		for name, cmd := range commands {
+			if cmd.summary != "" {
+				items = append(items, completionItem{Value: name, Description: cmd.summary})
+			}
+		}
+	case "subcommands":
+		if len(rest) > 0 {
+			for _, s := range subcommands[rest[0]] {
+				items = append(items, completionItem{Value: s})
+			}
+		}
+	case "flags":
+		items = a.completeFlags(ctx, rest)
+	case "profiles":
+		if cfg, err := a.loadConfig(); err == nil {
+			for name, p := range cfg.Profiles {
+				items = append(items, completionItem{Value: name, Description: p.Username})
+			}
+		}
+	case "devices", "locations", "rules":
+		device := ""
+		if len(rest) > 0 {
+			device = rest[0]
+		}
+		items = a.completeIDs(ctx, kind, device)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Value < items[j].Value })
+	for _, it := range items {
+		fmt.Fprintf(a.stdout, "%s\t%s\n", it.Value, it.Description)
+	}
+	return nil
+}
+
+// completeFlags lists the flags of a command by running it with -h while
+// flagSet hands each FlagSet to a hook instead of printing usage. Every
+// command parses its flags before doing anything else, and the dispatchers
+// of away, completion and flow send -h to parseDispatch or to their own
+// flags, so nothing runs.
+func (a *app) completeFlags(ctx context.Context, args []string) []completionItem {
+	if len(args) == 0 {
+		return nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok || cmd.summary == "" {
+		return nil
+	}
+	run := []string{"-h"}
+	if len(args) > 1 && slices.Contains(subcommands[args[0]], args[1]) {
+		run = []string{args[1], "-h"}
+	}
+	var items []completionItem
+	saved := *a
+	a.stderr = io.Discard
+	a.flagHook = func(fs *flag.FlagSet) {
+		fs.VisitAll(func(f *flag.Flag) {
+			items = append(items, completionItem{Value: "-" + f.Name, Description: f.Usage})
+		})
+	}
+	_ = cmd.run(ctx, a, run)
+	*a = saved
+	return items
+}
+
+func (a *app) completionCachePath() string {
+	return filepath.Join(a.profileDir(), "completion.json")
+}
+
+func (a *app) completionTTL() time.Duration {
+	if d, err := time.ParseDuration(a.getenv("FLUME_COMPLETION_TTL")); err == nil {
+		return d
+	}
+	return completionTTL
+}
+
+func (a *app) loadCompletionCache() *completionCache {
+	cache := &completionCache{}
+	if b, err := os.ReadFile(a.completionCachePath()); err == nil {
+		_ = json.Unmarshal(b, cache)
+	}
+	if cache.Rules == nil {
+		cache.Rules = map[string]*cachedItems{}
+	}
+	return cache
+}
+
+func (a *app) saveCompletionCache(cache *completionCache) error {
+	if err := os.MkdirAll(a.profileDir(), 0o700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.completionCachePath(), b, 0o600)
+}
+
+// completeIDs returns device, location or usage alert rule IDs, from the
+// cache while it is fresh and from the API otherwise. Rules are those of
+// device, which defaults like -device does.
+func (a *app) completeIDs(ctx context.Context, kind, device string) []completionItem {
+	if kind == "rules" && device == "" {
+		device = firstNonEmpty(a.getenv("FLUME_DEVICE"), a.settings.Device)
+		if device == "" {
+			// The only water sensor, as resolveDevice would choose.
+			var sensors []string
+			for _, it := range a.completeIDs(ctx, "devices", "") {
+				if it.Sensor {
+					sensors = append(sensors, it.Value)
+				}
+			}
+			if len(sensors) != 1 {
+				return nil
+			}
+			device = sensors[0]
+		}
+	}
+	cache := a.loadCompletionCache()
+	pick := func() *cachedItems {
+		switch kind {
+		case "devices":
+			return cache.Devices
+		case "locations":
+			return cache.Locations
+		}
+		return cache.Rules[device]
+	}
+	if c := pick(); c != nil && a.now().Sub(c.FetchedAt) < a.completionTTL() {
+		return c.Items
+	}
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	if a.fillCompletionCache(ctx, cache, kind, device) {
+		_ = a.saveCompletionCache(cache)
+	}
+	// A failed refresh falls back to stale results.
+	if c := pick(); c != nil {
+		return c.Items
+	}
+	return nil
+}
+
+// fillCompletionCache refreshes the cache entries kind depends on and
+// reports whether any changed.
+func (a *app) fillCompletionCache(ctx context.Context, cache *completionCache, kind, device string) bool {
+	c, err := a.client(ctx)
+	if err != nil {
+		return false
+	}
+	now := a.now()
+	if kind == "rules" {
+		resp, err := c.GetUsageAlertRules(ctx, device, nil)
+		if err != nil {
+			return false
+		}
+		entry := &cachedItems{FetchedAt: now}
+		for _, r := range resp.Data {
+			entry.Items = append(entry.Items, completionItem{Value: r.ID, Description: r.Name + ", " + formatThreshold(r)})
+		}
+		cache.Rules[device] = entry
+		return true
+	}
+
+	// Device descriptions name their location, so both lists are fetched
+	// together.
+	locs, err := c.GetLocations(ctx, nil)
+	if err != nil {
+		return false
+	}
+	names := map[int]string{}
+	cache.Locations = &cachedItems{FetchedAt: now}
+	for _, l := range locs.Data {
+		names[l.ID] = l.Name
+		cache.Locations.Items = append(cache.Locations.Items, completionItem{Value: strconv.Itoa(l.ID), Description: l.Name})
+	}
+	devs, err := c.GetDevices(ctx, nil)
+	if err != nil {
+		return true
+	}
+	cache.Devices = &cachedItems{FetchedAt: now}
+	for _, d := range devs.Data {
+		cache.Devices.Items = append(cache.Devices.Items, completionItem{
+			Value:       d.ID,
+			Description: describeDevice(d, names[d.LocationID]),
+			Sensor:      d.Type == meterDeviceType,
+		})
+	}
+	return true
+}
+
+func describeDevice(d goflume.Device, location string) string {
+	kind := "bridge"
+	if d.Type == meterDeviceType {
+		kind = "sensor"
+	}
+	desc := kind
+	if d.Product != "" {
+		desc = d.Product + " " + kind
+	}
+	if location != "" {
+		desc += " at " + location
+	}
+	return desc
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// The scripts find the command, subcommand, -profile and -device on the line
+// and ask "flume __complete" for candidates. Flags are accepted with one or
+// two dashes.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for flume; load with: source <(flume completion bash)
+_flume() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    local cmd= sub= device= i=1
+    local -a global=()
+    while (( i < COMP_CWORD )); do
+        case ${COMP_WORDS[i]} in
+        -profile|--profile) global=(-profile "${COMP_WORDS[i+1]}"); ((i++)) ;;
+        -device|--device) device=${COMP_WORDS[i+1]}; ((i++)) ;;
+        -*) ;;
+        *) if [[ -z $cmd ]]; then cmd=${COMP_WORDS[i]}; elif [[ -z $sub ]]; then sub=${COMP_WORDS[i]}; fi ;;
+        esac
+        ((i++))
+    done
+    local -a args
+    case $prev in
+    -profile|--profile) args=(profiles) ;;
+    -device|--device) args=(devices) ;;
+    -location|--location) args=(locations) ;;
+    -rule|--rule) args=(rules "$device") ;;
+    *)
+        if [[ -z $cmd ]]; then args=(commands)
+        elif [[ $cur == -* ]]; then args=(flags "$cmd" "$sub")
+        elif [[ -z $sub ]]; then args=(subcommands "$cmd")
+        else return
+        fi ;;
+    esac
+    local IFS=$'\n'
+    COMPREPLY=($(compgen -W "$(flume "${global[@]}" __complete "${args[@]}" 2>/dev/null | cut -f1)" -- "$cur"))
+}
+complete -F _flume flume
+`,
+	"zsh": `#compdef flume
+# zsh completion for flume; load with: source <(flume completion zsh)
+_flume() {
+    local cmd= sub= device= prev=${words[CURRENT-1]} cur=${words[CURRENT]} i=2
+    local -a global args items
+    while (( i < CURRENT )); do
+        case ${words[i]} in
+        -profile|--profile) global=(-profile "${words[i+1]}"); ((i++)) ;;
+        -device|--device) device=${words[i+1]}; ((i++)) ;;
+        -*) ;;
+        *) if [[ -z $cmd ]]; then cmd=${words[i]}; elif [[ -z $sub ]]; then sub=${words[i]}; fi ;;
+        esac
+        ((i++))
+    done
+    case $prev in
+    -profile|--profile) args=(profiles) ;;
+    -device|--device) args=(devices) ;;
+    -location|--location) args=(locations) ;;
+    -rule|--rule) args=(rules "$device") ;;
+    *)
+        if [[ -z $cmd ]]; then args=(commands)
+        elif [[ $cur == -* ]]; then args=(flags "$cmd" "$sub")
+        elif [[ -z $sub ]]; then args=(subcommands "$cmd")
+        else return 1
+        fi ;;
+    esac
+    items=("${(@f)$(flume "${global[@]}" __complete "${args[@]}" 2>/dev/null | awk -F'\t' '{gsub(/:/, "\\:", $1); print ($2 == "" ? $1 : $1 ":" $2)}')}")
+    _describe 'flume' items
+}
+compdef _flume flume
+`,
+	"fish": `# fish completion for flume; load with: flume completion fish | source
+function __flume_complete
+    set -l tokens (commandline -opc)
+    set -l cur (commandline -ct)
+    set -l global
+    set -l cmd
+    set -l sub
+    set -l device
+    set -l i 2
+    while test $i -le (count $tokens)
+        switch $tokens[$i]
+            case -profile --profile
+                set i (math $i + 1)
+                set global -profile $tokens[$i]
+            case -device --device
+                set i (math $i + 1)
+                set device $tokens[$i]
+            case '-*'
+            case '*'
+                if test -z "$cmd"
+                    set cmd $tokens[$i]
+                else if test -z "$sub"
+                    set sub $tokens[$i]
+                end
+        end
+        set i (math $i + 1)
+    end
+    set -l args
+    switch $tokens[-1]
+        case -profile --profile
+            set args profiles
+        case -device --device
+            set args devices
+        case -location --location
+            set args locations
+        case -rule --rule
+            set args rules "$device"
+        case '*'
+            if test -z "$cmd"
+                set args commands
+            else if string match -q -- '-*' $cur
+                set args flags $cmd "$sub"
+            else if test -z "$sub"
+                set args subcommands $cmd
+            else
+                return
+            end
+    end
+    flume $global __complete $args 2>/dev/null
+end
+complete -c flume -f -a '(__flume_complete)'
+`,
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+func TestCompletion_scripts(t *testing.T) {
+	ta := newTestApp(t)
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		code, out := ta.exec("completion", shell)
+		if code != 0 || !strings.Contains(out, "__complete") {
+			t.Errorf("%s: exit %d, output %q", shell, code, out)
+			continue
+		}
+		// Check the syntax where the shell is installed.
+		flag := "-n"
+		if shell == "fish" {
+			flag = "--no-execute"
+		}
+		if path, err := exec.LookPath(shell); err == nil {
+			script := filepath.Join(t.TempDir(), "flume."+shell)
+			if err := os.WriteFile(script, []byte(out), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := exec.Command(path, flag, script).CombinedOutput(); err != nil {
+				t.Errorf("%s rejects the script: %v\n%s", shell, err, b)
+			}
+		}
+	}
+	if code, _ := ta.exec("completion", "powershell"); code != 2 {
+		t.Errorf("unknown shell exited %d, want 2", code)
+	}
+	ta.exec("help")
+	if strings.Contains(ta.stderr.String(), "__complete") {
+		t.Error("usage lists the hidden __complete command")
+	}
+}
+
+func TestComplete_static(t *testing.T) {
+	ta := newTestApp(t)
+	for _, tc := range []struct {
+		args    []string
+		want    string
+		notWant string
+	}{
+		{[]string{"commands"}, "dashboard\tshow a live dashboard", "__complete"},
+		{[]string{"subcommands", "away"}, "schedule\t", ""},
+		{[]string{"flags", "usage"}, "-since\t", ""},
+		{[]string{"flags", "flow", "watch"}, "-alert-after\t", ""},
+		{[]string{"flags", "rules", "7d"}, "-rule\t", ""},
+	} {
+		code, out := ta.exec(append([]string{"__complete"}, tc.args...)...)
+		if code != 0 || !strings.Contains(out, tc.want) || (tc.notWant != "" && strings.Contains(out, tc.notWant)) {
+			t.Errorf("%v: exit %d, output %q", tc.args, code, out)
+		}
+	}
+}
+
+func TestDispatchers_help(t *testing.T) {
+	ta := newTestApp(t)
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"away", "-h"}, "flume away on|off|status|schedule|run"},
+		{[]string{"completion", "-h"}, "flume completion bash|zsh|fish"},
+		{[]string{"flow", "-h"}, "flume flow watch [flags]"},
+	} {
+		ta.stderr.Reset()
+		if code, _ := ta.exec(tc.args...); code != 0 || !strings.Contains(ta.stderr.String(), tc.want) {
+			t.Errorf("%v: exit %d, stderr %q", tc.args, code, ta.stderr.String())
+		}
+	}
+	if code, out := ta.exec("__complete", "flags", "away"); code != 0 || out != "" {
+		t.Errorf("away flags: exit %d, output %q", code, out)
+	}
+}
+
+func TestComplete_cachedIDs(t *testing.T) {
+	var requests atomic.Int32
+	var failing atomic.Bool
+	ta := newTestApp(t, flumetest.WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/oauth/token" {
+				requests.Add(1)
+				if failing.Load() {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	ta.srv.Update(func(s *flumetest.State) {
+		s.UsageAlertRules["6248148189204194987"] = []goflume.UsageAlertRule{{ID: "u1", Name: "Daily", Enabled: true, Threshold: 200, Unit: "GALLONS"}}
+	})
+	ta.login(t)
+	now := time.Now()
+	ta.now = func() time.Time { return now }
+	ta.env["FLUME_COMPLETION_TTL"] = "5m"
+
+	_, out := ta.exec("__complete", "devices")
+	if out != "6248148189204194987\tflume2 sensor at Home\n" {
+		t.Errorf("devices = %q", out)
+	}
+	if _, out := ta.exec("__complete", "locations"); out != "1\tHome\n" {
+		t.Errorf("locations = %q", out)
+	}
+	if _, out := ta.exec("__complete", "rules"); out != "u1\tDaily, > 200 gallons\n" {
+		t.Errorf("rules = %q", out)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("made %d requests, want 3 (locations and devices once, rules once)", n)
+	}
+
+	// Past the TTL the API is asked again; when it fails the stale
+	// results are still offered.
+	now = now.Add(10 * time.Minute)
+	failing.Store(true)
+	before := requests.Load()
+	if _, out := ta.exec("__complete", "devices"); !strings.HasPrefix(out, "6248148189204194987\t") {
+		t.Errorf("stale devices = %q", out)
+	}
+	if requests.Load() == before {
+		t.Error("expired cache was not refreshed")
+	}
+}
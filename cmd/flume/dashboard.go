@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// maxShownNotifications is how many unread notifications the dashboard
+// lists; each can be acknowledged with its number key.
+const maxShownNotifications = 9
+
+// budgetBarWidth is the width of the budget progress bars.
+const budgetBarWidth = 20
+
+// dashboard holds the latest data of every panel. A failed refresh keeps the
+// previous data and records the error, so a flaky API shows stale values
+// rather than an empty screen.
+type dashboard struct {
+	days int
+	// budget is shared with the flow watcher; requests it does not grant
+	// leave their panel stale. Without a budget every request is made.
+	budget *goflume.RequestBudget
+
+	devices    []goflume.Device
+	devicesErr error
+	meters     []*meterPanel
+
+	notes    []goflume.Notification
+	notesErr error
+
+	slowAt  time.Time // last refresh of devices, usage and budgets
+	message string    // result of the last keypress
+}
+
+// errQuotaUsed marks a panel that was not refreshed because the request
+// quota is used up.
+var errQuotaUsed = errors.New("request quota used up")
+
+// meterPanel is the data shown for one water sensor.
+type meterPanel struct {
+	flow       flowEvent
+	loc        *time.Location
+	today      int
+	average    float64
+	usageErr   error
+	budgets    []goflume.Budget
+	budgetsErr error
+}
+
+func runDashboard(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("dashboard")
+	quota := fs.Int("quota", 120, "requests per hour the dashboard may spend")
+	minInterval := fs.Duration("min-interval", 30*time.Second, "shortest refresh interval")
+	slowInterval := fs.Duration("slow-interval", 10*time.Minute, "how often to refresh devices, usage and budgets")
+	days := fs.Int("days", 7, "days in the trailing usage average")
+	count := fs.Int("count", 0, "stop after this many refreshes (default run until q or Ctrl-C)")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *quota < 1 || *days < 1 {
+		return fmt.Errorf("%w: -quota and -days must be positive", errUsage)
+	}
+
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	// The requests made before the first round count against the quota too.
+	d := &dashboard{days: *days, budget: &goflume.RequestBudget{Limit: *quota, Now: a.now}}
+	d.refreshDevices(ctx, c)
+	if d.devicesErr != nil {
+		return d.devicesErr
+	}
+	if len(d.meters) == 0 {
+		return errors.New("no water sensor found on this account")
+	}
+	d.budget.Spend(d.locateMeters(ctx, c))
+
+	redraw := isTerminal(a.stdout)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keys, restore := a.keypresses(ctx)
+	defer restore()
+
+	// The flow panels follow a FlowWatcher, which is started again when the
+	// slow refresh finds a different set of sensors. Every round of the
+	// watcher also refreshes the other panels.
+	var stream <-chan goflume.FlowEvent
+	stopWatch := func() {}
+	defer func() { stopWatch() }()
+	watch := func() error {
+		wctx, stop := context.WithCancel(ctx)
+		w := &goflume.FlowWatcher{
+			Client:         c,
+			DeviceIDs:      d.meterIDs(),
+			ActiveInterval: *minInterval,
+			IdleInterval:   *minInterval,
+			Budget:         d.budget,
+			RoundEvents:    true,
+			Now:            a.now,
+			After:          a.after,
+		}
+		s, err := w.Watch(wctx)
+		if err != nil {
+			stop()
+			return err
+		}
+		stopWatch()
+		stream, stopWatch = s, stop
+		return nil
+	}
+	if err := watch(); err != nil {
+		return err
+	}
+
+	var interval time.Duration
+	refresh := func(now time.Time) {
+		if now.Sub(d.slowAt) >= *slowInterval {
+			ids := d.meterIDs()
+			d.refreshSlow(ctx, c, now)
+			if len(d.meters) > 0 && !slices.Equal(ids, d.meterIDs()) {
+				if err := watch(); err != nil {
+					d.message = "Watching the new sensors failed: " + err.Error()
+				}
+			}
+		}
+		d.refreshNotifications(ctx, c)
+		d.draw(a.stdout, now, interval, redraw)
+	}
+
+	for round := 0; ; {
+		select {
+		case <-ctx.Done():
+			return nil
+		case fe, ok := <-stream:
+			if !ok {
+				return nil
+			}
+			if fe.Type != goflume.RoundDone {
+				if m := d.meter(fe.DeviceID); m != nil {
+					m.flow.apply(fe)
+				}
+				continue
+			}
+			round++
+			interval = fe.Next
+			refresh(fe.Time)
+			if *count > 0 && round >= *count {
+				return nil
+			}
+		case k, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+			switch k {
+			case 'q', 'Q':
+				return nil
+			case 'r', 'R':
+				d.slowAt = time.Time{}
+				refresh(a.now())
+				continue
+			}
+			if sent := d.key(ctx, c, k); sent > 0 {
+				d.budget.Spend(sent)
+				d.draw(a.stdout, a.now(), interval, redraw)
+			}
+		}
+	}
+}
+
+// reserve takes one request from the budget and reports whether it was
+// granted.
+func (d *dashboard) reserve() bool {
+	if d.budget == nil {
+		return true
+	}
+	_, ok := d.budget.TryReserve(1)
+	return ok
+}
+
+func (d *dashboard) meterIDs() []string {
+	ids := make([]string, len(d.meters))
+	for i, m := range d.meters {
+		ids[i] = m.flow.id
+	}
+	return ids
+}
+
+func (d *dashboard) meter(id string) *meterPanel {
+	for _, m := range d.meters {
+		if m.flow.id == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// refreshDevices reloads the device list and keeps one panel per water
+// sensor, preserving the flow history of sensors already shown.
+func (d *dashboard) refreshDevices(ctx context.Context, c *goflume.Client) {
+	if !d.reserve() {
+		d.devicesErr = errQuotaUsed
+		return
+	}
+	resp, err := c.GetDevices(ctx, nil)
+	if err != nil {
+		d.devicesErr = err
+		return
+	}
+	d.devices, d.devicesErr = resp.Data, nil
+	old := map[string]*meterPanel{}
+	for _, m := range d.meters {
+		old[m.flow.id] = m
+	}
+	d.meters = d.meters[:0]
+	for _, dev := range resp.Data {
+		if dev.Type != meterDeviceType {
+			continue
+		}
+		m, ok := old[dev.ID]
+		if !ok {
+			m = &meterPanel{flow: flowEvent{id: dev.ID}, loc: time.Local}
+		}
+		d.meters = append(d.meters, m)
+	}
+}
+
+// locateMeters sets the time zone of every sensor from its location, which
+// the device list already names, and returns the number of requests made.
+// Sensors sharing a location cost one request.
+func (d *dashboard) locateMeters(ctx context.Context, c *goflume.Client) int {
+	locationIDs := map[string]int{}
+	for _, dev := range d.devices {
+		locationIDs[dev.ID] = dev.LocationID
+	}
+	zones := map[int]*time.Location{}
+	for _, m := range d.meters {
+		id := locationIDs[m.flow.id]
+		loc, ok := zones[id]
+		if !ok {
+			loc = time.Local
+			if l, err := c.GetLocation(ctx, strconv.Itoa(id)); err == nil && len(l.Data) > 0 {
+				if tz, err := time.LoadLocation(l.Data[0].TZ); err == nil {
+					loc = tz
+				}
+			}
+			zones[id] = loc
+		}
+		m.loc = loc
+	}
+	return len(zones)
+}
+
+// refreshSlow reloads devices, usage and budgets.
+func (d *dashboard) refreshSlow(ctx context.Context, c *goflume.Client, now time.Time) {
+	d.refreshDevices(ctx, c)
+	for _, m := range d.meters {
+		if d.reserve() {
+			m.refreshUsage(ctx, c, now, d.days)
+		} else {
+			m.usageErr = errQuotaUsed
+		}
+		if !d.reserve() {
+			m.budgetsErr = errQuotaUsed
+			continue
+		}
+		resp, err := c.GetBudgets(ctx, m.flow.id, nil)
+		if err != nil {
+			m.budgetsErr = err
+		} else {
+			m.budgets, m.budgetsErr = resp.Data, nil
+		}
+	}
+	d.slowAt = now
+}
+
+// refreshUsage fetches daily totals for today and the trailing days.
+func (m *meterPanel) refreshUsage(ctx context.Context, c *goflume.Client, now time.Time, days int) {
+	local := now.In(m.loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, m.loc)
+	resp, err := c.QueryUsage(ctx, m.flow.id, goflume.QueryUsageRequestBody{
+		RequestID:     "dashboard",
+		Bucket:        "DAY",
+		SinceDatetime: today.AddDate(0, 0, -days).Format(datetimeLayout),
+		UntilDatetime: local.Format(datetimeLayout),
+		Units:         "GALLONS",
+	})
+	if err != nil {
+		m.usageErr = err
+		return
+	}
+	if len(resp.Data) == 0 {
+		m.usageErr = errors.New("no usage data")
+		return
+	}
+	readings := resp.Data
+	m.today, m.average, m.usageErr = readings[len(readings)-1].Value, 0, nil
+	if past := readings[:len(readings)-1]; len(past) > 0 {
+		total := 0
+		for _, r := range past {
+			total += r.Value
+		}
+		m.average = float64(total) / float64(len(past))
+	}
+}
+
+func (d *dashboard) refreshNotifications(ctx context.Context, c *goflume.Client) {
+	if !d.reserve() {
+		d.notesErr = errQuotaUsed
+		return
+	}
+	unread, limit := false, int32(maxShownNotifications)
+	field, direction := "created_datetime", "DESC"
+	resp, err := c.GetNotifications(ctx, &goflume.GetNotificationsParams{
+		Read: &unread, Limit: &limit, SortField: &field, SortDirection: &direction,
+	})
+	if err != nil {
+		d.notesErr = err
+		return
+	}
+	d.notes, d.notesErr = resp.Data, nil
+}
+
+// key handles a keypress other than quit and refresh and returns the number
+// of requests made; the screen is drawn again when there were any.
+func (d *dashboard) key(ctx context.Context, c *goflume.Client, k byte) int {
+	var ack []goflume.Notification
+	switch {
+	case k >= '1' && k <= '9':
+		i := int(k - '1')
+		if i >= len(d.notes) {
+			return 0
+		}
+		ack = d.notes[i : i+1]
+	case k == 'a' || k == 'A':
+		ack = d.notes
+	default:
+		return 0
+	}
+	if len(ack) == 0 {
+		return 0
+	}
+	var errs []error
+	done := map[int]bool{}
+	for _, n := range ack {
+		if _, err := c.UpdateNotification(ctx, n.ID, goflume.NotificationPatch{Read: true}); err != nil {
+			errs = append(errs, fmt.Errorf("notification %d: %w", n.ID, err))
+			continue
+		}
+		done[n.ID] = true
+	}
+	kept := d.notes[:0]
+	for _, n := range d.notes {
+		if !done[n.ID] {
+			kept = append(kept, n)
+		}
+	}
+	d.notes = kept
+	if err := errors.Join(errs...); err != nil {
+		d.message = "Acknowledge failed: " + err.Error()
+	} else {
+		d.message = fmt.Sprintf("Acknowledged %d notification(s).", len(done))
+	}
+	return len(ack)
+}
+
+func (d *dashboard) draw(w io.Writer, now time.Time, interval time.Duration, redraw bool) {
+	if redraw {
+		fmt.Fprint(w, "\x1b[H\x1b[2J")
+	} else {
+		fmt.Fprintln(w, strings.Repeat("-", 60))
+	}
+	fmt.Fprintf(w, "Flume dashboard  %s  (next refresh in %s)\n", now.Format("15:04:05"), interval.Round(time.Second))
+	fmt.Fprintln(w, "Keys: 1-9 acknowledge notification, a acknowledge all, r refresh, q quit")
+
+	fmt.Fprintf(w, "\nDevices%s\n", stale(d.devicesErr))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTYPE\tCONNECTED\tBATTERY\tLAST SEEN")
+	for _, dev := range d.devices {
+		kind := "bridge"
+		if dev.Type == meterDeviceType {
+			kind = "sensor"
+		}
+		connected := "yes"
+		if !dev.Connected {
+			connected = "NO"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", dev.ID, kind, connected, dev.BatteryLevel, dev.LastSeen)
+	}
+	_ = tw.Flush()
+
+	for _, m := range d.meters {
+		fmt.Fprintf(w, "\nSensor %s\n", m.flow.id)
+		switch {
+		case m.flow.err != nil:
+			fmt.Fprintf(w, "  Flow:   unreachable: %v\n", m.flow.err)
+		case m.flow.flow.Active:
+			fmt.Fprintf(w, "  Flow:   %.2f gpm for %s, %.1f gal  %s\n", m.flow.flow.GPM,
+				m.flow.duration(now).Round(time.Second), m.flow.gallons, sparkFloats(m.flow.history))
+		default:
+			fmt.Fprintf(w, "  Flow:   idle  %s\n", sparkFloats(m.flow.history))
+		}
+		fmt.Fprintf(w, "  Today:  %d gal so far, %d-day average %.0f gal/day%s\n", m.today, d.days, m.average, stale(m.usageErr))
+		if m.budgetsErr != nil {
+			fmt.Fprintf(w, "  Budgets%s\n", stale(m.budgetsErr))
+		}
+		for _, b := range m.budgets {
+			fmt.Fprintf(w, "  %-16s %s %3d%%  %d/%d gal\n", b.Name, progressBar(b.Actual, b.Value, budgetBarWidth),
+				percent(b.Actual, b.Value), b.Actual, b.Value)
+		}
+	}
+
+	fmt.Fprintf(w, "\nUnread notifications%s\n", stale(d.notesErr))
+	if len(d.notes) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for i, n := range d.notes {
+		fmt.Fprintf(w, "  %d. %s  %s: %s\n", i+1, n.CreatedDatetime, n.Title, n.Message)
+	}
+	if d.message != "" {
+		fmt.Fprintf(w, "\n%s\n", d.message)
+	}
+}
+
+// stale marks a panel whose last refresh failed.
+func stale(err error) string {
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprintf("  (stale: %v)", err)
+}
+
+func percent(actual, value int) int {
+	if value <= 0 {
+		return 0
+	}
+	return actual * 100 / value
+}
+
+// progressBar draws actual against value, marking an overrun with '!'.
+func progressBar(actual, value, width int) string {
+	filled := width
+	if value > 0 && actual < value {
+		filled = actual * width / value
+	}
+	fill := "#"
+	if value > 0 && actual > value {
+		fill = "!"
+	}
+	return "[" + strings.Repeat(fill, filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// keypresses delivers bytes read from stdin until ctx ends; the channel is
+// closed at end of input. On a terminal it switches to cbreak mode so keys
+// arrive without Enter; the returned function restores the terminal.
+func (a *app) keypresses(ctx context.Context) (<-chan byte, func()) {
+	keys := make(chan byte)
+	restore := func() {}
+	if f, ok := a.stdin.(*os.File); ok && isTerminal(f) {
+		if r := cbreak(f); r != nil {
+			restore = r
+		}
+	}
+	go func() {
+		defer close(keys)
+		r := bufio.NewReader(a.stdin)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			select {
+			case keys <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return keys, restore
+}
+
+// cbreak disables line buffering and echo on the terminal f using stty and
+// returns a function restoring the previous settings, or nil when stty is
+// not available.
+func cbreak(f *os.File) func() {
+	return sttyMode(f, "cbreak", "-echo")
+}
+
+// sttyMode applies stty settings to the terminal f and returns a function
+// restoring the previous ones, or nil when stty is not available.
+func sttyMode(f *os.File, settings ...string) func() {
+	stty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = f
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil
+	}
+	if _, err := stty(settings...); err != nil {
+		return nil
+	}
+	return func() { _, _ = stty(saved) }
+}
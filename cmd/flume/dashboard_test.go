@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+const dashboardDevice = "6248148189204194987"
+
+func newDashboardApp(t *testing.T, opts ...flumetest.Option) (*testApp, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{t: time.Date(2026, 9, 8, 19, 0, 0, 0, time.UTC)} // noon in Los Angeles
+	ta := newTestApp(t, append(opts, flumetest.WithClock(clock.now))...)
+	ta.now, ta.after = clock.now, clock.after
+	ta.login(t)
+	ta.srv.Update(func(s *flumetest.State) {
+		var usage []goflume.UsageQuery
+		for d := 1; d <= 8; d++ {
+			usage = append(usage, goflume.UsageQuery{Value: 100, Datetime: time.Date(2026, 9, d, 8, 0, 0, 0, time.UTC).Format(datetimeLayout)})
+		}
+		s.Usage[dashboardDevice] = usage
+		s.Budgets[dashboardDevice] = []goflume.Budget{{ID: 1, Name: "Monthly", Type: "MONTHLY", Value: 4000, Actual: 1000}}
+		s.Notifications = []goflume.Notification{
+			{ID: 1, DeviceID: dashboardDevice, Title: "Low battery", Message: "Replace soon", CreatedDatetime: "2026-09-07 10:00:00"},
+			{ID: 2, DeviceID: dashboardDevice, Title: "High flow", Message: "Over 5 gpm", CreatedDatetime: "2026-09-08 09:00:00"},
+		}
+	})
+	return ta, clock
+}
+
+func TestDashboard(t *testing.T) {
+	ta, clock := newDashboardApp(t)
+	start := clock.now()
+	ta.srv.SetFlowSource(dashboardDevice, func(now time.Time) goflume.Flow {
+		return goflume.Flow{Active: now.After(start), GPM: 1.5}
+	})
+
+	code, out := ta.exec("dashboard", "-count", "2", "-min-interval", "1m")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	for _, want := range []string{
+		"Today:  100 gal so far, 7-day average 100 gal/day",
+		"Monthly          [#####...............]  25%  1000/4000 gal",
+		"1. 2026-09-08 09:00:00  High flow: Over 5 gpm",
+		"2. 2026-09-07 10:00:00  Low battery: Replace soon",
+		"Flow:   1.50 gpm for 0s",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDashboard_newSensor(t *testing.T) {
+	ta, clock := newDashboardApp(t)
+	ta.srv.SetFlowSource("7", func(time.Time) goflume.Flow { return goflume.Flow{Active: true, GPM: 2} })
+	rounds := 0
+	ta.after = func(d time.Duration) <-chan time.Time {
+		if rounds++; rounds == 1 {
+			ta.srv.Update(func(s *flumetest.State) {
+				s.Devices = append(s.Devices, goflume.Device{ID: "7", Type: 2, LocationID: 1, UserID: 1})
+			})
+		}
+		return clock.after(d)
+	}
+	code, out := ta.exec("dashboard", "-count", "3", "-min-interval", "1m", "-slow-interval", "1m")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if !strings.Contains(out, "Sensor 7\n  Flow:   2.00 gpm") {
+		t.Errorf("expected the new sensor to be watched:\n%s", out)
+	}
+}
+
+func TestDashboard_startupCountsAgainstQuota(t *testing.T) {
+	ta, _ := newDashboardApp(t)
+	// Startup reads the devices and the sensor's location and the first
+	// flow poll uses the last of three requests, so the next poll waits for
+	// the window and the other panels are left stale.
+	code, out := ta.exec("dashboard", "-count", "1", "-quota", "3")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if !strings.Contains(out, "(next refresh in 1h0m0s)") {
+		t.Errorf("startup requests were not counted:\n%s", out)
+	}
+	if !strings.Contains(out, "Unread notifications  (stale: request quota used up)") {
+		t.Errorf("expected notifications to wait for the quota:\n%s", out)
+	}
+}
+
+func TestDashboard_staleAfterFailure(t *testing.T) {
+	var failing atomic.Bool
+	ta, _ := newDashboardApp(t, flumetest.WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failing.Load() && strings.HasSuffix(r.URL.Path, "/notifications") {
+				http.Error(w, `{"success":false,"message":"unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	ctx := context.Background()
+	c, err := ta.client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dashboard{days: 7}
+	d.refreshDevices(ctx, c)
+	d.refreshNotifications(ctx, c)
+	failing.Store(true)
+	d.refreshNotifications(ctx, c)
+
+	var b strings.Builder
+	d.draw(&b, ta.now(), time.Minute, false)
+	if !strings.Contains(b.String(), "Unread notifications  (stale: ") || !strings.Contains(b.String(), "High flow") {
+		t.Errorf("expected stale notifications to be kept:\n%s", b.String())
+	}
+}
+
+func TestDashboard_acknowledge(t *testing.T) {
+	ta, _ := newDashboardApp(t)
+	ctx := context.Background()
+	c, err := ta.client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dashboard{days: 7}
+	d.refreshNotifications(ctx, c)
+	if len(d.notes) != 2 {
+		t.Fatalf("got %d unread notifications", len(d.notes))
+	}
+	if sent := d.key(ctx, c, '3'); sent != 0 {
+		t.Errorf("key 3 made %d requests with two notifications", sent)
+	}
+	if sent := d.key(ctx, c, '1'); sent != 1 || len(d.notes) != 1 || d.notes[0].ID != 1 {
+		t.Fatalf("after key 1: sent %d, notes %+v", sent, d.notes)
+	}
+	if st := ta.srv.State(); st.Notifications[0].Read || !st.Notifications[1].Read {
+		t.Errorf("unexpected read flags: %+v", st.Notifications)
+	}
+	if sent := d.key(ctx, c, 'a'); sent != 1 || len(d.notes) != 0 || d.message != "Acknowledged 1 notification(s)." {
+		t.Errorf("after key a: sent %d, notes %+v, message %q", sent, d.notes, d.message)
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	for _, tc := range []struct {
+		actual, value int
+		want          string
+	}{
+		{0, 100, "[..........]"},
+		{50, 100, "[#####.....]"},
+		{100, 100, "[##########]"},
+		{150, 100, "[!!!!!!!!!!]"},
+		{10, 0, "[##########]"},
+	} {
+		if got := progressBar(tc.actual, tc.value, 10); got != tc.want {
+			t.Errorf("progressBar(%d, %d) = %s, want %s", tc.actual, tc.value, got, tc.want)
+		}
+	}
+}
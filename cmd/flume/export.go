@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/401unauthorized/go-flume/export"
+)
+
+func runExport(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("export")
+	dir := fs.String("dir", "", "export directory (required)")
+	bucket := fs.String("bucket", "HR", "usage history resolution: MIN, HR or DAY")
+	since := fs.String("since", "", "start of the usage history on a first export, YYYY-MM-DD (default: first recorded usage)")
+	full := fs.Bool("full", false, "ignore the previous export and fetch everything again")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("%w: -dir is required", errUsage)
+	}
+	opts := export.Options{Dir: *dir, Bucket: strings.ToUpper(*bucket), Full: *full, Now: a.now}
+	if *since != "" {
+		t, err := time.ParseInLocation("2006-01-02", *since, a.now().Location())
+		if err != nil {
+			return fmt.Errorf("%w: invalid -since %q, use YYYY-MM-DD", errUsage, *since)
+		}
+		opts.Since = t
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := export.Run(ctx, c, opts)
+	if err != nil {
+		return err
+	}
+
+	kind := "Full"
+	if m.Incremental {
+		kind = "Incremental"
+	}
+	readings, notes := 0, 0
+	for _, f := range m.Files {
+		switch {
+		case strings.HasSuffix(f.Path, "/usage.csv"):
+			readings += f.Added
+		case f.Path == "notifications.json":
+			notes = f.Added
+		}
+	}
+	fmt.Fprintf(a.stdout, "%s export of %d files to %s: %d new usage readings, %d new notifications.\n",
+		kind, len(m.Files), *dir, readings, notes)
+	ids := make([]string, 0, len(m.Usage))
+	for id := range m.Usage {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		r := m.Usage[id]
+		fmt.Fprintf(a.stdout, "Device %s usage from %s to %s (%s).\n", id, r.Since, r.Until, r.TZ)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/401unauthorized/go-flume/export"
+)
+
+func TestExport(t *testing.T) {
+	ta := newTestApp(t)
+	ta.login(t)
+	dir := filepath.Join(t.TempDir(), "backup")
+
+	code, out := ta.exec("export", "-dir", dir, "-bucket", "day", "-since", "2026-01-01")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if !strings.HasPrefix(out, "Full export of ") || !strings.Contains(out, "Device 6248148189204194987 usage from 2026-01-01 00:00:00") {
+		t.Errorf("unexpected output %q", out)
+	}
+	m, err := export.ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bucket != "DAY" {
+		t.Errorf("bucket = %s", m.Bucket)
+	}
+
+	code, out = ta.exec("export", "-dir", dir, "-bucket", "DAY")
+	if code != 0 || !strings.HasPrefix(out, "Incremental export of ") {
+		t.Errorf("exit %d, output %q: %s", code, out, ta.stderr)
+	}
+	if code, _ := ta.exec("export"); code != 2 {
+		t.Errorf("missing -dir exited %d, want 2", code)
+	}
+}
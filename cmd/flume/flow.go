@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+func runFlow(ctx context.Context, a *app, args []string) error {
+	if len(args) > 0 && args[0] == "watch" {
+		return runFlowWatch(ctx, a, args[1:])
+	}
+	fs := a.flagSet("flow")
+	format := outputFlag(fs)
+	device := deviceFlag(fs, a)
+	if a.flagHook == nil {
+		fs.Usage = func() {
+			fmt.Fprintln(a.stderr, "Usage: flume flow [flags] | flume flow watch [flags]")
+			fs.PrintDefaults()
+		}
+	}
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	id, err := resolveDevice(ctx, c, *device)
+	if err != nil {
+		return err
+	}
+	resp, err := c.GetCurrentFlow(ctx, id)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"DEVICE", "ACTIVE", "GPM", "DATETIME"}}
+	for _, f := range resp.Data {
+		t.add(id, f.Active, f.GPM, f.Datetime)
+	}
+	return render(a.stdout, *format, resp.Data, t)
+}
+
+// exitFlowAlert is the exit status of "flume flow watch" when a flow outlasts
+// -alert-after.
+const exitFlowAlert = 3
+
+// historyLen is how many samples the watch sparkline keeps per device.
+const historyLen = 40
+
+// stringList is a repeatable flag that also accepts comma separated values.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// flowEvent tracks the current flow of one watched device.
+type flowEvent struct {
+	id      string
+	flow    goflume.Flow
+	err     error
+	since   time.Time // start of the current flow, zero while idle
+	last    time.Time // time of the previous sample
+	gallons float64   // estimated volume of the current flow
+	history []float64
+}
+
+// sample adds a rate to the sparkline history.
+func (e *flowEvent) sample(gpm float64) {
+	e.history = append(e.history, gpm)
+	if len(e.history) > historyLen {
+		e.history = e.history[len(e.history)-historyLen:]
+	}
+}
+
+// fail records a poll that failed. Nothing is known about the flow while the
+// device is unreachable, so the current flow is dropped rather than left to
+// age into an alert; if the device still flows once it answers again, a new
+// flow starts then.
+func (e *flowEvent) fail(err error) {
+	e.err = err
+	e.flow, e.since, e.gallons = goflume.Flow{}, time.Time{}, 0
+}
+
+// apply folds an event of a FlowWatcher into the view. The watcher ends the
+// flow of a device that becomes unreachable, so a flow never ages into an
+// alert through an outage.
+func (e *flowEvent) apply(fe goflume.FlowEvent) {
+	switch fe.Type {
+	case goflume.FlowReading:
+		e.since = time.Time{}
+		if fe.Flow.Active {
+			e.since = fe.Time.Add(-fe.Duration)
+		}
+		e.flow, e.err, e.last, e.gallons = fe.Flow, nil, fe.Time, fe.Gallons
+		e.sample(fe.Flow.GPM)
+	case goflume.DeviceUnreachable:
+		e.fail(fe.Err)
+	}
+}
+
+func (e *flowEvent) duration(now time.Time) time.Duration {
+	if e.since.IsZero() {
+		return 0
+	}
+	return now.Sub(e.since)
+}
+
+func runFlowWatch(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("flow watch")
+	var devices stringList
+	fs.Var(&devices, "device", "device ID to watch, repeatable (default all water sensors)")
+	alertAfter := fs.Duration("alert-after", 0, "exit with status 3 when a flow lasts longer than this")
+	quota := fs.Int("quota", 120, "requests per hour the watch may spend")
+	minInterval := fs.Duration("min-interval", 15*time.Second, "shortest poll interval")
+	count := fs.Int("count", 0, "stop after this many polls (default run until interrupted)")
+	plain := fs.Bool("plain", false, "print one line per sample instead of redrawing the screen")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *quota < 1 {
+		return fmt.Errorf("%w: -quota must be positive", errUsage)
+	}
+
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &goflume.FlowWatcher{
+		Client:         c,
+		DeviceIDs:      devices,
+		ActiveInterval: *minInterval,
+		IdleInterval:   *minInterval,
+		Budget:         &goflume.RequestBudget{Limit: *quota, Now: a.now},
+		RoundEvents:    true,
+		Now:            a.now,
+		After:          a.after,
+	}
+	stream, err := w.Watch(ctx)
+	if err != nil {
+		return err
+	}
+
+	redraw := !*plain && isTerminal(a.stdout)
+	// Without -device the watcher finds the sensors, which its first round
+	// then names.
+	var events []*flowEvent
+	byID := map[string]*flowEvent{}
+	for _, id := range devices {
+		byID[id] = &flowEvent{id: id}
+		events = append(events, byID[id])
+	}
+
+	for poll := 0; ; {
+		fe, ok := <-stream
+		if !ok {
+			return nil
+		}
+		if fe.Type != goflume.RoundDone {
+			e, ok := byID[fe.DeviceID]
+			if !ok {
+				e = &flowEvent{id: fe.DeviceID}
+				byID[fe.DeviceID] = e
+				events = append(events, e)
+			}
+			e.apply(fe)
+			continue
+		}
+
+		poll++
+		now := fe.Time
+		if redraw {
+			fmt.Fprint(a.stdout, "\x1b[H\x1b[2J")
+			printFlowView(a.stdout, events, now, fe.Next)
+		} else {
+			printFlowLines(a.stdout, events, now)
+		}
+
+		if *alertAfter > 0 {
+			for _, e := range events {
+				if d := e.duration(now); d > *alertAfter {
+					return exitError{exitFlowAlert, fmt.Errorf("device %s has been flowing for %s (%.1f gal)", e.id, d.Round(time.Second), e.gallons)}
+				}
+			}
+		}
+		if *count > 0 && poll >= *count {
+			return nil
+		}
+	}
+}
+
+func printFlowView(w io.Writer, events []*flowEvent, now time.Time, interval time.Duration) {
+	fmt.Fprintf(w, "Flume flow  %s  (next poll in %s, Ctrl-C to quit)\n\n", now.Format("15:04:05"), interval.Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tSTATE\tGPM\tDURATION\tGALLONS\tHISTORY")
+	for _, e := range events {
+		if e.err != nil {
+			fmt.Fprintf(tw, "%s\tunreachable\t\t\t\t%s\n", e.id, e.err)
+			continue
+		}
+		state, dur, gal := "idle", "", ""
+		if e.flow.Active {
+			state = "flowing"
+			dur = e.duration(now).Round(time.Second).String()
+			gal = fmt.Sprintf("%.1f", e.gallons)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%s\t%s\t%s\n", e.id, state, e.flow.GPM, dur, gal, sparkFloats(e.history))
+	}
+	_ = tw.Flush()
+}
+
+func printFlowLines(w io.Writer, events []*flowEvent, now time.Time) {
+	for _, e := range events {
+		ts := now.Format(time.RFC3339)
+		switch {
+		case e.err != nil:
+			fmt.Fprintf(w, "%s %s unreachable: %v\n", ts, e.id, e.err)
+		case e.flow.Active:
+			fmt.Fprintf(w, "%s %s flowing %.2f gpm for %s, %.1f gal\n", ts, e.id, e.flow.GPM, e.duration(now).Round(time.Second), e.gallons)
+		default:
+			fmt.Fprintf(w, "%s %s idle\n", ts, e.id)
+		}
+	}
+}
+
+// sparkFloats renders fractional samples with the usage sparkline levels.
+func sparkFloats(values []float64) string {
+	scaled := make([]int, len(values))
+	for i, v := range values {
+		scaled[i] = int(v * 100)
+	}
+	return sparkline(scaled)
+}
+
+// isTerminal reports whether w is a character device, so the watch can
+// redraw in place instead of scrolling.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
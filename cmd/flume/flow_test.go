@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+// fakeClock advances only when a command waits on it.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	now := c.t
+	c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	ch <- now
+	return ch
+}
+
+func newWatchApp(t *testing.T) (*testApp, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{t: time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)}
+	ta := newTestApp(t, flumetest.WithClock(clock.now))
+	ta.now, ta.after = clock.now, clock.after
+	ta.login(t)
+	return ta, clock
+}
+
+func TestFlowWatch_alertAfter(t *testing.T) {
+	ta, clock := newWatchApp(t)
+	start := clock.now()
+	ta.srv.SetFlowSource("6248148189204194987", func(now time.Time) goflume.Flow {
+		return goflume.Flow{Active: now.Sub(start) >= time.Minute, GPM: 2}
+	})
+
+	code, out := ta.exec("flow", "watch", "-alert-after", "3m", "-min-interval", "1m")
+	if code != exitFlowAlert {
+		t.Fatalf("exit %d, want %d: %s", code, exitFlowAlert, ta.stderr)
+	}
+	if !strings.Contains(ta.stderr.String(), "flowing for 4m0s (8.0 gal)") {
+		t.Errorf("unexpected alert %q", ta.stderr)
+	}
+	if !strings.Contains(out, "idle") || !strings.Contains(out, "flowing 2.00 gpm for 1m0s, 2.0 gal") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestFlowWatch_unreachableDoesNotAlert(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)}
+	start := clock.now()
+	// The device flows throughout, but stops answering after two minutes.
+	ta := newTestApp(t, flumetest.WithClock(clock.now), flumetest.WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/query/active") && clock.now().Sub(start) >= 2*time.Minute {
+				http.Error(w, `{"success":false,"message":"gateway timeout"}`, http.StatusGatewayTimeout)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	ta.now, ta.after = clock.now, clock.after
+	ta.login(t)
+	ta.srv.SetFlowSource("6248148189204194987", func(time.Time) goflume.Flow {
+		return goflume.Flow{Active: true, GPM: 2}
+	})
+
+	code, out := ta.exec("flow", "watch", "-alert-after", "3m", "-min-interval", "1m", "-count", "8", "-device", "6248148189204194987")
+	if code != 0 {
+		t.Fatalf("exit %d, want 0: %s\n%s", code, ta.stderr, out)
+	}
+	if !strings.Contains(out, "unreachable") {
+		t.Errorf("expected the device to become unreachable:\n%s", out)
+	}
+}
+
+func TestFlowWatch_count(t *testing.T) {
+	ta, _ := newWatchApp(t)
+	code, out := ta.exec("flow", "watch", "-count", "3", "-device", "6248148189204194987,missing")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if got := strings.Count(out, "6248148189204194987 idle"); got != 3 {
+		t.Errorf("expected 3 idle samples, got %d:\n%s", got, out)
+	}
+	if !strings.Contains(out, "missing unreachable") {
+		t.Errorf("expected the missing device to be reported:\n%s", out)
+	}
+}
+
+func TestFlowEvent_apply(t *testing.T) {
+	now := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	later := now.Add(2 * time.Minute)
+	var e flowEvent
+	e.apply(goflume.FlowEvent{Type: goflume.FlowReading, Time: later, Flow: goflume.Flow{Active: true, GPM: 3}, Duration: 2 * time.Minute, Gallons: 4})
+	if e.gallons != 4 || e.duration(later) != 2*time.Minute || len(e.history) != 1 {
+		t.Errorf("gallons %v duration %v history %v", e.gallons, e.duration(later), e.history)
+	}
+	e.apply(goflume.FlowEvent{Type: goflume.DeviceUnreachable, Time: later, Err: errors.New("offline")})
+	if e.err == nil || e.duration(later) != 0 || e.gallons != 0 {
+		t.Errorf("expected an unreachable device to end the flow, got %+v", e)
+	}
+	e.apply(goflume.FlowEvent{Type: goflume.FlowReading, Time: later.Add(time.Minute)})
+	if e.err != nil || e.duration(later.Add(time.Minute)) != 0 {
+		t.Errorf("expected an idle reading to clear the error, got %+v", e)
+	}
+}
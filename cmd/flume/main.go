@@ -0,0 +1,237 @@
+// Command flume is a command-line client for the Flume Personal API.
+//
+// Run "flume login" once to store a token, then query the account:
+//
+//	flume devices
+//	flume flow --device 6248148189204194987
+//	flume notifications --unread -o json
+//
+// Every listing command accepts -o table, json or csv. Several accounts can be
+// kept as profiles in the config file and chosen with -profile or
+// FLUME_PROFILE:
+//
+//	flume profiles add rental -client-id ID -client-secret-env RENTAL_SECRET -username me@example.com
+//	flume -profile rental login
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// app carries everything a command touches outside the process so tests can
+// run commands against a fake server and a temporary directory.
+type app struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+	now    func() time.Time
+	after  func(time.Duration) <-chan time.Time
+
+	// configDir holds the config file and one directory per profile with
+	// its cached session.
+	configDir  string
+	httpClient *http.Client
+
+	// profile is the selected profile and settings its config entry.
+	profile  string
+	settings profile
+
+	// flagHook, when set, receives each command's FlagSet in place of its
+	// usage message; completion uses it to list flags.
+	flagHook func(*flag.FlagSet)
+}
+
+type command struct {
+	summary string
+	run     func(ctx context.Context, a *app, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"login":         {"authenticate and cache a token", runLogin},
+		"whoami":        {"show the authenticated user", runWhoami},
+		"devices":       {"list devices", runDevices},
+		"locations":     {"list locations", runLocations},
+		"flow":          {"show the current flow rate of a device", runFlow},
+		"usage":         {"query water usage of a device", runUsage},
+		"budgets":       {"list the budgets of a device", runBudgets},
+		"alerts":        {"list usage alerts", runAlerts},
+		"notifications": {"list notifications", runNotifications},
+		"rules":         {"list the event and usage alert rules of a device", runRules},
+		"contacts":      {"list contacts", runContacts},
+		"away":          {"show, set or schedule away mode", runAway},
+		"profiles":      {"list, add, remove or choose account profiles", runProfiles},
+		"export":        {"back up the account and usage history to a directory", runExport},
+		"dashboard":     {"show a live dashboard of devices, flow, usage, budgets and notifications", runDashboard},
+		"mqtt":          {"publish to MQTT with Home Assistant discovery and away mode control", runMQTT},
+		"completion":    {"print a bash, zsh or fish completion script", runCompletion},
+		"__complete":    {"", runComplete}, // used by the completion scripts
+	}
+}
+
+// errUsage marks errors caused by bad arguments; they exit with status 2.
+var errUsage = errors.New("usage error")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	dir := os.Getenv("FLUME_CONFIG_DIR")
+	if dir == "" {
+		if base, err := os.UserConfigDir(); err == nil {
+			dir = filepath.Join(base, "flume")
+		}
+	}
+	a := &app{
+		stdin:      os.Stdin,
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+		getenv:     os.Getenv,
+		now:        time.Now,
+		after:      time.After,
+		configDir:  dir,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	os.Exit(a.run(ctx, os.Args[1:]))
+}
+
+// run dispatches to a subcommand and maps its error to an exit status.
+func (a *app) run(ctx context.Context, args []string) int {
+	var profileFlag string
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help" {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+		if name != "profile" {
+			fmt.Fprintf(a.stderr, "flume: unknown flag %s\n", args[0])
+			return 2
+		}
+		if !hasValue {
+			if len(args) < 2 {
+				fmt.Fprintln(a.stderr, "flume: -profile needs a value")
+				return 2
+			}
+			value, args = args[1], args[1:]
+		}
+		profileFlag, args = value, args[1:]
+	}
+	if err := a.selectProfile(profileFlag); err != nil {
+		fmt.Fprintf(a.stderr, "flume: %v\n", err)
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		return 1
+	}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		a.usage()
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(a.stderr, "flume: unknown command %q\n\n", args[0])
+		a.usage()
+		return 2
+	}
+	err := cmd.run(ctx, a, args[1:])
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(a.stderr, "flume %s: %v\n", args[0], err)
+		return 2
+	default:
+		var exit exitError
+		if errors.As(err, &exit) {
+			fmt.Fprintf(a.stderr, "flume %s: %v\n", args[0], exit.err)
+			return exit.code
+		}
+		fmt.Fprintf(a.stderr, "flume %s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+// exitError lets a command choose its exit status.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string { return e.err.Error() }
+
+func (a *app) usage() {
+	fmt.Fprintln(a.stderr, "Usage: flume [-profile name] <command> [flags]")
+	fmt.Fprintln(a.stderr)
+	fmt.Fprintln(a.stderr, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name, cmd := range commands {
+		if cmd.summary != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(a.stderr, "  %-14s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(a.stderr)
+	fmt.Fprintln(a.stderr, `Run "flume <command> -h" for the flags of a command. The profile`)
+	fmt.Fprintln(a.stderr, `can also be chosen with FLUME_PROFILE.`)
+}
+
+// flagSet returns a FlagSet that reports errors instead of exiting.
+func (a *app) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("flume "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	if a.flagHook != nil {
+		hook := a.flagHook
+		fs.Usage = func() { hook(fs) }
+	}
+	return fs
+}
+
+// parseDispatch parses the flags given to a command in place of one of its
+// subcommands, which it has none of, so that -h lists the subcommands rather
+// than failing as an unknown one.
+func (a *app) parseDispatch(name string, args []string) error {
+	fs := a.flagSet(name)
+	if a.flagHook == nil {
+		fs.Usage = func() {
+			fmt.Fprintf(a.stderr, "Usage: flume %s %s ...\n", name, strings.Join(subcommands[name], "|"))
+		}
+	}
+	return parse(fs, args)
+}
+
+// parse parses args and rejects stray positional arguments.
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, fs.Arg(0))
+	}
+	// Reject a bad -o before the command makes any API request.
+	if f := fs.Lookup("output"); f != nil {
+		switch f.Value.String() {
+		case "table", "json", "csv", "":
+		default:
+			return fmt.Errorf("%w: unknown output format %q", errUsage, f.Value.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+type testApp struct {
+	*app
+	srv    *flumetest.Server
+	env    map[string]string
+	stdout *bytes.Buffer
+	stderr *bytes.Buffer
+}
+
+func newTestApp(t *testing.T, opts ...flumetest.Option) *testApp {
+	t.Helper()
+	srv := flumetest.NewServer(opts...)
+	t.Cleanup(srv.Close)
+	ta := &testApp{
+		srv: srv,
+		env: map[string]string{
+			"FLUME_CLIENT_ID":     flumetest.ClientID,
+			"FLUME_CLIENT_SECRET": flumetest.ClientSecret,
+			"FLUME_USERNAME":      flumetest.Username,
+			"FLUME_PASSWORD":      flumetest.Password,
+			"FLUME_BASE_URL":      srv.URL,
+		},
+		stdout: &bytes.Buffer{},
+		stderr: &bytes.Buffer{},
+	}
+	ta.app = &app{
+		stdin:      strings.NewReader(""),
+		stdout:     ta.stdout,
+		stderr:     ta.stderr,
+		getenv:     func(k string) string { return ta.env[k] },
+		now:        time.Now,
+		after:      time.After,
+		configDir:  t.TempDir(),
+		httpClient: srv.Client().HTTPClient,
+	}
+	return ta
+}
+
+// exec runs a command line and returns its exit status and stdout.
+func (ta *testApp) exec(args ...string) (int, string) {
+	ta.stdout.Reset()
+	ta.stderr.Reset()
+	code := ta.run(context.Background(), args)
+	return code, ta.stdout.String()
+}
+
+func (ta *testApp) login(t *testing.T) {
+	t.Helper()
+	if code, _ := ta.exec("login"); code != 0 {
+		t.Fatalf("login exited %d: %s", code, ta.stderr)
+	}
+}
+
+func TestLogin_cachesSession(t *testing.T) {
+	ta := newTestApp(t)
+	code, out := ta.exec("login")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if !strings.Contains(out, "Logged in as test@example.com") {
+		t.Errorf("unexpected output %q", out)
+	}
+	info, err := os.Stat(ta.sessionPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("session file mode %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestLogin_promptsForPassword(t *testing.T) {
+	ta := newTestApp(t)
+	delete(ta.env, "FLUME_PASSWORD")
+	ta.stdin = strings.NewReader(flumetest.Password + "\n")
+	if code, _ := ta.exec("login"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if !strings.Contains(ta.stderr.String(), "Password:") {
+		t.Errorf("expected a password prompt, got %q", ta.stderr)
+	}
+}
+
+func TestCommands_requireLogin(t *testing.T) {
+	ta := newTestApp(t)
+	code, _ := ta.exec("devices")
+	if code != 1 || !strings.Contains(ta.stderr.String(), "flume login") {
+		t.Errorf("exit %d, stderr %q", code, ta.stderr)
+	}
+}
+
+func TestCommands_refreshExpiredToken(t *testing.T) {
+	ta := newTestApp(t, flumetest.WithTokenTTL(30*time.Second))
+	ta.login(t)
+	before, _ := os.ReadFile(ta.sessionPath())
+	if code, _ := ta.exec("whoami"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	after, _ := os.ReadFile(ta.sessionPath())
+	if bytes.Equal(before, after) {
+		t.Error("expected the cached token to be refreshed")
+	}
+}
+
+func TestCommands_outputFormats(t *testing.T) {
+	ta := newTestApp(t)
+	ta.login(t)
+
+	code, out := ta.exec("devices")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[1], "6248148189204194987") {
+		t.Errorf("unexpected table:\n%s", out)
+	}
+
+	code, out = ta.exec("devices", "-o", "json")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	var devices []goflume.Device
+	if err := json.Unmarshal([]byte(out), &devices); err != nil || len(devices) != 1 {
+		t.Errorf("unexpected json %q: %v", out, err)
+	}
+
+	code, out = ta.exec("contacts", "-output", "csv")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if out != "ID,CATEGORY,TYPE,DETAIL\n1,primary,email,test@example.com\n" {
+		t.Errorf("unexpected csv %q", out)
+	}
+
+	if code, _ := ta.exec("devices", "-o", "yaml"); code != 2 {
+		t.Errorf("unknown format exited %d, want 2", code)
+	}
+}
+
+func TestOutputFormat_checkedBeforeRequests(t *testing.T) {
+	var requests atomic.Int32
+	ta := newTestApp(t, flumetest.WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			next.ServeHTTP(w, r)
+		})
+	}))
+	ta.login(t)
+	requests.Store(0)
+	for _, args := range [][]string{{"devices", "-o", "yaml"}, {"usage", "-output", "xml"}, {"away", "status", "-o", "yaml"}} {
+		if code, _ := ta.exec(args...); code != 2 || !strings.Contains(ta.stderr.String(), "unknown output format") {
+			t.Errorf("%v: exit %d, stderr %q", args, code, ta.stderr)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("an unknown format still made %d API requests", n)
+	}
+}
+
+func TestCommands_all(t *testing.T) {
+	ta := newTestApp(t)
+	ta.srv.Update(func(s *flumetest.State) {
+		s.Budgets["6248148189204194987"] = []goflume.Budget{{ID: 5, Name: "Monthly", Type: "MONTHLY", Value: 5000, Thresholds: []int{50, 100}}}
+		s.EventRules["6248148189204194987"] = []goflume.EventRule{{ID: "e1", Name: "High Flow", Active: true, FlowRate: 2, Duration: 30}}
+		s.UsageAlertRules["6248148189204194987"] = []goflume.UsageAlertRule{{ID: "u1", Name: "Daily", Enabled: true, Threshold: 200, Unit: "GALLONS"}}
+		s.Flow["6248148189204194987"] = goflume.Flow{Active: true, GPM: 1.5, Datetime: "2026-01-01 00:00:00"}
+	})
+	ta.login(t)
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"whoami"}, "test@example.com"},
+		{[]string{"locations"}, "America/Los_Angeles"},
+		{[]string{"flow"}, "1.5"},
+		{[]string{"budgets"}, "50,100"},
+		{[]string{"rules"}, "> 2 gpm for 30 min"},
+		{[]string{"rules", "-kind", "usage"}, "> 200 gallons"},
+		{[]string{"rules", "-rule", "u1"}, "Daily"},
+		{[]string{"alerts", "-leak"}, "TRIGGERED"},
+		{[]string{"notifications", "-unread"}, "MESSAGE"},
+		{[]string{"usage", "-since", "2026-01-01", "-until", "2026-01-02"}, "DATETIME"},
+	} {
+		code, out := ta.exec(tc.args...)
+		if code != 0 {
+			t.Errorf("%v: exit %d: %s", tc.args, code, ta.stderr)
+			continue
+		}
+		if !strings.Contains(out, tc.want) {
+			t.Errorf("%v: output does not contain %q:\n%s", tc.args, tc.want, out)
+		}
+	}
+}
+
+func TestRun_usageErrors(t *testing.T) {
+	ta := newTestApp(t)
+	for _, args := range [][]string{
+		{"nope"},
+		{"devices", "extra"},
+		{"devices", "-limit", "x"},
+		{"rules", "-kind", "other"},
+		{"rules", "-kind", "event", "-rule", "u1"},
+		{"mqtt"},
+		{"mqtt", "-broker", "localhost:1883", "-quota", "0"},
+	} {
+		if code, _ := ta.exec(args...); code != 2 {
+			t.Errorf("%v: exit %d, want 2", args, code)
+		}
+	}
+	if code, _ := ta.exec("devices", "-h"); code != 0 {
+		t.Errorf("-h exited %d, want 0", code)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/mqtt"
+)
+
+func runMQTT(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("mqtt")
+	broker := fs.String("broker", a.getenv("FLUME_MQTT_BROKER"), "MQTT broker host:port (env FLUME_MQTT_BROKER)")
+	username := fs.String("username", a.getenv("FLUME_MQTT_USERNAME"), "broker user name (env FLUME_MQTT_USERNAME)")
+	passwordEnv := fs.String("password-env", "FLUME_MQTT_PASSWORD", "environment variable holding the broker password")
+	clientID := fs.String("client-id", "", "MQTT client ID (default go-flume-PREFIX)")
+	prefix := fs.String("prefix", "flume", "topic prefix of the state and command topics")
+	discovery := fs.String("discovery-prefix", "homeassistant", "Home Assistant discovery prefix")
+	flowInterval := fs.Duration("flow-interval", time.Minute, "how often to publish the current flow")
+	slowInterval := fs.Duration("slow-interval", 15*time.Minute, "how often to refresh devices, usage and budgets")
+	quota := fs.Int("quota", goflume.DefaultRequestLimit, "requests per hour the publisher may spend")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *broker == "" {
+		return fmt.Errorf("%w: -broker is required", errUsage)
+	}
+	if *quota < 1 {
+		return fmt.Errorf("%w: -quota must be positive", errUsage)
+	}
+
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	p := &mqtt.Publisher{
+		Client: c,
+		Login: func(ctx context.Context, c *goflume.Client) error {
+			fresh, err := a.client(ctx)
+			if err != nil {
+				return err
+			}
+			return c.SetToken(fresh.Token)
+		},
+		Broker:          *broker,
+		Options:         mqtt.Options{ClientID: *clientID, Username: *username, Password: a.getenv(*passwordEnv)},
+		TopicPrefix:     *prefix,
+		DiscoveryPrefix: *discovery,
+		FlowInterval:    *flowInterval,
+		SlowInterval:    *slowInterval,
+		Budget:          &goflume.RequestBudget{Limit: *quota, Now: a.now},
+		OnError:         func(err error) { fmt.Fprintf(a.stderr, "flume mqtt: %v\n", err) },
+		Now:             a.now,
+		After:           a.after,
+	}
+	fmt.Fprintf(a.stderr, "Publishing to %s under %s/ with discovery under %s/.\n", *broker, *prefix, *discovery)
+	if err := p.Run(ctx); ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
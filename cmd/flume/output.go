@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// table is the tabular form of a command's result, used for the table and
+// CSV formats. The JSON format prints the underlying API data instead.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) add(cells ...any) {
+	row := make([]string, len(cells))
+	for i, c := range cells {
+		switch v := c.(type) {
+		case string:
+			row[i] = v
+		case float64:
+			row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			row[i] = fmt.Sprint(v)
+		}
+	}
+	t.rows = append(t.rows, row)
+}
+
+// outputFlag registers -o/-output on fs.
+func outputFlag(fs *flag.FlagSet) *string {
+	format := new(string)
+	fs.StringVar(format, "output", "table", "output `format`: table, json or csv")
+	fs.StringVar(format, "o", "table", "shorthand for -output")
+	return format
+}
+
+func render(w io.Writer, format string, data any, t table) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write(t.header)
+		_ = cw.WriteAll(t.rows)
+		return cw.Error()
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("%w: unknown output format %q", errUsage, format)
+	}
+}
+
+// optional is a flag.Value that leaves its destination nil unless the flag is
+// given, matching the pointer fields of the client's params structs.
+type optional[T any] struct {
+	dst    **T
+	parse  func(string) (T, error)
+	isBool bool
+}
+
+func (o optional[T]) Set(s string) error {
+	v, err := o.parse(s)
+	if err != nil {
+		return err
+	}
+	*o.dst = &v
+	return nil
+}
+
+func (o optional[T]) String() string {
+	if o.dst == nil || *o.dst == nil {
+		return ""
+	}
+	return fmt.Sprint(**o.dst)
+}
+
+func (o optional[T]) IsBoolFlag() bool { return o.isBool }
+
+func optString(fs *flag.FlagSet, dst **string, name, usage string) {
+	fs.Var(optional[string]{dst: dst, parse: func(s string) (string, error) { return s, nil }}, name, usage)
+}
+
+func optInt32(fs *flag.FlagSet, dst **int32, name, usage string) {
+	fs.Var(optional[int32]{dst: dst, parse: func(s string) (int32, error) {
+		n, err := strconv.ParseInt(s, 10, 32)
+		return int32(n), err
+	}}, name, usage)
+}
+
+func optBool(fs *flag.FlagSet, dst **bool, name, usage string) {
+	fs.Var(optional[bool]{dst: dst, parse: strconv.ParseBool, isBool: true}, name, usage)
+}
+
+// listFlags are the paging and sorting flags shared by listing commands.
+type listFlags struct {
+	Limit         *int32
+	Offset        *int32
+	SortField     *string
+	SortDirection *string
+}
+
+func addListFlags(fs *flag.FlagSet) *listFlags {
+	l := &listFlags{}
+	optInt32(fs, &l.Limit, "limit", "maximum number of results")
+	optInt32(fs, &l.Offset, "offset", "number of results to skip")
+	optString(fs, &l.SortField, "sort", "field to sort on")
+	optString(fs, &l.SortDirection, "direction", "sort direction, ASC or DESC")
+	return l
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// defaultProfile is used when neither -profile, FLUME_PROFILE nor the config
+// file names one.
+const defaultProfile = "default"
+
+// profile holds the non-secret settings of one Flume account. Secrets are
+// best supplied through the environment; the *_env fields name the variables
+// to read them from so several accounts can coexist in one shell.
+type profile struct {
+	ClientID        string `json:"client_id,omitempty"`
+	ClientSecret    string `json:"client_secret,omitempty"`
+	ClientSecretEnv string `json:"client_secret_env,omitempty"`
+	Username        string `json:"username,omitempty"`
+	PasswordEnv     string `json:"password_env,omitempty"`
+	BaseURL         string `json:"base_url,omitempty"`
+	Device          string `json:"device,omitempty"`
+}
+
+// config is the CLI config file, config.json in the config directory.
+type config struct {
+	DefaultProfile string             `json:"default_profile,omitempty"`
+	Profiles       map[string]profile `json:"profiles"`
+}
+
+var profileNameRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (a *app) configPath() string {
+	return filepath.Join(a.configDir, "config.json")
+}
+
+func (a *app) loadConfig() (*config, error) {
+	cfg := &config{Profiles: map[string]profile{}}
+	path := a.configPath()
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]profile{}
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 {
+		for name, p := range cfg.Profiles {
+			if p.ClientSecret != "" {
+				fmt.Fprintf(a.stderr, "warning: %s holds the client secret of profile %q but is readable by other users, run chmod 600\n", path, name)
+				break
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func (a *app) saveConfig(cfg *config) error {
+	if err := os.MkdirAll(a.configDir, 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.configPath(), append(b, '\n'), 0o600)
+}
+
+// selectProfile picks the active profile from the -profile flag, then
+// FLUME_PROFILE, then the config file default.
+func (a *app) selectProfile(flagValue string) error {
+	name := flagValue
+	if name == "" {
+		name = a.getenv("FLUME_PROFILE")
+	}
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	if name == "" {
+		name = defaultProfile
+	}
+	if !profileNameRE.MatchString(name) {
+		return fmt.Errorf("%w: invalid profile name %q", errUsage, name)
+	}
+	if _, ok := cfg.Profiles[name]; !ok && name != defaultProfile {
+		return fmt.Errorf("%w: unknown profile %q", errUsage, name)
+	}
+	a.profile, a.settings = name, cfg.Profiles[name]
+	return nil
+}
+
+// profileDir holds the cached session and other state of the active profile.
+func (a *app) profileDir() string {
+	return filepath.Join(a.configDir, "profiles", a.profile)
+}
+
+// credentials are the login settings of the active profile. Environment
+// variables take precedence over the config file.
+type credentials struct {
+	clientID     string
+	clientSecret string
+	username     string
+	password     string
+	baseURL      string
+}
+
+func (a *app) credentials() credentials {
+	p := a.settings
+	first := func(values ...string) string {
+		for _, v := range values {
+			if v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	envOf := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return a.getenv(name)
+	}
+	return credentials{
+		clientID:     first(a.getenv("FLUME_CLIENT_ID"), p.ClientID),
+		clientSecret: first(a.getenv("FLUME_CLIENT_SECRET"), envOf(p.ClientSecretEnv), p.ClientSecret),
+		username:     first(a.getenv("FLUME_USERNAME"), p.Username),
+		password:     first(a.getenv("FLUME_PASSWORD"), envOf(p.PasswordEnv)),
+		baseURL:      first(a.getenv("FLUME_BASE_URL"), p.BaseURL),
+	}
+}
+
+func runProfiles(ctx context.Context, a *app, args []string) error {
+	sub := "list"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		sub, args = args[0], args[1:]
+	}
+	switch sub {
+	case "list":
+		return runProfilesList(a, args)
+	case "add":
+		return runProfilesAdd(a, args)
+	case "remove":
+		return runProfilesRemove(a, args)
+	case "use":
+		return runProfilesUse(a, args)
+	}
+	return fmt.Errorf("%w: unknown profiles command %q", errUsage, sub)
+}
+
+func runProfilesList(a *app, args []string) error {
+	fs := a.flagSet("profiles")
+	format := outputFlag(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type row struct {
+		Name      string     `json:"name"`
+		Active    bool       `json:"active"`
+		Username  string     `json:"username,omitempty"`
+		ExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+	}
+	var rows []row
+	t := table{header: []string{"", "NAME", "USERNAME", "TOKEN"}}
+	for _, name := range names {
+		r := row{Name: name, Active: name == a.profile, Username: cfg.Profiles[name].Username}
+		token := "not logged in"
+		saved := &app{configDir: a.configDir, profile: name}
+		if s, err := saved.loadSession(); err == nil {
+			if exp, err := tokenExpiry(s); err == nil {
+				r.ExpiresAt = &exp
+				token = "expires " + exp.Local().Format(datetimeLayout)
+			}
+		}
+		marker := ""
+		if r.Active {
+			marker = "*"
+		}
+		rows = append(rows, r)
+		t.add(marker, name, r.Username, token)
+	}
+	return render(a.stdout, *format, rows, t)
+}
+
+func runProfilesAdd(a *app, args []string) error {
+	if len(args) == 0 || args[0] == "" || args[0][0] == '-' {
+		return fmt.Errorf("%w: expected a profile name", errUsage)
+	}
+	name := args[0]
+	if !profileNameRE.MatchString(name) {
+		return fmt.Errorf("%w: invalid profile name %q", errUsage, name)
+	}
+	fs := a.flagSet("profiles add")
+	var p profile
+	fs.StringVar(&p.ClientID, "client-id", "", "API client ID")
+	fs.StringVar(&p.ClientSecret, "client-secret", "", "API client secret, stored in the config file")
+	fs.StringVar(&p.ClientSecretEnv, "client-secret-env", "", "environment variable holding the client secret")
+	fs.StringVar(&p.Username, "username", "", "account email")
+	fs.StringVar(&p.PasswordEnv, "password-env", "", "environment variable holding the password")
+	fs.StringVar(&p.BaseURL, "base-url", "", "API base URL")
+	fs.StringVar(&p.Device, "device", "", "default device ID")
+	makeDefault := fs.Bool("default", false, "make this the default profile")
+	if err := parse(fs, args[1:]); err != nil {
+		return err
+	}
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Profiles[name] = p
+	if *makeDefault || len(cfg.Profiles) == 1 {
+		cfg.DefaultProfile = name
+	}
+	if err := a.saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "Saved profile %q to %s.\n", name, a.configPath())
+	return nil
+}
+
+func runProfilesRemove(a *app, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: expected a profile name", errUsage)
+	}
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	if _, ok := cfg.Profiles[name]; !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	delete(cfg.Profiles, name)
+	if cfg.DefaultProfile == name {
+		cfg.DefaultProfile = ""
+	}
+	if err := a.saveConfig(cfg); err != nil {
+		return err
+	}
+	if profileNameRE.MatchString(name) {
+		if err := os.RemoveAll(filepath.Join(a.configDir, "profiles", name)); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(a.stdout, "Removed profile %q and its cached token.\n", name)
+	return nil
+}
+
+func runProfilesUse(a *app, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: expected a profile name", errUsage)
+	}
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[args[0]]; !ok {
+		return fmt.Errorf("unknown profile %q", args[0])
+	}
+	cfg.DefaultProfile = args[0]
+	if err := a.saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "Default profile is now %q.\n", args[0])
+	return nil
+}
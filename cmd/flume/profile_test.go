@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+func TestProfiles_separateAccounts(t *testing.T) {
+	ta := newTestApp(t)
+	rental := flumetest.DefaultState(2000)
+	rental.User.EmailAddress = "rental@example.com"
+	other := flumetest.NewServer(
+		flumetest.WithCredentials("rental-id", "rental-secret", "rental@example.com", "rental-pass"),
+		flumetest.WithState(rental),
+	)
+	defer other.Close()
+	ta.env = map[string]string{"RENTAL_SECRET": "rental-secret", "RENTAL_PASSWORD": "rental-pass"}
+
+	for _, args := range [][]string{
+		{"profiles", "add", "home", "-client-id", flumetest.ClientID, "-client-secret", flumetest.ClientSecret,
+			"-username", flumetest.Username, "-base-url", ta.srv.URL},
+		{"profiles", "add", "rental", "-client-id", "rental-id", "-client-secret-env", "RENTAL_SECRET",
+			"-username", "rental@example.com", "-password-env", "RENTAL_PASSWORD", "-base-url", other.URL},
+	} {
+		if code, _ := ta.exec(args...); code != 0 {
+			t.Fatalf("%v: exit %d: %s", args, code, ta.stderr)
+		}
+	}
+	info, err := os.Stat(ta.configPath())
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("config file: %v %v", info, err)
+	}
+
+	// home is the default because it was added first; its password is typed.
+	ta.stdin = strings.NewReader(flumetest.Password + "\n")
+	if code, out := ta.exec("login"); code != 0 || !strings.Contains(out, `profile "home"`) {
+		t.Fatalf("exit %d: %s %s", code, out, ta.stderr)
+	}
+	ta.env["FLUME_PROFILE"] = "rental"
+	if code, out := ta.exec("login"); code != 0 || !strings.Contains(out, "rental@example.com") {
+		t.Fatalf("exit %d: %s %s", code, out, ta.stderr)
+	}
+	delete(ta.env, "FLUME_PROFILE")
+
+	if code, out := ta.exec("-profile", "rental", "whoami"); code != 0 || !strings.Contains(out, "rental@example.com") {
+		t.Errorf("rental whoami: exit %d: %s %s", code, out, ta.stderr)
+	}
+	if code, out := ta.exec("--profile=home", "whoami"); code != 0 || !strings.Contains(out, flumetest.Username) {
+		t.Errorf("home whoami: exit %d: %s %s", code, out, ta.stderr)
+	}
+
+	code, out := ta.exec("profiles", "-o", "json")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	var rows []struct {
+		Name      string     `json:"name"`
+		Active    bool       `json:"active"`
+		ExpiresAt *time.Time `json:"token_expires_at"`
+	}
+	if err := json.Unmarshal([]byte(out), &rows); err != nil || len(rows) != 2 {
+		t.Fatalf("unexpected profiles %q: %v", out, err)
+	}
+	if rows[0].Name != "home" || !rows[0].Active || rows[0].ExpiresAt == nil || rows[1].ExpiresAt == nil {
+		t.Errorf("unexpected profiles %+v", rows)
+	}
+
+	if code, _ := ta.exec("profiles", "use", "rental"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if code, out := ta.exec("whoami"); code != 0 || !strings.Contains(out, "rental@example.com") {
+		t.Errorf("default profile not switched: %s", out)
+	}
+	if code, _ := ta.exec("profiles", "remove", "rental"); code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if _, err := os.Stat(filepath.Join(ta.configDir, "profiles", "rental")); !os.IsNotExist(err) {
+		t.Errorf("expected the rental token to be removed, got %v", err)
+	}
+	if code, _ := ta.exec("-profile", "rental", "whoami"); code != 2 {
+		t.Errorf("removed profile exited %d, want 2", code)
+	}
+}
+
+func TestProfiles_reloginWithStoredPassword(t *testing.T) {
+	ta := newTestApp(t, flumetest.WithTokenTTL(30*time.Second))
+	ta.login(t)
+	// Replace the refresh token with one the server never issued.
+	s, err := ta.loadSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Token.RefreshToken = "revoked"
+	if err := ta.saveSession(s); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := ta.exec("whoami"); code != 0 {
+		t.Fatalf("expected a new login with FLUME_PASSWORD, exit %d: %s", code, ta.stderr)
+	}
+	delete(ta.env, "FLUME_PASSWORD")
+	s, _ = ta.loadSession()
+	s.Token.RefreshToken = "revoked"
+	_ = ta.saveSession(s)
+	if code, _ := ta.exec("whoami"); code != 1 || !strings.Contains(ta.stderr.String(), "flume login") {
+		t.Errorf("exit %d: %s", code, ta.stderr)
+	}
+}
+
+func TestProfiles_warnsOnReadableSecret(t *testing.T) {
+	ta := newTestApp(t)
+	if err := os.WriteFile(ta.configPath(), []byte(`{"profiles":{"home":{"client_secret":"x"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ta.exec("profiles")
+	if !strings.Contains(ta.stderr.String(), "chmod 600") {
+		t.Errorf("expected a permissions warning, got %q", ta.stderr)
+	}
+}
+
+func TestProfiles_sessionHoldsNoSecret(t *testing.T) {
+	ta := newTestApp(t, flumetest.WithTokenTTL(30*time.Second))
+	ta.login(t)
+	b, err := os.ReadFile(ta.sessionPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "client_secret") || strings.Contains(string(b), flumetest.ClientSecret) {
+		t.Errorf("session caches the client secret:\n%s", b)
+	}
+	// The token expires within the refresh margin, so it must be refreshed
+	// with the secret from the environment.
+	if code, _ := ta.exec("whoami"); code != 0 {
+		t.Fatalf("refresh with FLUME_CLIENT_SECRET: exit %d: %s", code, ta.stderr)
+	}
+	delete(ta.env, "FLUME_CLIENT_SECRET")
+	if code, _ := ta.exec("whoami"); code != 1 || !strings.Contains(ta.stderr.String(), "no client secret") {
+		t.Errorf("exit %d: %s", code, ta.stderr)
+	}
+}
+
+func TestProfiles_migratesLegacySession(t *testing.T) {
+	ta := newTestApp(t)
+	ta.login(t)
+	s, err := ta.loadSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A session cached before profiles existed, with the secret it held.
+	legacy, _ := json.Marshal(map[string]any{
+		"base_url": s.BaseURL, "client_id": s.ClientID, "client_secret": flumetest.ClientSecret, "token": s.Token,
+	})
+	if err := os.WriteFile(ta.legacySessionPath(), legacy, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(ta.profileDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, out := ta.exec("whoami"); code != 0 || !strings.Contains(out, flumetest.Username) {
+		t.Fatalf("exit %d: %s %s", code, out, ta.stderr)
+	}
+	if _, err := os.Stat(ta.legacySessionPath()); !os.IsNotExist(err) {
+		t.Errorf("legacy session left behind: %v", err)
+	}
+	b, err := os.ReadFile(ta.sessionPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "client_secret") {
+		t.Errorf("migrated session keeps the client secret:\n%s", b)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// session is the token cache written by "flume login". It holds no secret
+// but the token: the client secret needed to refresh it comes from the
+// profile or the environment, like at login.
+type session struct {
+	BaseURL  string        `json:"base_url"`
+	ClientID string        `json:"client_id"`
+	Token    goflume.Token `json:"token"`
+}
+
+// refreshMargin renews the access token this long before it expires.
+const refreshMargin = time.Minute
+
+func (a *app) sessionPath() string {
+	return filepath.Join(a.profileDir(), "session.json")
+}
+
+// legacySessionPath is where "flume login" cached the token before profiles
+// existed.
+func (a *app) legacySessionPath() string {
+	return filepath.Join(a.configDir, "session.json")
+}
+
+func (a *app) loadSession() (*session, error) {
+	b, err := os.ReadFile(a.sessionPath())
+	if errors.Is(err, fs.ErrNotExist) && a.profile == defaultProfile {
+		b, err = a.migrateLegacySession()
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf(`profile %q is not logged in, run "flume login" first`, a.profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s session
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("read %s: %w", a.sessionPath(), err)
+	}
+	return &s, nil
+}
+
+// migrateLegacySession moves a session cached before profiles existed into
+// the default profile, dropping the client secret it used to hold, and
+// returns the migrated session file.
+func (a *app) migrateLegacySession() ([]byte, error) {
+	b, err := os.ReadFile(a.legacySessionPath())
+	if err != nil {
+		return nil, err
+	}
+	var s session
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("read %s: %w", a.legacySessionPath(), err)
+	}
+	if err := a.saveSession(&s); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(a.legacySessionPath()); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(a.sessionPath())
+}
+
+func (a *app) saveSession(s *session) error {
+	if a.configDir == "" {
+		return errors.New("no config directory, set FLUME_CONFIG_DIR")
+	}
+	if err := os.MkdirAll(a.profileDir(), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.sessionPath(), append(b, '\n'), 0o600)
+}
+
+// tokenExpiry returns when the cached access token expires.
+func tokenExpiry(s *session) (time.Time, error) {
+	c := goflume.NewClient(s.ClientID, "", nil)
+	if err := c.SetToken(s.Token); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(c.JWT.Exp), 0), nil
+}
+
+// client returns an authenticated client from the cached session. A token
+// about to expire is refreshed; when the refresh token is no longer valid
+// and the profile can supply a password, it logs in again. Either way the
+// new token is cached.
+func (a *app) client(ctx context.Context) (*goflume.Client, error) {
+	s, err := a.loadSession()
+	if err != nil {
+		return nil, err
+	}
+	creds := a.credentials()
+	c := goflume.NewClient(s.ClientID, creds.clientSecret, a.httpClient)
+	if s.BaseURL != "" {
+		c.BaseURL = s.BaseURL
+	}
+	if err := c.SetToken(s.Token); err != nil {
+		return nil, fmt.Errorf("cached token: %w", err)
+	}
+	if time.Unix(int64(c.JWT.Exp), 0).After(a.now().Add(refreshMargin)) {
+		return c, nil
+	}
+	if creds.clientSecret == "" {
+		return nil, fmt.Errorf(`the cached token of profile %q has expired and no client secret is set to refresh it: set FLUME_CLIENT_SECRET, add it to the profile, or run "flume login" again`, a.profile)
+	}
+	if err := c.RefreshAccessToken(ctx); err != nil {
+		if creds.username == "" || creds.password == "" {
+			return nil, fmt.Errorf(`refresh token: %w (run "flume login" again)`, err)
+		}
+		if err := c.Authenticate(ctx, creds.username, creds.password); err != nil {
+			return nil, fmt.Errorf("log in again: %w", err)
+		}
+	}
+	s.Token = c.Token
+	if err := a.saveSession(s); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readPassword prompts for the password on stdin. On a terminal, echo is
+// turned off with stty while it is typed, and the prompt is refused when
+// that is not possible, as on Windows; from a pipe, the first line is read
+// as is.
+func (a *app) readPassword() (string, error) {
+	if f, ok := a.stdin.(*os.File); ok && isTerminal(f) {
+		var restore func()
+		if runtime.GOOS != "windows" {
+			restore = sttyMode(f, "-echo")
+		}
+		if restore == nil {
+			return "", errors.New("cannot turn off terminal echo to prompt for the password; set FLUME_PASSWORD or pipe the password on stdin")
+		}
+		defer func() {
+			restore()
+			// The Enter that ended the password was not echoed either.
+			fmt.Fprintln(a.stderr)
+		}()
+	}
+	fmt.Fprint(a.stderr, "Password: ")
+	line, err := bufio.NewReader(a.stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runLogin(ctx context.Context, a *app, args []string) error {
+	creds := a.credentials()
+	fs := a.flagSet("login")
+	clientID := fs.String("client-id", creds.clientID, "API client ID (env FLUME_CLIENT_ID)")
+	clientSecret := fs.String("client-secret", creds.clientSecret, "API client secret (env FLUME_CLIENT_SECRET)")
+	username := fs.String("username", creds.username, "account email (env FLUME_USERNAME)")
+	baseURL := fs.String("base-url", creds.baseURL, "API base URL (env FLUME_BASE_URL)")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *clientID == "" || *clientSecret == "" || *username == "" {
+		return fmt.Errorf("%w: -client-id, -client-secret and -username are required", errUsage)
+	}
+	password := creds.password
+	if password == "" {
+		var err error
+		if password, err = a.readPassword(); err != nil {
+			return err
+		}
+	}
+
+	c := goflume.NewClient(*clientID, *clientSecret, a.httpClient)
+	if *baseURL != "" {
+		c.BaseURL = *baseURL
+	}
+	if err := c.Authenticate(ctx, *username, password); err != nil {
+		return err
+	}
+	s := &session{ClientID: *clientID, Token: c.Token}
+	if *baseURL != "" {
+		s.BaseURL = *baseURL
+	}
+	if err := a.saveSession(s); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "Logged in as %s (user %d) with profile %q.\n", c.JWT.Sub, c.JWT.UserID, a.profile)
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// datetimeLayout is the Flume query datetime format.
+const datetimeLayout = "2006-01-02 15:04:05"
+
+// unit maps a -units value to the API name and its display label.
+type unit struct {
+	api   string
+	label string
+}
+
+var units = map[string]unit{
+	"gallons":      {"GALLONS", "gal"},
+	"liters":       {"LITERS", "L"},
+	"cubic-feet":   {"CUBIC_FEET", "ft³"},
+	"cubic-meters": {"CUBIC_METERS", "m³"},
+}
+
+func lookupUnit(name string) (unit, error) {
+	key := strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	if key == "litres" {
+		key = "liters"
+	}
+	u, ok := units[key]
+	if !ok {
+		return unit{}, fmt.Errorf("%w: unknown units %q, use gallons, liters, cubic-feet or cubic-meters", errUsage, name)
+	}
+	return u, nil
+}
+
+// usageReport is the JSON form of "flume usage".
+type usageReport struct {
+	DeviceID string               `json:"device_id"`
+	Since    string               `json:"since"`
+	Until    string               `json:"until"`
+	Bucket   string               `json:"bucket"`
+	Units    string               `json:"units"`
+	Total    int                  `json:"total"`
+	Readings []goflume.UsageQuery `json:"readings"`
+}
+
+func runUsage(ctx context.Context, a *app, args []string) error {
+	fs := a.flagSet("usage")
+	format := outputFlag(fs)
+	device := deviceFlag(fs, a)
+	since := fs.String("since", "", `start of the range: a date, a datetime or a span back from now such as "7d", "12h" or "2w" (default 24h)`)
+	until := fs.String("until", "", "end of the range (default now)")
+	month := fs.String("month", "", "a calendar month, `YYYY-MM`")
+	today := fs.Bool("today", false, "since midnight")
+	bucket := fs.String("bucket", "", "bucket size: MIN, HR, DAY, MON or YR (default chosen from the range)")
+	unitName := fs.String("units", "gallons", "gallons, liters, cubic-feet or cubic-meters")
+	chart := fs.String("chart", "bars", "table chart: bars, spark or none")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	u, err := lookupUnit(*unitName)
+	if err != nil {
+		return err
+	}
+	if *chart != "bars" && *chart != "spark" && *chart != "none" {
+		return fmt.Errorf("%w: -chart must be bars, spark or none", errUsage)
+	}
+
+	c, err := a.client(ctx)
+	if err != nil {
+		return err
+	}
+	id, err := resolveDevice(ctx, c, *device)
+	if err != nil {
+		return err
+	}
+	loc := deviceLocation(ctx, c, id)
+	from, to, err := parseRange(*since, *until, *month, *today, a.now().In(loc))
+	if err != nil {
+		return err
+	}
+	b := strings.ToUpper(*bucket)
+	if b == "" {
+		b = autoBucket(to.Sub(from))
+	}
+	if !slices.Contains(goflume.UsageBuckets, b) {
+		return fmt.Errorf("%w: unknown bucket %q", errUsage, *bucket)
+	}
+
+	var readings []goflume.UsageQuery
+	for i, w := range goflume.SplitUsageRange(from, to, b) {
+		resp, err := c.QueryUsage(ctx, id, goflume.QueryUsageRequestBody{
+			RequestID:     "usage-" + strconv.Itoa(i),
+			Bucket:        b,
+			SinceDatetime: w[0].Format(datetimeLayout),
+			UntilDatetime: w[1].Format(datetimeLayout),
+			Units:         u.api,
+		})
+		if err != nil {
+			return err
+		}
+		readings = append(readings, resp.Data...)
+	}
+
+	report := usageReport{
+		DeviceID: id,
+		Since:    from.Format(datetimeLayout),
+		Until:    to.Format(datetimeLayout),
+		Bucket:   b,
+		Units:    u.api,
+		Readings: readings,
+	}
+	for _, r := range readings {
+		report.Total += r.Value
+	}
+
+	if *format != "table" && *format != "" {
+		t := table{header: []string{"DATETIME", "VALUE"}}
+		for _, r := range readings {
+			t.add(r.Datetime, r.Value)
+		}
+		return render(a.stdout, *format, report, t)
+	}
+	printUsage(a.stdout, report, u, *chart, to.Sub(from))
+	return nil
+}
+
+// printUsage writes the table view: readings with an optional bar per row, a
+// sparkline, and the totals.
+func printUsage(w io.Writer, r usageReport, u unit, chart string, span time.Duration) {
+	values := make([]int, len(r.Readings))
+	for i, q := range r.Readings {
+		values[i] = q.Value
+	}
+	t := table{header: []string{"DATETIME", strings.ToUpper(u.label)}}
+	if chart == "bars" {
+		t.header = append(t.header, "")
+		bars := barChart(values, 40)
+		for i, q := range r.Readings {
+			t.add(q.Datetime, q.Value, bars[i])
+		}
+	} else {
+		for _, q := range r.Readings {
+			t.add(q.Datetime, q.Value)
+		}
+	}
+	_ = render(w, "table", nil, t)
+	if chart == "spark" && len(values) > 0 {
+		fmt.Fprintf(w, "\n%s\n", sparkline(values))
+	}
+
+	fmt.Fprintf(w, "\nTotal: %d %s from %s to %s", r.Total, u.label, r.Since, r.Until)
+	if days := span.Hours() / 24; days >= 1 {
+		fmt.Fprintf(w, " (%.1f %s/day)", float64(r.Total)/days, u.label)
+	}
+	fmt.Fprintln(w)
+}
+
+// deviceLocation returns the time zone of the device's location so ranges
+// like -today mean the day at the house, falling back to the local zone.
+func deviceLocation(ctx context.Context, c *goflume.Client, deviceID string) *time.Location {
+	dev, err := c.GetDevice(ctx, deviceID, nil)
+	if err != nil || len(dev.Data) == 0 {
+		return time.Local
+	}
+	l, err := c.GetLocation(ctx, strconv.Itoa(dev.Data[0].LocationID))
+	if err != nil || len(l.Data) == 0 {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(l.Data[0].TZ)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// parseRange turns the range flags into [from, to] in now's location.
+func parseRange(since, until, month string, today bool, now time.Time) (time.Time, time.Time, error) {
+	set := 0
+	for _, given := range []bool{since != "", month != "", today} {
+		if given {
+			set++
+		}
+	}
+	if set > 1 {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: use only one of -since, -month and -today", errUsage)
+	}
+	if month != "" && until != "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: -until cannot be combined with -month", errUsage)
+	}
+
+	to := now
+	if until != "" {
+		t, err := parseDatetime(until, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		to = t
+	}
+
+	var from time.Time
+	switch {
+	case month != "":
+		m, err := time.ParseInLocation("2006-01", month, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: cannot parse %q as YYYY-MM", errUsage, month)
+		}
+		from, to = m, m.AddDate(0, 1, 0).Add(-time.Second)
+		if to.After(now) {
+			to = now
+		}
+	case today:
+		from = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	case since != "":
+		if d, ok := parseSpan(since); ok {
+			from = to.Add(-d)
+		} else {
+			t, err := parseDatetime(since, now.Location())
+			if err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+			from = t
+		}
+	default:
+		from = to.Add(-24 * time.Hour)
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: the range is empty", errUsage)
+	}
+	return from, to, nil
+}
+
+// parseSpan parses "30m", "12h", "7d" or "2w".
+func parseSpan(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	switch s[len(s)-1] {
+	case 'm':
+		return time.Duration(n) * time.Minute, true
+	case 'h':
+		return time.Duration(n) * time.Hour, true
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, true
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// parseDatetime accepts a Flume datetime or a bare date.
+func parseDatetime(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{datetimeLayout, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: cannot parse %q as a date", errUsage, s)
+}
+
+// autoBucket picks a bucket that gives a readable number of rows.
+func autoBucket(span time.Duration) string {
+	switch {
+	case span <= 3*time.Hour:
+		return "MIN"
+	case span <= 3*24*time.Hour:
+		return "HR"
+	case span <= 92*24*time.Hour:
+		return "DAY"
+	case span <= 3*366*24*time.Hour:
+		return "MON"
+	default:
+		return "YR"
+	}
+}
+
+// barChart renders each value as a row of '#' scaled to width.
+func barChart(values []int, width int) []string {
+	peak := 0
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	bars := make([]string, len(values))
+	if peak == 0 {
+		return bars
+	}
+	for i, v := range values {
+		n := int(math.Round(float64(v) / float64(peak) * float64(width)))
+		if v > 0 && n == 0 {
+			n = 1
+		}
+		bars[i] = strings.Repeat("#", n)
+	}
+	return bars
+}
+
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as a single line of block characters.
+func sparkline(values []int) string {
+	peak := 0
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if peak > 0 {
+			level = int(math.Round(float64(v) / float64(peak) * float64(len(sparkLevels)-1)))
+		}
+		b.WriteRune(sparkLevels[level])
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+func TestParseRange(t *testing.T) {
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	now := time.Date(2026, 9, 15, 10, 30, 0, 0, la)
+	for _, tc := range []struct {
+		name             string
+		since, until     string
+		month            string
+		today            bool
+		wantFrom, wantTo string
+		wantErr          bool
+	}{
+		{name: "default", wantFrom: "2026-09-14 10:30:00", wantTo: "2026-09-15 10:30:00"},
+		{name: "span", since: "7d", wantFrom: "2026-09-08 10:30:00", wantTo: "2026-09-15 10:30:00"},
+		{name: "span until", since: "12h", until: "2026-09-10", wantFrom: "2026-09-09 12:00:00", wantTo: "2026-09-10 00:00:00"},
+		{name: "date", since: "2026-09-01", wantFrom: "2026-09-01 00:00:00", wantTo: "2026-09-15 10:30:00"},
+		{name: "month", month: "2026-08", wantFrom: "2026-08-01 00:00:00", wantTo: "2026-08-31 23:59:59"},
+		{name: "current month", month: "2026-09", wantFrom: "2026-09-01 00:00:00", wantTo: "2026-09-15 10:30:00"},
+		{name: "today", today: true, wantFrom: "2026-09-15 00:00:00", wantTo: "2026-09-15 10:30:00"},
+		{name: "conflict", since: "7d", today: true, wantErr: true},
+		{name: "month until", month: "2026-08", until: "2026-08-02", wantErr: true},
+		{name: "bad", since: "yesterday", wantErr: true},
+		{name: "empty", since: "2026-09-20", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			from, to, err := parseRange(tc.since, tc.until, tc.month, tc.today, now)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v - %v", from, to)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := from.Format(datetimeLayout); got != tc.wantFrom {
+				t.Errorf("from = %s, want %s", got, tc.wantFrom)
+			}
+			if got := to.Format(datetimeLayout); got != tc.wantTo {
+				t.Errorf("to = %s, want %s", got, tc.wantTo)
+			}
+		})
+	}
+}
+
+func TestAutoBucket(t *testing.T) {
+	for span, want := range map[time.Duration]string{
+		time.Hour:                "MIN",
+		24 * time.Hour:           "HR",
+		7 * 24 * time.Hour:       "DAY",
+		31 * 24 * time.Hour:      "DAY",
+		365 * 24 * time.Hour:     "MON",
+		5 * 365 * 24 * time.Hour: "YR",
+	} {
+		if got := autoBucket(span); got != want {
+			t.Errorf("autoBucket(%v) = %s, want %s", span, got, want)
+		}
+	}
+}
+
+func TestCharts(t *testing.T) {
+	bars := barChart([]int{0, 1, 50, 100}, 10)
+	if bars[0] != "" || bars[1] != "#" || bars[2] != "#####" || bars[3] != "##########" {
+		t.Errorf("unexpected bars %q", bars)
+	}
+	if got := sparkline([]int{0, 7, 14}); got != "▁▅█" {
+		t.Errorf("unexpected sparkline %q", got)
+	}
+}
+
+func TestUsage_longRange(t *testing.T) {
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	start := time.Date(2026, 7, 1, 12, 0, 0, 0, la)
+	var readings []goflume.UsageQuery
+	for d := 0; d < 40; d++ {
+		readings = append(readings, goflume.UsageQuery{Value: 10, Datetime: start.AddDate(0, 0, d).Format(datetimeLayout)})
+	}
+	ta := newTestApp(t)
+	ta.srv.Update(func(s *flumetest.State) { s.Usage["6248148189204194987"] = readings })
+	ta.login(t)
+
+	code, out := ta.exec("usage", "-since", "2026-07-01", "-until", "2026-08-10", "-bucket", "hr", "-o", "json")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	var report usageReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 400 || report.Bucket != "HR" {
+		t.Errorf("unexpected report: total %d bucket %s", report.Total, report.Bucket)
+	}
+	if len(report.Readings) != 40*24+1 {
+		t.Errorf("expected one reading per hour, got %d", len(report.Readings))
+	}
+
+	code, out = ta.exec("usage", "-month", "2026-07", "-units", "liters")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, ta.stderr)
+	}
+	if !strings.Contains(out, "Total: 1178 L") || !strings.Contains(out, "#") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+type Result struct {
+	Change   Change
+	Envelope *goflume.APIResponseEnvelope
+	Err      error
+}
+
+// Apply issues the write calls of the plan in order and stops at the first
+// failure. The returned results cover every change that was attempted.
+func Apply(ctx context.Context, c *goflume.Client, plan *Plan) ([]Result, error) {
+	var results []Result
+	for _, change := range plan.Changes {
+		env, err := applyChange(ctx, c, change)
+		results = append(results, Result{Change: change, Envelope: env, Err: err})
+		if err != nil {
+			return results, fmt.Errorf("%s %s %q: %w", change.Action, change.Kind, change.Key, err)
+		}
+	}
+	return results, nil
+}
+
+func applyChange(ctx context.Context, c *goflume.Client, ch Change) (*goflume.APIResponseEnvelope, error) {
+	switch ch.Kind {
+	case KindLocation:
+		if ch.Action != ActionUpdate {
+			break
+		}
+		l := ch.After.(Location)
+		return c.UpdateLocation(ctx, ch.ID, goflume.LocationPatch{AwayMode: *l.AwayMode})
+
+	case KindSubscription:
+		switch ch.Action {
+		case ActionCreate:
+			s := ch.After.(Subscription)
+			resp, err := c.CreateSubscription(ctx, goflume.SubscriptionParams{
+				AlertType: s.AlertType, AlertInfo: s.AlertInfo, DeviceID: ch.DeviceID, NotificationTypes: s.Channels,
+			})
+			return envelope(resp, err)
+		case ActionUpdate:
+			id, err := strconv.Atoi(ch.ID)
+			if err != nil {
+				return nil, err
+			}
+			s := ch.After.(Subscription)
+			resp, err := c.UpdateSubscription(ctx, id, goflume.SubscriptionPatch{AlertInfo: &s.AlertInfo, NotificationTypes: &s.Channels})
+			return envelope(resp, err)
+		case ActionDelete:
+			id, err := strconv.Atoi(ch.ID)
+			if err != nil {
+				return nil, err
+			}
+			return c.DeleteSubscription(ctx, id)
+		}
+	}
+	return nil, fmt.Errorf("unsupported change %s %s", ch.Action, ch.Kind)
+}
+
+type enveloped interface {
+	Envelope() *goflume.APIResponseEnvelope
+}
+
+// envelope extracts the embedded envelope from any typed response.
+func envelope(resp enveloped, err error) (*goflume.APIResponseEnvelope, error) {
+	if err != nil {
+		return nil, err
+	}
+	return resp.Envelope(), nil
+}
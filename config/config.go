@@ -0,0 +1,130 @@
+// Package config keeps the writable parts of a Flume account, alert
+// subscriptions and location away mode, in a declarative JSON file
+// and reconciles the account against it. Budgets, usage alert rules, event
+// rules and contacts are read into the State but cannot be declared: the API
+// offers no documented way to change them.
+//
+// The workflow is Load the desired File, Fetch the current State, Diff the two
+// into a Plan, show the Plan to a human and finally Apply it.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+type File struct {
+	Locations []Location `json:"locations,omitempty"`
+	Devices   []Device   `json:"devices,omitempty"`
+}
+
+// Location is matched on ID when set and on Name otherwise. A location
+// without AwayMode is left as it is.
+type Location struct {
+	ID       int    `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	AwayMode *bool  `json:"away_mode,omitempty"`
+}
+
+type Device struct {
+	ID            string         `json:"id"`
+	Subscriptions []Subscription `json:"subscriptions,omitempty"`
+}
+
+// Subscription is matched on AlertType within its device.
+type Subscription struct {
+	AlertType goflume.AlertType           `json:"alert_type"`
+	AlertInfo string                      `json:"alert_info,omitempty"`
+	Channels  goflume.NotificationChannel `json:"notification_types"`
+}
+
+// Load reads a JSON File. YAML is not supported; files named .yaml or .yml are
+// rejected rather than guessed at.
+func Load(path string) (*File, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("config: %s: YAML is not supported, write the file as JSON", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	file, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Decode reads a File and rejects unknown fields so that typos in the file do
+// not silently turn into resources being pruned or left at zero values.
+func Decode(r io.Reader) (*File, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var file File
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	if err := file.Validate(); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (f *File) Encode(w io.Writer) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func (f *File) Validate() error {
+	for i, l := range f.Locations {
+		if l.ID == 0 && l.Name == "" {
+			return fmt.Errorf("locations[%d]: id or name is required", i)
+		}
+	}
+	seenDevices := map[string]bool{}
+	for i, d := range f.Devices {
+		if d.ID == "" {
+			return fmt.Errorf("devices[%d]: id is required", i)
+		}
+		if seenDevices[d.ID] {
+			return fmt.Errorf("devices[%d]: duplicate device %s", i, d.ID)
+		}
+		seenDevices[d.ID] = true
+		if err := unique(d.Subscriptions, func(s Subscription) string { return string(s.AlertType) }); err != nil {
+			return fmt.Errorf("devices[%d].subscriptions: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func unique[T any](items []T, key func(T) string) error {
+	seen := map[string]bool{}
+	for i, item := range items {
+		k := key(item)
+		if k == "" {
+			return fmt.Errorf("[%d]: missing identifying field", i)
+		}
+		if seen[k] {
+			return fmt.Errorf("[%d]: duplicate entry %q", i, k)
+		}
+		seen[k] = true
+	}
+	return nil
+}
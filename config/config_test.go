@@ -0,0 +1,318 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// The subscription channel bits the tests use; the API does not document them.
+const (
+	channelPush  = 1
+	channelEmail = 2
+	channelSMS   = 4
+)
+
+func testState() *State {
+	return &State{
+		Locations: []goflume.Location{{ID: 10, Name: "Home"}, {ID: 11, Name: "Cabin", AwayMode: true}},
+		Devices: []DeviceState{{
+			Device:          goflume.Device{ID: "d1"},
+			Budgets:         []goflume.Budget{{ID: 1, Name: "Monthly", Type: "MONTHLY", Value: 5000}, {ID: 2, Name: "Old", Type: "DAILY", Value: 10}},
+			UsageAlertRules: []goflume.UsageAlertRule{{ID: "u1", Name: "High", Enabled: true, Threshold: 50, Unit: "GALLONS"}},
+			EventRules:      []goflume.EventRule{{ID: "e1", Name: "Flow", Active: true, FlowRate: 2, Duration: 30}},
+			Subscriptions:   []goflume.Subscription{{ID: 5, AlertType: "budget", DeviceID: "d1", NotificationTypes: channelPush}},
+		}},
+		Contacts: []goflume.Contact{{ID: 3, Category: "primary", Type: "email", Detail: "a@b.com"}},
+	}
+}
+
+func TestDecode_rejectsUnknownFields(t *testing.T) {
+	_, err := Decode(strings.NewReader(`{"devices":[{"id":"d1","budgets":[]}]}`))
+	if err == nil || !strings.Contains(err.Error(), "budgets") {
+		t.Errorf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestDecode_rejectsDuplicates(t *testing.T) {
+	_, err := Decode(strings.NewReader(`{"devices":[{"id":"d1","subscriptions":[{"alert_type":"budget"},{"alert_type":"budget"}]}]}`))
+	if err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("expected duplicate error, got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flume.json")
+	const file = `{"locations": [{"name": "Cabin", "away_mode": true}], "devices": [{"id": "d1"}]}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Locations) != 1 || got.Locations[0].Name != "Cabin" || len(got.Devices) != 1 {
+		t.Errorf("unexpected file: %+v", got)
+	}
+
+	yamlPath := filepath.Join(dir, "flume.yaml")
+	if err := os.WriteFile(yamlPath, []byte("locations: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(yamlPath); err == nil || !strings.Contains(err.Error(), "YAML is not supported") {
+		t.Errorf("expected YAML to be rejected, got %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	away := true
+	desired := &File{
+		Locations: []Location{{Name: "Home", AwayMode: &away}, {ID: 11, AwayMode: &away}},
+		Devices: []Device{{
+			ID: "d1",
+			Subscriptions: []Subscription{
+				{AlertType: "budget", Channels: channelPush | channelEmail},
+				{AlertType: "low_battery", Channels: channelSMS},
+			},
+		}},
+	}
+
+	plan, err := Diff(testState(), desired, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, c := range plan.Changes {
+		got = append(got, fmt.Sprintf("%s %s %s %s", c.Action, c.Kind, c.Key, c.ID))
+	}
+	want := []string{
+		"update location Home 10",
+		"update subscription budget 5",
+		"create subscription low_battery ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected plan:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	pruned, err := Diff(testState(), &File{Devices: []Device{{ID: "d1"}}}, Options{Prune: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pruned.Count(ActionDelete) != 1 {
+		t.Errorf("expected subscription budget to be pruned, got %+v", pruned.Changes)
+	}
+}
+
+func TestDiff_awayModeUnset(t *testing.T) {
+	// The cabin is away; a file that does not mention away mode keeps it so.
+	desired, err := Decode(strings.NewReader(`{"locations":[{"name":"Cabin"},{"id":10}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := Diff(testState(), desired, Options{Prune: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("expected no changes, got %+v", plan.Changes)
+	}
+}
+
+func TestDiff_pruneDuplicates(t *testing.T) {
+	state := testState()
+	state.Devices[0].Subscriptions = append(state.Devices[0].Subscriptions,
+		goflume.Subscription{ID: 6, AlertType: "budget", DeviceID: "d1", NotificationTypes: channelEmail},
+		goflume.Subscription{ID: 7, AlertType: "budget", DeviceID: "d1", NotificationTypes: channelPush},
+	)
+	desired := &File{Devices: []Device{{ID: "d1", Subscriptions: []Subscription{
+		{AlertType: "budget", Channels: channelPush},
+	}}}}
+
+	plan, err := Diff(state, desired, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("expected duplicates to be left alone without prune, got %+v", plan.Changes)
+	}
+
+	plan, err = Diff(state, desired, Options{Prune: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, c := range plan.Changes {
+		got = append(got, fmt.Sprintf("%s %s", c.Action, c.ID))
+	}
+	if strings.Join(got, ",") != "delete 6,delete 7" {
+		t.Errorf("unexpected plan: %v", got)
+	}
+}
+
+func TestDiff_unknownResources(t *testing.T) {
+	if _, err := Diff(testState(), &File{Devices: []Device{{ID: "nope"}}}, Options{}); err == nil {
+		t.Error("expected error for unknown device, got nil")
+	}
+	if _, err := Diff(testState(), &File{Locations: []Location{{Name: "Nowhere"}}}, Options{}); err == nil {
+		t.Error("expected error for unknown location, got nil")
+	}
+}
+
+func TestPlan_Print(t *testing.T) {
+	plan, err := Diff(testState(), &File{Devices: []Device{{ID: "d1", Subscriptions: []Subscription{
+		{AlertType: "budget", Channels: channelPush | channelEmail},
+	}}}}, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := plan.Print(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"Plan: 0 to create, 1 to update, 0 to delete.", `~ subscription "budget" (device d1)`, "notification_types: 1 -> 3"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plan output missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	_ = (&Plan{}).Print(&buf)
+	if !strings.Contains(buf.String(), "No changes") {
+		t.Errorf("unexpected empty plan output: %s", buf.String())
+	}
+}
+
+type recordedRequest struct {
+	Method string
+	Path   string
+	Body   map[string]any
+}
+
+func TestApply(t *testing.T) {
+	var got []recordedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		rec := recordedRequest{Method: r.Method, Path: r.URL.Path}
+		_ = json.Unmarshal(b, &rec.Body)
+		got = append(got, rec)
+		if strings.HasPrefix(r.URL.Path, "/users/1/subscriptions") {
+			_, _ = io.WriteString(w, `{"success":true,"data":{}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"success":true,"data":[]}`)
+	}))
+	defer srv.Close()
+	c := goflume.NewClient("id", "secret", srv.Client())
+	c.BaseURL = srv.URL
+	c.JWT = goflume.JWTPayload{UserID: 1}
+
+	away := true
+	desired := &File{
+		Locations: []Location{{ID: 10, AwayMode: &away}},
+		Devices: []Device{{
+			ID:            "d1",
+			Subscriptions: []Subscription{{AlertType: "low_battery", Channels: channelSMS}},
+		}},
+	}
+	plan, err := Diff(testState(), desired, Options{Prune: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results, err := Apply(context.Background(), c, plan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != len(plan.Changes) {
+		t.Fatalf("expected %d results, got %d", len(plan.Changes), len(results))
+	}
+	var calls []string
+	for _, r := range got {
+		calls = append(calls, r.Method+" "+r.Path)
+	}
+	want := []string{
+		"PATCH /users/1/locations/10",
+		"POST /users/1/subscriptions",
+		"DELETE /users/1/subscriptions/5",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+	if got[1].Body["device_id"] != "d1" || got[1].Body["alert_type"] != "low_battery" {
+		t.Errorf("unexpected subscription body: %+v", got[1].Body)
+	}
+}
+
+func TestApply_stopsOnError(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	c := goflume.NewClient("id", "secret", srv.Client())
+	c.BaseURL = srv.URL
+	c.JWT = goflume.JWTPayload{UserID: 1}
+
+	plan := &Plan{Changes: []Change{
+		{Action: ActionDelete, Kind: KindSubscription, Key: "budget", ID: "1"},
+		{Action: ActionDelete, Kind: KindSubscription, Key: "low_battery", ID: "2"},
+	}}
+	results, err := Apply(context.Background(), c, plan)
+	if err == nil || !strings.Contains(err.Error(), `delete subscription "budget"`) {
+		t.Errorf("expected wrapped error, got %v", err)
+	}
+	if calls != 1 || len(results) != 1 || results[0].Err == nil {
+		t.Errorf("expected to stop after first failure, calls=%d results=%+v", calls, results)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		switch r.URL.Path {
+		case "/users/1/locations":
+			body = `{"data":[{"id":10,"name":"Home"}]}`
+		case "/users/1/devices":
+			body = `{"data":[{"id":"d1"},{"id":"d2"}]}`
+		case "/users/1/devices/d1/budgets":
+			body = `{"data":[{"id":1,"name":"Monthly"}]}`
+		case "/users/1/subscriptions":
+			body = `{"data":[{"id":5,"device_id":"d1","alert_type":"budget"},{"id":6,"device_id":"d2","alert_type":"budget"}]}`
+		case "/users/1/contacts":
+			body = `{"data":[{"id":3,"type":"email","detail":"a@b.com"}]}`
+		case "/users/1/devices/d1/usage_alert_rules", "/users/1/devices/d1/event_rules":
+			body = `{"data":[]}`
+		default:
+			http.Error(w, "unexpected "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	defer srv.Close()
+	c := goflume.NewClient("id", "secret", srv.Client())
+	c.BaseURL = srv.URL
+	c.JWT = goflume.JWTPayload{UserID: 1}
+
+	state, err := Fetch(context.Background(), c, "d1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(state.Devices) != 1 || len(state.Devices[0].Budgets) != 1 || len(state.Devices[0].Subscriptions) != 1 {
+		t.Errorf("unexpected device state: %+v", state.Devices)
+	}
+	if len(state.Locations) != 1 || len(state.Contacts) != 1 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+type Kind string
+
+const (
+	KindLocation     Kind = "location"
+	KindSubscription Kind = "subscription"
+)
+
+// Change is a single write call. Before and After hold the config shape of the
+// resource (Location or Subscription); Before is nil for creates and After is nil
+// for deletes. ID is the API identifier of the existing resource.
+type Change struct {
+	Action   Action
+	Kind     Kind
+	DeviceID string
+	Key      string
+	ID       string
+	Before   any
+	After    any
+}
+
+type Plan struct {
+	Changes []Change
+}
+
+type Options struct {
+	// Prune deletes subscriptions of the devices listed in the file that the
+	// file does not mention, and duplicates of those it does. Devices and
+	// locations that are not listed are never touched.
+	Prune bool
+}
+
+// Diff computes the changes needed to turn current into desired.
+func Diff(current *State, desired *File, opts Options) (*Plan, error) {
+	var plan Plan
+
+	for _, want := range desired.Locations {
+		var have *goflume.Location
+		for i, l := range current.Locations {
+			if (want.ID != 0 && l.ID == want.ID) || (want.ID == 0 && l.Name == want.Name) {
+				have = &current.Locations[i]
+				break
+			}
+		}
+		if have == nil {
+			return nil, fmt.Errorf("location %s not found", locationKey(want))
+		}
+		if want.AwayMode == nil || *want.AwayMode == have.AwayMode {
+			continue
+		}
+		before := locationFrom(*have)
+		after := before
+		after.AwayMode = want.AwayMode
+		plan.Changes = append(plan.Changes, Change{
+			Action: ActionUpdate, Kind: KindLocation, Key: have.Name, ID: strconv.Itoa(have.ID), Before: before, After: after,
+		})
+	}
+
+	for _, want := range desired.Devices {
+		have := current.device(want.ID)
+		if have == nil {
+			return nil, fmt.Errorf("device %s not found", want.ID)
+		}
+		plan.Changes = append(plan.Changes, diffSet(KindSubscription, want.ID, opts.Prune,
+			have.Subscriptions, func(s goflume.Subscription) (string, string, Subscription) {
+				return s.AlertType, strconv.Itoa(s.ID), subscriptionFrom(s)
+			},
+			want.Subscriptions, func(s Subscription) string { return string(s.AlertType) })...)
+	}
+
+	return &plan, nil
+}
+
+// diffSet matches current and desired resources of one kind on their key.
+// When several existing resources share a key the first one is kept and, with
+// prune, the others are deleted even if the file mentions the key.
+func diffSet[C any, D any](kind Kind, deviceID string, prune bool,
+	current []C, describe func(C) (key, id string, shape D),
+	desired []D, keyOf func(D) string) []Change {
+
+	type existing struct {
+		id    string
+		shape D
+	}
+	byKey := map[string][]existing{}
+	var order []string
+	for _, c := range current {
+		key, id, shape := describe(c)
+		if _, seen := byKey[key]; !seen {
+			order = append(order, key)
+		}
+		byKey[key] = append(byKey[key], existing{id: id, shape: shape})
+	}
+
+	var changes []Change
+	wanted := map[string]bool{}
+	for _, want := range desired {
+		key := keyOf(want)
+		wanted[key] = true
+		have, ok := byKey[key]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Action: ActionCreate, Kind: kind, DeviceID: deviceID, Key: key, After: want})
+		case !reflect.DeepEqual(normalize(have[0].shape), normalize(want)):
+			changes = append(changes, Change{Action: ActionUpdate, Kind: kind, DeviceID: deviceID, Key: key, ID: have[0].id, Before: have[0].shape, After: want})
+		}
+	}
+	if prune {
+		for _, key := range order {
+			have := byKey[key]
+			if wanted[key] {
+				have = have[1:]
+			}
+			for _, h := range have {
+				changes = append(changes, Change{Action: ActionDelete, Kind: kind, DeviceID: deviceID, Key: key, ID: h.id, Before: h.shape})
+			}
+		}
+	}
+	return changes
+}
+
+// normalize compares values by their JSON form so nil and empty slices are
+// treated alike.
+func normalize(v any) map[string]any {
+	b, _ := json.Marshal(v)
+	var m map[string]any
+	_ = json.Unmarshal(b, &m)
+	for k, val := range m {
+		if s, ok := val.([]any); ok && len(s) == 0 {
+			delete(m, k)
+		}
+	}
+	return m
+}
+
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+func (p *Plan) Count(action Action) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Print writes a human-readable summary of the plan.
+func (p *Plan) Print(w io.Writer) error {
+	var b strings.Builder
+	if p.Empty() {
+		b.WriteString("No changes. The account matches the configuration.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	fmt.Fprintf(&b, "Plan: %d to create, %d to update, %d to delete.\n\n",
+		p.Count(ActionCreate), p.Count(ActionUpdate), p.Count(ActionDelete))
+	for _, c := range p.Changes {
+		b.WriteString(c.String())
+		b.WriteString("\n")
+		for _, line := range c.fieldDiff() {
+			b.WriteString("      ")
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (c Change) String() string {
+	symbol := map[Action]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-"}[c.Action]
+	s := fmt.Sprintf("  %s %s %q", symbol, c.Kind, c.Key)
+	if c.DeviceID != "" {
+		s += fmt.Sprintf(" (device %s)", c.DeviceID)
+	}
+	return s
+}
+
+func (c Change) fieldDiff() []string {
+	before, after := normalize(c.Before), normalize(c.After)
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var lines []string
+	for _, k := range sorted {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		switch {
+		case c.Action == ActionCreate && inAfter:
+			lines = append(lines, fmt.Sprintf("%s: %s", k, jsonString(a)))
+		case c.Action == ActionUpdate && !reflect.DeepEqual(b, a):
+			from, to := "(unset)", "(unset)"
+			if inBefore {
+				from = jsonString(b)
+			}
+			if inAfter {
+				to = jsonString(a)
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s -> %s", k, from, to))
+		}
+	}
+	return lines
+}
+
+func jsonString(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func locationKey(l Location) string {
+	if l.ID != 0 {
+		return strconv.Itoa(l.ID)
+	}
+	return strconv.Quote(l.Name)
+}
+
+func locationFrom(l goflume.Location) Location {
+	away := l.AwayMode
+	return Location{ID: l.ID, Name: l.Name, AwayMode: &away}
+}
+
+func subscriptionFrom(s goflume.Subscription) Subscription {
+	return Subscription{AlertType: goflume.AlertType(s.AlertType), AlertInfo: s.AlertInfo, Channels: goflume.NotificationChannel(s.NotificationTypes)}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// State is the current configuration of an account as reported by the API.
+// It includes the read-only budgets, rules and contacts so that snapshots
+// record them.
+type State struct {
+	Locations []goflume.Location `json:"locations"`
+	Devices   []DeviceState      `json:"devices"`
+	Contacts  []goflume.Contact  `json:"contacts"`
+}
+
+type DeviceState struct {
+	Device          goflume.Device           `json:"device"`
+	Budgets         []goflume.Budget         `json:"budgets"`
+	UsageAlertRules []goflume.UsageAlertRule `json:"usage_alert_rules"`
+	EventRules      []goflume.EventRule      `json:"event_rules"`
+	Subscriptions   []goflume.Subscription   `json:"subscriptions"`
+}
+
+// FromState turns a State into the File that describes it, which is handy for
+// bootstrapping a configuration file from an existing account.
+func FromState(s *State) *File {
+	var f File
+	for _, l := range s.Locations {
+		f.Locations = append(f.Locations, locationFrom(l))
+	}
+	for _, ds := range s.Devices {
+		d := Device{ID: ds.Device.ID}
+		for _, sub := range ds.Subscriptions {
+			d.Subscriptions = append(d.Subscriptions, subscriptionFrom(sub))
+		}
+		f.Devices = append(f.Devices, d)
+	}
+	return &f
+}
+
+func (s *State) device(id string) *DeviceState {
+	for i := range s.Devices {
+		if s.Devices[i].Device.ID == id {
+			return &s.Devices[i]
+		}
+	}
+	return nil
+}
+
+const pageSize = int32(50)
+
+// Fetch reads the current state through the client's getters. When deviceIDs
+// is empty every device on the account is read, otherwise only the listed
+// ones, which keeps the number of requests down for large accounts.
+func Fetch(ctx context.Context, c *goflume.Client, deviceIDs ...string) (*State, error) {
+	var state State
+	var err error
+
+	state.Locations, err = goflume.CollectPages(pageSize, func(limit, offset *int32) ([]goflume.Location, error) {
+		resp, err := c.GetLocations(ctx, &goflume.GetLocationsParams{Limit: limit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch locations: %w", err)
+	}
+
+	devices, err := goflume.CollectPages(pageSize, func(limit, offset *int32) ([]goflume.Device, error) {
+		resp, err := c.GetDevices(ctx, &goflume.DevicesParams{Limit: limit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch devices: %w", err)
+	}
+	wanted := map[string]bool{}
+	for _, id := range deviceIDs {
+		wanted[id] = true
+	}
+	for _, d := range devices {
+		if len(wanted) > 0 && !wanted[d.ID] {
+			continue
+		}
+		ds, err := fetchDevice(ctx, c, d)
+		if err != nil {
+			return nil, err
+		}
+		state.Devices = append(state.Devices, *ds)
+	}
+
+	subs, err := goflume.CollectPages(pageSize, func(limit, offset *int32) ([]goflume.Subscription, error) {
+		resp, err := c.GetSubscriptions(ctx, &goflume.GetSubscriptionsParams{Limit: limit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch subscriptions: %w", err)
+	}
+	for _, s := range subs {
+		if ds := state.device(s.DeviceID); ds != nil {
+			ds.Subscriptions = append(ds.Subscriptions, s)
+		}
+	}
+
+	state.Contacts, err = goflume.CollectPages(pageSize, func(limit, offset *int32) ([]goflume.Contact, error) {
+		resp, err := c.GetContacts(ctx, &goflume.GetContactsParams{Limit: limit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch contacts: %w", err)
+	}
+	return &state, nil
+}
+
+func fetchDevice(ctx context.Context, c *goflume.Client, d goflume.Device) (*DeviceState, error) {
+	ds := DeviceState{Device: d}
+	var err error
+	ds.Budgets, err = goflume.CollectPages(pageSize, func(limit, offset *int32) ([]goflume.Budget, error) {
+		resp, err := c.GetBudgets(ctx, d.ID, &goflume.GetBudgetsParams{Limit: limit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch budgets for device %s: %w", d.ID, err)
+	}
+	ds.UsageAlertRules, err = goflume.CollectPages(pageSize, func(limit, offset *int32) ([]goflume.UsageAlertRule, error) {
+		resp, err := c.GetUsageAlertRules(ctx, d.ID, &goflume.GetUsageAlertRulesParams{Limit: limit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch usage alert rules for device %s: %w", d.ID, err)
+	}
+	ds.EventRules, err = goflume.CollectPages(pageSize, func(limit, offset *int32) ([]goflume.EventRule, error) {
+		resp, err := c.GetEventRules(ctx, d.ID, &goflume.GetEventRulesParams{Limit: limit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch event rules for device %s: %w", d.ID, err)
+	}
+	return &ds, nil
+}
@@ -0,0 +1,167 @@
+package goflume
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UsageSeries is the usage of one device over the window of a usage alert.
+type UsageSeries struct {
+	DeviceID string       `json:"device_id"`
+	Usage    []UsageQuery `json:"usage"`
+	Gallons  float64      `json:"gallons"`
+	// PeakGPM is the highest average rate of a single bucket, so it is
+	// only as sharp as the bucket of the query.
+	PeakGPM float64 `json:"peak_gpm"`
+	// Duration runs from the start of the first bucket with usage to the
+	// end of the last one.
+	Duration time.Duration `json:"duration"`
+}
+
+// EnrichedUsageAlert is a usage alert with the usage that triggered it.
+// Gallons and Duration cover all devices of the alert and PeakGPM is the
+// highest of their peaks.
+type EnrichedUsageAlert struct {
+	UsageAlert
+	Series   []UsageSeries `json:"series"`
+	Gallons  float64       `json:"gallons"`
+	PeakGPM  float64       `json:"peak_gpm"`
+	Duration time.Duration `json:"duration"`
+}
+
+// EnrichUsageAlert re-runs the query that triggered an alert for each of its
+// devices.
+func (c *Client) EnrichUsageAlert(ctx context.Context, alert UsageAlert) (*EnrichedUsageAlert, error) {
+	return c.enrichUsageAlert(ctx, alert, nil)
+}
+
+// AllUsageAlertsEnriched enriches a page of GetUsageAlerts results. Every
+// request, including the one for the page, waits for the budget so that a
+// long page stays inside the rate limit; a nil budget allows
+// DefaultRequestLimit requests per hour.
+func (c *Client) AllUsageAlertsEnriched(ctx context.Context, params *GetUsageAlertsParams, budget *RequestBudget) ([]EnrichedUsageAlert, error) {
+	if budget == nil {
+		budget = &RequestBudget{}
+	}
+	if err := budget.Wait(ctx, 1); err != nil {
+		return nil, err
+	}
+	resp, err := c.GetUsageAlerts(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]EnrichedUsageAlert, 0, len(resp.Data))
+	for _, alert := range resp.Data {
+		e, err := c.enrichUsageAlert(ctx, alert, budget)
+		if err != nil {
+			return out, fmt.Errorf("usage alert %d: %w", alert.ID, err)
+		}
+		out = append(out, *e)
+	}
+	return out, nil
+}
+
+func (c *Client) enrichUsageAlert(ctx context.Context, alert UsageAlert, budget *RequestBudget) (*EnrichedUsageAlert, error) {
+	q := alert.Query
+	if q.SinceDatetime == "" {
+		return nil, fmt.Errorf("usage alert %d has no query window", alert.ID)
+	}
+	tz := time.UTC
+	if q.TZ != "" {
+		var err error
+		if tz, err = time.LoadLocation(q.TZ); err != nil {
+			return nil, fmt.Errorf("usage alert %d: %w", alert.ID, err)
+		}
+	}
+	bucket := strings.ToUpper(q.Bucket)
+	if bucket == "" {
+		bucket = "MIN"
+	}
+	devices := q.DeviceID
+	if len(devices) == 0 {
+		devices = []string{alert.DeviceID}
+	}
+	requestID := q.RequestID
+	if requestID == "" {
+		requestID = "usage-alert-" + strconv.Itoa(alert.ID)
+	}
+
+	e := &EnrichedUsageAlert{UsageAlert: alert}
+	var first, last time.Time
+	for _, id := range devices {
+		if budget != nil {
+			if err := budget.Wait(ctx, 1); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := c.QueryUsage(ctx, id, QueryUsageRequestBody{
+			RequestID:     requestID,
+			Bucket:        bucket,
+			SinceDatetime: q.SinceDatetime,
+			UntilDatetime: q.UntilDatetime,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query usage of %s: %w", id, err)
+		}
+		s := UsageSeries{DeviceID: id, Usage: resp.Data}
+		var start, end time.Time
+		for _, row := range resp.Data {
+			from, err := time.ParseInLocation(usageDatetimeLayout, row.Datetime, tz)
+			if err != nil {
+				return nil, fmt.Errorf("usage of %s: %w", id, err)
+			}
+			if row.Value <= 0 {
+				continue
+			}
+			to, err := bucketEnd(from, bucket)
+			if err != nil {
+				return nil, err
+			}
+			s.Gallons += float64(row.Value)
+			s.PeakGPM = max(s.PeakGPM, float64(row.Value)/to.Sub(from).Minutes())
+			if start.IsZero() || from.Before(start) {
+				start = from
+			}
+			end = maxTime(end, to)
+		}
+		s.Duration = end.Sub(start)
+		e.Series = append(e.Series, s)
+		e.Gallons += s.Gallons
+		e.PeakGPM = max(e.PeakGPM, s.PeakGPM)
+		if !start.IsZero() {
+			if first.IsZero() || start.Before(first) {
+				first = start
+			}
+			last = maxTime(last, end)
+		}
+	}
+	e.Duration = last.Sub(first)
+	return e, nil
+}
+
+// bucketEnd returns the end of the usage bucket starting at from.
+func bucketEnd(from time.Time, bucket string) (time.Time, error) {
+	switch bucket {
+	case "MIN":
+		return from.Add(time.Minute), nil
+	case "HR":
+		return from.Add(time.Hour), nil
+	case "DAY":
+		return from.AddDate(0, 0, 1), nil
+	case "MON":
+		return from.AddDate(0, 1, 0), nil
+	case "YR":
+		return from.AddDate(1, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown usage bucket %q", bucket)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
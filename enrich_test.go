@@ -0,0 +1,93 @@
+package goflume
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEnrichClient(t *testing.T, alerts []UsageAlert, usage map[string][]UsageQuery) *Client {
+	return &Client{
+		BaseURL: "http://flume.test",
+		JWT:     JWTPayload{UserID: 1},
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			var data any = alerts
+			if r.Method == http.MethodPost {
+				var body QueryUsageRequestBody
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Error(err)
+				}
+				if body.SinceDatetime != "2026-09-01 02:00:00" || body.UntilDatetime != "2026-09-01 02:59:00" || body.Bucket != "MIN" || body.RequestID != "alert-req" {
+					t.Errorf("query = %+v", body)
+				}
+				data = usage[strings.Split(r.URL.Path, "/")[4]]
+			}
+			b, _ := json.Marshal(map[string]any{"success": true, "data": data})
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(string(b))), Header: make(http.Header)}, nil
+		})},
+	}
+}
+
+func TestEnrichUsageAlert(t *testing.T) {
+	alert := UsageAlert{ID: 9, DeviceID: "a", Query: UsageAlertQuery{
+		RequestID: "alert-req", SinceDatetime: "2026-09-01 02:00:00", UntilDatetime: "2026-09-01 02:59:00",
+		TZ: "America/Los_Angeles", Bucket: "MIN", DeviceID: []string{"a", "b"},
+	}}
+	c := newEnrichClient(t, nil, map[string][]UsageQuery{
+		"a": {{0, "2026-09-01 02:10:00"}, {3, "2026-09-01 02:11:00"}, {1, "2026-09-01 02:12:00"}, {0, "2026-09-01 02:13:00"}},
+		"b": {{2, "2026-09-01 02:20:00"}},
+	})
+	e, err := c.EnrichUsageAlert(context.Background(), alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Series) != 2 || e.ID != 9 {
+		t.Fatalf("enriched = %+v", e)
+	}
+	if a := e.Series[0]; a.DeviceID != "a" || a.Gallons != 4 || a.PeakGPM != 3 || a.Duration != 2*time.Minute || len(a.Usage) != 4 {
+		t.Errorf("series a = %+v", a)
+	}
+	if e.Gallons != 6 || e.PeakGPM != 3 || e.Duration != 10*time.Minute {
+		t.Errorf("totals: %v gal, %v gpm, %v", e.Gallons, e.PeakGPM, e.Duration)
+	}
+}
+
+func TestEnrichUsageAlert_hourBuckets(t *testing.T) {
+	alert := UsageAlert{ID: 1, DeviceID: "a", Query: UsageAlertQuery{SinceDatetime: "2026-09-01 00:00:00", Bucket: "HR"}}
+	c := &Client{BaseURL: "http://flume.test", JWT: JWTPayload{UserID: 1}, HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header),
+			Body: io.NopCloser(strings.NewReader(`{"success":true,"data":[{"value":120,"datetime":"2026-09-01 03:00:00"}]}`))}, nil
+	})}}
+	e, err := c.EnrichUsageAlert(context.Background(), alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.PeakGPM != 2 || e.Duration != time.Hour {
+		t.Errorf("peak %v gpm over %v", e.PeakGPM, e.Duration)
+	}
+
+	if _, err := c.EnrichUsageAlert(context.Background(), UsageAlert{ID: 2}); err == nil {
+		t.Error("expected an error for an alert without a query")
+	}
+}
+
+func TestAllUsageAlertsEnriched(t *testing.T) {
+	q := UsageAlertQuery{RequestID: "alert-req", SinceDatetime: "2026-09-01 02:00:00", UntilDatetime: "2026-09-01 02:59:00", Bucket: "MIN"}
+	alerts := []UsageAlert{{ID: 1, DeviceID: "a", Query: q}, {ID: 2, DeviceID: "b", Query: q}}
+	c := newEnrichClient(t, alerts, map[string][]UsageQuery{"a": {{5, "2026-09-01 02:30:00"}}})
+	budget := &RequestBudget{Limit: 10}
+	got, err := c.AllUsageAlertsEnriched(context.Background(), nil, budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Gallons != 5 || got[1].Gallons != 0 || got[1].Duration != 0 {
+		t.Errorf("enriched = %+v", got)
+	}
+	if left := budget.Remaining(); left != 7 {
+		t.Errorf("%d requests left, want 7", left)
+	}
+}
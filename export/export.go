@@ -0,0 +1,301 @@
+// Package export writes a complete backup of a Flume account to a directory:
+// the user, locations, devices with their budgets, rules and subscriptions,
+// contacts, notifications, usage alerts and the usage history, together with
+// a manifest that lets later runs fetch only new usage and notifications.
+//
+// The layout of an export directory is
+//
+//	manifest.json
+//	user.json
+//	locations.json
+//	contacts.json
+//	notifications.json
+//	usage_alerts.json
+//	devices/<id>/device.json
+//	devices/<id>/budgets.json
+//	devices/<id>/event_rules.json
+//	devices/<id>/usage_alert_rules.json
+//	devices/<id>/subscriptions.json
+//	devices/<id>/usage.csv
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/config"
+)
+
+// FormatVersion is the manifest format written by this package.
+const FormatVersion = 1
+
+// ManifestFile is the name of the manifest inside an export directory.
+const ManifestFile = "manifest.json"
+
+// meterDeviceType is the Device.Type of a water sensor; bridges have no usage.
+const meterDeviceType = 2
+
+// Options controls an export.
+type Options struct {
+	// Dir is the export directory. It is created if needed.
+	Dir string
+
+	// Bucket is the usage history resolution, MIN, HR or DAY. Defaults to HR.
+	// An incremental export must use the bucket of the previous one.
+	Bucket string
+
+	// Since is where the usage history starts on a first export. Its date and
+	// clock time are read in each device's time zone, like Flume datetimes.
+	// When zero the start is found by looking for the first year with any
+	// usage.
+	Since time.Time
+
+	// Full ignores an existing manifest and exports everything again.
+	Full bool
+
+	// Now replaces time.Now.
+	Now func() time.Time
+}
+
+// Manifest describes the contents of an export directory.
+type Manifest struct {
+	Version     int       `json:"version"`
+	ExportedAt  time.Time `json:"exported_at"`
+	UserID      int       `json:"user_id"`
+	Bucket      string    `json:"bucket"`
+	Incremental bool      `json:"incremental"`
+	Files       []File    `json:"files"`
+
+	// Usage holds the extent of each device's usage history.
+	Usage map[string]UsageRange `json:"usage"`
+
+	// LastNotificationID is the highest notification ID exported so far.
+	LastNotificationID int `json:"last_notification_id"`
+}
+
+// File is one file of an export.
+type File struct {
+	Path    string `json:"path"`
+	Records int    `json:"records"`
+	Added   int    `json:"added"` // records new in this run
+	SHA256  string `json:"sha256"`
+}
+
+// UsageRange is the exported usage history of one device, as Flume
+// datetimes in the device's location time zone. Until is the end of the
+// last complete bucket written.
+type UsageRange struct {
+	TZ    string `json:"tz"`
+	Since string `json:"since"`
+	Until string `json:"until"`
+}
+
+// ReadManifest loads the manifest of an export directory.
+func ReadManifest(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if m.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return &m, nil
+}
+
+// Run exports the account of c into opts.Dir and returns the new manifest.
+// When the directory holds a previous export with the same bucket, only usage
+// after its end and notifications newer than its last one are fetched; the
+// rest of the account is small and is always exported again.
+func Run(ctx context.Context, c *goflume.Client, opts Options) (*Manifest, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("export directory cannot be empty")
+	}
+	if opts.Bucket == "" {
+		opts.Bucket = "HR"
+	}
+	if opts.Bucket != "MIN" && opts.Bucket != "HR" && opts.Bucket != "DAY" {
+		return nil, fmt.Errorf("unsupported bucket %q, use MIN, HR or DAY", opts.Bucket)
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	var prev *Manifest
+	if !opts.Full {
+		m, err := ReadManifest(opts.Dir)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, err
+		case m.Bucket != opts.Bucket:
+			return nil, fmt.Errorf("previous export used bucket %s, export with Full to change it", m.Bucket)
+		default:
+			prev = m
+		}
+	}
+
+	e := &exporter{c: c, opts: opts, prev: prev, manifest: &Manifest{
+		Version:     FormatVersion,
+		ExportedAt:  opts.Now().UTC(),
+		UserID:      c.JWT.UserID,
+		Bucket:      opts.Bucket,
+		Incremental: prev != nil,
+		Usage:       map[string]UsageRange{},
+	}}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := e.run(ctx); err != nil {
+		return nil, err
+	}
+	// The manifest goes last: a run that fails before it is resumed from the
+	// previous manifest, and the usage and notification files drop what that
+	// run had already added beyond it.
+	if err := writeJSON(filepath.Join(opts.Dir, ManifestFile), e.manifest); err != nil {
+		return nil, err
+	}
+	return e.manifest, nil
+}
+
+type exporter struct {
+	c        *goflume.Client
+	opts     Options
+	prev     *Manifest
+	manifest *Manifest
+}
+
+func (e *exporter) run(ctx context.Context) error {
+	user, err := e.c.GetUser(ctx)
+	if err != nil {
+		return fmt.Errorf("fetch user: %w", err)
+	}
+	if err := e.writeJSON("user.json", user.Data, len(user.Data)); err != nil {
+		return err
+	}
+
+	state, err := config.Fetch(ctx, e.c)
+	if err != nil {
+		return err
+	}
+	if err := e.writeJSON("locations.json", state.Locations, len(state.Locations)); err != nil {
+		return err
+	}
+	if err := e.writeJSON("contacts.json", state.Contacts, len(state.Contacts)); err != nil {
+		return err
+	}
+
+	zones := map[int]string{}
+	for _, l := range state.Locations {
+		zones[l.ID] = l.TZ
+	}
+	for _, ds := range state.Devices {
+		dir := filepath.Join("devices", ds.Device.ID)
+		// A slice rather than a map keeps Manifest.Files in the same order
+		// on every run.
+		for _, f := range []struct {
+			name string
+			v    any
+		}{
+			{"device.json", ds.Device},
+			{"budgets.json", ds.Budgets},
+			{"event_rules.json", ds.EventRules},
+			{"usage_alert_rules.json", ds.UsageAlertRules},
+			{"subscriptions.json", ds.Subscriptions},
+		} {
+			if err := e.writeJSON(filepath.Join(dir, f.name), f.v, count(f.v)); err != nil {
+				return err
+			}
+		}
+		if ds.Device.Type == meterDeviceType {
+			if err := e.exportUsage(ctx, ds.Device, zones[ds.Device.LocationID]); err != nil {
+				return fmt.Errorf("export usage for device %s: %w", ds.Device.ID, err)
+			}
+		}
+	}
+
+	if err := e.exportNotifications(ctx); err != nil {
+		return err
+	}
+	alerts, err := goflume.CollectPages(pageSize, func(limit, offset *int32) ([]goflume.UsageAlert, error) {
+		resp, err := e.c.GetUsageAlerts(ctx, &goflume.GetUsageAlertsParams{Limit: limit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	})
+	if err != nil {
+		return fmt.Errorf("fetch usage alerts: %w", err)
+	}
+	return e.writeJSON("usage_alerts.json", alerts, len(alerts))
+}
+
+// writeJSON writes v to a file of the export and records it in the manifest.
+func (e *exporter) writeJSON(rel string, v any, records int) error {
+	path := filepath.Join(e.opts.Dir, rel)
+	if err := writeJSON(path, v); err != nil {
+		return err
+	}
+	return e.record(rel, records, records)
+}
+
+func (e *exporter) record(rel string, records, added int) error {
+	b, err := os.ReadFile(filepath.Join(e.opts.Dir, rel))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	e.manifest.Files = append(e.manifest.Files, File{
+		Path:    filepath.ToSlash(rel),
+		Records: records,
+		Added:   added,
+		SHA256:  hex.EncodeToString(sum[:]),
+	})
+	return nil
+}
+
+// writeJSON replaces path atomically so a crash never leaves half a file.
+func writeJSON(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// count returns the number of records in v: its length for slices, one
+// otherwise.
+func count(v any) int {
+	switch v := v.(type) {
+	case []goflume.Budget:
+		return len(v)
+	case []goflume.EventRule:
+		return len(v)
+	case []goflume.UsageAlertRule:
+		return len(v)
+	case []goflume.Subscription:
+		return len(v)
+	}
+	return 1
+}
+
+const pageSize = int32(100)
@@ -0,0 +1,219 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+const deviceID = "6248148189204194987"
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newServerClient(t *testing.T, clk *clock) (*flumetest.Server, *goflume.Client) {
+	t.Helper()
+	srv := flumetest.NewServer(flumetest.WithClock(clk.now), flumetest.WithTokenTTL(30*24*time.Hour))
+	t.Cleanup(srv.Close)
+	c := srv.Client()
+	if err := c.Authenticate(context.Background(), flumetest.Username, flumetest.Password); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	return srv, c
+}
+
+func readUsage(t *testing.T, dir string) []string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, "devices", deviceID, "usage.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func fileEntry(m *Manifest, path string) File {
+	for _, f := range m.Files {
+		if f.Path == path {
+			return f
+		}
+	}
+	return File{}
+}
+
+func TestRun_incremental(t *testing.T) {
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	clk := &clock{t: time.Date(2026, 9, 15, 10, 30, 0, 0, la)}
+	srv, c := newServerClient(t, clk)
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = []goflume.UsageQuery{
+			{Value: 7, Datetime: "2026-03-02 08:15:00"},
+			{Value: 5, Datetime: "2026-09-14 07:00:00"},
+			{Value: 9, Datetime: "2026-09-15 09:00:00"}, // today, incomplete
+		}
+		s.Notifications = []goflume.Notification{
+			{ID: 1, DeviceID: deviceID, Title: "Low battery"},
+			{ID: 2, DeviceID: deviceID, Title: "High flow"},
+		}
+	})
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	m, err := Run(ctx, c, Options{Dir: dir, Bucket: "DAY", Now: clk.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Incremental {
+		t.Error("first export marked incremental")
+	}
+	want := UsageRange{TZ: "America/Los_Angeles", Since: "2026-01-01 00:00:00", Until: "2026-09-14 23:59:59"}
+	if got := m.Usage[deviceID]; got != want {
+		t.Errorf("usage range = %+v, want %+v", got, want)
+	}
+	rows := readUsage(t, dir)
+	if rows[0] != "datetime,gallons" || len(rows) != 1+257 {
+		t.Fatalf("usage.csv has %d lines starting %q", len(rows), rows[0])
+	}
+	if rows[61] != "2026-03-02 00:00:00,7" || rows[len(rows)-1] != "2026-09-14 00:00:00,5" {
+		t.Errorf("unexpected rows %q and %q", rows[61], rows[len(rows)-1])
+	}
+	if m.LastNotificationID != 2 {
+		t.Errorf("last notification = %d, want 2", m.LastNotificationID)
+	}
+	for _, path := range []string{"user.json", "locations.json", "contacts.json", "usage_alerts.json",
+		"devices/" + deviceID + "/device.json", "devices/" + deviceID + "/budgets.json"} {
+		if fileEntry(m, path).SHA256 == "" {
+			t.Errorf("manifest is missing %s", path)
+		}
+	}
+
+	clk.t = clk.t.AddDate(0, 0, 2)
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = append(s.Usage[deviceID], goflume.UsageQuery{Value: 4, Datetime: "2026-09-16 12:00:00"})
+		s.Notifications = append(s.Notifications, goflume.Notification{ID: 3, DeviceID: deviceID, Title: "Leak"})
+	})
+	m, err = Run(ctx, c, Options{Dir: dir, Bucket: "DAY", Now: clk.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Incremental {
+		t.Error("second export not incremental")
+	}
+	rows = readUsage(t, dir)
+	if got := rows[len(rows)-2:]; got[0] != "2026-09-15 00:00:00,9" || got[1] != "2026-09-16 00:00:00,4" {
+		t.Errorf("appended rows = %q", got)
+	}
+	if f := fileEntry(m, "devices/"+deviceID+"/usage.csv"); f.Records != 259 || f.Added != 2 {
+		t.Errorf("usage.csv entry = %+v", f)
+	}
+	if f := fileEntry(m, "notifications.json"); f.Records != 3 || f.Added != 1 {
+		t.Errorf("notifications.json entry = %+v", f)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "notifications.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notes []goflume.Notification
+	if err := json.Unmarshal(b, &notes); err != nil || len(notes) != 3 || notes[2].Title != "Leak" {
+		t.Errorf("notifications = %+v, %v", notes, err)
+	}
+
+	// Nothing new since the last run.
+	m, err = Run(ctx, c, Options{Dir: dir, Bucket: "DAY", Now: clk.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := fileEntry(m, "devices/"+deviceID+"/usage.csv"); f.Records != 259 || f.Added != 0 {
+		t.Errorf("usage.csv entry = %+v", f)
+	}
+	if got := m.Usage[deviceID].Until; got != "2026-09-16 23:59:59" {
+		t.Errorf("until = %s", got)
+	}
+}
+
+func TestRun_resumesInterruptedRun(t *testing.T) {
+	clk := &clock{t: time.Date(2026, 9, 15, 10, 30, 0, 0, time.UTC)}
+	srv, c := newServerClient(t, clk)
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = []goflume.UsageQuery{{Value: 5, Datetime: "2026-09-14 07:00:00"}}
+		s.Notifications = []goflume.Notification{{ID: 1, DeviceID: deviceID, Title: "Low battery"}}
+	})
+	dir := t.TempDir()
+	ctx := context.Background()
+	first, err := Run(ctx, c, Options{Dir: dir, Bucket: "DAY", Now: clk.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A run a day later that wrote its data but failed before the manifest.
+	clk.t = clk.t.AddDate(0, 0, 1)
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = append(s.Usage[deviceID], goflume.UsageQuery{Value: 3, Datetime: "2026-09-15 12:00:00"})
+		s.Notifications = append(s.Notifications, goflume.Notification{ID: 2, DeviceID: deviceID, Title: "Leak"})
+	})
+	usage := filepath.Join(dir, "devices", deviceID, "usage.csv")
+	f, err := os.OpenFile(usage, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("2026-09-15 00:00:00,3\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	notes := []goflume.Notification{{ID: 1, DeviceID: deviceID, Title: "Low battery"}, {ID: 2, DeviceID: deviceID, Title: "Leak"}}
+	b, _ := json.Marshal(notes)
+	if err := os.WriteFile(filepath.Join(dir, "notifications.json"), b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Run(ctx, c, Options{Dir: dir, Bucket: "DAY", Now: clk.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := readUsage(t, dir); len(rows) != 1+258 || rows[len(rows)-2] != "2026-09-14 00:00:00,5" || rows[len(rows)-1] != "2026-09-15 00:00:00,3" {
+		t.Errorf("usage.csv has %d rows ending %q", len(rows)-1, rows[len(rows)-2:])
+	}
+	if f := fileEntry(m, "devices/"+deviceID+"/usage.csv"); f.Records != 258 || f.Added != 1 {
+		t.Errorf("usage.csv entry = %+v", f)
+	}
+	if f := fileEntry(m, "notifications.json"); f.Records != 2 || f.Added != 1 {
+		t.Errorf("notifications.json entry = %+v", f)
+	}
+
+	if len(m.Files) != len(first.Files) {
+		t.Fatalf("got %d files, then %d", len(first.Files), len(m.Files))
+	}
+	for i := range m.Files {
+		if m.Files[i].Path != first.Files[i].Path {
+			t.Errorf("file %d is %s, was %s", i, m.Files[i].Path, first.Files[i].Path)
+		}
+	}
+}
+
+func TestRun_bucketMismatch(t *testing.T) {
+	clk := &clock{t: time.Date(2026, 9, 15, 10, 30, 0, 0, time.UTC)}
+	_, c := newServerClient(t, clk)
+	dir := t.TempDir()
+	ctx := context.Background()
+	since := clk.t.AddDate(0, 0, -2)
+	if _, err := Run(ctx, c, Options{Dir: dir, Bucket: "HR", Since: since, Now: clk.now}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(ctx, c, Options{Dir: dir, Bucket: "DAY", Now: clk.now}); err == nil {
+		t.Fatal("expected an error when the bucket changes")
+	}
+	m, err := Run(ctx, c, Options{Dir: dir, Bucket: "DAY", Since: since, Full: true, Now: clk.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readUsage(t, dir)) != 1+2 || m.Incremental {
+		t.Errorf("full export did not start over: %d rows", len(readUsage(t, dir))-1)
+	}
+}
@@ -0,0 +1,83 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// exportNotifications merges notifications newer than the previous export
+// into notifications.json. They are fetched newest first so an incremental
+// run stops at the first page reaching an already exported ID.
+func (e *exporter) exportNotifications(ctx context.Context) error {
+	const rel = "notifications.json"
+	var (
+		existing []goflume.Notification
+		lastID   int
+	)
+	if e.prev != nil {
+		b, err := os.ReadFile(filepath.Join(e.opts.Dir, rel))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &existing); err != nil {
+				return fmt.Errorf("read previous notifications: %w", err)
+			}
+			lastID = e.prev.LastNotificationID
+		}
+	}
+
+	// The file may hold notifications newer than lastID from a run that
+	// failed before writing its manifest; they are fetched again, not
+	// duplicated.
+	kept := existing[:0]
+	for _, n := range existing {
+		if n.ID <= lastID {
+			kept = append(kept, n)
+		}
+	}
+	existing = kept
+
+	field, direction := "id", "DESC"
+	limit := pageSize
+	var fresh []goflume.Notification
+	for offset := int32(0); ; offset += limit {
+		o := offset
+		resp, err := e.c.GetNotifications(ctx, &goflume.GetNotificationsParams{
+			Limit: &limit, Offset: &o, SortField: &field, SortDirection: &direction,
+		})
+		if err != nil {
+			return fmt.Errorf("fetch notifications: %w", err)
+		}
+		done := len(resp.Data) < int(limit)
+		for _, n := range resp.Data {
+			if n.ID <= lastID {
+				done = true
+				break
+			}
+			fresh = append(fresh, n)
+		}
+		if done {
+			break
+		}
+	}
+
+	all := append(existing, fresh...)
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	if len(all) > 0 {
+		lastID = all[len(all)-1].ID
+	}
+	e.manifest.LastNotificationID = lastID
+	if err := writeJSON(filepath.Join(e.opts.Dir, rel), all); err != nil {
+		return err
+	}
+	return e.record(rel, len(all), len(fresh))
+}
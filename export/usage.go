@@ -0,0 +1,186 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+const datetimeLayout = "2006-01-02 15:04:05"
+
+// historyYears bounds the search for the first year with usage.
+const historyYears = 10
+
+// exportUsage adds the complete buckets since the previous export to the
+// device's usage.csv, or writes the whole history on a first export. The file
+// is replaced atomically, and an interrupted run leaves nothing the next one
+// would duplicate.
+func (e *exporter) exportUsage(ctx context.Context, d goflume.Device, tz string) error {
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "" {
+		loc = time.UTC
+	}
+	rel := filepath.Join("devices", d.ID, "usage.csv")
+	path := filepath.Join(e.opts.Dir, rel)
+
+	// Only whole buckets are exported so a later run never has to rewrite
+	// a row.
+	end := goflume.UsageBucketStart(e.opts.Now().In(loc), e.opts.Bucket).Add(-time.Second)
+
+	r := UsageRange{TZ: loc.String()}
+	var from time.Time
+	prev, incremental := UsageRange{}, false
+	if e.prev != nil {
+		prev, incremental = e.prev.Usage[d.ID]
+	}
+	if incremental {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("previous usage file: %w", err)
+		}
+		until, err := time.ParseInLocation(datetimeLayout, prev.Until, loc)
+		if err != nil {
+			return fmt.Errorf("previous usage cursor: %w", err)
+		}
+		from, r.Since = until.Add(time.Second), prev.Since
+	} else {
+		s := e.opts.Since
+		from = time.Date(s.Year(), s.Month(), s.Day(), s.Hour(), s.Minute(), s.Second(), 0, loc)
+		if s.IsZero() {
+			if from, err = e.firstUsage(ctx, d.ID, end); err != nil {
+				return err
+			}
+		}
+		from = goflume.UsageBucketStart(from, e.opts.Bucket)
+		r.Since = from.Format(datetimeLayout)
+	}
+	if !incremental || from.Before(end) {
+		r.Until = end.Format(datetimeLayout)
+	} else {
+		r.Until = prev.Until
+	}
+
+	// The file is rebuilt next to the old one and swapped in at the end.
+	// On an incremental run, rows after the previous manifest's Until are
+	// dropped: they were written by a run that failed before its manifest,
+	// and the queries below fetch them again.
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := csv.NewWriter(bufio.NewWriter(f))
+	_ = w.Write([]string{"datetime", "gallons"})
+	records := 0
+	if incremental {
+		if records, err = copyRows(w, path, prev.Until); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	added := 0
+	if from.Before(end) {
+		for i, win := range goflume.SplitUsageRange(from, end, e.opts.Bucket) {
+			resp, err := e.c.QueryUsage(ctx, d.ID, goflume.QueryUsageRequestBody{
+				RequestID:     "export-" + strconv.Itoa(i),
+				Bucket:        e.opts.Bucket,
+				SinceDatetime: win[0].Format(datetimeLayout),
+				UntilDatetime: win[1].Format(datetimeLayout),
+				Units:         "GALLONS",
+			})
+			if err != nil {
+				_ = f.Close()
+				return err
+			}
+			for _, u := range resp.Data {
+				_ = w.Write([]string{u.Datetime, strconv.Itoa(u.Value)})
+				added++
+			}
+		}
+	}
+	if err := finishCSV(w, f, tmp, path); err != nil {
+		return err
+	}
+	e.manifest.Usage[d.ID] = r
+	return e.record(rel, records+added, added)
+}
+
+// finishCSV flushes the rebuilt usage file and moves it into place.
+func finishCSV(w *csv.Writer, f *os.File, tmp, path string) error {
+	w.Flush()
+	if err := errors.Join(w.Error(), f.Sync(), f.Close()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// copyRows copies the data rows of the usage file at path up to and
+// including the datetime until and returns how many it copied. Flume
+// datetimes sort as strings.
+func copyRows(w *csv.Writer, path, until string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("previous usage file: %w", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(bufio.NewReader(f))
+	if _, err := r.Read(); err != nil {
+		return 0, fmt.Errorf("previous usage file: %w", err)
+	}
+	n := 0
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("previous usage file: %w", err)
+		}
+		if row[0] > until {
+			continue
+		}
+		if err := w.Write(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// firstUsage finds the start of the first year with any recorded usage, or
+// end when the device has none.
+func (e *exporter) firstUsage(ctx context.Context, deviceID string, end time.Time) (time.Time, error) {
+	start := time.Date(end.Year()-historyYears+1, 1, 1, 0, 0, 0, 0, end.Location())
+	resp, err := e.c.QueryUsage(ctx, deviceID, goflume.QueryUsageRequestBody{
+		RequestID:     "export-history",
+		Bucket:        "YR",
+		SinceDatetime: start.Format(datetimeLayout),
+		UntilDatetime: end.Format(datetimeLayout),
+		Units:         "GALLONS",
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, u := range resp.Data {
+		if u.Value == 0 {
+			continue
+		}
+		t, err := time.ParseInLocation(datetimeLayout, u.Datetime, end.Location())
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t, nil
+	}
+	return end, nil
+}
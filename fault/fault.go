@@ -0,0 +1,178 @@
+// Package fault injects latency, errors, malformed bodies and expired
+// sessions into Flume API traffic, either on the client side as an
+// http.RoundTripper or on the server side as middleware for a flumetest
+// server. Scenarios are declarative and, for a sequential request stream,
+// reproducible from their seed.
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Duration is a time.Duration that reads and writes strings such as "250ms"
+// in scenario files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Rule describes the faults for requests that match Method and Path. Path is
+// a path.Match pattern such as "/users/*/devices/*/query"; empty fields match
+// every request. Only the first matching rule of a scenario applies.
+type Rule struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+
+	Latency Duration `json:"latency,omitempty"`
+	Jitter  Duration `json:"jitter,omitempty"` // Random extra latency in [0, Jitter)
+
+	ErrorRate  float64  `json:"error_rate,omitempty"`  // Probability of answering with Status
+	Status     int      `json:"status,omitempty"`      // Defaults to 500
+	RetryAfter Duration `json:"retry_after,omitempty"` // Sent as Retry-After with injected errors
+
+	MalformedRate float64 `json:"malformed_rate,omitempty"` // Probability of truncating the JSON body
+
+	DropRate float64 `json:"drop_rate,omitempty"` // Probability of failing the connection
+
+	// UnauthorizedAfter answers 401 to every matching call after the first
+	// N, which simulates an access token expiring mid-session.
+	UnauthorizedAfter int `json:"unauthorized_after,omitempty"`
+}
+
+type Scenario struct {
+	Name  string `json:"name,omitempty"`
+	Seed  uint64 `json:"seed"`
+	Rules []Rule `json:"rules"`
+}
+
+func LoadScenario(file string) (*Scenario, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", file, err)
+	}
+	for i, r := range s.Rules {
+		if _, err := path.Match(r.Path, "/"); err != nil {
+			return nil, fmt.Errorf("scenario %s: rules[%d]: %w", file, i, err)
+		}
+	}
+	return &s, nil
+}
+
+// Decision is what an Injector chose for one request.
+type Decision struct {
+	Latency    time.Duration
+	Status     int
+	RetryAfter time.Duration
+	Malformed  bool
+	Drop       bool
+}
+
+type Injector struct {
+	scenario Scenario
+	mu       sync.Mutex
+	rng      *rand.Rand
+	calls    []int
+}
+
+func NewInjector(s Scenario) *Injector {
+	return &Injector{scenario: s, rng: rand.New(rand.NewPCG(s.Seed, s.Seed^0x9e3779b97f4a7c15)), calls: make([]int, len(s.Rules))}
+}
+
+// Decide picks the faults for a request. Randomness is drawn in a fixed order
+// so the same request sequence always yields the same decisions.
+func (in *Injector) Decide(method, urlPath string) Decision {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for i, r := range in.scenario.Rules {
+		if !r.matches(method, urlPath) {
+			continue
+		}
+		in.calls[i]++
+		var d Decision
+		d.Latency = time.Duration(r.Latency)
+		if r.Jitter > 0 {
+			d.Latency += time.Duration(in.rng.Int64N(int64(r.Jitter)))
+		}
+		drop, fail, malformed := in.rng.Float64(), in.rng.Float64(), in.rng.Float64()
+		switch {
+		case r.UnauthorizedAfter > 0 && in.calls[i] > r.UnauthorizedAfter:
+			d.Status = 401
+		case drop < r.DropRate:
+			d.Drop = true
+		case fail < r.ErrorRate:
+			d.Status = r.Status
+			if d.Status == 0 {
+				d.Status = 500
+			}
+			d.RetryAfter = time.Duration(r.RetryAfter)
+		case malformed < r.MalformedRate:
+			d.Malformed = true
+		}
+		return d
+	}
+	return Decision{}
+}
+
+// Calls returns how many requests matched each rule so far.
+func (in *Injector) Calls() []int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return append([]int(nil), in.calls...)
+}
+
+func (r Rule) matches(method, urlPath string) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	if r.Path == "" {
+		return true
+	}
+	ok, _ := path.Match(r.Path, urlPath)
+	return ok
+}
+
+func errorBody(status int) []byte {
+	b, _ := json.Marshal(map[string]any{
+		"success":      false,
+		"code":         status,
+		"message":      "injected fault",
+		"http_code":    status,
+		"http_message": http.StatusText(status),
+		"data":         []any{},
+	})
+	return b
+}
+
+// truncate cuts a body in half so it is no longer valid JSON.
+func truncate(b []byte) []byte {
+	if len(b) < 2 {
+		return []byte("{")
+	}
+	return b[:len(b)/2]
+}
@@ -0,0 +1,183 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+func TestDecide_reproducible(t *testing.T) {
+	s := Scenario{Seed: 9, Rules: []Rule{{ErrorRate: 0.3, MalformedRate: 0.3, Jitter: Duration(time.Second)}}}
+	run := func() []Decision {
+		in := NewInjector(s)
+		var out []Decision
+		for i := 0; i < 50; i++ {
+			out = append(out, in.Decide("GET", "/users/1/devices"))
+		}
+		return out
+	}
+	a, b := run(), run()
+	if !reflect.DeepEqual(a, b) {
+		t.Error("same seed produced different decisions")
+	}
+	failures := 0
+	for _, d := range a {
+		if d.Status == 500 {
+			failures++
+		}
+	}
+	if failures == 0 || failures == len(a) {
+		t.Errorf("expected some but not all requests to fail, got %d/%d", failures, len(a))
+	}
+}
+
+func TestDecide_firstMatchingRule(t *testing.T) {
+	in := NewInjector(Scenario{Rules: []Rule{
+		{Method: "POST", Path: "/users/*/devices/*/query", ErrorRate: 1, Status: 429, RetryAfter: Duration(30 * time.Second)},
+		{Path: "/users/*", ErrorRate: 1, Status: 503},
+	}})
+	if d := in.Decide("POST", "/users/1/devices/d1/query"); d.Status != 429 || d.RetryAfter != 30*time.Second {
+		t.Errorf("unexpected decision for query: %+v", d)
+	}
+	if d := in.Decide("GET", "/users/1"); d.Status != 503 {
+		t.Errorf("unexpected decision for user: %+v", d)
+	}
+	if d := in.Decide("GET", "/oauth/token"); d != (Decision{}) {
+		t.Errorf("expected no fault for unmatched path, got %+v", d)
+	}
+	if got := in.Calls(); !reflect.DeepEqual(got, []int{1, 1}) {
+		t.Errorf("unexpected call counts: %v", got)
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "scenario.json")
+	_ = os.WriteFile(file, []byte(`{"name":"slow","seed":1,"rules":[{"path":"/users/*","latency":"250ms","unauthorized_after":3}]}`), 0o644)
+	s, err := LoadScenario(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Name != "slow" || time.Duration(s.Rules[0].Latency) != 250*time.Millisecond || s.Rules[0].UnauthorizedAfter != 3 {
+		t.Errorf("unexpected scenario: %+v", s)
+	}
+	_ = os.WriteFile(file, []byte(`{"rules":[{"path":"["}]}`), 0o644)
+	if _, err := LoadScenario(file); err == nil {
+		t.Error("expected error for bad path pattern")
+	}
+}
+
+func newServerClient(t *testing.T, opts ...flumetest.Option) (*flumetest.Server, *goflume.Client) {
+	t.Helper()
+	srv := flumetest.NewServer(opts...)
+	t.Cleanup(srv.Close)
+	c := srv.Client()
+	if err := c.Authenticate(context.Background(), flumetest.Username, flumetest.Password); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	return srv, c
+}
+
+func TestTransport(t *testing.T) {
+	srv, c := newServerClient(t)
+	in := NewInjector(Scenario{Rules: []Rule{
+		{Path: "/users/*/devices", UnauthorizedAfter: 2},
+		{Path: "/users/*/locations", MalformedRate: 1},
+		{Path: "/users/*/contacts", DropRate: 1},
+		{Path: "/users/*", Latency: Duration(20 * time.Millisecond)},
+	}})
+	c.HTTPClient = &http.Client{Transport: &Transport{Base: srv.Server.Client().Transport, Injector: in}}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetDevices(ctx, nil); err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i, err)
+		}
+	}
+	if _, err := c.GetDevices(ctx, nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 after two calls, got %v", err)
+	}
+	if _, err := c.GetLocations(ctx, nil); err == nil {
+		t.Error("expected decode error for truncated body")
+	}
+	if _, err := c.GetContacts(ctx, nil); !errors.Is(err, ErrDropped) {
+		t.Errorf("expected dropped connection, got %v", err)
+	}
+	start := time.Now()
+	if _, err := c.GetUser(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("expected injected latency")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.GetUser(cancelled); err == nil {
+		t.Error("expected cancelled context to abort the injected delay")
+	}
+}
+
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestTransport_closesBodyAndRoundsRetryAfter(t *testing.T) {
+	tr := &Transport{Injector: NewInjector(Scenario{Rules: []Rule{
+		{Path: "/drop", DropRate: 1},
+		{Path: "/busy", ErrorRate: 1, Status: 429, RetryAfter: Duration(300 * time.Millisecond)},
+	}})}
+	for _, path := range []string{"/drop", "/busy"} {
+		body := &closeRecorder{Reader: strings.NewReader("{}")}
+		req, _ := http.NewRequest("POST", "http://x"+path, body)
+		resp, err := tr.RoundTrip(req)
+		if !body.closed {
+			t.Errorf("%s: request body not closed", path)
+		}
+		if path == "/busy" {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Header.Get("Retry-After"); got != "1" {
+				t.Errorf("Retry-After = %q, want 1", got)
+			}
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	in := NewInjector(Scenario{Rules: []Rule{
+		{Method: "GET", Path: "/users/*/notifications", ErrorRate: 1, Status: 429, RetryAfter: Duration(time.Minute)},
+		{Path: "/users/*/locations", MalformedRate: 1},
+		{Path: "/users/*/contacts", DropRate: 1},
+	}})
+	_, c := newServerClient(t, flumetest.WithMiddleware(Middleware(in)))
+	ctx := context.Background()
+
+	if _, err := c.GetNotifications(ctx, nil); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("expected 429, got %v", err)
+	}
+	if _, err := c.GetLocations(ctx, nil); err == nil {
+		t.Error("expected decode error for truncated body")
+	}
+	if _, err := c.GetContacts(ctx, nil); err == nil {
+		t.Error("expected error for dropped connection")
+	}
+	if _, err := c.GetDevices(ctx, nil); err != nil {
+		t.Errorf("unexpected error for unaffected endpoint: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package fault
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+// Middleware applies an Injector's decisions on the server side. It fits
+// flumetest.WithMiddleware.
+func Middleware(in *Injector) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := in.Decide(r.Method, r.URL.Path)
+			if err := sleep(r.Context(), d.Latency); err != nil {
+				return
+			}
+			if d.Drop {
+				if hj, ok := w.(http.Hijacker); ok {
+					if conn, _, err := hj.Hijack(); err == nil {
+						_ = conn.Close()
+						return
+					}
+				}
+				panic(http.ErrAbortHandler)
+			}
+			if d.Status != 0 {
+				w.Header().Set("Content-Type", "application/json")
+				if d.RetryAfter > 0 {
+					w.Header().Set("Retry-After", retryAfter(d.RetryAfter))
+				}
+				w.WriteHeader(d.Status)
+				_, _ = w.Write(errorBody(d.Status))
+				return
+			}
+			if !d.Malformed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := httptest.NewRecorder()
+			next.ServeHTTP(rec, r)
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.Header().Del("Content-Length")
+			w.WriteHeader(rec.Code)
+			_, _ = w.Write(truncate(rec.Body.Bytes()))
+		})
+	}
+}
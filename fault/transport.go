@@ -0,0 +1,97 @@
+package fault
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrDropped = errors.New("fault: connection dropped")
+
+// Transport applies an Injector's decisions to outgoing requests.
+type Transport struct {
+	Base     http.RoundTripper // Defaults to http.DefaultTransport
+	Injector *Injector
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	d := t.Injector.Decide(req.Method, req.URL.Path)
+	if err := sleep(req.Context(), d.Latency); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	if d.Drop {
+		closeBody(req)
+		return nil, ErrDropped
+	}
+	if d.Status != 0 {
+		closeBody(req)
+		body := errorBody(d.Status)
+		header := http.Header{"Content-Type": []string{"application/json"}}
+		if d.RetryAfter > 0 {
+			header.Set("Retry-After", retryAfter(d.RetryAfter))
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", d.Status, http.StatusText(d.Status)),
+			StatusCode:    d.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil || !d.Malformed {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = truncate(body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// closeBody closes the request body, which a RoundTripper must do even when
+// it does not send the request.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
+
+// retryAfter formats a delay as Retry-After seconds, rounded up so that a
+// delay under a second is not sent as zero.
+func retryAfter(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
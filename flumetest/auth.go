@@ -0,0 +1,145 @@
+package flumetest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+type tokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.ClientID != s.opts.clientID || req.ClientSecret != s.opts.clientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid client credentials")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch req.GrantType {
+	case "password":
+		if req.Username != s.opts.username || req.Password != s.opts.password {
+			writeError(w, http.StatusUnauthorized, "invalid username or password")
+			return
+		}
+	case "refresh_token":
+		if !s.refreshTokens[req.RefreshToken] {
+			writeError(w, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+		delete(s.refreshTokens, req.RefreshToken)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported grant_type")
+		return
+	}
+
+	refresh := randomToken()
+	s.refreshTokens[refresh] = true
+	token := goflume.Token{
+		AccessToken:  s.issueJWT(),
+		RefreshToken: refresh,
+		ExpiresIn:    int(s.opts.tokenTTL.Seconds()),
+		TokenType:    "bearer",
+	}
+	writeData(w, http.StatusOK, []goflume.Token{token})
+}
+
+// IssueToken returns a valid access token for the seeded user, for tests that
+// want to skip the OAuth exchange.
+func (s *Server) IssueToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueJWT()
+}
+
+func (s *Server) issueJWT() string {
+	now := s.opts.now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(goflume.JWTPayload{
+		Exp:    int(now.Add(s.opts.tokenTTL).Unix()),
+		Iat:    int(now.Unix()),
+		Iss:    "flumetest",
+		Scope:  []string{"read", "update"},
+		Sub:    s.state.User.EmailAddress,
+		Type:   "USER",
+		UserID: s.state.User.ID,
+	})
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return header + "." + payload + "." + s.sign(header+"."+payload)
+}
+
+func (s *Server) sign(data string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) verifyJWT(token string) (*goflume.JWTPayload, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	if !hmac.Equal([]byte(s.sign(parts[0]+"."+parts[1])), []byte(parts[2])) {
+		return nil, errors.New("invalid token signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	var claims goflume.JWTPayload
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, errors.New("malformed token")
+	}
+	if time.Unix(int64(claims.Exp), 0).Before(s.opts.now()) {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+// authorize wraps an API handler with bearer token and user checks.
+func (s *Server) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			writeError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		claims, err := s.verifyJWT(strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if r.PathValue("user") != "" && r.PathValue("user") != itoa(claims.UserID) {
+			writeError(w, http.StatusForbidden, "token does not grant access to this user")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,352 @@
+package flumetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// patch applies a partial JSON body onto a copy of v.
+func patch[T any](w http.ResponseWriter, r *http.Request, v T) (T, bool) {
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return v, false
+	}
+	return v, true
+}
+
+func pathInt(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	n, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid "+name)
+		return 0, false
+	}
+	return n, true
+}
+
+// requireDevice must be called with s.mu held.
+func (s *Server) requireDevice(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("device")
+	if s.state.device(id) == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("device %s not found", id))
+		return "", false
+	}
+	return id, true
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeData(w, http.StatusOK, []goflume.User{s.state.User})
+}
+
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	writeList(w, r, s.state.Devices, "id", func(d goflume.Device) bool {
+		return matches(q, "location_id", d.LocationID) && matches(q, "type", d.Type)
+	}, false)
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.requireDevice(w, r)
+	if !ok {
+		return
+	}
+	writeData(w, http.StatusOK, []goflume.Device{*s.state.device(id)})
+}
+
+func (s *Server) currentFlow(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.requireDevice(w, r)
+	if !ok {
+		return
+	}
+	if source, ok := s.flowSources[id]; ok {
+		writeData(w, http.StatusOK, []goflume.Flow{source(s.opts.now())})
+		return
+	}
+	flow, ok := s.state.Flow[id]
+	if !ok {
+		flow = goflume.Flow{Datetime: s.opts.now().UTC().Format(datetimeLayout)}
+	}
+	writeData(w, http.StatusOK, []goflume.Flow{flow})
+}
+
+func (s *Server) listLocations(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeList(w, r, s.state.Locations, "id", nil, false)
+}
+
+func (s *Server) location(w http.ResponseWriter, r *http.Request) *goflume.Location {
+	id, ok := pathInt(w, r, "location")
+	if !ok {
+		return nil
+	}
+	for i := range s.state.Locations {
+		if s.state.Locations[i].ID == id {
+			return &s.state.Locations[i]
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("location %d not found", id))
+	return nil
+}
+
+func (s *Server) getLocation(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l := s.location(w, r); l != nil {
+		writeData(w, http.StatusOK, []goflume.Location{*l})
+	}
+}
+
+func (s *Server) updateLocation(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.location(w, r)
+	if l == nil {
+		return
+	}
+	var body struct {
+		AwayMode *bool `json:"away_mode"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if body.AwayMode != nil {
+		l.AwayMode = *body.AwayMode
+	}
+	writeData(w, http.StatusOK, []goflume.Location{*l})
+}
+
+func (s *Server) listBudgets(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.requireDevice(w, r); ok {
+		writeList(w, r, s.state.Budgets[id], "id", nil, false)
+	}
+}
+
+func (s *Server) listEventRules(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.requireDevice(w, r); ok {
+		writeList(w, r, s.state.EventRules[id], "id", nil, false)
+	}
+}
+
+func (s *Server) listUsageAlertRules(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.requireDevice(w, r); ok {
+		writeList(w, r, s.state.UsageAlertRules[id], "id", nil, false)
+	}
+}
+
+func (s *Server) findUsageAlertRule(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	device, ok := s.requireDevice(w, r)
+	if !ok {
+		return "", 0, false
+	}
+	for i, rule := range s.state.UsageAlertRules[device] {
+		if rule.ID == r.PathValue("id") {
+			return device, i, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("usage alert rule %s not found", r.PathValue("id")))
+	return "", 0, false
+}
+
+func (s *Server) getUsageAlertRule(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if device, i, ok := s.findUsageAlertRule(w, r); ok {
+		writeData(w, http.StatusOK, []goflume.UsageAlertRule{s.state.UsageAlertRules[device][i]})
+	}
+}
+
+func (s *Server) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	writeList(w, r, s.state.Subscriptions, "id", func(sub goflume.Subscription) bool {
+		if !matches(q, "alert_type", sub.AlertType) || !matches(q, "device_id", sub.DeviceID) ||
+			!matches(q, "notification_types", int(sub.NotificationTypes)) {
+			return false
+		}
+		if bit, err := strconv.Atoi(q.Get("notification_type")); err == nil && int(sub.NotificationTypes)&bit == 0 {
+			return false
+		}
+		if q.Get("device_type") != "" || q.Get("location_id") != "" {
+			d := s.state.device(sub.DeviceID)
+			if d == nil || !matches(q, "device_type", d.Type) || !matches(q, "location_id", d.LocationID) {
+				return false
+			}
+		}
+		return true
+	}, true)
+}
+
+func (s *Server) findSubscription(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, ok := pathInt(w, r, "id")
+	if !ok {
+		return 0, false
+	}
+	for i, sub := range s.state.Subscriptions {
+		if sub.ID == id {
+			return i, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("subscription %d not found", id))
+	return 0, false
+}
+
+func (s *Server) getSubscription(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.findSubscription(w, r); ok {
+		writeData(w, http.StatusOK, s.state.Subscriptions[i])
+	}
+}
+
+func (s *Server) createSubscription(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := patch(w, r, goflume.Subscription{})
+	if !ok {
+		return
+	}
+	if sub.AlertType == "" || sub.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "alert_type and device_id are required")
+		return
+	}
+	if s.state.device(sub.DeviceID) == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("device %s not found", sub.DeviceID))
+		return
+	}
+	now := s.opts.now().UTC().Format(timestampLayout)
+	sub.ID = s.id()
+	sub.UserID = s.state.User.ID
+	sub.CreatedDatetime, sub.UpdatedDatetime = now, now
+	s.state.Subscriptions = append(s.state.Subscriptions, sub)
+	writeData(w, http.StatusOK, sub)
+}
+
+func (s *Server) updateSubscription(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.findSubscription(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		AlertInfo         *string `json:"alert_info"`
+		NotificationTypes *int    `json:"notification_types"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	sub := &s.state.Subscriptions[i]
+	if body.AlertInfo != nil {
+		sub.AlertInfo = *body.AlertInfo
+	}
+	if body.NotificationTypes != nil {
+		sub.NotificationTypes = *body.NotificationTypes
+	}
+	sub.UpdatedDatetime = s.opts.now().UTC().Format(timestampLayout)
+	writeData(w, http.StatusOK, *sub)
+}
+
+func (s *Server) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.findSubscription(w, r); ok {
+		s.state.Subscriptions = remove(s.state.Subscriptions, i)
+		writeData(w, http.StatusOK, []any{})
+	}
+}
+
+func (s *Server) listContacts(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	writeList(w, r, s.state.Contacts, "id", func(c goflume.Contact) bool {
+		return matches(q, "type", c.Type) && matches(q, "category", c.Category)
+	}, false)
+}
+
+func (s *Server) listNotifications(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	writeList(w, r, s.state.Notifications, "created_datetime", func(n goflume.Notification) bool {
+		if !matches(q, "device_id", n.DeviceID) || !matches(q, "type", n.Type) || !matches(q, "read", n.Read) {
+			return false
+		}
+		if mask, err := strconv.Atoi(q.Get("types")); err == nil && n.Type&mask == 0 {
+			return false
+		}
+		if q.Get("location_id") != "" {
+			d := s.state.device(n.DeviceID)
+			if d == nil || !matches(q, "location_id", d.LocationID) {
+				return false
+			}
+		}
+		return true
+	}, false)
+}
+
+func (s *Server) updateNotification(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := pathInt(w, r, "id")
+	if !ok {
+		return
+	}
+	for i, n := range s.state.Notifications {
+		if n.ID != id {
+			continue
+		}
+		var body struct {
+			Read *bool `json:"read"`
+		}
+		if !decodeBody(w, r, &body) {
+			return
+		}
+		if body.Read != nil {
+			s.state.Notifications[i].Read = *body.Read
+		}
+		writeData(w, http.StatusOK, []goflume.Notification{s.state.Notifications[i]})
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("notification %d not found", id))
+}
+
+func (s *Server) listUsageAlerts(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	writeList(w, r, s.state.UsageAlerts, "id", func(a goflume.UsageAlert) bool {
+		return matches(q, "device_id", a.DeviceID) && matches(q, "flume_leak", a.FlumeLeak)
+	}, false)
+}
+
+func remove[T any](items []T, i int) []T {
+	return append(items[:i:i], items[i+1:]...)
+}
@@ -0,0 +1,139 @@
+package flumetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultLimit = 50
+
+// writeList filters, sorts and pages items the way the API list endpoints do
+// and writes the enveloped result.
+func writeList[T any](w http.ResponseWriter, r *http.Request, items []T, defaultSort string, keep func(T) bool, paginated bool) {
+	q := r.URL.Query()
+	limit, err := intParam(q, "limit", defaultLimit)
+	if err != nil || limit < 0 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	offset, err := intParam(q, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	field := q.Get("sort_field")
+	if field == "" {
+		field = defaultSort
+	}
+	direction := strings.ToUpper(q.Get("sort_direction"))
+	if direction == "" {
+		direction = "ASC"
+	}
+	if direction != "ASC" && direction != "DESC" {
+		writeError(w, http.StatusBadRequest, "invalid sort_direction")
+		return
+	}
+
+	type row struct {
+		item T
+		key  any
+	}
+	var rows []row
+	for _, item := range items {
+		if keep != nil && !keep(item) {
+			continue
+		}
+		fields := fieldsOf(item)
+		key, ok := fields[field]
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid sort_field %q", field))
+			return
+		}
+		rows = append(rows, row{item: item, key: key})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if direction == "DESC" {
+			return less(rows[j].key, rows[i].key)
+		}
+		return less(rows[i].key, rows[j].key)
+	})
+
+	total := len(rows)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	out := make([]T, 0, end-offset)
+	for _, row := range rows[offset:end] {
+		out = append(out, row.item)
+	}
+
+	env := envelope{Success: true, Code: 602, Message: "Request OK", Data: out, Count: total}
+	if paginated {
+		p := map[string]any{"next": nil, "prev": nil}
+		if end < total {
+			p["next"] = pageURL(r, limit, end)
+		}
+		if offset > 0 {
+			prev := offset - limit
+			if prev < 0 {
+				prev = 0
+			}
+			p["prev"] = pageURL(r, limit, prev)
+		}
+		env.Pagination = p
+	}
+	writeEnvelope(w, http.StatusOK, env)
+}
+
+func pageURL(r *http.Request, limit, offset int) string {
+	q := r.URL.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	return r.URL.Path + "?" + q.Encode()
+}
+
+func fieldsOf(v any) map[string]any {
+	b, _ := json.Marshal(v)
+	var m map[string]any
+	_ = json.Unmarshal(b, &m)
+	return m
+}
+
+func less(a, b any) bool {
+	switch x := a.(type) {
+	case float64:
+		y, _ := b.(float64)
+		return x < y
+	case string:
+		y, _ := b.(string)
+		return x < y
+	case bool:
+		y, _ := b.(bool)
+		return !x && y
+	}
+	return false
+}
+
+func intParam(q url.Values, name string, def int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// matches reports whether an optional query parameter is absent or equal to
+// the formatted value.
+func matches(q url.Values, name string, value any) bool {
+	v := q.Get(name)
+	return v == "" || v == fmt.Sprint(value)
+}
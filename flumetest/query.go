@@ -0,0 +1,204 @@
+package flumetest
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+const (
+	datetimeLayout  = "2006-01-02 15:04:05"
+	timestampLayout = "2006-01-02T15:04:05.000Z"
+	maxBuckets      = 10000
+)
+
+var unitFactors = map[string]float64{
+	"":             1,
+	"GALLONS":      1,
+	"LITERS":       3.785411784,
+	"CUBIC_FEET":   0.133680556,
+	"CUBIC_METERS": 0.003785412,
+}
+
+func (s *Server) queryUsage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.requireDevice(w, r)
+	if !ok {
+		return
+	}
+	var body goflume.QueryUsageRequestBody
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	loc := s.deviceTZ(device)
+
+	since, err := time.ParseInLocation(datetimeLayout, body.SinceDatetime, loc)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid since_datetime")
+		return
+	}
+	until := s.opts.now().In(loc)
+	if body.UntilDatetime != "" {
+		if until, err = time.ParseInLocation(datetimeLayout, body.UntilDatetime, loc); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid until_datetime")
+			return
+		}
+	}
+	if until.Before(since) {
+		writeError(w, http.StatusBadRequest, "until_datetime is before since_datetime")
+		return
+	}
+	multiplier := 1
+	if body.GroupMultiplier != "" {
+		if multiplier, err = strconv.Atoi(body.GroupMultiplier); err != nil || multiplier < 1 {
+			writeError(w, http.StatusBadRequest, "invalid group_multiplier")
+			return
+		}
+	}
+	factor, ok := unitFactors[strings.ToUpper(body.Units)]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid units")
+		return
+	}
+	if strings.EqualFold(body.Operation, "CNT") {
+		factor = 1
+	}
+	bucket := strings.ToUpper(body.Bucket)
+	if _, err := truncate(since, bucket); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Collect bucket start times covering [since, until].
+	var starts []time.Time
+	start, _ := truncate(since, bucket)
+	for t := start; !t.After(until); t = advance(t, bucket, multiplier) {
+		starts = append(starts, t)
+		if len(starts) > maxBuckets {
+			writeError(w, http.StatusBadRequest, "query spans too many buckets")
+			return
+		}
+	}
+	index := func(t time.Time) int {
+		lo, hi := 0, len(starts)-1
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			if starts[mid].After(t) {
+				hi = mid - 1
+			} else {
+				lo = mid
+			}
+		}
+		return lo
+	}
+
+	values := make([][]float64, len(starts))
+	for _, reading := range s.state.Usage[device] {
+		t, err := time.ParseInLocation(datetimeLayout, reading.Datetime, loc)
+		if err != nil || t.Before(since) || t.After(until) {
+			continue
+		}
+		i := index(t)
+		values[i] = append(values[i], float64(reading.Value))
+	}
+
+	out := make([]goflume.UsageQuery, len(starts))
+	for i, t := range starts {
+		out[i] = goflume.UsageQuery{
+			Value:    int(math.Round(aggregate(values[i], body.Operation) * factor)),
+			Datetime: t.Format(datetimeLayout),
+		}
+	}
+	if strings.EqualFold(body.SortDirection, "DESC") {
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+	writeData(w, http.StatusOK, out)
+}
+
+func (s *Server) deviceTZ(deviceID string) *time.Location {
+	d := s.state.device(deviceID)
+	if d == nil {
+		return time.UTC
+	}
+	for _, l := range s.state.Locations {
+		if l.ID == d.LocationID && l.TZ != "" {
+			if loc, err := time.LoadLocation(l.TZ); err == nil {
+				return loc
+			}
+		}
+	}
+	return time.UTC
+}
+
+func truncate(t time.Time, bucket string) (time.Time, error) {
+	switch bucket {
+	case "MIN":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case "HR":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()), nil
+	case "DAY":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), nil
+	case "MON":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()), nil
+	case "YR":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location()), nil
+	}
+	return t, fmt.Errorf("invalid bucket %q", bucket)
+}
+
+func advance(t time.Time, bucket string, n int) time.Time {
+	switch bucket {
+	case "MIN":
+		return t.Add(time.Duration(n) * time.Minute)
+	case "HR":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "DAY":
+		return t.AddDate(0, 0, n)
+	case "MON":
+		return t.AddDate(0, n, 0)
+	default:
+		return t.AddDate(n, 0, 0)
+	}
+}
+
+func aggregate(values []float64, op string) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	switch strings.ToUpper(op) {
+	case "AVG":
+		return sum(values) / float64(len(values))
+	case "MIN":
+		m := values[0]
+		for _, v := range values {
+			m = math.Min(m, v)
+		}
+		return m
+	case "MAX":
+		m := values[0]
+		for _, v := range values {
+			m = math.Max(m, v)
+		}
+		return m
+	case "CNT":
+		return float64(len(values))
+	default:
+		return sum(values)
+	}
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
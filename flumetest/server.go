@@ -0,0 +1,240 @@
+// Package flumetest provides an in-memory fake of the Flume Personal API for
+// offline integration tests.
+//
+//	srv := flumetest.NewServer()
+//	defer srv.Close()
+//	client := srv.Client()
+//	err := client.Authenticate(ctx, flumetest.Username, flumetest.Password)
+//
+// The server issues signed JWTs from /oauth/token, checks them on every
+// request, wraps responses in the API envelope and honours the limit, offset,
+// sort_field and sort_direction parameters of the list endpoints.
+package flumetest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// Default credentials accepted by a Server.
+const (
+	ClientID     = "flumetest-client"
+	ClientSecret = "flumetest-secret"
+	Username     = "test@example.com"
+	Password     = "password"
+	UserID       = 1000
+)
+
+type options struct {
+	clientID     string
+	clientSecret string
+	username     string
+	password     string
+	tokenTTL     time.Duration
+	now          func() time.Time
+	state        *State
+	middleware   []func(http.Handler) http.Handler
+}
+
+type Option func(*options)
+
+func WithCredentials(clientID, clientSecret, username, password string) Option {
+	return func(o *options) {
+		o.clientID, o.clientSecret, o.username, o.password = clientID, clientSecret, username, password
+	}
+}
+
+// WithState seeds the server with state instead of DefaultState.
+func WithState(state State) Option {
+	return func(o *options) {
+		o.state = &state
+	}
+}
+
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.tokenTTL = ttl
+	}
+}
+
+// WithClock replaces time.Now for token expiry checks.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// WithMiddleware wraps the API handler, outermost first.
+func WithMiddleware(mw ...func(http.Handler) http.Handler) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, mw...)
+	}
+}
+
+type Server struct {
+	*httptest.Server
+
+	opts          options
+	signingKey    []byte
+	mu            sync.Mutex
+	state         State
+	refreshTokens map[string]bool
+	nextID        int
+	flowSources   map[string]func(time.Time) goflume.Flow
+}
+
+func NewServer(opts ...Option) *Server {
+	o := options{
+		clientID:     ClientID,
+		clientSecret: ClientSecret,
+		username:     Username,
+		password:     Password,
+		tokenTTL:     time.Hour,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := &Server{opts: o, refreshTokens: map[string]bool{}, nextID: 1000, flowSources: map[string]func(time.Time) goflume.Flow{}}
+	s.signingKey = make([]byte, 32)
+	_, _ = rand.Read(s.signingKey)
+	if o.state != nil {
+		s.state = o.state.clone()
+	} else {
+		s.state = DefaultState(UserID)
+	}
+	s.state.init()
+
+	var h http.Handler = s.routes()
+	for i := len(o.middleware) - 1; i >= 0; i-- {
+		h = o.middleware[i](h)
+	}
+	s.Server = httptest.NewServer(h)
+	return s
+}
+
+// Client returns an unauthenticated client pointed at the server.
+func (s *Server) Client() *goflume.Client {
+	c := goflume.NewClient(s.opts.clientID, s.opts.clientSecret, s.Server.Client())
+	c.BaseURL = s.URL
+	return c
+}
+
+// State returns a copy of the current state.
+func (s *Server) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.clone()
+}
+
+// Update lets a test change the state while the server is running.
+func (s *Server) Update(fn func(*State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+	s.state.init()
+}
+
+// SetUsage replaces the minute usage the query endpoint of deviceID
+// aggregates.
+func (s *Server) SetUsage(deviceID string, usage []goflume.UsageQuery) {
+	s.Update(func(st *State) {
+		st.Usage[deviceID] = usage
+	})
+}
+
+// SetFlowSource makes the current flow endpoint of deviceID report whatever
+// source returns for the server's clock, instead of the static State.Flow.
+func (s *Server) SetFlowSource(deviceID string, source func(time.Time) goflume.Flow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if source == nil {
+		delete(s.flowSources, deviceID)
+		return
+	}
+	s.flowSources[deviceID] = source
+}
+
+func (s *Server) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", s.handleToken)
+
+	api := map[string]http.HandlerFunc{
+		"GET /users/{user}":                                         s.getUser,
+		"GET /users/{user}/devices":                                 s.listDevices,
+		"GET /users/{user}/devices/{device}":                        s.getDevice,
+		"POST /users/{user}/devices/{device}/query":                 s.queryUsage,
+		"GET /users/{user}/devices/{device}/query/active":           s.currentFlow,
+		"GET /users/{user}/locations":                               s.listLocations,
+		"GET /users/{user}/locations/{location}":                    s.getLocation,
+		"PATCH /users/{user}/locations/{location}":                  s.updateLocation,
+		"GET /users/{user}/devices/{device}/budgets":                s.listBudgets,
+		"GET /users/{user}/devices/{device}/event_rules":            s.listEventRules,
+		"GET /users/{user}/devices/{device}/usage_alert_rules":      s.listUsageAlertRules,
+		"GET /users/{user}/devices/{device}/usage_alert_rules/{id}": s.getUsageAlertRule,
+		"GET /users/{user}/subscriptions":                           s.listSubscriptions,
+		"POST /users/{user}/subscriptions":                          s.createSubscription,
+		"GET /users/{user}/subscriptions/{id}":                      s.getSubscription,
+		"PATCH /users/{user}/subscriptions/{id}":                    s.updateSubscription,
+		"DELETE /users/{user}/subscriptions/{id}":                   s.deleteSubscription,
+		"GET /users/{user}/contacts":                                s.listContacts,
+		"GET /users/{user}/notifications":                           s.listNotifications,
+		"PATCH /users/{user}/notifications/{id}":                    s.updateNotification,
+		"GET /users/{user}/usage-alerts":                            s.listUsageAlerts,
+	}
+	for pattern, h := range api {
+		mux.HandleFunc(pattern, s.authorize(h))
+	}
+	return mux
+}
+
+type envelope struct {
+	Success     bool   `json:"success"`
+	Code        int    `json:"code"`
+	Message     string `json:"message"`
+	HTTPCode    int    `json:"http_code"`
+	HTTPMessage string `json:"http_message"`
+	Detailed    any    `json:"detailed"`
+	Data        any    `json:"data"`
+	Count       int    `json:"count"`
+	Pagination  any    `json:"pagination"`
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, env envelope) {
+	env.HTTPCode = status
+	env.HTTPMessage = http.StatusText(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(env)
+}
+
+func writeData(w http.ResponseWriter, status int, data any) {
+	count := 1
+	if b, err := json.Marshal(data); err == nil {
+		var items []json.RawMessage
+		if json.Unmarshal(b, &items) == nil {
+			count = len(items)
+		}
+	}
+	writeEnvelope(w, status, envelope{Success: true, Code: 602, Message: "Request OK", Data: data, Count: count})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeEnvelope(w, status, envelope{Success: false, Code: status, Message: message, Data: []any{}})
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
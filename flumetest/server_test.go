@@ -0,0 +1,243 @@
+package flumetest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// The subscription channel bits the tests use; the API does not document them.
+const (
+	channelPush  = 1
+	channelEmail = 2
+	channelSMS   = 4
+)
+
+func authenticated(t *testing.T, srv *Server) *goflume.Client {
+	t.Helper()
+	c := srv.Client()
+	if err := c.Authenticate(context.Background(), Username, Password); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	return c
+}
+
+func TestAuthenticateAndRefresh(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	c := authenticated(t, srv)
+	if c.JWT.UserID != UserID || c.JWT.Exp <= int(time.Now().Unix()) {
+		t.Errorf("unexpected JWT claims: %+v", c.JWT)
+	}
+	first := c.Token.RefreshToken
+	if err := c.RefreshAccessToken(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	c.Token.RefreshToken = first
+	if err := c.RefreshAccessToken(context.Background()); err == nil {
+		t.Error("expected used refresh token to be rejected")
+	}
+}
+
+func TestAuthenticate_badCredentials(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	if err := srv.Client().Authenticate(context.Background(), Username, "wrong"); err == nil {
+		t.Error("expected authentication to fail")
+	}
+}
+
+func TestRequiresValidToken(t *testing.T) {
+	now := time.Now()
+	srv := NewServer(WithClock(func() time.Time { return now }), WithTokenTTL(time.Minute))
+	defer srv.Close()
+	c := srv.Client()
+	c.JWT.UserID = UserID
+	if _, err := c.GetUser(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected 401 without token, got %v", err)
+	}
+	c = authenticated(t, srv)
+	if _, err := c.GetUser(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := c.GetUser(context.Background()); err == nil || !strings.Contains(err.Error(), "token expired") {
+		t.Errorf("expected expired token error, got %v", err)
+	}
+	c.Token.AccessToken = srv.IssueToken()
+	c.JWT.UserID = UserID + 1
+	if _, err := c.GetUser(context.Background()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected 403 for another user, got %v", err)
+	}
+}
+
+func TestListPagingAndSorting(t *testing.T) {
+	state := DefaultState(UserID)
+	state.Contacts = []goflume.Contact{
+		{ID: 1, Type: "email", Detail: "c@x"},
+		{ID: 2, Type: "sms", Detail: "a@x"},
+		{ID: 3, Type: "email", Detail: "b@x"},
+	}
+	srv := NewServer(WithState(state))
+	defer srv.Close()
+	c := authenticated(t, srv)
+
+	limit, offset := int32(2), int32(1)
+	field, dir := "detail", "DESC"
+	resp, err := c.GetContacts(context.Background(), &goflume.GetContactsParams{Limit: &limit, Offset: &offset, SortField: &field, SortDirection: &dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Data) != 2 || resp.Data[0].Detail != "b@x" || resp.Data[1].Detail != "a@x" || resp.Count != 3 {
+		t.Errorf("unexpected page: %+v (count %d)", resp.Data, resp.Count)
+	}
+
+	typ := "email"
+	resp, err = c.GetContacts(context.Background(), &goflume.GetContactsParams{Type: &typ})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Data) != 2 {
+		t.Errorf("expected filter to keep 2 contacts, got %+v", resp.Data)
+	}
+
+	bad := "nope"
+	if _, err := c.GetContacts(context.Background(), &goflume.GetContactsParams{SortField: &bad}); err == nil {
+		t.Error("expected error for unknown sort field")
+	}
+}
+
+func TestSubscriptionsPagination(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	c := authenticated(t, srv)
+	device := srv.State().Devices[0].ID
+	for _, at := range []goflume.AlertType{"budget", "low_battery", "usage_alert"} {
+		if _, err := c.CreateSubscription(context.Background(), goflume.SubscriptionParams{AlertType: at, DeviceID: device, NotificationTypes: channelPush}); err != nil {
+			t.Fatalf("create subscription: %v", err)
+		}
+	}
+	limit := int32(2)
+	resp, err := c.GetSubscriptions(context.Background(), &goflume.GetSubscriptionsParams{Limit: &limit})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Data) != 2 || !strings.Contains(resp.Pagination.Next, "offset=2") {
+		t.Errorf("unexpected page: %+v %+v", resp.Data, resp.Pagination)
+	}
+	got, err := c.GetSubscriptionByID(context.Background(), resp.Data[0].ID)
+	if err != nil || got.Data.ID != resp.Data[0].ID {
+		t.Errorf("get subscription: %+v %v", got, err)
+	}
+}
+
+func TestWritesUpdateState(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	c := authenticated(t, srv)
+	ctx := context.Background()
+	srv.Update(func(s *State) {
+		s.Notifications = []goflume.Notification{{ID: 5, DeviceID: s.Devices[0].ID, Title: "Leak"}}
+	})
+	st := srv.State()
+	device := st.Devices[0].ID
+
+	if _, err := c.UpdateNotification(ctx, 5, goflume.NotificationPatch{Read: true}); err != nil {
+		t.Fatalf("update notification: %v", err)
+	}
+	if _, err := c.UpdateLocation(ctx, "1", goflume.LocationPatch{AwayMode: true}); err != nil {
+		t.Fatalf("update location: %v", err)
+	}
+	srv.Update(func(s *State) {
+		s.UsageAlertRules[device] = []goflume.UsageAlertRule{{ID: "r1", Name: "High", Enabled: true, Threshold: 10, Unit: "GALLONS"}}
+	})
+	if got, err := c.GetUsageAlertRule(ctx, device, "r1"); err != nil || got.Data[0].Name != "High" {
+		t.Errorf("get rule: %+v %v", got, err)
+	}
+	sub, err := c.CreateSubscription(ctx, goflume.SubscriptionParams{AlertType: "budget", DeviceID: device, NotificationTypes: channelPush})
+	if err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	if _, err := c.DeleteSubscription(ctx, sub.Data.ID); err != nil {
+		t.Fatalf("delete subscription: %v", err)
+	}
+	if _, err := c.DeleteSubscription(ctx, sub.Data.ID); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected 404 for deleted subscription, got %v", err)
+	}
+
+	st = srv.State()
+	if !st.Locations[0].AwayMode || len(st.Subscriptions) != 0 || !st.Notifications[0].Read {
+		t.Errorf("unexpected state after writes: %+v", st)
+	}
+}
+
+func TestQueryUsage(t *testing.T) {
+	state := DefaultState(UserID)
+	device := state.Devices[0].ID
+	state.Usage = map[string][]goflume.UsageQuery{device: {
+		{Value: 2, Datetime: "2026-03-01 06:00:00"},
+		{Value: 3, Datetime: "2026-03-01 06:30:00"},
+		{Value: 5, Datetime: "2026-03-01 08:15:00"},
+		{Value: 7, Datetime: "2026-03-02 00:00:00"},
+	}}
+	srv := NewServer(WithState(state))
+	defer srv.Close()
+	c := authenticated(t, srv)
+
+	resp, err := c.QueryUsage(context.Background(), device, goflume.QueryUsageRequestBody{
+		RequestID: "r", Bucket: "HR", SinceDatetime: "2026-03-01 06:00:00", UntilDatetime: "2026-03-01 08:59:59",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []int
+	for _, u := range resp.Data {
+		got = append(got, u.Value)
+	}
+	if len(got) != 3 || got[0] != 5 || got[1] != 0 || got[2] != 5 || resp.Data[2].Datetime != "2026-03-01 08:00:00" {
+		t.Errorf("unexpected hourly usage: %+v", resp.Data)
+	}
+
+	resp, err = c.QueryUsage(context.Background(), device, goflume.QueryUsageRequestBody{
+		RequestID: "r", Bucket: "DAY", SinceDatetime: "2026-03-01 00:00:00", UntilDatetime: "2026-03-02 23:59:59", Units: "LITERS",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Data) != 2 || resp.Data[0].Value != 38 || resp.Data[1].Value != 26 {
+		t.Errorf("unexpected daily usage in liters: %+v", resp.Data)
+	}
+
+	if _, err := c.QueryUsage(context.Background(), device, goflume.QueryUsageRequestBody{Bucket: "WEEK", SinceDatetime: "2026-03-01 00:00:00"}); err == nil {
+		t.Error("expected error for invalid bucket")
+	}
+}
+
+func TestCurrentFlowAndMiddleware(t *testing.T) {
+	state := DefaultState(UserID)
+	device := state.Devices[0].ID
+	state.Flow = map[string]goflume.Flow{device: {Active: true, GPM: 1.5, Datetime: "2026-03-01 06:00:00"}}
+	calls := 0
+	srv := NewServer(WithState(state), WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			next.ServeHTTP(w, r)
+		})
+	}))
+	defer srv.Close()
+	c := authenticated(t, srv)
+	resp, err := c.GetCurrentFlow(context.Background(), device)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Data[0].Active || resp.Data[0].GPM != 1.5 || !resp.Success {
+		t.Errorf("unexpected flow: %+v", resp)
+	}
+	if calls != 2 {
+		t.Errorf("expected middleware to see 2 requests, got %d", calls)
+	}
+}
@@ -0,0 +1,78 @@
+package flumetest
+
+import (
+	"encoding/json"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// State is the in-memory account served by a Server. Per-device collections
+// are keyed by device ID. Usage holds minute-level readings which the query
+// endpoint aggregates into the requested bucket.
+type State struct {
+	User            goflume.User
+	Devices         []goflume.Device
+	Locations       []goflume.Location
+	Budgets         map[string][]goflume.Budget
+	EventRules      map[string][]goflume.EventRule
+	UsageAlertRules map[string][]goflume.UsageAlertRule
+	Subscriptions   []goflume.Subscription
+	Contacts        []goflume.Contact
+	Notifications   []goflume.Notification
+	UsageAlerts     []goflume.UsageAlert
+	Usage           map[string][]goflume.UsageQuery
+	Flow            map[string]goflume.Flow
+}
+
+// DefaultState is a small single-home account with one device.
+func DefaultState(userID int) State {
+	return State{
+		User: goflume.User{ID: userID, EmailAddress: "test@example.com", FirstName: "Test", LastName: "User", Status: "ACTIVE", Type: "USER"},
+		Devices: []goflume.Device{
+			{ID: "6248148189204194987", Type: 2, LocationID: 1, UserID: userID, BridgeID: "6248148189204194000", Oriented: true,
+				LastSeen: "2026-01-01T00:00:00.000Z", Connected: true, BatteryLevel: "high", Product: "flume2"},
+		},
+		Locations: []goflume.Location{
+			{ID: 1, UserID: userID, Name: "Home", PrimaryLocation: true, Address: "1 Main St", City: "Springfield", State: "CA",
+				PostalCode: "90000", Country: "US", TZ: "America/Los_Angeles", Installation: "complete", BuildingType: "SINGLE_FAMILY_HOME"},
+		},
+		Contacts: []goflume.Contact{{ID: 1, Category: "primary", Type: "email", Detail: "test@example.com"}},
+	}
+}
+
+func (s *State) init() {
+	if s.Budgets == nil {
+		s.Budgets = map[string][]goflume.Budget{}
+	}
+	if s.EventRules == nil {
+		s.EventRules = map[string][]goflume.EventRule{}
+	}
+	if s.UsageAlertRules == nil {
+		s.UsageAlertRules = map[string][]goflume.UsageAlertRule{}
+	}
+	if s.Usage == nil {
+		s.Usage = map[string][]goflume.UsageQuery{}
+	}
+	if s.Flow == nil {
+		s.Flow = map[string]goflume.Flow{}
+	}
+}
+
+// clone deep-copies the state through JSON so callers cannot race with the
+// server on shared slices.
+func (s State) clone() State {
+	b, _ := json.Marshal(s)
+	var out State
+	_ = json.Unmarshal(b, &out)
+	out.init()
+	return out
+}
+
+func (s *State) device(id string) *goflume.Device {
+	for i := range s.Devices {
+		if s.Devices[i].ID == id {
+			return &s.Devices[i]
+		}
+	}
+	return nil
+}
@@ -8,19 +8,40 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
+// Operation is a mutating request that was recorded instead of sent while the
+// client was in dry-run mode.
+type Operation struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Time   time.Time       `json:"time"`
+}
+
+const dryRunResponse = `{"success":true,"code":0,"message":"dry run: request not sent","http_code":200,"http_message":"OK"}`
+
 func (c *Client) apiRequest(ctx context.Context, method string, u *url.URL, reqBody, respBody any) error {
 	if u == nil {
 		return fmt.Errorf("endpoint cannot be nil")
 	}
 
+	var payload []byte
 	var body io.Reader
 	if reqBody != nil {
-		b, _ := json.Marshal(reqBody)
+		b, err := json.Marshal(reqBody)
+		if err != nil {
+			return fmt.Errorf("encode request body: %w", err)
+		}
+		payload = b
 		body = bytes.NewBuffer(b)
 	}
-	req, _ := http.NewRequestWithContext(ctx, method, u.String(), body)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return err
+	}
 	if c.Token.AccessToken != "" {
 		req.Header.Set("Authorization", "Bearer "+c.Token.AccessToken)
 	}
@@ -30,8 +51,35 @@ func (c *Client) apiRequest(ctx context.Context, method string, u *url.URL, reqB
 	q.Set("envelope", "true")
 	req.URL.RawQuery = q.Encode()
 
+	mutating := isMutating(method, u)
+	entry := AuditEntry{Method: method, Endpoint: u.String()}
+
+	if c.DryRun && mutating {
+		c.recordOperation(Operation{Method: method, URL: req.URL.String(), Body: payload, Time: time.Now()})
+		var env APIResponseEnvelope
+		_ = json.Unmarshal([]byte(dryRunResponse), &env)
+		env.Simulated = true
+		entry.DryRun = true
+		entry.Envelope = &env
+		c.audit(ctx, entry, payload)
+		if respBody == nil {
+			return nil
+		}
+		if err := json.Unmarshal([]byte(dryRunResponse), respBody); err != nil {
+			return err
+		}
+		if e, ok := respBody.(interface{ markSimulated() }); ok {
+			e.markSimulated()
+		}
+		return nil
+	}
+
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
+		if mutating {
+			entry.Error = err.Error()
+			c.audit(ctx, entry, payload)
+		}
 		return err
 	}
 	defer func(Body io.ReadCloser) {
@@ -39,10 +87,77 @@ func (c *Client) apiRequest(ctx context.Context, method string, u *url.URL, reqB
 	}(resp.Body)
 	if resp.StatusCode >= 400 {
 		dat, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error: %s (%d) %s", u, resp.StatusCode, dat)
+		err := fmt.Errorf("API error: %s (%d) %s", u, resp.StatusCode, dat)
+		if mutating {
+			entry.Status = resp.StatusCode
+			entry.Envelope = decodeEnvelope(dat)
+			entry.Error = err.Error()
+			c.audit(ctx, entry, payload)
+		}
+		return err
+	}
+	if mutating && c.Audit != nil {
+		dat, err := io.ReadAll(resp.Body)
+		entry.Status = resp.StatusCode
+		entry.Envelope = decodeEnvelope(dat)
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		c.audit(ctx, entry, payload)
+		if err != nil {
+			return err
+		}
+		if respBody != nil {
+			return json.Unmarshal(dat, respBody)
+		}
+		return nil
 	}
 	if respBody != nil {
 		return json.NewDecoder(resp.Body).Decode(respBody)
 	}
 	return nil
 }
+
+func decodeEnvelope(dat []byte) *APIResponseEnvelope {
+	var env APIResponseEnvelope
+	if err := json.Unmarshal(dat, &env); err != nil {
+		return nil
+	}
+	return &env
+}
+
+// isMutating reports whether a request changes account state. Usage queries
+// are POSTed but only read data, so they are sent even in dry-run mode.
+func isMutating(method string, u *url.URL) bool {
+	if method == http.MethodGet || method == http.MethodHead {
+		return false
+	}
+	if method == http.MethodPost && strings.HasSuffix(u.Path, "/query") {
+		return false
+	}
+	return true
+}
+
+func (c *Client) recordOperation(op Operation) {
+	l := c.operationLog()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ops = append(l.ops, op)
+}
+
+// RecordedOperations returns the requests captured while DryRun was enabled.
+func (c *Client) RecordedOperations() []Operation {
+	l := c.operationLog()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ops := make([]Operation, len(l.ops))
+	copy(ops, l.ops)
+	return ops
+}
+
+func (c *Client) ResetRecordedOperations() {
+	l := c.operationLog()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ops = nil
+}
@@ -137,3 +137,102 @@ func TestApiRequest_NoRespBody(t *testing.T) {
 		t.Fatalf("expected nil error, got %v", err)
 	}
 }
+
+func TestApiRequest_DryRunRecordsMutations(t *testing.T) {
+	sent := false
+	client := newMockClient(nil, errors.New("should not be sent"), func(*http.Request) { sent = true })
+	client.BaseURL = "http://x"
+	client.JWT = JWTPayload{UserID: 1}
+	client.DryRun = true
+
+	got, err := client.UpdateLocation(context.Background(), "7", LocationPatch{AwayMode: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sent {
+		t.Error("request was sent in dry-run mode")
+	}
+	if !got.Success || !got.Simulated {
+		t.Errorf("expected simulated success, got %+v", got)
+	}
+	ops := client.RecordedOperations()
+	if len(ops) != 1 {
+		t.Fatalf("expected 1 recorded operation, got %d", len(ops))
+	}
+	if ops[0].Method != "PATCH" || !strings.HasPrefix(ops[0].URL, "http://x/users/1/locations/7") || string(ops[0].Body) != `{"away_mode":true}` {
+		t.Errorf("unexpected operation: %+v", ops[0])
+	}
+	client.ResetRecordedOperations()
+	if len(client.RecordedOperations()) != 0 {
+		t.Error("expected recorded operations to be cleared")
+	}
+}
+
+func TestApiRequest_DryRunPaginatedResponse(t *testing.T) {
+	client := newMockClient(nil, errors.New("should not be sent"), nil)
+	client.BaseURL = "http://x"
+	client.DryRun = true
+	u, _ := url.Parse("http://x/users/1/subscriptions")
+
+	var got SubscriptionsResponse
+	if err := client.apiRequest(context.Background(), http.MethodPost, u, SubscriptionParams{AlertType: "usage", DeviceID: "d"}, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Success || !got.Simulated {
+		t.Errorf("expected simulated success, got %+v", got.APIResponseEnvelopePagination)
+	}
+	if len(client.RecordedOperations()) != 1 {
+		t.Errorf("expected 1 recorded operation, got %d", len(client.RecordedOperations()))
+	}
+}
+
+func TestClient_copyable(t *testing.T) {
+	client := newMockClient(nil, nil, nil)
+	client.BaseURL = "http://x"
+	client.DryRun = true
+	copied := *client
+	if _, err := copied.DeleteSubscription(context.Background(), 4); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(copied.RecordedOperations()) != 1 || len(client.RecordedOperations()) != 0 {
+		t.Error("a copy made before recording should keep its own operations")
+	}
+}
+
+func TestApiRequest_DryRunSendsReads(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/users/1/devices"},
+		{http.MethodPost, "/users/1/devices/d1/query"},
+	} {
+		resp := &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{}`)), Header: make(http.Header)}
+		sent := false
+		client := newMockClient(resp, nil, func(*http.Request) { sent = true })
+		client.DryRun = true
+		u, _ := url.Parse("http://x" + tc.path)
+		if err := client.apiRequest(context.Background(), tc.method, u, nil, nil); err != nil {
+			t.Fatalf("%s %s: unexpected error: %v", tc.method, tc.path, err)
+		}
+		if !sent {
+			t.Errorf("%s %s: read request was not sent in dry-run mode", tc.method, tc.path)
+		}
+		if len(client.RecordedOperations()) != 0 {
+			t.Errorf("%s %s: read request was recorded", tc.method, tc.path)
+		}
+	}
+}
+
+func TestApiRequest_InvalidReqBody(t *testing.T) {
+	client := newMockClient(nil, nil, nil)
+	client.DryRun = true
+	u, _ := url.Parse("http://x/users/1/contacts")
+	err := client.apiRequest(context.Background(), http.MethodPost, u, map[string]any{"bad": make(chan int)}, nil)
+	if err == nil || !strings.Contains(err.Error(), "encode request body") {
+		t.Errorf("expected encode error, got %v", err)
+	}
+	if len(client.RecordedOperations()) != 0 {
+		t.Error("invalid request was recorded")
+	}
+}
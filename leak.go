@@ -0,0 +1,248 @@
+package goflume
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// usageDatetimeLayout is the format of UsageQuery.Datetime.
+const usageDatetimeLayout = "2006-01-02 15:04:05"
+
+// LeakThreshold raises an incident when flow stays above GPM for longer
+// than For.
+type LeakThreshold struct {
+	GPM float64       `json:"gpm"`
+	For time.Duration `json:"for"`
+
+	// From and To limit the threshold to a time of day, as offsets from
+	// midnight in the detector's time zone. The window wraps past midnight
+	// when To is before From. Leave both zero for a threshold that applies
+	// all day.
+	From time.Duration `json:"from,omitempty"`
+	To   time.Duration `json:"to,omitempty"`
+}
+
+// applies reports whether the threshold covers the time of day of t.
+func (th LeakThreshold) applies(t time.Time) bool {
+	if th.From == th.To {
+		return true
+	}
+	h, m, s := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if th.From < th.To {
+		return tod >= th.From && tod < th.To
+	}
+	return tod >= th.From || tod < th.To
+}
+
+// LeakIncident is continuous flow that stayed above a threshold. It is open
+// until the flow stops.
+type LeakIncident struct {
+	DeviceID string `json:"device_id"`
+	// LocationID is the location of the device, from LeakDetector.LocationIDs,
+	// so a handler can turn on away mode with UpdateLocation.
+	LocationID int           `json:"location_id,omitempty"`
+	Threshold  LeakThreshold `json:"threshold"`
+
+	Start   time.Time  `json:"start"`         // when the flow went above the threshold
+	Opened  time.Time  `json:"opened"`        // when the incident was raised
+	End     *time.Time `json:"end,omitempty"` // when the flow stopped; nil while open
+	PeakGPM float64    `json:"peak_gpm"`
+	// Gallons is the volume since Start, and the total once closed.
+	Gallons float64 `json:"gallons"`
+}
+
+// Open reports whether the flow of the incident is still running.
+func (i LeakIncident) Open() bool { return i.End == nil }
+
+// Duration returns how long the flow has run, up to now for an open
+// incident.
+func (i LeakIncident) Duration(now time.Time) time.Duration {
+	if i.End != nil {
+		now = *i.End
+	}
+	return now.Sub(i.Start)
+}
+
+// LocationIDString returns LocationID in the form UpdateLocation takes.
+func (i LeakIncident) LocationIDString() string {
+	return strconv.Itoa(i.LocationID)
+}
+
+// LeakDetector watches the flow of one or more devices for water that keeps
+// running. A run starts when a reading is above the threshold in effect at
+// its time and is forgotten when a reading drops to the threshold or below.
+// Once a run has lasted the threshold's For, an incident is opened; it
+// stays open until a reading shows no flow at all, so a leak hovering around
+// the threshold is reported once, and it is then closed with the total
+// volume of the run.
+//
+// Readings of a device must come in time order; older readings are ignored,
+// so overlapping usage queries can be fed as they are. Readings further
+// apart than MaxGap are not taken to be continuous: the run starts over and
+// an open incident is closed at the last reading before the gap. It is safe
+// for concurrent use.
+type LeakDetector struct {
+	// Thresholds are checked in order and the first one covering the time
+	// of day of a reading applies. No threshold applies outside of all
+	// windows.
+	Thresholds []LeakThreshold
+	// TZ is the time zone of the threshold windows. Defaults to UTC.
+	TZ *time.Location
+	// LocationIDs maps device IDs to their Device.LocationID.
+	LocationIDs map[string]int
+	// MaxGap is the longest time between two readings of a device over
+	// which the flow counts as continuous. Zero never breaks a run; 15
+	// minutes suits FlowWatcher events and minute usage.
+	MaxGap time.Duration
+
+	mu      sync.Mutex
+	devices map[string]*leakState
+}
+
+type leakState struct {
+	last     time.Time
+	gpm      float64
+	run      *LeakIncident // the current run, open as an incident once Opened is set
+	incident bool
+}
+
+// threshold returns the threshold in effect at t.
+func (d *LeakDetector) threshold(t time.Time) (LeakThreshold, bool) {
+	tz := d.TZ
+	if tz == nil {
+		tz = time.UTC
+	}
+	t = t.In(tz)
+	for _, th := range d.Thresholds {
+		if th.applies(t) {
+			return th, true
+		}
+	}
+	return LeakThreshold{}, false
+}
+
+// Observe feeds a flow rate measured at a time. It returns the incident when
+// the reading opens or closes one, and nil otherwise.
+func (d *LeakDetector) Observe(deviceID string, at time.Time, gpm float64) *LeakIncident {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.devices == nil {
+		d.devices = make(map[string]*leakState)
+	}
+	s := d.devices[deviceID]
+	if s == nil {
+		s = &leakState{}
+		d.devices[deviceID] = s
+	}
+	if !s.last.IsZero() && !at.After(s.last) {
+		return nil
+	}
+	var closed *LeakIncident
+	if d.MaxGap > 0 && !s.last.IsZero() && at.Sub(s.last) > d.MaxGap {
+		// Nothing is known about the flow during the gap, so no volume
+		// accrues over it.
+		if s.incident {
+			inc, end := *s.run, s.last
+			inc.End = &end
+			closed = &inc
+		}
+		s.run, s.incident = nil, false
+	} else if s.run != nil {
+		// The previous rate lasted until this reading.
+		s.run.Gallons += s.gpm * at.Sub(s.last).Minutes()
+	}
+	s.last, s.gpm = at, gpm
+
+	th, ok := d.threshold(at)
+	switch {
+	case closed != nil:
+		// The reading may start the next run, which can only open on a
+		// later reading.
+		if ok && gpm > th.GPM {
+			s.run = &LeakIncident{DeviceID: deviceID, LocationID: d.LocationIDs[deviceID], Start: at, PeakGPM: gpm}
+		}
+		return closed
+	case s.incident && gpm <= 0:
+		inc := *s.run
+		inc.End = &at
+		s.run, s.incident = nil, false
+		return &inc
+	case s.incident:
+		s.run.PeakGPM = max(s.run.PeakGPM, gpm)
+		return nil
+	case !ok || gpm <= th.GPM:
+		s.run = nil
+		return nil
+	case s.run == nil:
+		s.run = &LeakIncident{DeviceID: deviceID, LocationID: d.LocationIDs[deviceID], Start: at}
+	}
+	s.run.PeakGPM = max(s.run.PeakGPM, gpm)
+	if at.Sub(s.run.Start) < th.For {
+		return nil
+	}
+	s.run.Threshold, s.run.Opened, s.incident = th, at, true
+	inc := *s.run
+	return &inc
+}
+
+// ObserveFlow feeds a FlowWatcher event. Only FlowReading events carry a
+// reading; the others are ignored.
+func (d *LeakDetector) ObserveFlow(e FlowEvent) *LeakIncident {
+	if e.Type != FlowReading {
+		return nil
+	}
+	gpm := e.Flow.GPM
+	if !e.Flow.Active {
+		gpm = 0
+	}
+	return d.Observe(e.DeviceID, e.Time, gpm)
+}
+
+// ObserveUsage feeds minute usage of a device, as returned by QueryUsage
+// with the MIN bucket. Datetimes are read in tz, the time zone of the
+// device's location. It returns the incidents opened or closed, in order.
+func (d *LeakDetector) ObserveUsage(deviceID string, tz *time.Location, rows []UsageQuery) ([]LeakIncident, error) {
+	if tz == nil {
+		tz = time.UTC
+	}
+	readings := make([]time.Time, len(rows))
+	for i, r := range rows {
+		t, err := time.ParseInLocation(usageDatetimeLayout, r.Datetime, tz)
+		if err != nil {
+			return nil, fmt.Errorf("usage row %d: %w", i, err)
+		}
+		readings[i] = t
+	}
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return readings[order[a]].Before(readings[order[b]]) })
+
+	var out []LeakIncident
+	for _, i := range order {
+		// A minute bucket holds the gallons used in that minute.
+		if inc := d.Observe(deviceID, readings[i], float64(rows[i].Value)); inc != nil {
+			out = append(out, *inc)
+		}
+	}
+	return out, nil
+}
+
+// Incidents returns the open incidents, ordered by device ID.
+func (d *LeakDetector) Incidents() []LeakIncident {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []LeakIncident
+	for _, s := range d.devices {
+		if s.incident {
+			out = append(out, *s.run)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].DeviceID < out[b].DeviceID })
+	return out
+}
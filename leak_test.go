@@ -0,0 +1,196 @@
+package goflume
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLeakDetector_openClose(t *testing.T) {
+	d := &LeakDetector{Thresholds: []LeakThreshold{{GPM: 0.5, For: 5 * time.Minute}}, LocationIDs: map[string]int{"d1": 7}}
+	start := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+
+	// 1 gpm from minute 1 to 8, dipping under the threshold at minute 7.
+	gpm := []float64{0, 1, 1, 1, 1, 1, 1, 0.2, 1, 0}
+	var got []*LeakIncident
+	for m, g := range gpm {
+		if inc := d.Observe("d1", at(m), g); inc != nil {
+			got = append(got, inc)
+		}
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d incident changes, want open and close", len(got))
+	}
+	opened, closed := got[0], got[1]
+	if !opened.Open() || !opened.Start.Equal(at(1)) || !opened.Opened.Equal(at(6)) || opened.Gallons != 5 || opened.LocationID != 7 {
+		t.Errorf("opened = %+v", opened)
+	}
+	if closed.Open() || !closed.End.Equal(at(9)) || closed.Duration(at(20)) != 8*time.Minute || closed.PeakGPM != 1 {
+		t.Errorf("closed = %+v", closed)
+	}
+	if want := 6 + 0.2 + 1; closed.Gallons != want {
+		t.Errorf("closed gallons = %v, want %v", closed.Gallons, want)
+	}
+	if open := d.Incidents(); len(open) != 0 {
+		t.Errorf("open incidents after close: %+v", open)
+	}
+	if closed.LocationIDString() != "7" {
+		t.Errorf("location ID = %q", closed.LocationIDString())
+	}
+}
+
+func TestLeakDetector_shortRunsAndOldReadings(t *testing.T) {
+	d := &LeakDetector{Thresholds: []LeakThreshold{{GPM: 0.5, For: 5 * time.Minute}}}
+	start := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	for m := 0; m < 20; m++ {
+		// Four minutes on, one off, never long enough.
+		g := 2.0
+		if m%5 == 4 {
+			g = 0.5
+		}
+		if inc := d.Observe("d1", start.Add(time.Duration(m)*time.Minute), g); inc != nil {
+			t.Fatalf("minute %d raised %+v", m, inc)
+		}
+	}
+	if inc := d.Observe("d1", start, 2); inc != nil {
+		t.Errorf("an old reading raised %+v", inc)
+	}
+}
+
+func TestLeakDetector_timeOfDay(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip(err)
+	}
+	d := &LeakDetector{
+		TZ: la,
+		Thresholds: []LeakThreshold{
+			{GPM: 0.1, For: 10 * time.Minute, From: 0, To: 5 * time.Hour}, // nights
+			{GPM: 2, For: 30 * time.Minute},
+		},
+	}
+	for _, tc := range []struct {
+		hour int
+		want bool
+	}{{1, true}, {12, false}} {
+		device := fmt.Sprintf("at-%d", tc.hour)
+		start := time.Date(2026, 9, 1, tc.hour, 0, 0, 0, la)
+		var opened *LeakIncident
+		for m := 0; m <= 15; m++ {
+			if inc := d.Observe(device, start.Add(time.Duration(m)*time.Minute), 1); inc != nil {
+				opened = inc
+			}
+		}
+		if (opened != nil) != tc.want {
+			t.Errorf("1 gpm for 15m at %d:00: incident %+v", tc.hour, opened)
+		}
+		if opened != nil && opened.Threshold.GPM != 0.1 {
+			t.Errorf("incident at %d:00 used threshold %+v", tc.hour, opened.Threshold)
+		}
+	}
+}
+
+func TestLeakDetector_maxGap(t *testing.T) {
+	d := &LeakDetector{Thresholds: []LeakThreshold{{GPM: 0.5, For: 5 * time.Minute}}, MaxGap: 15 * time.Minute}
+	start := time.Date(2026, 9, 1, 3, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+
+	// A run broken by a gap starts over rather than opening an incident.
+	for _, m := range []int{0, 1, 2, 30, 31, 32, 33, 34} {
+		if inc := d.Observe("d1", at(m), 1); inc != nil {
+			t.Fatalf("minute %d: unexpected incident %+v", m, inc)
+		}
+	}
+	if inc := d.Observe("d1", at(35), 1); inc == nil || !inc.Start.Equal(at(30)) {
+		t.Fatalf("incident = %+v", inc)
+	}
+
+	// An open incident ends at the last reading before a gap, with no
+	// volume for the gap, and the flow after it is a new run.
+	closed := d.Observe("d1", at(60), 1)
+	if closed == nil || closed.Open() || !closed.End.Equal(at(35)) || closed.Gallons != 5 {
+		t.Fatalf("closed = %+v", closed)
+	}
+	for m := 61; m < 65; m++ {
+		if inc := d.Observe("d1", at(m), 1); inc != nil {
+			t.Fatalf("minute %d: unexpected incident %+v", m, inc)
+		}
+	}
+	if inc := d.Observe("d1", at(65), 1); inc == nil || !inc.Start.Equal(at(60)) || inc.Gallons != 5 {
+		t.Fatalf("incident after the gap = %+v", inc)
+	}
+
+	// Without a MaxGap readings are continuous however far apart.
+	d = &LeakDetector{Thresholds: []LeakThreshold{{GPM: 0.5, For: 5 * time.Minute}}}
+	d.Observe("d1", at(0), 1)
+	if inc := d.Observe("d1", at(30), 1); inc == nil || inc.Gallons != 30 {
+		t.Errorf("incident without a gap limit = %+v", inc)
+	}
+}
+
+func TestLeakDetector_observeUsage(t *testing.T) {
+	d := &LeakDetector{Thresholds: []LeakThreshold{{GPM: 0.5, For: 3 * time.Minute}}}
+	rows := []UsageQuery{
+		{Value: 2, Datetime: "2026-09-01 03:02:00"},
+		{Value: 0, Datetime: "2026-09-01 03:00:00"},
+		{Value: 1, Datetime: "2026-09-01 03:01:00"},
+		{Value: 2, Datetime: "2026-09-01 03:03:00"},
+		{Value: 1, Datetime: "2026-09-01 03:04:00"},
+	}
+	got, err := d.ObserveUsage("d1", time.UTC, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].Open() || got[0].Opened.Format(usageDatetimeLayout) != "2026-09-01 03:04:00" {
+		t.Fatalf("incidents = %+v", got)
+	}
+
+	// The next query overlaps the previous one.
+	got, err = d.ObserveUsage("d1", time.UTC, append(rows[3:], UsageQuery{Value: 0, Datetime: "2026-09-01 03:05:00"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Open() || got[0].Gallons != 6 {
+		t.Fatalf("incidents = %+v", got)
+	}
+
+	if _, err := d.ObserveUsage("d1", time.UTC, []UsageQuery{{Datetime: "yesterday"}}); err == nil {
+		t.Error("expected an error for a bad datetime")
+	}
+}
+
+func TestLeakDetector_observeFlow(t *testing.T) {
+	d := &LeakDetector{Thresholds: []LeakThreshold{{GPM: 0.5, For: time.Minute}}}
+	start := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	events := []FlowEvent{
+		{Type: FlowReading, Time: start, Flow: Flow{Active: true, GPM: 1}},
+		{Type: DeviceUnreachable, Time: start.Add(time.Minute)},
+		{Type: FlowReading, Time: start.Add(2 * time.Minute), Flow: Flow{Active: true, GPM: 1}},
+		{Type: FlowReading, Time: start.Add(3 * time.Minute), Flow: Flow{Active: false, GPM: 0.3}},
+	}
+	var got []*LeakIncident
+	for _, e := range events {
+		e.DeviceID = "d1"
+		if inc := d.ObserveFlow(e); inc != nil {
+			got = append(got, inc)
+		}
+	}
+	if len(got) != 2 || got[1].Open() || got[1].Gallons != 3 {
+		t.Errorf("incidents = %+v", got)
+	}
+}
+
+func TestLeakThreshold_applies(t *testing.T) {
+	night := LeakThreshold{From: 22 * time.Hour, To: 6 * time.Hour}
+	day := LeakThreshold{From: 6 * time.Hour, To: 22 * time.Hour}
+	for hour, wantNight := range map[int]bool{0: true, 5: true, 6: false, 12: false, 21: false, 22: true, 23: true} {
+		at := time.Date(2026, 9, 1, hour, 30, 0, 0, time.UTC)
+		if night.applies(at) != wantNight || day.applies(at) == wantNight {
+			t.Errorf("%d:30: night %v, day %v", hour, night.applies(at), day.applies(at))
+		}
+	}
+	if !(LeakThreshold{}).applies(time.Now()) {
+		t.Error("a threshold without a window should always apply")
+	}
+}
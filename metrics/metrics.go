@@ -0,0 +1,499 @@
+// Package metrics exposes Flume account data as Prometheus metrics.
+//
+// An Exporter refreshes its data in the background and renders scrapes from
+// that cache, so Prometheus scrape intervals never drive API requests:
+//
+//	e := &metrics.Exporter{Client: client}
+//	go e.Run(ctx)
+//	http.Handle("/metrics", e)
+//
+// The current flow of every water sensor is refreshed every FlowInterval.
+// Devices, locations, budgets and usage are refreshed every SlowInterval.
+// Both share a goflume.RequestBudget, so the exporter stays inside the API
+// rate limit no matter how often it is scraped.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// waterSensorType is the goflume.Device.Type of a flow sensor.
+const waterSensorType = 2
+
+const datetimeLayout = "2006-01-02 15:04:05"
+
+// Exporter serves Prometheus metrics from a cache refreshed by Run.
+type Exporter struct {
+	Client *goflume.Client
+	// Login, when set, is called before a refresh once the access token
+	// expires within a minute. It should refresh the token or log in
+	// again.
+	Login func(ctx context.Context, c *goflume.Client) error
+
+	// FlowInterval defaults to one minute and SlowInterval to 15 minutes.
+	FlowInterval time.Duration
+	SlowInterval time.Duration
+
+	// UsageLag is how far back every usage refresh reads again, because
+	// Flume fills in minute usage late. Growth of a minute already counted
+	// is added to flume_usage_gallons_total. It defaults to 30 minutes.
+	UsageLag time.Duration
+
+	// Budget is shared with other pollers of the same account. When nil
+	// the exporter uses its own budget of goflume.DefaultRequestLimit per
+	// hour.
+	Budget *goflume.RequestBudget
+
+	// Now and After replace time.Now and time.After.
+	Now   func() time.Time
+	After func(time.Duration) <-chan time.Time
+
+	mu        sync.Mutex
+	devices   []goflume.Device
+	locations map[int]goflume.Location
+	flows     map[string]goflume.Flow
+	budgets   map[string][]goflume.Budget
+	usage     map[string]*usageCounter
+	requests  map[string]*endpointStats
+	refreshes map[string]*refreshStats
+	slowAt    time.Time
+}
+
+// usageCounter accumulates complete minute buckets of a device.
+type usageCounter struct {
+	gallons float64
+	next    time.Time         // start of the first bucket not read yet
+	seen    map[int64]float64 // gallons counted per recent bucket, by Unix time
+	skipped int               // minutes never read after a long outage
+}
+
+type endpointStats struct{ requests, errors int }
+
+type refreshStats struct {
+	last   time.Time
+	errors int
+}
+
+// errThrottled stops a refresh when the request budget is used up.
+var errThrottled = errors.New("request budget exhausted")
+
+func (e *Exporter) init() {
+	if e.Budget == nil {
+		e.Budget = &goflume.RequestBudget{Now: e.Now}
+	}
+	if e.requests == nil {
+		e.locations = map[int]goflume.Location{}
+		e.flows = map[string]goflume.Flow{}
+		e.budgets = map[string][]goflume.Budget{}
+		e.usage = map[string]*usageCounter{}
+		e.requests = map[string]*endpointStats{}
+		e.refreshes = map[string]*refreshStats{"flow": {}, "slow": {}}
+	}
+}
+
+// Run refreshes the cache until ctx is done, and returns ctx.Err(). Failed
+// refreshes keep the previous values and are counted in
+// flume_exporter_refresh_errors_total.
+func (e *Exporter) Run(ctx context.Context) error {
+	e.mu.Lock()
+	e.init()
+	e.mu.Unlock()
+	for {
+		e.Refresh(ctx)
+		e.mu.Lock()
+		perRound := len(e.sensors())
+		e.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.after(e.Budget.Pace(max(perRound, 1), e.flowInterval(), false)):
+		}
+	}
+}
+
+// Refresh updates the cache once: the slow data when it is due, then the
+// current flow. It returns the first error.
+func (e *Exporter) Refresh(ctx context.Context) error {
+	e.mu.Lock()
+	e.init()
+	slowDue := e.slowAt.IsZero() || !e.now().Before(e.slowAt.Add(e.slowInterval()))
+	e.mu.Unlock()
+
+	if e.Login != nil && time.Unix(int64(e.Client.JWT.Exp), 0).Before(e.now().Add(time.Minute)) {
+		if err := e.Login(ctx, e.Client); err != nil {
+			e.failed("slow")
+			e.failed("flow")
+			return fmt.Errorf("login: %w", err)
+		}
+	}
+	var first error
+	if slowDue {
+		if err := e.refreshSlow(ctx); err != nil {
+			e.failed("slow")
+			first = err
+		}
+	}
+	if err := e.refreshFlow(ctx); err != nil {
+		e.failed("flow")
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (e *Exporter) failed(kind string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.refreshes[kind].errors++
+}
+
+func (e *Exporter) refreshed(kind string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.refreshes[kind].last = e.now()
+	if kind == "slow" {
+		e.slowAt = e.now()
+	}
+}
+
+// call spends one request of the budget on fn and counts it for endpoint.
+func (e *Exporter) call(endpoint string, fn func() error) error {
+	if _, ok := e.Budget.TryReserve(1); !ok {
+		return errThrottled
+	}
+	err := fn()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.requests[endpoint]
+	if s == nil {
+		s = &endpointStats{}
+		e.requests[endpoint] = s
+	}
+	s.requests++
+	if err != nil {
+		s.errors++
+	}
+	return err
+}
+
+func (e *Exporter) refreshSlow(ctx context.Context) error {
+	var devices *goflume.DevicesResponse
+	if err := e.call("devices", func() (err error) {
+		devices, err = e.Client.GetDevices(ctx, nil)
+		return err
+	}); err != nil {
+		return fmt.Errorf("list devices: %w", err)
+	}
+	var locations *goflume.LocationsResponse
+	if err := e.call("locations", func() (err error) {
+		locations, err = e.Client.GetLocations(ctx, nil)
+		return err
+	}); err != nil {
+		return fmt.Errorf("list locations: %w", err)
+	}
+	e.mu.Lock()
+	e.devices = devices.Data
+	for _, l := range locations.Data {
+		e.locations[l.ID] = l
+	}
+	sensors := e.sensors()
+	e.mu.Unlock()
+
+	for _, d := range sensors {
+		var budgets *goflume.BudgetsResponse
+		if err := e.call("budgets", func() (err error) {
+			budgets, err = e.Client.GetBudgets(ctx, d.ID, nil)
+			return err
+		}); err != nil {
+			return fmt.Errorf("budgets of %s: %w", d.ID, err)
+		}
+		e.mu.Lock()
+		e.budgets[d.ID] = budgets.Data
+		e.mu.Unlock()
+		if err := e.refreshUsage(ctx, d); err != nil {
+			return err
+		}
+	}
+	e.refreshed("slow")
+	return nil
+}
+
+// refreshUsage adds the complete minutes since the last refresh to the usage
+// counter of d, and reads the last UsageLag again to add what Flume reported
+// late. The first refresh counts from the start of the day in the device's
+// time zone. A query never reaches back more than a day; minutes older than
+// that are counted in flume_usage_skipped_minutes_total.
+func (e *Exporter) refreshUsage(ctx context.Context, d goflume.Device) error {
+	e.mu.Lock()
+	tz := e.tz(d)
+	c := e.usage[d.ID]
+	if c == nil {
+		y, m, day := e.now().In(tz).Date()
+		c = &usageCounter{next: time.Date(y, m, day, 0, 0, 0, 0, tz), seen: map[int64]float64{}}
+		e.usage[d.ID] = c
+	}
+	since := c.next.In(tz)
+	e.mu.Unlock()
+
+	// The minute in progress is not complete yet.
+	until := e.now().In(tz).Truncate(time.Minute).Add(-time.Minute)
+	recent := until.Add(-e.usageLag())
+	if recent.Before(since) {
+		since = recent
+	}
+	skipped := 0
+	if oldest := until.Add(-24 * time.Hour); since.Before(oldest) {
+		skipped = int(oldest.Sub(since) / time.Minute)
+		since = oldest
+	}
+	var resp *goflume.QueryUsageResponse
+	if err := e.call("query", func() (err error) {
+		resp, err = e.Client.QueryUsage(ctx, d.ID, goflume.QueryUsageRequestBody{
+			RequestID:     "exporter",
+			Bucket:        "MIN",
+			SinceDatetime: since.Format(datetimeLayout),
+			UntilDatetime: until.Format(datetimeLayout),
+		})
+		return err
+	}); err != nil {
+		return fmt.Errorf("usage of %s: %w", d.ID, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, row := range resp.Data {
+		t, err := time.ParseInLocation(datetimeLayout, row.Datetime, tz)
+		if err != nil {
+			return fmt.Errorf("usage of %s: %w", d.ID, err)
+		}
+		if t.Before(since) || t.After(until) {
+			continue
+		}
+		// Only growth is counted, so the counter never goes down.
+		if v := float64(row.Value); v > c.seen[t.Unix()] {
+			c.gallons += v - c.seen[t.Unix()]
+			c.seen[t.Unix()] = v
+		}
+	}
+	for t := range c.seen {
+		if t < recent.Unix() {
+			delete(c.seen, t)
+		}
+	}
+	c.skipped += skipped
+	c.next = until.Add(time.Minute)
+	return nil
+}
+
+func (e *Exporter) refreshFlow(ctx context.Context) error {
+	e.mu.Lock()
+	sensors := e.sensors()
+	e.mu.Unlock()
+	for _, d := range sensors {
+		var resp *goflume.FlowResponse
+		if err := e.call("flow", func() (err error) {
+			resp, err = e.Client.GetCurrentFlow(ctx, d.ID)
+			return err
+		}); err != nil {
+			return fmt.Errorf("flow of %s: %w", d.ID, err)
+		}
+		if len(resp.Data) == 0 {
+			continue
+		}
+		e.mu.Lock()
+		e.flows[d.ID] = resp.Data[0]
+		e.mu.Unlock()
+	}
+	e.refreshed("flow")
+	return nil
+}
+
+// sensors returns the water sensors; e.mu must be held.
+func (e *Exporter) sensors() []goflume.Device {
+	var out []goflume.Device
+	for _, d := range e.devices {
+		if d.Type == waterSensorType {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// tz returns the time zone of the location of d; e.mu must be held.
+func (e *Exporter) tz(d goflume.Device) *time.Location {
+	if l, ok := e.locations[d.LocationID]; ok && l.TZ != "" {
+		if tz, err := time.LoadLocation(l.TZ); err == nil {
+			return tz
+		}
+	}
+	return time.UTC
+}
+
+// ServeHTTP writes the cached metrics in the Prometheus text format. It
+// never calls the API.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.mu.Lock()
+	e.init()
+	b := e.render()
+	e.mu.Unlock()
+	_, _ = w.Write(b)
+}
+
+// batteryLevels maps goflume.Device.BatteryLevel to a gauge value.
+var batteryLevels = map[string]float64{"low": 1, "medium": 2, "high": 3}
+
+// render formats the cache; e.mu must be held.
+func (e *Exporter) render() []byte {
+	now := e.now()
+	var w writer
+
+	devices := append([]goflume.Device(nil), e.devices...)
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+
+	w.family("flume_device_info", "gauge", "Device metadata, always 1.")
+	for _, d := range devices {
+		w.sample("flume_device_info", 1, "device", d.ID, "location", e.locations[d.LocationID].Name,
+			"product", d.Product, "type", fmt.Sprint(d.Type), "bridge", d.BridgeID)
+	}
+	w.family("flume_device_connected", "gauge", "Whether the device is connected (1) or not (0).")
+	for _, d := range devices {
+		w.sample("flume_device_connected", boolValue(d.Connected), "device", d.ID)
+	}
+	w.family("flume_device_battery_level", "gauge", "Battery level of the device: 1 low, 2 medium, 3 high.")
+	for _, d := range devices {
+		if v, ok := batteryLevels[d.BatteryLevel]; ok {
+			w.sample("flume_device_battery_level", v, "device", d.ID)
+		}
+	}
+	w.family("flume_device_last_seen_age_seconds", "gauge", "Seconds since the device was last seen by Flume.")
+	for _, d := range devices {
+		if t, err := time.Parse(time.RFC3339, d.LastSeen); err == nil {
+			w.sample("flume_device_last_seen_age_seconds", now.Sub(t).Seconds(), "device", d.ID)
+		}
+	}
+
+	sensors := e.sensors()
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].ID < sensors[j].ID })
+	w.family("flume_flow_gpm", "gauge", "Current flow rate in gallons per minute.")
+	for _, d := range sensors {
+		if f, ok := e.flows[d.ID]; ok {
+			w.sample("flume_flow_gpm", f.GPM, "device", d.ID)
+		}
+	}
+	w.family("flume_flow_active", "gauge", "Whether water is flowing (1) or not (0).")
+	for _, d := range sensors {
+		if f, ok := e.flows[d.ID]; ok {
+			w.sample("flume_flow_active", boolValue(f.Active), "device", d.ID)
+		}
+	}
+	w.family("flume_usage_gallons_total", "counter", "Water used since the start of the day the exporter started, in complete minutes.")
+	for _, d := range sensors {
+		if c, ok := e.usage[d.ID]; ok {
+			w.sample("flume_usage_gallons_total", c.gallons, "device", d.ID)
+		}
+	}
+	w.family("flume_usage_skipped_minutes_total", "counter", "Minutes of usage never counted because no refresh succeeded for more than a day.")
+	for _, d := range sensors {
+		if c, ok := e.usage[d.ID]; ok {
+			w.sample("flume_usage_skipped_minutes_total", float64(c.skipped), "device", d.ID)
+		}
+	}
+	w.family("flume_budget_actual_gallons", "gauge", "Water used in the current budget period.")
+	for _, d := range sensors {
+		for _, b := range e.budgets[d.ID] {
+			w.sample("flume_budget_actual_gallons", float64(b.Actual), "device", d.ID, "budget", b.Name, "period", b.Type)
+		}
+	}
+	w.family("flume_budget_value_gallons", "gauge", "Budgeted water for the budget period.")
+	for _, d := range sensors {
+		for _, b := range e.budgets[d.ID] {
+			w.sample("flume_budget_value_gallons", float64(b.Value), "device", d.ID, "budget", b.Name, "period", b.Type)
+		}
+	}
+
+	endpoints := make([]string, 0, len(e.requests))
+	for ep := range e.requests {
+		endpoints = append(endpoints, ep)
+	}
+	sort.Strings(endpoints)
+	w.family("flume_api_requests_total", "counter", "API requests made by the exporter.")
+	for _, ep := range endpoints {
+		w.sample("flume_api_requests_total", float64(e.requests[ep].requests), "endpoint", ep)
+	}
+	w.family("flume_api_errors_total", "counter", "API requests of the exporter that failed.")
+	for _, ep := range endpoints {
+		w.sample("flume_api_errors_total", float64(e.requests[ep].errors), "endpoint", ep)
+	}
+	w.family("flume_api_quota_remaining", "gauge", "Requests left in the exporter's rate limit window.")
+	w.sample("flume_api_quota_remaining", float64(e.Budget.Remaining()))
+	w.family("flume_api_quota_limit", "gauge", "Requests allowed per rate limit window.")
+	limit := e.Budget.Limit
+	if limit <= 0 {
+		limit = goflume.DefaultRequestLimit
+	}
+	w.sample("flume_api_quota_limit", float64(limit))
+
+	w.family("flume_exporter_last_refresh_timestamp_seconds", "gauge", "Unix time of the last successful refresh.")
+	for _, kind := range []string{"flow", "slow"} {
+		if r := e.refreshes[kind]; !r.last.IsZero() {
+			w.sample("flume_exporter_last_refresh_timestamp_seconds", float64(r.last.UnixMilli())/1000, "refresh", kind)
+		}
+	}
+	w.family("flume_exporter_refresh_errors_total", "counter", "Refreshes that failed.")
+	for _, kind := range []string{"flow", "slow"} {
+		w.sample("flume_exporter_refresh_errors_total", float64(e.refreshes[kind].errors), "refresh", kind)
+	}
+	return w.Bytes()
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e *Exporter) flowInterval() time.Duration {
+	if e.FlowInterval > 0 {
+		return e.FlowInterval
+	}
+	return time.Minute
+}
+
+func (e *Exporter) slowInterval() time.Duration {
+	if e.SlowInterval > 0 {
+		return e.SlowInterval
+	}
+	return 15 * time.Minute
+}
+
+func (e *Exporter) usageLag() time.Duration {
+	if e.UsageLag > 0 {
+		return e.UsageLag
+	}
+	return 30 * time.Minute
+}
+
+func (e *Exporter) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
+
+func (e *Exporter) after(d time.Duration) <-chan time.Time {
+	if e.After != nil {
+		return e.After(d)
+	}
+	return time.After(d)
+}
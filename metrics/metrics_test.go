@@ -0,0 +1,222 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+const deviceID = "6248148189204194987"
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func scrape(t *testing.T, e *Exporter) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestExporter(t *testing.T) {
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	clk := &clock{t: time.Date(2026, 9, 15, 10, 30, 20, 0, la)}
+	var requests atomic.Int32
+	srv := flumetest.NewServer(flumetest.WithClock(clk.now), flumetest.WithTokenTTL(24*time.Hour),
+		flumetest.WithMiddleware(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				next.ServeHTTP(w, r)
+			})
+		}))
+	t.Cleanup(srv.Close)
+	c := srv.Client()
+	if err := c.Authenticate(context.Background(), flumetest.Username, flumetest.Password); err != nil {
+		t.Fatal(err)
+	}
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = []goflume.UsageQuery{
+			{Value: 9, Datetime: "2026-09-14 23:59:00"}, // yesterday
+			{Value: 4, Datetime: "2026-09-15 07:00:00"},
+			{Value: 2, Datetime: "2026-09-15 10:29:00"},
+			{Value: 5, Datetime: "2026-09-15 10:30:00"}, // the minute in progress
+		}
+		s.Budgets[deviceID] = []goflume.Budget{{ID: 1, Name: "Monthly", Type: "MONTHLY", Value: 4000, Actual: 1200}}
+		s.Flow[deviceID] = goflume.Flow{Active: true, GPM: 1.5}
+	})
+
+	e := &Exporter{Client: c, Now: clk.now}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sent := requests.Load()
+	out := scrape(t, e)
+	for _, want := range []string{
+		"# TYPE flume_flow_gpm gauge\nflume_flow_gpm{device=\"6248148189204194987\"} 1.5\n",
+		"flume_flow_active{device=\"6248148189204194987\"} 1\n",
+		"# TYPE flume_usage_gallons_total counter\nflume_usage_gallons_total{device=\"6248148189204194987\"} 6\n",
+		"flume_budget_actual_gallons{device=\"6248148189204194987\",budget=\"Monthly\",period=\"MONTHLY\"} 1200\n",
+		"flume_budget_value_gallons{device=\"6248148189204194987\",budget=\"Monthly\",period=\"MONTHLY\"} 4000\n",
+		"flume_device_connected{device=\"6248148189204194987\"} 1\n",
+		"flume_device_battery_level{device=\"6248148189204194987\"} 3\n",
+		"flume_device_info{device=\"6248148189204194987\",location=\"Home\",product=\"flume2\",type=\"2\",bridge=\"6248148189204194000\"} 1\n",
+		"flume_api_requests_total{endpoint=\"flow\"} 1\n",
+		"flume_api_quota_remaining 115\n",
+		"flume_exporter_refresh_errors_total{refresh=\"slow\"} 0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape is missing %q:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(out, "flume_device_last_seen_age_seconds{device=\"6248148189204194987\"} 2.226782e+07\n") {
+		t.Errorf("unexpected last seen age:\n%s", out)
+	}
+
+	// Scrapes are served from the cache.
+	scrape(t, e)
+	if requests.Load() != sent {
+		t.Errorf("scraping made %d API requests", requests.Load()-sent)
+	}
+
+	// Only the current flow is refreshed until the slow data is due, and
+	// usage is counted once.
+	clk.t = clk.t.Add(2 * time.Minute)
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = append(s.Usage[deviceID], goflume.UsageQuery{Value: 3, Datetime: "2026-09-15 10:31:00"})
+		s.Flow[deviceID] = goflume.Flow{}
+	})
+	sent = requests.Load()
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load() - sent; n != 1 {
+		t.Errorf("flow refresh made %d requests", n)
+	}
+	clk.t = clk.t.Add(15 * time.Minute)
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	out = scrape(t, e)
+	for _, want := range []string{
+		"flume_usage_gallons_total{device=\"6248148189204194987\"} 14\n",
+		"flume_flow_active{device=\"6248148189204194987\"} 0\n",
+		"flume_api_requests_total{endpoint=\"query\"} 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExporter_lateUsage(t *testing.T) {
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	clk := &clock{t: time.Date(2026, 9, 15, 10, 30, 20, 0, la)}
+	srv := flumetest.NewServer(flumetest.WithClock(clk.now), flumetest.WithTokenTTL(30*24*time.Hour))
+	t.Cleanup(srv.Close)
+	c := srv.Client()
+	if err := c.Authenticate(context.Background(), flumetest.Username, flumetest.Password); err != nil {
+		t.Fatal(err)
+	}
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = []goflume.UsageQuery{{Value: 2, Datetime: "2026-09-15 10:20:00"}}
+	})
+	e := &Exporter{Client: c, Now: clk.now}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flume reports more for a minute already counted, and a late minute
+	// before the last refresh.
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = []goflume.UsageQuery{
+			{Value: 5, Datetime: "2026-09-15 10:20:00"},
+			{Value: 1, Datetime: "2026-09-15 10:25:00"},
+		}
+	})
+	clk.t = clk.t.Add(15 * time.Minute)
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if out := scrape(t, e); !strings.Contains(out, "flume_usage_gallons_total{device=\"6248148189204194987\"} 6\n") ||
+		!strings.Contains(out, "flume_usage_skipped_minutes_total{device=\"6248148189204194987\"} 0\n") {
+		t.Errorf("late usage was not counted:\n%s", out)
+	}
+
+	// After three days without a refresh only the last day is queried.
+	clk.t = clk.t.Add(72 * time.Hour)
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if out := scrape(t, e); !strings.Contains(out, "flume_usage_skipped_minutes_total{device=\"6248148189204194987\"} 2879\n") {
+		t.Errorf("skipped minutes were not counted:\n%s", out)
+	}
+}
+
+func TestExporter_failuresKeepValues(t *testing.T) {
+	clk := &clock{t: time.Date(2026, 9, 15, 10, 30, 0, 0, time.UTC)}
+	var failing atomic.Bool
+	srv := flumetest.NewServer(flumetest.WithClock(clk.now), flumetest.WithTokenTTL(time.Hour),
+		flumetest.WithMiddleware(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if failing.Load() && strings.HasSuffix(r.URL.Path, "/query/active") {
+					http.Error(w, `{"success":false}`, http.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		}))
+	t.Cleanup(srv.Close)
+	c := srv.Client()
+	if err := c.Authenticate(context.Background(), flumetest.Username, flumetest.Password); err != nil {
+		t.Fatal(err)
+	}
+	srv.Update(func(s *flumetest.State) { s.Flow[deviceID] = goflume.Flow{Active: true, GPM: 2} })
+
+	logins := 0
+	e := &Exporter{Client: c, Now: clk.now, Login: func(ctx context.Context, c *goflume.Client) error {
+		logins++
+		return c.Authenticate(ctx, flumetest.Username, flumetest.Password)
+	}}
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	failing.Store(true)
+	clk.t = clk.t.Add(59*time.Minute + 30*time.Second)
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Fatal("expected the flow refresh to fail")
+	}
+	if logins != 1 {
+		t.Errorf("logged in %d times, want once before the token expired", logins)
+	}
+	out := scrape(t, e)
+	for _, want := range []string{
+		"flume_flow_gpm{device=\"6248148189204194987\"} 2\n",
+		"flume_api_errors_total{endpoint=\"flow\"} 1\n",
+		"flume_exporter_refresh_errors_total{refresh=\"flow\"} 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var w writer
+	w.family("x", "gauge", "Help with \\ backslash.")
+	w.sample("x", 0.5, "name", "a \"quoted\"\nvalue")
+	want := "# HELP x Help with \\\\ backslash.\n# TYPE x gauge\nx{name=\"a \\\"quoted\\\"\\nvalue\"} 0.5\n"
+	if got := w.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
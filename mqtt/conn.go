@@ -0,0 +1,454 @@
+// Package mqtt publishes Flume data to an MQTT broker with Home Assistant
+// discovery, and takes away mode commands back from it.
+//
+// It carries its own minimal MQTT 3.1.1 client, limited to what the
+// publisher needs: QoS 0 publishing with retained messages, QoS 0
+// subscriptions, a last will and keep-alive pings.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Packet types of MQTT 3.1.1.
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+	maxRemainingBytes = 268435455
+)
+
+// Message is an application message.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Options configures a connection.
+type Options struct {
+	ClientID string
+	Username string
+	Password string
+	// KeepAlive is the ping interval. Defaults to 30 seconds. A connection
+	// whose ping goes unanswered for an interval is closed.
+	KeepAlive time.Duration
+	// Will is published by the broker when the connection is lost.
+	Will *Message
+	// Dial opens the network connection, for example with TLS. Defaults
+	// to a net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Conn is a connection to a broker.
+type Conn struct {
+	conn     net.Conn
+	messages chan Message
+	pongs    chan struct{}
+	queued   chan struct{} // signals deliverLoop that queue has messages
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu       sync.Mutex
+	nextID   uint16
+	subacks  map[uint16]chan []byte
+	queue    []Message // received but not yet taken from Messages
+	err      error
+	done     chan struct{}
+	closeOne sync.Once
+}
+
+// Dial connects to the broker at addr (host:port) and waits for it to
+// accept the connection.
+func Dial(ctx context.Context, addr string, opts Options) (*Conn, error) {
+	dial := opts.Dial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	nc, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	keepAlive := opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = 30 * time.Second
+	}
+
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientID)
+	if opts.Will != nil {
+		flags |= 0x04
+		if opts.Will.Retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, opts.Will.Topic)
+		payload = appendBytes(payload, opts.Will.Payload)
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+	}
+	if opts.Password != "" {
+		flags |= 0x40
+		payload = appendString(payload, opts.Password)
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(keepAlive/time.Second))
+	body = append(body, payload...)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = nc.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+	if err := writePacket(w, packetConnect<<4, body); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	typ, ack, err := readPacket(r)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("read connack: %w", err)
+	}
+	if typ>>4 != packetConnack || len(ack) != 2 {
+		nc.Close()
+		return nil, fmt.Errorf("unexpected packet %d instead of connack", typ>>4)
+	}
+	if ack[1] != 0 {
+		nc.Close()
+		return nil, fmt.Errorf("broker refused the connection: %s", connackReason(ack[1]))
+	}
+	_ = nc.SetDeadline(time.Time{})
+
+	c := &Conn{
+		conn:     nc,
+		w:        w,
+		messages: make(chan Message),
+		pongs:    make(chan struct{}, 1),
+		queued:   make(chan struct{}, 1),
+		subacks:  map[uint16]chan []byte{},
+		done:     make(chan struct{}),
+	}
+	go c.readLoop(r)
+	go c.deliverLoop()
+	go c.pingLoop(keepAlive)
+	return c, nil
+}
+
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
+
+// Publish sends a message with QoS 0.
+func (c *Conn) Publish(m Message) error {
+	var header byte = packetPublish << 4
+	if m.Retain {
+		header |= 0x01
+	}
+	body := appendString(nil, m.Topic)
+	return c.write(header, append(body, m.Payload...))
+}
+
+// Subscribe asks for the messages matching a topic filter with QoS 0 and
+// waits for the broker to confirm. They arrive on Messages.
+func (c *Conn) Subscribe(ctx context.Context, filter string) error {
+	c.mu.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	id := c.nextID
+	ack := make(chan []byte, 1)
+	c.subacks[id] = ack
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.subacks, id)
+		c.mu.Unlock()
+	}()
+
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendString(body, filter)
+	body = append(body, 0)
+	if err := c.write(packetSubscribe<<4|0x02, body); err != nil {
+		return err
+	}
+	select {
+	case codes := <-ack:
+		if len(codes) != 1 || codes[0] == 0x80 {
+			return fmt.Errorf("broker refused the subscription to %s", filter)
+		}
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Messages returns the messages of the subscriptions. It is closed when the
+// connection ends. Messages wait in an unbounded queue until they are taken,
+// so a slow reader never holds up the keep-alive pings.
+func (c *Conn) Messages() <-chan Message { return c.messages }
+
+// Done is closed when the connection ends.
+func (c *Conn) Done() <-chan struct{} { return c.done }
+
+// Err returns why the connection ended, or nil while it is up or after
+// Close.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close disconnects cleanly, so the broker does not publish the will.
+func (c *Conn) Close() error {
+	err := c.write(packetDisconnect<<4, nil)
+	c.shutdown(nil)
+	return err
+}
+
+func (c *Conn) write(header byte, body []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.done:
+		if err := c.Err(); err != nil {
+			return err
+		}
+		return net.ErrClosed
+	default:
+	}
+	if err := writePacket(c.w, header, body); err != nil {
+		c.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) shutdown(err error) {
+	c.closeOne.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *Conn) readLoop(r *bufio.Reader) {
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				err = nil
+			}
+			c.shutdown(err)
+			return
+		}
+		switch header >> 4 {
+		case packetPublish:
+			m, err := parsePublish(header, body)
+			if err != nil {
+				c.shutdown(err)
+				return
+			}
+			c.mu.Lock()
+			c.queue = append(c.queue, m)
+			c.mu.Unlock()
+			select {
+			case c.queued <- struct{}{}:
+			default:
+			}
+		case packetSuback:
+			if len(body) < 2 {
+				c.shutdown(errors.New("short suback"))
+				return
+			}
+			c.mu.Lock()
+			ack := c.subacks[binary.BigEndian.Uint16(body)]
+			c.mu.Unlock()
+			if ack != nil {
+				// A duplicate suback finds the buffer full and is dropped.
+				select {
+				case ack <- body[2:]:
+				default:
+				}
+			}
+		case packetPingresp:
+			select {
+			case c.pongs <- struct{}{}:
+			default:
+			}
+		default:
+			c.shutdown(fmt.Errorf("unexpected packet type %d", header>>4))
+			return
+		}
+	}
+}
+
+// deliverLoop hands the queued messages to Messages until the connection
+// ends.
+func (c *Conn) deliverLoop() {
+	defer close(c.messages)
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.queued:
+		}
+		c.mu.Lock()
+		batch := c.queue
+		c.queue = nil
+		c.mu.Unlock()
+		for _, m := range batch {
+			select {
+			case c.messages <- m:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// pingLoop pings the broker every interval. A broker that has not answered
+// the previous ping by the next one is taken to be gone, since a half-open
+// TCP connection would otherwise go unnoticed until a write fails.
+func (c *Conn) pingLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	waiting := false
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.pongs:
+			waiting = false
+		case <-t.C:
+			if waiting {
+				c.shutdown(errors.New("no ping response from the broker"))
+				return
+			}
+			if c.write(packetPingreq<<4, nil) != nil {
+				return
+			}
+			waiting = true
+		}
+	}
+}
+
+func parsePublish(header byte, body []byte) (Message, error) {
+	topic, rest, err := readString(body)
+	if err != nil {
+		return Message{}, err
+	}
+	if qos := header >> 1 & 0x03; qos > 0 {
+		// Subscriptions are QoS 0, but skip the packet ID of a broker that
+		// sends more.
+		if len(rest) < 2 {
+			return Message{}, errors.New("short publish")
+		}
+		rest = rest[2:]
+	}
+	return Message{Topic: topic, Payload: rest, Retain: header&0x01 != 0}, nil
+}
+
+func writePacket(w *bufio.Writer, header byte, body []byte) error {
+	if len(body) > maxRemainingBytes {
+		return errors.New("packet too large")
+	}
+	buf := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	if _, err := w.Write(append(buf, body...)); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		mult *= 128
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("short string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("short string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
@@ -0,0 +1,349 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker is a small in-process MQTT 3.1.1 broker with QoS 0 routing,
+// retained messages and wills.
+type testBroker struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	retained map[string][]byte
+	subs     map[*brokerClient][]string
+}
+
+type brokerClient struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (c *brokerClient) send(header byte, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = writePacket(c.w, header, body)
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{ln: ln, retained: map[string][]byte{}, subs: map[*brokerClient][]string{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(nc)
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) addr() string { return b.ln.Addr().String() }
+
+func (b *testBroker) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	c := &brokerClient{w: bufio.NewWriter(nc)}
+	header, body, err := readPacket(r)
+	if err != nil || header>>4 != packetConnect {
+		return
+	}
+	will, user, ok := parseConnect(body)
+	if !ok || user == "refused" {
+		c.send(packetConnack<<4, []byte{0, 5})
+		return
+	}
+	c.send(packetConnack<<4, []byte{0, 0})
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, c)
+		b.mu.Unlock()
+		if will != nil {
+			b.publish(*will)
+		}
+	}()
+
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case packetPublish:
+			m, err := parsePublish(header, body)
+			if err != nil {
+				return
+			}
+			b.publish(m)
+		case packetSubscribe:
+			filter, _, err := readString(body[2:])
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.subs[c] = append(b.subs[c], filter)
+			var retained []Message
+			for topic, payload := range b.retained {
+				if match(filter, topic) {
+					retained = append(retained, Message{Topic: topic, Payload: payload, Retain: true})
+				}
+			}
+			b.mu.Unlock()
+			c.send(packetSuback<<4, append(body[:2:2], 0))
+			if user == "dupack" {
+				c.send(packetSuback<<4, append(body[:2:2], 0))
+			}
+			for _, m := range retained {
+				c.send(packetPublish<<4|1, append(appendString(nil, m.Topic), m.Payload...))
+			}
+		case packetPingreq:
+			if user != "mute" {
+				c.send(packetPingresp<<4, nil)
+			}
+		case packetDisconnect:
+			will = nil
+			return
+		}
+	}
+}
+
+// parseConnect returns the will and user name of a CONNECT packet.
+func parseConnect(body []byte) (*Message, string, bool) {
+	name, rest, err := readString(body)
+	if err != nil || name != "MQTT" || len(rest) < 4 || rest[0] != 4 {
+		return nil, "", false
+	}
+	flags := rest[1]
+	rest = rest[4:]
+	if _, rest, err = readString(rest); err != nil {
+		return nil, "", false
+	}
+	var will *Message
+	if flags&0x04 != 0 {
+		topic, r, err := readString(rest)
+		if err != nil {
+			return nil, "", false
+		}
+		payload, r, err := readString(r)
+		if err != nil {
+			return nil, "", false
+		}
+		will, rest = &Message{Topic: topic, Payload: []byte(payload), Retain: flags&0x20 != 0}, r
+	}
+	var user string
+	if flags&0x80 != 0 {
+		if user, _, err = readString(rest); err != nil {
+			return nil, "", false
+		}
+	}
+	return will, user, true
+}
+
+func (b *testBroker) publish(m Message) {
+	b.mu.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m.Payload
+		}
+	}
+	var targets []*brokerClient
+	for c, filters := range b.subs {
+		for _, f := range filters {
+			if match(f, m.Topic) {
+				targets = append(targets, c)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, c := range targets {
+		c.send(packetPublish<<4, append(appendString(nil, m.Topic), m.Payload...))
+	}
+}
+
+// waitRetained waits until the retained message of topic satisfies ok.
+func (b *testBroker) waitRetained(t *testing.T, topic string, ok func(string) bool) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		payload, found := b.retained[topic]
+		b.mu.Unlock()
+		if found && ok(string(payload)) {
+			return string(payload)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s, last %q", topic, payload)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// match reports whether topic matches a filter with + and # wildcards.
+func match(filter, topic string) bool {
+	f, tp := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, part := range f {
+		if part == "#" {
+			return true
+		}
+		if i >= len(tp) || (part != "+" && part != tp[i]) {
+			return false
+		}
+	}
+	return len(f) == len(tp)
+}
+
+func TestConn(t *testing.T) {
+	b := newTestBroker(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := Dial(ctx, b.addr(), Options{ClientID: "sub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	pub, err := Dial(ctx, b.addr(), Options{ClientID: "pub", Username: "u", Password: "p",
+		Will: &Message{Topic: "pub/status", Payload: []byte("gone"), Retain: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish(Message{Topic: "a/retained", Payload: []byte("kept"), Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+	b.waitRetained(t, "a/retained", func(string) bool { return true })
+	if err := sub.Subscribe(ctx, "a/+"); err != nil {
+		t.Fatal(err)
+	}
+	big := bytes.Repeat([]byte("x"), 20000) // a three byte remaining length
+	if err := pub.Publish(Message{Topic: "a/big", Payload: big}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish(Message{Topic: "b/other", Payload: []byte("no")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []Message{{Topic: "a/retained", Payload: []byte("kept"), Retain: true}, {Topic: "a/big", Payload: big}} {
+		select {
+		case m := <-sub.Messages():
+			if m.Topic != want.Topic || !bytes.Equal(m.Payload, want.Payload) || m.Retain != want.Retain {
+				t.Errorf("got %s (%d bytes, retain %v), want %s", m.Topic, len(m.Payload), m.Retain, want.Topic)
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for a message")
+		}
+	}
+
+	// Losing the connection publishes the will; closing cleanly does not.
+	pub.conn.Close()
+	b.waitRetained(t, "pub/status", func(p string) bool { return p == "gone" })
+	<-pub.Done()
+	if err := pub.Publish(Message{Topic: "a/late"}); err == nil {
+		t.Error("expected publishing on a lost connection to fail")
+	}
+}
+
+func TestDial_refused(t *testing.T) {
+	b := newTestBroker(t)
+	_, err := Dial(context.Background(), b.addr(), Options{ClientID: "x", Username: "refused"})
+	if err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("expected a refused connection, got %v", err)
+	}
+}
+
+func TestConn_pingTimeout(t *testing.T) {
+	b := newTestBroker(t)
+	ctx := context.Background()
+	live, err := Dial(ctx, b.addr(), Options{ClientID: "live", KeepAlive: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	// The broker does not answer the pings of this one.
+	mute, err := Dial(ctx, b.addr(), Options{ClientID: "mute", Username: "mute", KeepAlive: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-mute.Done():
+		if mute.Err() == nil {
+			t.Error("expected an error for the unanswered ping")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection without ping responses stayed up")
+	}
+	select {
+	case <-live.Done():
+		t.Errorf("answered connection closed: %v", live.Err())
+	default:
+	}
+}
+
+func TestConn_slowReader(t *testing.T) {
+	b := newTestBroker(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub, err := Dial(ctx, b.addr(), Options{ClientID: "sub", Username: "dupack", KeepAlive: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := sub.Subscribe(ctx, "a/#"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Subscribe(ctx, "b/#"); err != nil {
+		t.Fatal(err)
+	}
+	const n = 100
+	for i := 0; i < n; i++ {
+		b.publish(Message{Topic: "a/x", Payload: []byte{byte(i)}})
+	}
+	// Nobody reads for several keep-alive intervals; pings must still be
+	// answered.
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-sub.Done():
+		t.Fatalf("connection closed while the reader was busy: %v", sub.Err())
+	default:
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case m := <-sub.Messages():
+			if m.Payload[0] != byte(i) {
+				t.Fatalf("message %d out of order: %v", i, m.Payload)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out after %d messages", i)
+		}
+	}
+}
+
+func TestRemainingLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		if err := writePacket(w, packetPublish<<4, make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+		header, body, err := readPacket(bufio.NewReader(&buf))
+		if err != nil || header != packetPublish<<4 || len(body) != n {
+			t.Errorf("%d bytes: header %x, %d bytes, %v", n, header, len(body), err)
+		}
+	}
+}
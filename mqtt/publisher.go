@@ -0,0 +1,511 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// waterSensorType is the goflume.Device.Type of a flow sensor.
+const waterSensorType = 2
+
+const datetimeLayout = "2006-01-02 15:04:05"
+
+// Publisher publishes the state of every water sensor and the away mode of
+// every location to an MQTT broker, with Home Assistant discovery configs
+// so the entities appear on their own. Setting the away mode switch in Home
+// Assistant calls UpdateLocation.
+//
+// Topics, with the default prefixes:
+//
+//	flume/status                          online or offline (retained, also the will)
+//	flume/<device>/state                  JSON state of a device (retained)
+//	flume/location/<id>/away              away mode, ON or OFF (retained)
+//	flume/location/<id>/away/set          away mode commands
+//	homeassistant/<component>/.../config  discovery configs (retained)
+type Publisher struct {
+	Client *goflume.Client
+	// Login, when set, is called before a refresh once the access token
+	// expires within a minute. It should refresh the token or log in
+	// again.
+	Login func(ctx context.Context, c *goflume.Client) error
+
+	// Broker is the host:port of the MQTT broker and Options the
+	// connection settings. The will is set by the publisher.
+	Broker  string
+	Options Options
+
+	// TopicPrefix defaults to "flume" and DiscoveryPrefix to
+	// "homeassistant".
+	TopicPrefix     string
+	DiscoveryPrefix string
+
+	// FlowInterval defaults to one minute and SlowInterval, for devices,
+	// usage and budgets, to 15 minutes.
+	FlowInterval time.Duration
+	SlowInterval time.Duration
+
+	// Budget is shared with other pollers of the same account. When nil
+	// the publisher uses its own budget of goflume.DefaultRequestLimit per
+	// hour.
+	Budget *goflume.RequestBudget
+	// OnError receives the errors Run recovers from.
+	OnError func(error)
+
+	// Now and After replace time.Now and time.After.
+	Now   func() time.Time
+	After func(time.Duration) <-chan time.Time
+
+	devices   []goflume.Device
+	locations []goflume.Location
+	budgets   map[string][]goflume.Budget
+	states    map[string]*deviceState
+	slowAt    time.Time
+}
+
+// deviceState is the JSON published on the state topic of a device.
+type deviceState struct {
+	FlowGPM    float64            `json:"flow_gpm"`
+	Flowing    bool               `json:"flowing"`
+	UsageToday float64            `json:"usage_today"`
+	UsageMonth float64            `json:"usage_month"`
+	Battery    string             `json:"battery"`
+	Connected  bool               `json:"connected"`
+	Budgets    map[string]float64 `json:"budgets"` // percent used by budget ID
+}
+
+// errThrottled stops a refresh when the request budget is used up.
+var errThrottled = errors.New("request budget exhausted")
+
+// Run publishes until ctx is done, and returns ctx.Err(). A lost broker
+// connection is redialed with backoff.
+func (p *Publisher) Run(ctx context.Context) error {
+	backoff := time.Second
+	for {
+		start := p.now()
+		err := p.session(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			err = errors.New("broker closed the connection")
+		}
+		p.report(err)
+		if p.now().Sub(start) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.after(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// session runs one broker connection.
+func (p *Publisher) session(ctx context.Context) error {
+	if p.Client == nil {
+		return errors.New("mqtt publisher needs a client")
+	}
+	if p.Budget == nil {
+		p.Budget = &goflume.RequestBudget{Now: p.Now}
+	}
+	opts := p.Options
+	if opts.ClientID == "" {
+		opts.ClientID = "go-flume-" + p.prefix()
+	}
+	opts.Will = &Message{Topic: p.topic("status"), Payload: []byte("offline"), Retain: true}
+	conn, err := Dial(ctx, p.Broker, opts)
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", p.Broker, err)
+	}
+	defer conn.Close()
+	if err := conn.Subscribe(ctx, p.topic("location", "+", "away", "set")); err != nil {
+		return err
+	}
+	if err := conn.Publish(Message{Topic: p.topic("status"), Payload: []byte("online"), Retain: true}); err != nil {
+		return err
+	}
+	// Publish discovery configs again for this connection.
+	p.slowAt = time.Time{}
+
+	timer := p.after(0)
+	for {
+		select {
+		case <-ctx.Done():
+			_ = conn.Publish(Message{Topic: p.topic("status"), Payload: []byte("offline"), Retain: true})
+			return nil
+		case <-conn.Done():
+			return conn.Err()
+		case m, ok := <-conn.Messages():
+			if !ok {
+				return conn.Err()
+			}
+			if err := p.command(ctx, conn, m); err != nil {
+				p.report(err)
+			}
+		case <-timer:
+			if err := p.refresh(ctx, conn); err != nil {
+				if !errors.Is(err, errThrottled) {
+					p.report(err)
+				}
+			}
+			timer = p.after(p.Budget.Pace(max(len(p.sensors()), 1), p.flowInterval(), false))
+		}
+	}
+}
+
+// refresh fetches what is due and publishes the states.
+func (p *Publisher) refresh(ctx context.Context, conn *Conn) error {
+	if p.Login != nil && time.Unix(int64(p.Client.JWT.Exp), 0).Before(p.now().Add(time.Minute)) {
+		if err := p.Login(ctx, p.Client); err != nil {
+			return fmt.Errorf("login: %w", err)
+		}
+	}
+	if p.slowAt.IsZero() || !p.now().Before(p.slowAt.Add(p.slowInterval())) {
+		if err := p.refreshSlow(ctx); err != nil {
+			return err
+		}
+		p.slowAt = p.now()
+		if err := p.publishDiscovery(conn); err != nil {
+			return err
+		}
+		for _, l := range p.locations {
+			if err := p.publishAway(conn, l); err != nil {
+				return err
+			}
+		}
+	}
+	for _, d := range p.sensors() {
+		if !p.reserve() {
+			return errThrottled
+		}
+		resp, err := p.Client.GetCurrentFlow(ctx, d.ID)
+		if err != nil {
+			return fmt.Errorf("flow of %s: %w", d.ID, err)
+		}
+		if s := p.states[d.ID]; s != nil && len(resp.Data) > 0 {
+			s.FlowGPM, s.Flowing = resp.Data[0].GPM, resp.Data[0].Active
+		}
+	}
+	for _, d := range p.sensors() {
+		s := p.states[d.ID]
+		if s == nil {
+			continue
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		if err := conn.Publish(Message{Topic: p.topic(d.ID, "state"), Payload: b, Retain: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) refreshSlow(ctx context.Context) error {
+	if !p.reserve() {
+		return errThrottled
+	}
+	devices, err := p.Client.GetDevices(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("list devices: %w", err)
+	}
+	if !p.reserve() {
+		return errThrottled
+	}
+	locations, err := p.Client.GetLocations(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("list locations: %w", err)
+	}
+	p.devices, p.locations = devices.Data, locations.Data
+	sort.Slice(p.devices, func(i, j int) bool { return p.devices[i].ID < p.devices[j].ID })
+	if p.states == nil {
+		p.states = map[string]*deviceState{}
+		p.budgets = map[string][]goflume.Budget{}
+	}
+
+	for _, d := range p.sensors() {
+		s := p.states[d.ID]
+		if s == nil {
+			s = &deviceState{}
+			p.states[d.ID] = s
+		}
+		s.Battery, s.Connected = d.BatteryLevel, d.Connected
+
+		tz := time.UTC
+		if l := p.location(d.LocationID); l != nil && l.TZ != "" {
+			if loc, err := time.LoadLocation(l.TZ); err == nil {
+				tz = loc
+			}
+		}
+		now := p.now().In(tz)
+		y, m, day := now.Date()
+		for _, q := range []struct {
+			bucket string
+			since  time.Time
+			total  *float64
+		}{
+			{"DAY", time.Date(y, m, day, 0, 0, 0, 0, tz), &s.UsageToday},
+			{"MON", time.Date(y, m, 1, 0, 0, 0, 0, tz), &s.UsageMonth},
+		} {
+			if !p.reserve() {
+				return errThrottled
+			}
+			resp, err := p.Client.QueryUsage(ctx, d.ID, goflume.QueryUsageRequestBody{
+				RequestID:     "mqtt",
+				Bucket:        q.bucket,
+				SinceDatetime: q.since.Format(datetimeLayout),
+				UntilDatetime: now.Format(datetimeLayout),
+			})
+			if err != nil {
+				return fmt.Errorf("usage of %s: %w", d.ID, err)
+			}
+			*q.total = 0
+			for _, row := range resp.Data {
+				*q.total += float64(row.Value)
+			}
+		}
+
+		if !p.reserve() {
+			return errThrottled
+		}
+		budgets, err := p.Client.GetBudgets(ctx, d.ID, nil)
+		if err != nil {
+			return fmt.Errorf("budgets of %s: %w", d.ID, err)
+		}
+		p.budgets[d.ID] = budgets.Data
+		s.Budgets = map[string]float64{}
+		for _, b := range budgets.Data {
+			percent := 0.0
+			if b.Value > 0 {
+				percent = float64(b.Actual) * 100 / float64(b.Value)
+			}
+			s.Budgets[strconv.Itoa(b.ID)] = percent
+		}
+	}
+	return nil
+}
+
+// command handles an away mode command: ON or OFF on
+// <prefix>/location/<id>/away/set, for one of the account's locations.
+// Retained commands are ignored: the broker replays them on every
+// subscription, and acting on one would undo any change made since.
+func (p *Publisher) command(ctx context.Context, conn *Conn, m Message) error {
+	if m.Retain {
+		return nil
+	}
+	parts := strings.Split(strings.TrimPrefix(m.Topic, p.prefix()+"/"), "/")
+	if len(parts) != 4 || parts[0] != "location" || parts[2] != "away" || parts[3] != "set" {
+		return fmt.Errorf("unexpected topic %s", m.Topic)
+	}
+	var on bool
+	switch strings.ToUpper(strings.TrimSpace(string(m.Payload))) {
+	case "ON":
+		on = true
+	case "OFF":
+	default:
+		return fmt.Errorf("away mode command %q on %s: expected ON or OFF", m.Payload, m.Topic)
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("unexpected topic %s", m.Topic)
+	}
+	l := p.location(id)
+	if l == nil {
+		return fmt.Errorf("away mode command for unknown location %d", id)
+	}
+	// Commands are not held back by the budget, but they count.
+	p.Budget.Spend(1)
+	if _, err := p.Client.UpdateLocation(ctx, parts[1], goflume.LocationPatch{AwayMode: on}); err != nil {
+		return fmt.Errorf("set away mode of location %d: %w", id, err)
+	}
+	l.AwayMode = on
+	return p.publishAway(conn, *l)
+}
+
+func (p *Publisher) publishAway(conn *Conn, l goflume.Location) error {
+	state := "OFF"
+	if l.AwayMode {
+		state = "ON"
+	}
+	return conn.Publish(Message{Topic: p.topic("location", strconv.Itoa(l.ID), "away"), Payload: []byte(state), Retain: true})
+}
+
+// publishDiscovery publishes the Home Assistant discovery config of every
+// entity.
+func (p *Publisher) publishDiscovery(conn *Conn) error {
+	availability := p.topic("status")
+	var configs []struct {
+		topic  string
+		config map[string]any
+	}
+	add := func(component, node, object string, config map[string]any) {
+		config["unique_id"] = node + "_" + object
+		config["object_id"] = node + "_" + object
+		config["availability_topic"] = availability
+		configs = append(configs, struct {
+			topic  string
+			config map[string]any
+		}{fmt.Sprintf("%s/%s/%s/%s/config", p.discoveryPrefix(), component, node, object), config})
+	}
+
+	for _, d := range p.sensors() {
+		node := "flume_" + d.ID
+		name := "Flume"
+		if l := p.location(d.LocationID); l != nil && l.Name != "" {
+			name = "Flume " + l.Name
+		}
+		device := map[string]any{
+			"identifiers":  []string{node},
+			"name":         name,
+			"manufacturer": "Flume",
+			"model":        d.Product,
+		}
+		state := p.topic(d.ID, "state")
+		entity := func(name, template string, extra map[string]any) map[string]any {
+			c := map[string]any{"name": name, "state_topic": state, "value_template": template, "device": device}
+			for k, v := range extra {
+				c[k] = v
+			}
+			return c
+		}
+		onOff := func(field string) string {
+			return "{{ 'ON' if value_json." + field + " else 'OFF' }}"
+		}
+		add("sensor", node, "flow", entity("Flow", "{{ value_json.flow_gpm }}", map[string]any{
+			"unit_of_measurement": "gal/min", "device_class": "volume_flow_rate", "state_class": "measurement"}))
+		add("binary_sensor", node, "flowing", entity("Water flowing", onOff("flowing"), map[string]any{
+			"device_class": "running"}))
+		add("sensor", node, "usage_today", entity("Usage today", "{{ value_json.usage_today }}", map[string]any{
+			"unit_of_measurement": "gal", "device_class": "water", "state_class": "total_increasing"}))
+		add("sensor", node, "usage_month", entity("Usage this month", "{{ value_json.usage_month }}", map[string]any{
+			"unit_of_measurement": "gal", "device_class": "water", "state_class": "total_increasing"}))
+		add("sensor", node, "battery", entity("Battery", "{{ value_json.battery }}", map[string]any{
+			"icon": "mdi:battery", "entity_category": "diagnostic"}))
+		add("binary_sensor", node, "connected", entity("Connected", onOff("connected"), map[string]any{
+			"device_class": "connectivity", "entity_category": "diagnostic"}))
+		for _, b := range p.budgets[d.ID] {
+			id := strconv.Itoa(b.ID)
+			add("sensor", node, "budget_"+id, entity("Budget "+firstNonEmpty(b.Name, id), "{{ value_json.budgets['"+id+"'] | round(1) }}", map[string]any{
+				"unit_of_measurement": "%", "state_class": "measurement", "icon": "mdi:gauge"}))
+		}
+	}
+
+	for _, l := range p.locations {
+		node := "flume_location_" + strconv.Itoa(l.ID)
+		id := strconv.Itoa(l.ID)
+		add("switch", node, "away_mode", map[string]any{
+			"name":          "Away mode",
+			"state_topic":   p.topic("location", id, "away"),
+			"command_topic": p.topic("location", id, "away", "set"),
+			"payload_on":    "ON",
+			"payload_off":   "OFF",
+			"icon":          "mdi:home-export-outline",
+			"device": map[string]any{
+				"identifiers":  []string{node},
+				"name":         "Flume " + firstNonEmpty(l.Name, id),
+				"manufacturer": "Flume",
+			},
+		})
+	}
+
+	for _, c := range configs {
+		b, err := json.Marshal(c.config)
+		if err != nil {
+			return err
+		}
+		if err := conn.Publish(Message{Topic: c.topic, Payload: b, Retain: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) sensors() []goflume.Device {
+	var out []goflume.Device
+	for _, d := range p.devices {
+		if d.Type == waterSensorType {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func (p *Publisher) location(id int) *goflume.Location {
+	for i := range p.locations {
+		if p.locations[i].ID == id {
+			return &p.locations[i]
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) reserve() bool {
+	_, ok := p.Budget.TryReserve(1)
+	return ok
+}
+
+func (p *Publisher) topic(parts ...string) string {
+	return p.prefix() + "/" + strings.Join(parts, "/")
+}
+
+func (p *Publisher) prefix() string {
+	return firstNonEmpty(p.TopicPrefix, "flume")
+}
+
+func (p *Publisher) discoveryPrefix() string {
+	return firstNonEmpty(p.DiscoveryPrefix, "homeassistant")
+}
+
+func (p *Publisher) flowInterval() time.Duration {
+	if p.FlowInterval > 0 {
+		return p.FlowInterval
+	}
+	return time.Minute
+}
+
+func (p *Publisher) slowInterval() time.Duration {
+	if p.SlowInterval > 0 {
+		return p.SlowInterval
+	}
+	return 15 * time.Minute
+}
+
+func (p *Publisher) report(err error) {
+	if p.OnError != nil {
+		p.OnError(err)
+	}
+}
+
+func (p *Publisher) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+func (p *Publisher) after(d time.Duration) <-chan time.Time {
+	if p.After != nil {
+		return p.After(d)
+	}
+	return time.After(d)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+const deviceID = "6248148189204194987"
+
+func TestPublisher(t *testing.T) {
+	broker := newTestBroker(t)
+	srv := flumetest.NewServer()
+	t.Cleanup(srv.Close)
+	c := srv.Client()
+	if err := c.Authenticate(context.Background(), flumetest.Username, flumetest.Password); err != nil {
+		t.Fatal(err)
+	}
+
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	now := time.Now().In(la)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, la)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, la)
+	usage := []goflume.UsageQuery{{Value: 5, Datetime: today.Format(datetimeLayout)}}
+	wantMonth := 5.0
+	if !month.Equal(today) {
+		usage = append(usage, goflume.UsageQuery{Value: 7, Datetime: month.Format(datetimeLayout)})
+		wantMonth = 12
+	}
+	srv.Update(func(s *flumetest.State) {
+		s.Usage[deviceID] = usage
+		s.Budgets[deviceID] = []goflume.Budget{{ID: 3, Name: "Monthly", Type: "MONTHLY", Value: 4000, Actual: 1000}}
+		s.Flow[deviceID] = goflume.Flow{Active: true, GPM: 1.5}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 10)
+	p := &Publisher{
+		Client:       c,
+		Broker:       broker.addr(),
+		FlowInterval: 10 * time.Millisecond,
+		Budget:       &goflume.RequestBudget{Limit: 1_000_000},
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx) }()
+
+	broker.waitRetained(t, "flume/status", func(s string) bool { return s == "online" })
+	state := broker.waitRetained(t, "flume/"+deviceID+"/state", func(string) bool { return true })
+	var got deviceState
+	if err := json.Unmarshal([]byte(state), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := deviceState{FlowGPM: 1.5, Flowing: true, UsageToday: 5, UsageMonth: wantMonth, Battery: "high", Connected: true,
+		Budgets: map[string]float64{"3": 25}}
+	if got.FlowGPM != want.FlowGPM || got.Flowing != want.Flowing || got.UsageToday != want.UsageToday ||
+		got.UsageMonth != want.UsageMonth || got.Battery != want.Battery || !got.Connected || got.Budgets["3"] != 25 {
+		t.Errorf("state = %+v, want %+v", got, want)
+	}
+
+	var flow map[string]any
+	cfg := broker.waitRetained(t, "homeassistant/sensor/flume_"+deviceID+"/flow/config", func(string) bool { return true })
+	if err := json.Unmarshal([]byte(cfg), &flow); err != nil {
+		t.Fatal(err)
+	}
+	if flow["state_topic"] != "flume/"+deviceID+"/state" || flow["unit_of_measurement"] != "gal/min" ||
+		flow["availability_topic"] != "flume/status" || flow["unique_id"] != "flume_"+deviceID+"_flow" {
+		t.Errorf("flow config = %v", flow)
+	}
+	budget := broker.waitRetained(t, "homeassistant/sensor/flume_"+deviceID+"/budget_3/config", func(string) bool { return true })
+	if !strings.Contains(budget, `"name":"Budget Monthly"`) {
+		t.Errorf("budget config = %s", budget)
+	}
+	sw := broker.waitRetained(t, "homeassistant/switch/flume_location_1/away_mode/config", func(string) bool { return true })
+	if !strings.Contains(sw, `"command_topic":"flume/location/1/away/set"`) {
+		t.Errorf("away mode config = %s", sw)
+	}
+	broker.waitRetained(t, "flume/location/1/away", func(s string) bool { return s == "OFF" })
+
+	// Home Assistant flips the switch.
+	ha, err := Dial(ctx, broker.addr(), Options{ClientID: "home-assistant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ha.Close()
+	if err := ha.Publish(Message{Topic: "flume/location/1/away/set", Payload: []byte("ON")}); err != nil {
+		t.Fatal(err)
+	}
+	broker.waitRetained(t, "flume/location/1/away", func(s string) bool { return s == "ON" })
+	if !srv.State().Locations[0].AwayMode {
+		t.Error("away mode was not set through the API")
+	}
+	if err := ha.Publish(Message{Topic: "flume/location/1/away/set", Payload: []byte("maybe")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "expected ON or OFF") {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("a bad command was not reported")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run returned %v", err)
+	}
+	broker.waitRetained(t, "flume/status", func(s string) bool { return s == "offline" })
+}
+
+func TestPublisher_ignoredCommands(t *testing.T) {
+	// Without a client, a command reaching the API would panic.
+	p := &Publisher{locations: []goflume.Location{{ID: 1}}}
+	ctx := context.Background()
+	if err := p.command(ctx, nil, Message{Topic: "flume/location/1/away/set", Payload: []byte("ON"), Retain: true}); err != nil {
+		t.Errorf("retained command: %v", err)
+	}
+	err := p.command(ctx, nil, Message{Topic: "flume/location/2/away/set", Payload: []byte("ON")})
+	if err == nil || !strings.Contains(err.Error(), "unknown location 2") {
+		t.Errorf("command for another location: %v", err)
+	}
+	if p.locations[0].AwayMode || len(p.locations) != 1 {
+		t.Errorf("locations = %+v", p.locations)
+	}
+}
+
+func TestPublisher_reconnects(t *testing.T) {
+	srv := flumetest.NewServer()
+	t.Cleanup(srv.Close)
+	c := srv.Client()
+	if err := c.Authenticate(context.Background(), flumetest.Username, flumetest.Password); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 10)
+	p := &Publisher{
+		Client: c,
+		Broker: "127.0.0.1:1", // nothing listens here
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+		After: func(time.Duration) <-chan time.Time {
+			ch := make(chan time.Time, 1)
+			ch <- time.Time{}
+			return ch
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !strings.Contains(err.Error(), "connect to 127.0.0.1:1") {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run returned %v", err)
+	}
+}
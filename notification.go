@@ -78,3 +78,25 @@ func (c *Client) GetNotifications(ctx context.Context, params *GetNotificationsP
 	}
 	return &resp, nil
 }
+
+// NotificationPatch is the body of UpdateNotification.
+type NotificationPatch struct {
+	Read bool `json:"read"`
+}
+
+// UpdateNotification changes a notification, typically to mark it read.
+func (c *Client) UpdateNotification(ctx context.Context, notificationID int, patch NotificationPatch) (*APIResponseEnvelope, error) {
+	if notificationID <= 0 {
+		return nil, fmt.Errorf("notificationID must be positive")
+	}
+	req := fmt.Sprintf("%s/users/%d/notifications/%d", c.BaseURL, c.JWT.UserID, notificationID)
+	u, err := url.Parse(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp APIResponseEnvelope
+	if err := c.apiRequest(ctx, "PATCH", u, patch, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
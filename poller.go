@@ -0,0 +1,540 @@
+package goflume
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NotificationCursor is how far a NotificationPoller got. A CursorStore
+// keeps it between runs so a restart neither repeats nor loses
+// notifications.
+type NotificationCursor struct {
+	// CreatedDatetime and ID identify the newest notification dispatched.
+	CreatedDatetime string `json:"created_datetime,omitempty"`
+	ID              int    `json:"id,omitempty"`
+	// Started is set by the first poll, so that an account without
+	// notifications at the time is not taken for a new start again.
+	Started bool `json:"started,omitempty"`
+	// Retries are failed deliveries waiting to be tried again.
+	Retries []NotificationRetry `json:"retries,omitempty"`
+}
+
+// IsZero reports whether the cursor is from a poller that never ran.
+func (c NotificationCursor) IsZero() bool {
+	return !c.Started && c.CreatedDatetime == "" && c.ID == 0
+}
+
+// seen reports whether n is not newer than the cursor.
+func (c NotificationCursor) seen(n Notification) bool {
+	if n.CreatedDatetime != c.CreatedDatetime {
+		return n.CreatedDatetime < c.CreatedDatetime
+	}
+	return n.ID <= c.ID
+}
+
+// NotificationRetry is a delivery of a notification to a handler that
+// failed and is due again at Next.
+type NotificationRetry struct {
+	Handler      string       `json:"handler"`
+	Notification Notification `json:"notification"`
+	Attempts     int          `json:"attempts"`
+	Next         time.Time    `json:"next"`
+	Err          string       `json:"error"`
+	// KeepUnread is set once another handler gave up on the notification,
+	// so that it is not marked read.
+	KeepUnread bool `json:"keep_unread,omitempty"`
+}
+
+// CursorStore persists the cursor of a NotificationPoller.
+type CursorStore interface {
+	// LoadCursor returns the saved cursor, or the zero cursor when none
+	// was saved yet.
+	LoadCursor(ctx context.Context) (NotificationCursor, error)
+	SaveCursor(ctx context.Context, c NotificationCursor) error
+}
+
+// FileCursorStore keeps the cursor in a JSON file, replaced atomically on
+// every save.
+type FileCursorStore struct {
+	Path string
+}
+
+func (s FileCursorStore) LoadCursor(ctx context.Context) (NotificationCursor, error) {
+	var c NotificationCursor
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("read cursor %s: %w", s.Path, err)
+	}
+	return c, nil
+}
+
+func (s FileCursorStore) SaveCursor(ctx context.Context, c NotificationCursor) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// MemoryCursorStore keeps the cursor in memory, for tests and pollers that
+// may start over after a restart.
+type MemoryCursorStore struct {
+	mu     sync.Mutex
+	cursor NotificationCursor
+}
+
+func (s *MemoryCursorStore) LoadCursor(ctx context.Context) (NotificationCursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.cursor
+	c.Retries = append([]NotificationRetry(nil), c.Retries...)
+	return c, nil
+}
+
+func (s *MemoryCursorStore) SaveCursor(ctx context.Context, c NotificationCursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursor = c
+	return nil
+}
+
+// NotificationHandler handles one notification. A returned error makes the
+// poller try again later.
+type NotificationHandler func(ctx context.Context, n Notification) error
+
+// NotificationFilter selects the notifications a handler receives.
+type NotificationFilter struct {
+	// Types is a bitmask of Notification.Type values, like the types
+	// parameter of GetNotifications. Zero matches every type.
+	Types int
+	// DeviceIDs limits the handler to notifications of these devices.
+	// Empty matches every device.
+	DeviceIDs []string
+}
+
+// Match reports whether n passes the filter.
+func (f NotificationFilter) Match(n Notification) bool {
+	if f.Types != 0 && n.Type&f.Types == 0 {
+		return false
+	}
+	if len(f.DeviceIDs) == 0 {
+		return true
+	}
+	for _, id := range f.DeviceIDs {
+		if id == n.DeviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryError reports a handler failure. Final is set when the poller
+// gave up on the delivery.
+type DeliveryError struct {
+	Handler      string
+	Notification Notification
+	Attempts     int
+	Final        bool
+	Err          error
+}
+
+func (e *DeliveryError) Error() string {
+	msg := fmt.Sprintf("handler %s failed on notification %d (attempt %d): %v", e.Handler, e.Notification.ID, e.Attempts, e.Err)
+	if e.Final {
+		msg += "; giving up"
+	}
+	return msg
+}
+
+func (e *DeliveryError) Unwrap() error { return e.Err }
+
+// NotificationPoller fetches new notifications and dispatches them, oldest
+// first, to the registered handlers. Its cursor is saved after every poll.
+// A failing handler is retried with exponential backoff on later polls
+// while the other handlers carry on. Polls run one at a time; handlers may
+// call Handle, which takes effect from the next poll.
+type NotificationPoller struct {
+	Client *Client
+	// Store keeps the cursor. Defaults to a MemoryCursorStore.
+	Store CursorStore
+	// Interval between polls of Run. Defaults to one minute.
+	Interval time.Duration
+	// Backfill dispatches the notifications that existed before the first
+	// poll. Otherwise the first poll only records where to start.
+	Backfill bool
+	// MarkRead marks a notification read once every matching handler has
+	// handled it.
+	MarkRead bool
+
+	// MaxAttempts is how often a delivery is tried before giving up, 5 by
+	// default. The first retry waits RetryDelay, 30 seconds by default, and
+	// each later one twice as long as the one before.
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// HandlerTimeout is the deadline of the context of each handler call,
+	// one minute by default; a negative value sets none. A call that has
+	// not returned by then fails and is retried, and the handler's other
+	// deliveries wait for a later poll, so that one stuck handler cannot
+	// hold up the poll.
+	HandlerTimeout time.Duration
+
+	// Budget is shared with other pollers of the same account. When nil
+	// the poller uses its own budget of DefaultRequestLimit per hour.
+	Budget *RequestBudget
+	// OnError receives the errors Run does not return: failed polls and
+	// every *DeliveryError.
+	OnError func(error)
+
+	// Now and After replace time.Now and time.After.
+	Now   func() time.Time
+	After func(time.Duration) <-chan time.Time
+
+	poll     sync.Mutex // held for a whole poll
+	mu       sync.Mutex // guards handlers and the defaults set by Poll
+	handlers []notificationHandler
+}
+
+type notificationHandler struct {
+	name   string
+	filter NotificationFilter
+	fn     NotificationHandler
+}
+
+// notificationPageSize is how many notifications a poll fetches per request.
+const notificationPageSize = 50
+
+// Handle registers a handler under a name, which identifies its pending
+// retries in the cursor. It panics if the name is already taken.
+func (p *NotificationPoller) Handle(name string, filter NotificationFilter, fn NotificationHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.handlers {
+		if h.name == name {
+			panic("goflume: notification handler " + name + " registered twice")
+		}
+	}
+	p.handlers = append(p.handlers, notificationHandler{name: name, filter: filter, fn: fn})
+}
+
+// Run polls every Interval until ctx is done, and returns ctx.Err().
+func (p *NotificationPoller) Run(ctx context.Context) error {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	for {
+		if err := p.Poll(ctx); err != nil && ctx.Err() == nil {
+			p.report(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.after(interval):
+		}
+	}
+}
+
+// delivery is one notification for one handler, new or retried.
+type delivery struct {
+	n     Notification
+	retry *NotificationRetry
+	err   error
+}
+
+// Poll fetches the notifications newer than the cursor, dispatches them
+// along with the retries that are due, and saves the cursor.
+func (p *NotificationPoller) Poll(ctx context.Context) error {
+	p.poll.Lock()
+	defer p.poll.Unlock()
+	if p.Client == nil {
+		return errors.New("notification poller needs a client")
+	}
+	// The handlers are copied so that none runs with p.mu held.
+	p.mu.Lock()
+	if p.Store == nil {
+		p.Store = &MemoryCursorStore{}
+	}
+	if p.Budget == nil {
+		p.Budget = &RequestBudget{Now: p.Now}
+	}
+	handlers := append([]notificationHandler(nil), p.handlers...)
+	p.mu.Unlock()
+
+	cur, err := p.Store.LoadCursor(ctx)
+	if err != nil {
+		return fmt.Errorf("load cursor: %w", err)
+	}
+	first := cur.IsZero()
+	fresh, err := p.fetch(ctx, cur, first && !p.Backfill)
+	if err != nil {
+		return err
+	}
+	if len(fresh) > 0 {
+		newest := fresh[len(fresh)-1]
+		cur.CreatedDatetime, cur.ID = newest.CreatedDatetime, newest.ID
+	}
+	cur.Started = true
+	if first && !p.Backfill {
+		return p.save(ctx, cur)
+	}
+
+	// Queue the due retries and the new notifications per handler.
+	now := p.now()
+	queues := make([][]*delivery, len(handlers))
+	index := make(map[string]int, len(handlers))
+	for i, h := range handlers {
+		index[h.name] = i
+	}
+	var waiting []NotificationRetry
+	for i := range cur.Retries {
+		r := &cur.Retries[i]
+		h, ok := index[r.Handler]
+		if !ok || r.Next.After(now) {
+			// Keep retries of handlers that are not registered in this
+			// run, in case they are again in the next.
+			waiting = append(waiting, *r)
+			continue
+		}
+		queues[h] = append(queues[h], &delivery{n: r.Notification, retry: r})
+	}
+	for _, n := range fresh {
+		for i, h := range handlers {
+			if h.filter.Match(n) {
+				queues[i] = append(queues[i], &delivery{n: n})
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, h := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var stuck error
+			for _, d := range queues[i] {
+				if stuck != nil {
+					d.err = stuck
+					continue
+				}
+				var returned bool
+				if returned, d.err = p.call(ctx, h, d.n); !returned {
+					stuck = fmt.Errorf("handler still busy with notification %d", d.n.ID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Work out what to retry and which notifications are done.
+	type outcome struct{ ok, failed, keepUnread bool }
+	outcomes := map[int]*outcome{}
+	var gaveUp []int
+	for i, q := range queues {
+		for _, d := range q {
+			o := outcomes[d.n.ID]
+			if o == nil {
+				o = &outcome{}
+				outcomes[d.n.ID] = o
+			}
+			if d.retry != nil && d.retry.KeepUnread {
+				o.keepUnread = true
+			}
+			if d.err == nil {
+				o.ok = true
+				continue
+			}
+			o.failed = true
+			r := NotificationRetry{Handler: handlers[i].name, Notification: d.n, Attempts: 1}
+			if d.retry != nil {
+				r = *d.retry
+				r.Attempts++
+			}
+			r.Err = d.err.Error()
+			final := r.Attempts >= p.maxAttempts()
+			p.report(&DeliveryError{Handler: r.Handler, Notification: d.n, Attempts: r.Attempts, Final: final, Err: d.err})
+			if final {
+				gaveUp = append(gaveUp, d.n.ID)
+				continue
+			}
+			r.Next = now.Add(p.retryDelay() << (r.Attempts - 1))
+			waiting = append(waiting, r)
+		}
+	}
+	for _, id := range gaveUp {
+		outcomes[id].keepUnread = true
+		for i := range waiting {
+			if waiting[i].Notification.ID == id {
+				waiting[i].KeepUnread = true
+			}
+		}
+	}
+	pending := map[int]bool{}
+	for _, r := range waiting {
+		pending[r.Notification.ID] = true
+	}
+	cur.Retries = waiting
+	if err := p.save(ctx, cur); err != nil {
+		return err
+	}
+
+	if !p.MarkRead {
+		return nil
+	}
+	var done []int
+	for id, o := range outcomes {
+		if o.ok && !o.failed && !o.keepUnread && !pending[id] {
+			done = append(done, id)
+		}
+	}
+	sort.Ints(done)
+	for _, id := range done {
+		if !p.Budget.reserve(ctx, p.after) {
+			return ctx.Err()
+		}
+		if _, err := p.Client.UpdateNotification(ctx, id, NotificationPatch{Read: true}); err != nil {
+			p.report(fmt.Errorf("mark notification %d read: %w", id, err))
+		}
+	}
+	return nil
+}
+
+// call runs h on n under HandlerTimeout. It reports whether the handler
+// returned; one that did not is left running and the call fails.
+func (p *NotificationPoller) call(ctx context.Context, h notificationHandler, n Notification) (bool, error) {
+	timeout := p.handlerTimeout()
+	if timeout < 0 {
+		return true, h.fn(ctx, n)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	done := make(chan error, 1)
+	go func() {
+		defer cancel()
+		done <- h.fn(ctx, n)
+	}()
+	select {
+	case err := <-done:
+		return true, err
+	case <-ctx.Done():
+		return false, fmt.Errorf("handler did not return in %s: %w", timeout, ctx.Err())
+	}
+}
+
+// fetch returns the notifications newer than cur, oldest first. It pages
+// newest first until it reaches older ones; with latest it only fetches
+// the newest notification.
+func (p *NotificationPoller) fetch(ctx context.Context, cur NotificationCursor, latest bool) ([]Notification, error) {
+	field, direction := "created_datetime", "DESC"
+	limit := int32(notificationPageSize)
+	if latest {
+		limit = 1
+	}
+	var fresh []Notification
+	// A notification created while paging shifts the offsets, so the next
+	// page can repeat the last ones of the page before.
+	ids := map[int]bool{}
+	for offset := int32(0); ; offset += limit {
+		if !p.Budget.reserve(ctx, p.after) {
+			return nil, ctx.Err()
+		}
+		o := offset
+		resp, err := p.Client.GetNotifications(ctx, &GetNotificationsParams{
+			Limit: &limit, Offset: &o, SortField: &field, SortDirection: &direction,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("fetch notifications: %w", err)
+		}
+		done := latest || len(resp.Data) < int(limit)
+		for _, n := range resp.Data {
+			if ids[n.ID] {
+				continue
+			}
+			ids[n.ID] = true
+			if !cur.seen(n) {
+				fresh = append(fresh, n)
+			} else if n.CreatedDatetime < cur.CreatedDatetime {
+				// Notifications created in the same second as the
+				// cursor may continue on the next page.
+				done = true
+			}
+		}
+		if done {
+			break
+		}
+	}
+	sort.SliceStable(fresh, func(i, j int) bool {
+		if fresh[i].CreatedDatetime != fresh[j].CreatedDatetime {
+			return fresh[i].CreatedDatetime < fresh[j].CreatedDatetime
+		}
+		return fresh[i].ID < fresh[j].ID
+	})
+	return fresh, nil
+}
+
+func (p *NotificationPoller) save(ctx context.Context, cur NotificationCursor) error {
+	if err := p.Store.SaveCursor(ctx, cur); err != nil {
+		return fmt.Errorf("save cursor: %w", err)
+	}
+	return nil
+}
+
+func (p *NotificationPoller) report(err error) {
+	if p.OnError != nil {
+		p.OnError(err)
+	}
+}
+
+func (p *NotificationPoller) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return 5
+}
+
+func (p *NotificationPoller) retryDelay() time.Duration {
+	if p.RetryDelay > 0 {
+		return p.RetryDelay
+	}
+	return 30 * time.Second
+}
+
+func (p *NotificationPoller) handlerTimeout() time.Duration {
+	if p.HandlerTimeout != 0 {
+		return p.HandlerTimeout
+	}
+	return time.Minute
+}
+
+func (p *NotificationPoller) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+func (p *NotificationPoller) after(d time.Duration) <-chan time.Time {
+	if p.After != nil {
+		return p.After(d)
+	}
+	return time.After(d)
+}
@@ -0,0 +1,378 @@
+package goflume
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notificationAPI serves GetNotifications and UpdateNotification from memory.
+type notificationAPI struct {
+	mu    sync.Mutex
+	notes []Notification
+	gets  int
+	// late is added once the first page was served, as if created while
+	// the poller pages.
+	late []Notification
+}
+
+func (a *notificationAPI) add(n ...Notification) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notes = append(a.notes, n...)
+}
+
+func (a *notificationAPI) read() []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ids []int
+	for _, n := range a.notes {
+		if n.Read {
+			ids = append(ids, n.ID)
+		}
+	}
+	return ids
+}
+
+func (a *notificationAPI) client() *Client {
+	return &Client{
+		BaseURL: "http://flume.test",
+		JWT:     JWTPayload{UserID: 1},
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			var data []Notification
+			if r.Method == http.MethodPatch {
+				id, _ := strconv.Atoi(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+				for i := range a.notes {
+					if a.notes[i].ID == id {
+						a.notes[i].Read = true
+					}
+				}
+			} else {
+				a.gets++
+				q := r.URL.Query()
+				if q.Get("sort_field") != "created_datetime" || q.Get("sort_direction") != "DESC" {
+					return nil, errors.New("unexpected order " + r.URL.RawQuery)
+				}
+				sorted := append([]Notification(nil), a.notes...)
+				sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedDatetime > sorted[j].CreatedDatetime })
+				limit, _ := strconv.Atoi(q.Get("limit"))
+				offset, _ := strconv.Atoi(q.Get("offset"))
+				data = sorted[min(offset, len(sorted)):min(offset+limit, len(sorted))]
+				if a.gets == 1 {
+					a.notes = append(a.notes, a.late...)
+				}
+			}
+			b, _ := json.Marshal(map[string]any{"success": true, "data": data})
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(string(b))), Header: make(http.Header)}, nil
+		})},
+	}
+}
+
+func note(id int, created string, typ int, device string) Notification {
+	return Notification{ID: id, CreatedDatetime: created, Type: typ, DeviceID: device, Title: "n" + strconv.Itoa(id)}
+}
+
+func TestNotificationPoller_cursor(t *testing.T) {
+	api := &notificationAPI{}
+	api.add(note(1, "2026-09-01 10:00:00", 1, "a"))
+	store := FileCursorStore{Path: filepath.Join(t.TempDir(), "state", "cursor.json")}
+	var got []string
+	newPoller := func() *NotificationPoller {
+		p := &NotificationPoller{Client: api.client(), Store: store}
+		p.Handle("chat", NotificationFilter{}, func(ctx context.Context, n Notification) error {
+			got = append(got, n.Title)
+			return nil
+		})
+		return p
+	}
+	ctx := context.Background()
+
+	p := newPoller()
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("first poll without backfill dispatched %v", got)
+	}
+
+	// More than a page of new notifications, some in the same second.
+	for id := 2; id <= 60; id++ {
+		api.add(note(id, "2026-09-01 11:00:"+strconv.Itoa(10+id/10), 1, "a"))
+	}
+	if err := newPoller().Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 59 || got[0] != "n2" || got[58] != "n60" {
+		t.Fatalf("dispatched %d: %v", len(got), got)
+	}
+
+	// A restart picks up where the last poll stopped.
+	got = nil
+	api.add(note(61, "2026-09-01 12:00:00", 1, "a"))
+	if err := newPoller().Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "n61" {
+		t.Fatalf("after restart dispatched %v", got)
+	}
+	cur, err := store.LoadCursor(ctx)
+	if err != nil || cur.ID != 61 || cur.CreatedDatetime != "2026-09-01 12:00:00" {
+		t.Errorf("cursor = %+v, %v", cur, err)
+	}
+}
+
+func TestNotificationPoller_emptyFirstPoll(t *testing.T) {
+	api := &notificationAPI{}
+	store := FileCursorStore{Path: filepath.Join(t.TempDir(), "cursor.json")}
+	var got []int
+	newPoller := func() *NotificationPoller {
+		p := &NotificationPoller{Client: api.client(), Store: store}
+		p.Handle("chat", NotificationFilter{}, func(ctx context.Context, n Notification) error {
+			got = append(got, n.ID)
+			return nil
+		})
+		return p
+	}
+	ctx := context.Background()
+
+	// Nothing to record on the first poll but that it happened.
+	if err := newPoller().Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	api.add(note(1, "2026-09-01 10:00:00", 1, "a"))
+	if err := newPoller().Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("dispatched %v, want the new notification", got)
+	}
+}
+
+func TestNotificationPoller_handlerRegisters(t *testing.T) {
+	api := &notificationAPI{}
+	api.add(note(1, "2026-09-01 10:00:00", 1, "a"), note(2, "2026-09-01 10:01:00", 1, "a"))
+	p := &NotificationPoller{Client: api.client(), Backfill: true}
+	var late []int
+	p.Handle("setup", NotificationFilter{}, func(ctx context.Context, n Notification) error {
+		if n.ID == 1 {
+			p.Handle("late", NotificationFilter{}, func(ctx context.Context, n Notification) error {
+				late = append(late, n.ID)
+				return nil
+			})
+		}
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- p.Poll(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-ctx.Done():
+		t.Fatal("a handler calling Handle deadlocked the poll")
+	}
+
+	// The new handler starts with the next poll.
+	api.add(note(3, "2026-09-01 10:02:00", 1, "a"))
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(late) != 1 || late[0] != 3 {
+		t.Errorf("late handler got %v", late)
+	}
+}
+
+func TestNotificationPoller_filtersRetriesAndMarkRead(t *testing.T) {
+	api := &notificationAPI{}
+	api.add(
+		note(1, "2026-09-01 10:00:00", 1, "a"),
+		note(2, "2026-09-01 10:01:00", 4, "b"),
+		note(3, "2026-09-01 10:02:00", 2, "a"),
+	)
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	var errs []error
+	p := &NotificationPoller{
+		Client: api.client(), Backfill: true, MarkRead: true, MaxAttempts: 2, RetryDelay: time.Minute,
+		Now: func() time.Time { return now }, OnError: func(err error) { errs = append(errs, err) },
+	}
+	var usage, chat []int
+	failChat := map[int]int{2: 1, 3: 5} // failures left per notification
+	p.Handle("usage", NotificationFilter{Types: 1 | 2, DeviceIDs: []string{"a"}}, func(ctx context.Context, n Notification) error {
+		usage = append(usage, n.ID)
+		return nil
+	})
+	p.Handle("chat", NotificationFilter{}, func(ctx context.Context, n Notification) error {
+		if failChat[n.ID] > 0 {
+			failChat[n.ID]--
+			return errors.New("chat is down")
+		}
+		chat = append(chat, n.ID)
+		return nil
+	})
+	ctx := context.Background()
+
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 || usage[0] != 1 || usage[1] != 3 || len(chat) != 1 || chat[0] != 1 {
+		t.Fatalf("first poll: usage %v, chat %v", usage, chat)
+	}
+	if read := api.read(); len(read) != 1 || read[0] != 1 {
+		t.Errorf("read after first poll: %v", read)
+	}
+	var de *DeliveryError
+	if len(errs) != 2 || !errors.As(errs[0], &de) || de.Handler != "chat" || de.Final {
+		t.Fatalf("errors = %v", errs)
+	}
+
+	// Retries are not due yet.
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(chat) != 1 || len(errs) != 2 {
+		t.Fatalf("early retry: chat %v, errors %v", chat, errs)
+	}
+
+	now = now.Add(time.Minute)
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(chat) != 2 || chat[1] != 2 || len(usage) != 2 {
+		t.Errorf("after retry: chat %v, usage %v", chat, usage)
+	}
+	if len(errs) != 3 || !errors.As(errs[2], &de) || !de.Final || de.Notification.ID != 3 {
+		t.Errorf("errors = %v", errs)
+	}
+	// Notification 3 was given up by chat, so it stays unread.
+	if read := api.read(); len(read) != 2 || read[1] != 2 {
+		t.Errorf("read after retry: %v", read)
+	}
+	if cur, _ := p.Store.LoadCursor(ctx); len(cur.Retries) != 0 || cur.ID != 3 {
+		t.Errorf("cursor = %+v", cur)
+	}
+}
+
+func TestNotificationPoller_newDuringPaging(t *testing.T) {
+	api := &notificationAPI{late: []Notification{note(100, "2026-09-02 00:00:00", 1, "a")}}
+	for id := 1; id <= 60; id++ {
+		api.add(note(id, "2026-09-01 10:"+strconv.Itoa(10+id/10)+":00", 1, "a"))
+	}
+	p := &NotificationPoller{Client: api.client(), Backfill: true}
+	got := map[int]int{}
+	p.Handle("count", NotificationFilter{}, func(ctx context.Context, n Notification) error {
+		got[n.ID]++
+		return nil
+	})
+	ctx := context.Background()
+	for range 2 {
+		if err := p.Poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 61 {
+		t.Errorf("dispatched %d notifications, want 61", len(got))
+	}
+	for id, n := range got {
+		if n != 1 {
+			t.Errorf("notification %d dispatched %d times", id, n)
+		}
+	}
+}
+
+func TestNotificationPoller_handlerTimeout(t *testing.T) {
+	api := &notificationAPI{}
+	api.add(note(1, "2026-09-01 10:00:00", 1, "a"), note(2, "2026-09-01 10:01:00", 1, "a"))
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	var errs []error
+	p := &NotificationPoller{Client: api.client(), Backfill: true, HandlerTimeout: 20 * time.Millisecond,
+		OnError: func(err error) { errs = append(errs, err) }}
+	// stuck ignores its context, so only the poller's deadline ends the call.
+	p.Handle("stuck", NotificationFilter{}, func(ctx context.Context, n Notification) error {
+		<-release
+		return nil
+	})
+	var fast []int
+	p.Handle("fast", NotificationFilter{}, func(ctx context.Context, n Notification) error {
+		fast = append(fast, n.ID)
+		return nil
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- p.Poll(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stuck handler held up the poll")
+	}
+	if len(fast) != 2 {
+		t.Errorf("fast handler got %v", fast)
+	}
+	cur, _ := p.Store.LoadCursor(context.Background())
+	if len(cur.Retries) != 2 || cur.Retries[0].Handler != "stuck" || !strings.Contains(cur.Retries[0].Err, "did not return") {
+		t.Errorf("retries = %+v", cur.Retries)
+	}
+	var de *DeliveryError
+	if len(errs) != 2 || !errors.As(errs[0], &de) || !errors.Is(de, context.DeadlineExceeded) {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestNotificationPoller_run(t *testing.T) {
+	api := &notificationAPI{}
+	ctx, cancel := context.WithCancel(context.Background())
+	polls := 0
+	p := &NotificationPoller{
+		Client: api.client(),
+		After: func(d time.Duration) <-chan time.Time {
+			if polls++; polls == 3 {
+				cancel()
+				return nil
+			}
+			ch := make(chan time.Time, 1)
+			ch <- time.Time{}
+			return ch
+		},
+	}
+	if err := p.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v", err)
+	}
+	if api.gets != 3 {
+		t.Errorf("fetched %d times, want 3", api.gets)
+	}
+}
+
+func TestNotificationFilter(t *testing.T) {
+	n := note(1, "", 4, "a")
+	for _, tc := range []struct {
+		f    NotificationFilter
+		want bool
+	}{
+		{NotificationFilter{}, true},
+		{NotificationFilter{Types: 4 | 8}, true},
+		{NotificationFilter{Types: 1}, false},
+		{NotificationFilter{DeviceIDs: []string{"b", "a"}}, true},
+		{NotificationFilter{Types: 4, DeviceIDs: []string{"b"}}, false},
+	} {
+		if got := tc.f.Match(n); got != tc.want {
+			t.Errorf("%+v.Match = %v", tc.f, got)
+		}
+	}
+}
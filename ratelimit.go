@@ -0,0 +1,154 @@
+package goflume
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultRequestLimit is the number of requests the Flume Personal API allows
+// per user per hour.
+const DefaultRequestLimit = 120
+
+// RequestBudget shares the API rate limit between everything polling one
+// account, so several watchers together stay inside it. The zero value
+// allows DefaultRequestLimit requests per hour. It is safe for concurrent
+// use.
+type RequestBudget struct {
+	// Limit is the number of requests allowed per Window.
+	Limit int
+	// Window is the sliding period of the limit. Defaults to one hour.
+	Window time.Duration
+	// Now replaces time.Now.
+	Now func() time.Time
+
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+func (b *RequestBudget) limit() int {
+	if b.Limit > 0 {
+		return b.Limit
+	}
+	return DefaultRequestLimit
+}
+
+func (b *RequestBudget) window() time.Duration {
+	if b.Window > 0 {
+		return b.Window
+	}
+	return time.Hour
+}
+
+func (b *RequestBudget) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+// prune drops requests that left the window; b.mu must be held.
+func (b *RequestBudget) prune(now time.Time) {
+	cutoff := now.Add(-b.window())
+	i := 0
+	for i < len(b.sent) && !b.sent[i].After(cutoff) {
+		i++
+	}
+	b.sent = b.sent[i:]
+}
+
+// delay returns how long until n more requests fit; b.mu must be held.
+func (b *RequestBudget) delay(now time.Time, n int) time.Duration {
+	b.prune(now)
+	over := len(b.sent) + n - b.limit()
+	if over <= 0 || len(b.sent) == 0 {
+		return 0
+	}
+	over = min(over, len(b.sent))
+	return b.sent[over-1].Add(b.window()).Sub(now)
+}
+
+// Spend records n requests made now, whether or not they fit.
+func (b *RequestBudget) Spend(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	for range n {
+		b.sent = append(b.sent, now)
+	}
+}
+
+// Remaining returns how many requests are left in the current window.
+func (b *RequestBudget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(b.now())
+	return max(0, b.limit()-len(b.sent))
+}
+
+// TryReserve spends n requests if they fit in the window. Otherwise it
+// spends nothing and returns how long until they would.
+func (b *RequestBudget) TryReserve(n int) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if d := b.delay(now, n); d > 0 {
+		return d, false
+	}
+	for range n {
+		b.sent = append(b.sent, now)
+	}
+	return 0, true
+}
+
+// Wait blocks until n requests fit and spends them.
+func (b *RequestBudget) Wait(ctx context.Context, n int) error {
+	for {
+		d, ok := b.TryReserve(n)
+		if ok {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// reserve is Wait with a replaceable timer. It reports whether the request
+// was granted before ctx ended.
+func (b *RequestBudget) reserve(ctx context.Context, after func(time.Duration) <-chan time.Time) bool {
+	for {
+		d, ok := b.TryReserve(1)
+		if ok {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-after(d):
+		}
+	}
+}
+
+// Pace returns how long a poller making perRound requests per round should
+// wait before the next round. It waits at least minWait. When urgent and
+// at least half of the limit is left it polls at minWait; otherwise it
+// slows to the rate the limit sustains, and when the window is used up it
+// waits for enough of it to roll off.
+func (b *RequestBudget) Pace(perRound int, minWait time.Duration, urgent bool) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if d := b.delay(now, perRound); d > 0 {
+		return max(minWait, d)
+	}
+	if urgent && b.limit()-len(b.sent) >= b.limit()/2 {
+		return minWait
+	}
+	steady := b.window() * time.Duration(perRound) / time.Duration(b.limit())
+	return max(minWait, steady)
+}
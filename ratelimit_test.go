@@ -0,0 +1,66 @@
+package goflume
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRequestBudget_pace(t *testing.T) {
+	now := time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)
+	b := &RequestBudget{Now: func() time.Time { return now }}
+
+	if got := b.Pace(2, 10*time.Second, false); got != time.Minute {
+		t.Errorf("idle interval for 2 devices = %v, want 1m", got)
+	}
+	if got := b.Pace(2, 10*time.Second, true); got != 10*time.Second {
+		t.Errorf("urgent interval = %v, want the minimum", got)
+	}
+
+	start := now
+	for i := 0; i < 70; i++ {
+		now = start.Add(time.Duration(i) * time.Second)
+		b.Spend(1)
+	}
+	now = start.Add(70 * time.Second)
+	if got := b.Pace(2, 10*time.Second, true); got != time.Minute {
+		t.Errorf("urgent interval past half the limit = %v, want 1m", got)
+	}
+
+	b.Spend(50)
+	if got := b.Remaining(); got != 0 {
+		t.Errorf("remaining = %d, want 0", got)
+	}
+	if got := b.Pace(2, 10*time.Second, false); got != 58*time.Minute+51*time.Second {
+		t.Errorf("exhausted limit interval = %v", got)
+	}
+}
+
+func TestRequestBudget_reserve(t *testing.T) {
+	now := time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)
+	b := &RequestBudget{Limit: 3, Window: time.Minute, Now: func() time.Time { return now }}
+	if _, ok := b.TryReserve(2); !ok {
+		t.Fatal("first reservation refused")
+	}
+	now = now.Add(20 * time.Second)
+	if _, ok := b.TryReserve(1); !ok {
+		t.Fatal("second reservation refused")
+	}
+	d, ok := b.TryReserve(2)
+	if ok || d != 40*time.Second {
+		t.Fatalf("over the limit: wait %v, ok %v", d, ok)
+	}
+	if b.Remaining() != 0 {
+		t.Errorf("a refused reservation spent requests")
+	}
+	now = now.Add(d)
+	if _, ok := b.TryReserve(2); !ok {
+		t.Error("reservation refused after the window rolled off")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Wait(ctx, 1); err == nil {
+		t.Error("Wait on an exhausted budget ignored the cancelled context")
+	}
+}
@@ -0,0 +1,457 @@
+package goflume
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Schema fixture tests pin every response model to a JSON fixture under
+// testdata/schema. Each fixture must decode with no unknown fields, must
+// carry every field the model declares, and must survive a marshal round
+// trip unchanged.
+//
+// The checked-in fixtures are synthetic: they were written from the API
+// documentation and the struct tags, not captured from the live API (see
+// testdata/schema/README.md). They only catch regressions in the models;
+// only the live run compares the models with the real API.
+//
+// Setting FLUME_SCHEMA_LIVE=1 together with FLUME_CLIENT_ID,
+// FLUME_CLIENT_SECRET, FLUME_USERNAME and FLUME_PASSWORD runs the same checks
+// against the live API. Adding -schema.update rewrites the fixtures from the
+// live responses after scrubbing personal data.
+
+var updateFixtures = flag.Bool("schema.update", false, "rewrite testdata/schema fixtures from live responses")
+
+// liveIDs carries identifiers discovered during a live run so that later
+// cases can address a specific resource.
+type liveIDs struct {
+	deviceID   string
+	locationID string
+	ruleID     string
+}
+
+type schemaCase struct {
+	fixture string
+	model   func() any
+	// live issues the matching request against the real API. Cases
+	// without one are only checked against their fixture.
+	live func(ctx context.Context, c *Client, ids *liveIDs) error
+}
+
+var schemaCases = []schemaCase{
+	{"token.json", func() any { return new(TokenResponse) }, nil},
+	{"user.json", func() any { return new(UserResponse) }, func(ctx context.Context, c *Client, _ *liveIDs) error {
+		_, err := c.GetUser(ctx)
+		return err
+	}},
+	{"devices.json", func() any { return new(DevicesResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		resp, err := c.GetDevices(ctx, nil)
+		if err == nil {
+			for _, d := range resp.Data {
+				if d.Type == 2 {
+					ids.deviceID = d.ID
+				}
+			}
+		}
+		return err
+	}},
+	{"device.json", func() any { return new(DeviceResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		_, err := c.GetDevice(ctx, ids.deviceID, nil)
+		return err
+	}},
+	{"locations.json", func() any { return new(LocationsResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		resp, err := c.GetLocations(ctx, nil)
+		if err == nil && len(resp.Data) > 0 {
+			ids.locationID = fmt.Sprint(resp.Data[0].ID)
+		}
+		return err
+	}},
+	{"location.json", func() any { return new(LocationResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		_, err := c.GetLocation(ctx, ids.locationID)
+		return err
+	}},
+	{"update_location.json", func() any { return new(APIResponseEnvelope) }, nil},
+	{"update_notification.json", func() any { return new(APIResponseEnvelope) }, nil},
+	{"budgets.json", func() any { return new(BudgetsResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		_, err := c.GetBudgets(ctx, ids.deviceID, nil)
+		return err
+	}},
+	{"subscriptions.json", func() any { return new(SubscriptionsResponse) }, func(ctx context.Context, c *Client, _ *liveIDs) error {
+		_, err := c.GetSubscriptions(ctx, nil)
+		return err
+	}},
+	{"subscription.json", func() any { return new(SubscriptionResponse) }, nil},
+	{"notifications.json", func() any { return new(NotificationsResponse) }, func(ctx context.Context, c *Client, _ *liveIDs) error {
+		_, err := c.GetNotifications(ctx, nil)
+		return err
+	}},
+	{"usage_alerts.json", func() any { return new(UsageAlertsResponse) }, func(ctx context.Context, c *Client, _ *liveIDs) error {
+		_, err := c.GetUsageAlerts(ctx, nil)
+		return err
+	}},
+	{"event_rules.json", func() any { return new(EventRulesResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		_, err := c.GetEventRules(ctx, ids.deviceID, nil)
+		return err
+	}},
+	{"usage_alert_rules.json", func() any { return new(UsageAlertRulesResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		resp, err := c.GetUsageAlertRules(ctx, ids.deviceID, nil)
+		if err == nil && len(resp.Data) > 0 {
+			ids.ruleID = resp.Data[0].ID
+		}
+		return err
+	}},
+	{"usage_alert_rule.json", func() any { return new(UsageAlertRuleResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		if ids.ruleID == "" {
+			return errSkipLive
+		}
+		_, err := c.GetUsageAlertRule(ctx, ids.deviceID, ids.ruleID)
+		return err
+	}},
+	{"contacts.json", func() any { return new(ContactsResponse) }, func(ctx context.Context, c *Client, _ *liveIDs) error {
+		_, err := c.GetContacts(ctx, nil)
+		return err
+	}},
+	{"query_usage.json", func() any { return new(QueryUsageResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		now := time.Now()
+		_, err := c.QueryUsage(ctx, ids.deviceID, QueryUsageRequestBody{
+			RequestID:     "schema",
+			Bucket:        "HR",
+			SinceDatetime: now.Add(-3 * time.Hour).Format("2006-01-02 15:04:05"),
+			UntilDatetime: now.Format("2006-01-02 15:04:05"),
+		})
+		return err
+	}},
+	{"current_flow.json", func() any { return new(FlowResponse) }, func(ctx context.Context, c *Client, ids *liveIDs) error {
+		_, err := c.GetCurrentFlow(ctx, ids.deviceID)
+		return err
+	}},
+}
+
+var errSkipLive = fmt.Errorf("no resource to check")
+
+func TestSchemaFixtures(t *testing.T) {
+	seen := map[string]bool{}
+	for _, ct := range schemaCases {
+		seen[ct.fixture] = true
+		t.Run(strings.TrimSuffix(ct.fixture, ".json"), func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "schema", ct.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSchema(t, data, ct.model)
+		})
+	}
+
+	// Every fixture on disk must belong to a case, otherwise a renamed
+	// model would leave its fixture silently unchecked.
+	files, _ := filepath.Glob(filepath.Join("testdata", "schema", "*.json"))
+	for _, f := range files {
+		if !seen[filepath.Base(f)] {
+			t.Errorf("fixture %s has no case", f)
+		}
+	}
+}
+
+func TestFixtureScrubber(t *testing.T) {
+	// Responses in the order of a live run.
+	bodies := []string{
+		`{"data":[{"id":"6248148189204194987","bridge_id":"6248148189204194000","location_id":7}]}`,
+		`{"data":[{"id":7,"name":"Lake House","address":"1 Real Rd","away_mode":false}]}`,
+		`{"data":[{"device_id":"6248148189204194987","message":"Leak at Lake House on 6248148189204194987"}]}`,
+	}
+	scrub := newFixtureScrubber()
+	var out []map[string]any
+	for _, body := range bodies {
+		var v map[string]any
+		if err := json.Unmarshal([]byte(body), &v); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(scrub.scrub(v))
+		if strings.Contains(string(b), "6248148189204194") || strings.Contains(string(b), "Lake House") || strings.Contains(string(b), "Real Rd") {
+			t.Errorf("scrubbed fixture still has real data: %s", b)
+		}
+		out = append(out, v["data"].([]any)[0].(map[string]any))
+	}
+	device, location, note := out[0], out[1], out[2]
+	if location["name"] != "Home" {
+		t.Errorf("location name = %v", location["name"])
+	}
+	if note["device_id"] != device["id"] || device["id"] == device["bridge_id"] {
+		t.Errorf("device IDs are not replaced consistently: device %v, notification %v", device, note)
+	}
+	if want := fmt.Sprintf("Leak at Home on %s", device["id"]); note["message"] != want {
+		t.Errorf("message = %v, want %q", note["message"], want)
+	}
+}
+
+func TestSchemaFixtures_live(t *testing.T) {
+	if os.Getenv("FLUME_SCHEMA_LIVE") == "" {
+		t.Skip("set FLUME_SCHEMA_LIVE=1 and Flume credentials to run against the live API")
+	}
+	env := map[string]string{}
+	for _, k := range []string{"FLUME_CLIENT_ID", "FLUME_CLIENT_SECRET", "FLUME_USERNAME", "FLUME_PASSWORD"} {
+		if env[k] = os.Getenv(k); env[k] == "" {
+			t.Fatalf("%s must be set for a live run", k)
+		}
+	}
+
+	capture := &capturingTransport{base: http.DefaultTransport}
+	c := NewClient(env["FLUME_CLIENT_ID"], env["FLUME_CLIENT_SECRET"], &http.Client{Timeout: 30 * time.Second, Transport: capture})
+	ctx := context.Background()
+	if err := c.Authenticate(ctx, env["FLUME_USERNAME"], env["FLUME_PASSWORD"]); err != nil {
+		t.Fatal(err)
+	}
+	scrub := newFixtureScrubber()
+	checkLive(t, scrub, schemaCases[0], capture.last())
+
+	ids := &liveIDs{}
+	for _, ct := range schemaCases[1:] {
+		if ct.live == nil {
+			continue
+		}
+		err := ct.live(ctx, c, ids)
+		if err == errSkipLive {
+			t.Logf("%s: skipped, %v", ct.fixture, err)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", ct.fixture, err)
+			continue
+		}
+		checkLive(t, scrub, ct, capture.last())
+	}
+}
+
+func checkLive(t *testing.T, scrub *fixtureScrubber, ct schemaCase, body []byte) {
+	t.Helper()
+	t.Run(strings.TrimSuffix(ct.fixture, ".json"), func(t *testing.T) {
+		checkSchema(t, body, ct.model)
+		if !*updateFixtures {
+			return
+		}
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			t.Fatal(err)
+		}
+		out, err := json.MarshalIndent(scrub.scrub(v), "", "    ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join("testdata", "schema", ct.fixture), append(out, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// checkSchema runs the strict decode, missing field and round trip checks
+// for one response body.
+func checkSchema(t *testing.T, data []byte, model func() any) {
+	t.Helper()
+
+	v := model()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		t.Fatalf("strict decode: %v", err)
+	}
+
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range missingFields(reflect.TypeOf(v).Elem(), raw, "") {
+		t.Errorf("field %s is declared by the model but absent from the response", field)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	again := model()
+	if err := json.Unmarshal(out, again); err != nil {
+		t.Fatalf("unmarshal round trip: %v", err)
+	}
+	if !reflect.DeepEqual(v, again) {
+		t.Errorf("round trip changed the value:\nbefore %+v\nafter  %+v", v, again)
+	}
+}
+
+// missingFields walks typ alongside the decoded JSON value and returns the
+// dotted paths of struct fields that have no key in the JSON. Empty arrays and
+// null values are not descended into.
+func missingFields(typ reflect.Type, raw any, prefix string) []string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	var missing []string
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array:
+		items, _ := raw.([]any)
+		for i, item := range items {
+			missing = append(missing, missingFields(typ.Elem(), item, fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+	case reflect.Struct:
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			return nil
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if f.Anonymous && f.Tag.Get("json") == "" {
+				missing = append(missing, missingFields(f.Type, raw, prefix)...)
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			path := strings.TrimPrefix(prefix+"."+name, ".")
+			val, ok := obj[name]
+			if !ok {
+				missing = append(missing, path)
+				continue
+			}
+			missing = append(missing, missingFields(f.Type, val, path)...)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// scrubbedFields lists the keys whose values identify a person or grant
+// access and must never be written to a fixture.
+var scrubbedFields = map[string]string{
+	"email_address": "user@example.com",
+	"first_name":    "Jane",
+	"last_name":     "Doe",
+	"phone":         "+15555550100",
+	"address":       "100 Example St",
+	"address_2":     "",
+	"city":          "Springfield",
+	"postal_code":   "90000",
+	"detail":        "user@example.com",
+	"access_token":  "redacted",
+	"refresh_token": "redacted",
+}
+
+// fixtureScrubber removes personal data from live responses. Device and
+// bridge IDs get stable stand-ins and location names become "Home", also
+// where a later response mentions them in another field, so the fixtures of
+// one run stay consistent with each other.
+type fixtureScrubber struct {
+	replace map[string]string // real ID or name to its stand-in
+	ids     int
+}
+
+func newFixtureScrubber() *fixtureScrubber {
+	return &fixtureScrubber{replace: map[string]string{}}
+}
+
+func (s *fixtureScrubber) deviceID(real string) string {
+	if real == "" {
+		return real
+	}
+	fake, ok := s.replace[real]
+	if !ok {
+		s.ids++
+		fake = fmt.Sprintf("100000000000000%04d", s.ids)
+		s.replace[real] = fake
+	}
+	return fake
+}
+
+func (s *fixtureScrubber) locationName(real string) string {
+	if real != "" {
+		s.replace[real] = "Home"
+	}
+	return "Home"
+}
+
+func (s *fixtureScrubber) scrub(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		// Devices are the objects with a bridge_id, locations the ones
+		// with an away_mode.
+		_, device := v["bridge_id"]
+		_, location := v["away_mode"]
+		for k, val := range v {
+			str, isString := val.(string)
+			repl, personal := scrubbedFields[k]
+			switch {
+			case !isString:
+				v[k] = s.scrub(val)
+			case personal:
+				v[k] = repl
+			case k == "device_id" || k == "bridge_id" || (device && k == "id"):
+				v[k] = s.deviceID(str)
+			case location && k == "name":
+				v[k] = s.locationName(str)
+			default:
+				v[k] = s.scrub(val)
+			}
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = s.scrub(v[i])
+		}
+		return v
+	case string:
+		for real, fake := range s.replace {
+			v = strings.ReplaceAll(v, real, fake)
+		}
+		return v
+	}
+	return v
+}
+
+// capturingTransport keeps the body of the most recent response so a live
+// run can check the raw JSON rather than the leniently decoded model.
+type capturingTransport struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	body []byte
+}
+
+func (c *capturingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.body = body
+	c.mu.Unlock()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func (c *capturingTransport) last() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.body
+}
@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/config"
+)
+
+type RestoreOptions struct {
+	// DryRun computes and reports the changes without issuing any write.
+	DryRun bool
+}
+
+// Report describes what a restore changed, or would change in dry-run mode.
+type Report struct {
+	DryRun  bool
+	Plan    *config.Plan
+	Results []config.Result
+	Skipped []string
+}
+
+// Restore reads the current state of the devices in snap and issues the
+// minimal set of write calls to bring them back to the recorded values.
+func Restore(ctx context.Context, c *goflume.Client, snap *Snapshot, opts RestoreOptions) (*Report, error) {
+	var ids []string
+	for _, d := range snap.State.Devices {
+		ids = append(ids, d.Device.ID)
+	}
+	current, err := config.Fetch(ctx, c, ids...)
+	if err != nil {
+		return nil, err
+	}
+	plan, skipped, err := diffState(current, &snap.State)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{DryRun: opts.DryRun, Plan: plan, Skipped: skipped}
+	if opts.DryRun {
+		return report, nil
+	}
+	report.Results, err = config.Apply(ctx, c, plan)
+	return report, err
+}
+
+func (r *Report) Print(w io.Writer) error {
+	var b strings.Builder
+	if r.DryRun {
+		b.WriteString("Dry run: no changes were made.\n")
+	}
+	for _, s := range r.Skipped {
+		fmt.Fprintf(&b, "Skipped %s: it no longer exists on the account.\n", s)
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	if err := r.Plan.Print(w); err != nil {
+		return err
+	}
+	if r.DryRun || len(r.Results) == 0 {
+		return nil
+	}
+	b.Reset()
+	b.WriteString("\nResults:\n")
+	for _, res := range r.Results {
+		status := "ok"
+		switch {
+		case res.Err != nil:
+			status = "failed: " + res.Err.Error()
+		case res.Envelope != nil && res.Envelope.Simulated:
+			status = "simulated"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", res.Change, status)
+	}
+	if applied := len(r.Results); applied < len(r.Plan.Changes) {
+		fmt.Fprintf(&b, "%d change(s) not attempted.\n", len(r.Plan.Changes)-applied)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
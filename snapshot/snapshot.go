@@ -0,0 +1,136 @@
+// Package snapshot records the configurable resources of a Flume account in a
+// versioned JSON document so that changes can be compared and rolled back.
+// Only what the config package can write, subscriptions and location away
+// mode, is compared and restored; budgets, rules and contacts are recorded
+// for reference.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/config"
+)
+
+// FormatVersion is bumped whenever the document layout changes incompatibly.
+const FormatVersion = 1
+
+type Snapshot struct {
+	Version int          `json:"version"`
+	TakenAt time.Time    `json:"taken_at"`
+	UserID  int          `json:"user_id"`
+	State   config.State `json:"state"`
+}
+
+func Take(ctx context.Context, c *goflume.Client) (*Snapshot, error) {
+	state, err := config.Fetch(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Version: FormatVersion, TakenAt: time.Now().UTC(), UserID: c.JWT.UserID, State: *state}, nil
+}
+
+func (s *Snapshot) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+func Decode(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d (want %d)", s.Version, FormatVersion)
+	}
+	return &s, nil
+}
+
+func (s *Snapshot) Save(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := s.Encode(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func Load(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	s, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Diff returns the changes that turn the state recorded in from into the
+// state recorded in to. Devices and locations that only exist in to cannot be
+// recreated through the API and are reported as skipped.
+func Diff(from, to *Snapshot) (*config.Plan, []string, error) {
+	return diffState(&from.State, &to.State)
+}
+
+func diffState(current, target *config.State) (*config.Plan, []string, error) {
+	want := config.FromState(target)
+	var skipped []string
+
+	devices := want.Devices[:0]
+	for _, d := range want.Devices {
+		if !hasDevice(current, d.ID) {
+			skipped = append(skipped, "device "+d.ID)
+			continue
+		}
+		devices = append(devices, d)
+	}
+	want.Devices = devices
+
+	locations := want.Locations[:0]
+	for _, l := range want.Locations {
+		if !hasLocation(current, l.ID) {
+			skipped = append(skipped, fmt.Sprintf("location %d", l.ID))
+			continue
+		}
+		locations = append(locations, l)
+	}
+	want.Locations = locations
+
+	plan, err := config.Diff(current, want, config.Options{Prune: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan, skipped, nil
+}
+
+func hasDevice(s *config.State, id string) bool {
+	for _, d := range s.Devices {
+		if d.Device.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLocation(s *config.State, id int) bool {
+	for _, l := range s.Locations {
+		if l.ID == id {
+			return true
+		}
+	}
+	return false
+}
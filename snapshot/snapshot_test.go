@@ -0,0 +1,159 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/config"
+)
+
+// The subscription channel bits the tests use; the API does not document them.
+const (
+	channelPush  = 1
+	channelEmail = 2
+	channelSMS   = 4
+)
+
+// newServer serves a fixed account and records every non-GET request.
+func newServer(t *testing.T, awayMode bool, writes *[]string) *goflume.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			*writes = append(*writes, r.Method+" "+r.URL.Path)
+			_, _ = io.WriteString(w, `{"success":true,"data":[]}`)
+			return
+		}
+		body := `{"data":[]}`
+		switch r.URL.Path {
+		case "/users/1/locations":
+			body = `{"data":[{"id":10,"name":"Home","away_mode":` + strconv.FormatBool(awayMode) + `}]}`
+		case "/users/1/devices":
+			body = `{"data":[{"id":"d1"}]}`
+		case "/users/1/devices/d1/budgets":
+			body = `{"data":[{"id":1,"name":"Monthly","type":"MONTHLY","value":5000}]}`
+		case "/users/1/subscriptions":
+			body = `{"data":[{"id":5,"device_id":"d1","alert_type":"budget","notification_types":1}]}`
+		case "/users/1/contacts":
+			body = `{"data":[{"id":3,"category":"primary","type":"email","detail":"a@b.com"}]}`
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	c := goflume.NewClient("id", "secret", srv.Client())
+	c.BaseURL = srv.URL
+	c.JWT = goflume.JWTPayload{UserID: 1}
+	return c
+}
+
+func TestTakeSaveLoad(t *testing.T) {
+	var writes []string
+	c := newServer(t, false, &writes)
+	snap, err := Take(context.Background(), c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if snap.Version != FormatVersion || snap.UserID != 1 || time.Since(snap.TakenAt) > time.Minute {
+		t.Errorf("unexpected snapshot header: %+v", snap)
+	}
+	path := filepath.Join(t.TempDir(), "snap.json")
+	if err := snap.Save(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(loaded.State.Devices) != 1 || loaded.State.Devices[0].Budgets[0].Value != 5000 {
+		t.Errorf("unexpected loaded state: %+v", loaded.State)
+	}
+	if len(writes) != 0 {
+		t.Errorf("taking a snapshot issued writes: %v", writes)
+	}
+}
+
+func TestDecode_rejectsUnknownVersion(t *testing.T) {
+	_, err := Decode(strings.NewReader(`{"version":99}`))
+	if err == nil || !strings.Contains(err.Error(), "unsupported snapshot version") {
+		t.Errorf("expected version error, got %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := &Snapshot{Version: FormatVersion, State: config.State{
+		Devices: []config.DeviceState{{
+			Device:        goflume.Device{ID: "d1"},
+			Subscriptions: []goflume.Subscription{{ID: 5, DeviceID: "d1", AlertType: "budget", NotificationTypes: channelPush}},
+		}},
+	}}
+	after := &Snapshot{Version: FormatVersion, State: config.State{
+		Devices: []config.DeviceState{
+			{
+				Device: goflume.Device{ID: "d1"},
+				Subscriptions: []goflume.Subscription{
+					{ID: 5, DeviceID: "d1", AlertType: "budget", NotificationTypes: channelEmail},
+					{ID: 6, DeviceID: "d1", AlertType: "low_battery", NotificationTypes: channelPush},
+				},
+			},
+			{Device: goflume.Device{ID: "d2"}},
+		},
+	}}
+	plan, skipped, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Count(config.ActionUpdate) != 1 || plan.Count(config.ActionCreate) != 1 {
+		t.Errorf("unexpected plan: %+v", plan.Changes)
+	}
+	if len(skipped) != 1 || skipped[0] != "device d2" {
+		t.Errorf("unexpected skipped: %v", skipped)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	var writes []string
+	snap, err := Take(context.Background(), newServer(t, false, &writes))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snap.State.Devices[0].Subscriptions = nil // a subscription added after the snapshot should be removed
+
+	c := newServer(t, true, &writes)
+	report, err := Restore(context.Background(), c, snap, RestoreOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(writes) != 0 {
+		t.Errorf("dry-run restore issued writes: %v", writes)
+	}
+	if len(report.Plan.Changes) != 2 {
+		t.Errorf("unexpected dry-run plan: %+v", report.Plan.Changes)
+	}
+	var buf bytes.Buffer
+	_ = report.Print(&buf)
+	if !strings.Contains(buf.String(), "Dry run") || !strings.Contains(buf.String(), "away_mode: true -> false") {
+		t.Errorf("unexpected dry-run report:\n%s", buf.String())
+	}
+
+	report, err = Restore(context.Background(), c, snap, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"PATCH /users/1/locations/10", "DELETE /users/1/subscriptions/5"}
+	if strings.Join(writes, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected writes %v, want %v", writes, want)
+	}
+	buf.Reset()
+	_ = report.Print(&buf)
+	if !strings.Contains(buf.String(), "Results:") {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
@@ -4,8 +4,28 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strconv"
 )
 
+// AlertType is the kind of alert a subscription routes, as in
+// Subscription.AlertType.
+//
+// The Flume API reference does not list the accepted values, so the package
+// does not define any. Copy them from the subscriptions the Flume app created,
+// for example AlertType(sub.AlertType).
+type AlertType string
+
+// NotificationChannel is the bitmask of channels an alert is delivered over,
+// as in Subscription.NotificationTypes.
+//
+// The Flume API reference does not document the bits either; take them from
+// an existing subscription, for example NotificationChannel(sub.NotificationTypes).
+type NotificationChannel int
+
+func (n NotificationChannel) Has(channel NotificationChannel) bool {
+	return n&channel == channel
+}
+
 type Subscription struct {
 	ID                int    `json:"id"`
 	UserID            int    `json:"user_id"`
@@ -87,6 +107,9 @@ type SubscriptionResponse struct {
 	Data Subscription `json:"data"`
 }
 
+// GetSubscription returns the subscription with the given ID.
+//
+// Deprecated: Use GetSubscriptionByID, which takes Subscription.ID as is.
 func (c *Client) GetSubscription(ctx context.Context, subscriptionID string) (*SubscriptionResponse, error) {
 	if subscriptionID == "" {
 		return nil, fmt.Errorf("subscriptionID cannot be empty")
@@ -102,3 +125,145 @@ func (c *Client) GetSubscription(ctx context.Context, subscriptionID string) (*S
 	}
 	return &resp, nil
 }
+
+// GetSubscriptionByID returns the subscription with the given ID.
+func (c *Client) GetSubscriptionByID(ctx context.Context, subscriptionID int) (*SubscriptionResponse, error) {
+	if subscriptionID <= 0 {
+		return nil, fmt.Errorf("subscriptionID must be positive")
+	}
+	return c.GetSubscription(ctx, strconv.Itoa(subscriptionID))
+}
+
+type SubscriptionParams struct {
+	AlertType         AlertType           `json:"alert_type,omitempty"`
+	AlertInfo         string              `json:"alert_info,omitempty"`
+	DeviceID          string              `json:"device_id,omitempty"`
+	NotificationTypes NotificationChannel `json:"notification_types"`
+}
+
+func (c *Client) CreateSubscription(ctx context.Context, params SubscriptionParams) (*SubscriptionResponse, error) {
+	if params.AlertType == "" {
+		return nil, fmt.Errorf("alertType cannot be empty")
+	}
+	if params.DeviceID == "" {
+		return nil, fmt.Errorf("deviceID cannot be empty")
+	}
+	req := fmt.Sprintf("%s/users/%d/subscriptions", c.BaseURL, c.JWT.UserID)
+	u, err := url.Parse(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp SubscriptionResponse
+	if err := c.apiRequest(ctx, "POST", u, params, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+type SubscriptionPatch struct {
+	AlertInfo         *string              `json:"alert_info,omitempty"`
+	NotificationTypes *NotificationChannel `json:"notification_types,omitempty"`
+}
+
+func (c *Client) UpdateSubscription(ctx context.Context, subscriptionID int, patch SubscriptionPatch) (*SubscriptionResponse, error) {
+	if subscriptionID <= 0 {
+		return nil, fmt.Errorf("subscriptionID must be positive")
+	}
+	req := fmt.Sprintf("%s/users/%d/subscriptions/%d", c.BaseURL, c.JWT.UserID, subscriptionID)
+	u, err := url.Parse(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp SubscriptionResponse
+	if err := c.apiRequest(ctx, "PATCH", u, patch, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) DeleteSubscription(ctx context.Context, subscriptionID int) (*APIResponseEnvelope, error) {
+	if subscriptionID <= 0 {
+		return nil, fmt.Errorf("subscriptionID must be positive")
+	}
+	req := fmt.Sprintf("%s/users/%d/subscriptions/%d", c.BaseURL, c.JWT.UserID, subscriptionID)
+	u, err := url.Parse(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp APIResponseEnvelope
+	if err := c.apiRequest(ctx, "DELETE", u, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// EnsureDeviceSubscriptions makes sure deviceID has exactly one subscription per
+// alert type delivered over channels, creating missing subscriptions, updating
+// ones whose channels differ and deleting duplicates of an alert type, keeping
+// the one with the lowest ID. Subscriptions for other alert types are left
+// untouched, so calling it repeatedly is safe.
+func (c *Client) EnsureDeviceSubscriptions(ctx context.Context, deviceID string, alertTypes []AlertType, channels NotificationChannel) ([]Subscription, error) {
+	if deviceID == "" {
+		return nil, fmt.Errorf("deviceID cannot be empty")
+	}
+	existing := map[AlertType]Subscription{}
+	var duplicates []Subscription
+	limit := int32(50)
+	for offset := int32(0); ; offset += limit {
+		resp, err := c.GetSubscriptions(ctx, &GetSubscriptionsParams{Limit: &limit, Offset: &offset, DeviceID: &deviceID})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range resp.Data {
+			alertType := AlertType(s.AlertType)
+			first, ok := existing[alertType]
+			switch {
+			case !ok:
+				existing[alertType] = s
+			case s.ID < first.ID:
+				existing[alertType] = s
+				duplicates = append(duplicates, first)
+			default:
+				duplicates = append(duplicates, s)
+			}
+		}
+		if len(resp.Data) < int(limit) {
+			break
+		}
+	}
+
+	wanted := map[AlertType]bool{}
+	for _, alertType := range alertTypes {
+		wanted[alertType] = true
+	}
+	for _, s := range duplicates {
+		if !wanted[AlertType(s.AlertType)] {
+			continue
+		}
+		if _, err := c.DeleteSubscription(ctx, s.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	var out []Subscription
+	for _, alertType := range alertTypes {
+		s, ok := existing[alertType]
+		switch {
+		case !ok:
+			resp, err := c.CreateSubscription(ctx, SubscriptionParams{AlertType: alertType, DeviceID: deviceID, NotificationTypes: channels})
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, resp.Data)
+		case NotificationChannel(s.NotificationTypes) != channels:
+			resp, err := c.UpdateSubscription(ctx, s.ID, SubscriptionPatch{NotificationTypes: &channels})
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, resp.Data)
+		default:
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"time"
 )
 
 type QueryUsageRequestBody struct {
@@ -43,3 +44,53 @@ func (c *Client) QueryUsage(ctx context.Context, deviceID string, data QueryUsag
 	}
 	return &resp, nil
 }
+
+// UsageBuckets are the buckets QueryUsage accepts, finest first.
+var UsageBuckets = []string{"MIN", "HR", "DAY", "MON", "YR"}
+
+// usageSpans bounds how much time a single query covers for each bucket, so
+// a long range at a fine bucket is fetched in several requests. MON and YR
+// never need splitting.
+var usageSpans = map[string]func(time.Time) time.Time{
+	"MIN": func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	"HR":  func(t time.Time) time.Time { return t.AddDate(0, 0, 30) },
+	"DAY": func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+}
+
+// UsageBucketStart truncates t to the start of the usage bucket holding it.
+func UsageBucketStart(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case "MIN":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	case "HR":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case "MON":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case "YR":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
+
+// SplitUsageRange returns consecutive [since, until] windows covering
+// [from, to], each small enough for one QueryUsage request at the bucket.
+// Windows split at bucket boundaries, so no bucket is counted twice, and
+// each ends one second before the next starts because the API treats
+// until_datetime as inclusive.
+func SplitUsageRange(from, to time.Time, bucket string) [][2]time.Time {
+	next := usageSpans[bucket]
+	if next == nil {
+		return [][2]time.Time{{from, to}}
+	}
+	var windows [][2]time.Time
+	for start := from; !start.After(to); {
+		end := next(UsageBucketStart(start, bucket))
+		if !end.Before(to) {
+			return append(windows, [2]time.Time{start, to})
+		}
+		windows = append(windows, [2]time.Time{start, end.Add(-time.Second)})
+		start = end
+	}
+	return windows
+}
@@ -0,0 +1,63 @@
+package goflume
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitUsageRange(t *testing.T) {
+	from := time.Date(2026, 1, 1, 6, 30, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 15, 23, 59, 59, 0, time.UTC)
+	windows := SplitUsageRange(from, to, "HR")
+	if len(windows) != 3 {
+		t.Fatalf("expected 3 windows, got %d", len(windows))
+	}
+	if !windows[0][0].Equal(from) || !windows[2][1].Equal(to) {
+		t.Errorf("windows do not cover the range: %v", windows)
+	}
+	for i := 1; i < len(windows); i++ {
+		if got := windows[i][0].Sub(windows[i-1][1]); got != time.Second {
+			t.Errorf("gap between window %d and %d is %v", i-1, i, got)
+		}
+		if windows[i][0].Minute() != 0 || windows[i][0].Second() != 0 {
+			t.Errorf("window %d does not start on an hour: %v", i, windows[i][0])
+		}
+	}
+	if got := SplitUsageRange(from, to, "MON"); len(got) != 1 {
+		t.Errorf("MON range was split into %d windows", len(got))
+	}
+}
+
+func TestUsageBucketStart(t *testing.T) {
+	at := time.Date(2026, 9, 15, 10, 30, 45, 0, time.UTC)
+	for bucket, want := range map[string]time.Time{
+		"MIN": time.Date(2026, 9, 15, 10, 30, 0, 0, time.UTC),
+		"HR":  time.Date(2026, 9, 15, 10, 0, 0, 0, time.UTC),
+		"DAY": time.Date(2026, 9, 15, 0, 0, 0, 0, time.UTC),
+		"MON": time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC),
+		"YR":  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		if got := UsageBucketStart(at, bucket); !got.Equal(want) {
+			t.Errorf("UsageBucketStart(%s) = %v, want %v", bucket, got, want)
+		}
+	}
+}
+
+func TestCollectPages(t *testing.T) {
+	var offsets []int32
+	items, err := CollectPages(2, func(limit, offset *int32) ([]int, error) {
+		offsets = append(offsets, *offset)
+		n := min(int32(5)-*offset, *limit)
+		page := make([]int, n)
+		for i := range page {
+			page[i] = int(*offset) + i
+		}
+		return page, nil
+	})
+	if err != nil || len(items) != 5 || items[4] != 4 {
+		t.Fatalf("items = %v, %v", items, err)
+	}
+	if len(offsets) != 3 || offsets[2] != 4 {
+		t.Errorf("offsets = %v", offsets)
+	}
+}
@@ -0,0 +1,92 @@
+package usagegen
+
+import "time"
+
+// Profile describes the water habits of a household.
+type Profile struct {
+	Name      string
+	Occupants int
+	WakeHour  int // Hour most occupants get up
+	SleepHour int // Hour most occupants go to bed
+
+	ShowersPerPerson float64 // Average showers per person per day
+	ShowerMinutes    int
+	ShowerGPM        float64
+
+	FlushesPerPerson float64 // Average toilet flushes per person per day
+	FlushGallons     float64
+
+	FaucetUsesPerPerson float64 // Average faucet uses (hand washing, cooking) per person per day
+	FaucetGPM           float64
+
+	Irrigation []IrrigationCycle
+}
+
+// IrrigationCycle is a sprinkler zone that runs on a fixed schedule.
+type IrrigationCycle struct {
+	Weekdays []time.Weekday
+	Hour     int
+	Minute   int
+	Duration time.Duration
+	GPM      float64
+}
+
+var (
+	Apartment = Profile{
+		Name: "apartment", Occupants: 1, WakeHour: 7, SleepHour: 23,
+		ShowersPerPerson: 0.9, ShowerMinutes: 8, ShowerGPM: 1.8,
+		FlushesPerPerson: 5, FlushGallons: 1.28,
+		FaucetUsesPerPerson: 8, FaucetGPM: 1.0,
+	}
+
+	FamilyHome = Profile{
+		Name: "family-home", Occupants: 4, WakeHour: 6, SleepHour: 22,
+		ShowersPerPerson: 0.8, ShowerMinutes: 9, ShowerGPM: 2.1,
+		FlushesPerPerson: 5, FlushGallons: 1.6,
+		FaucetUsesPerPerson: 10, FaucetGPM: 1.2,
+		Irrigation: []IrrigationCycle{
+			{Weekdays: []time.Weekday{time.Monday, time.Wednesday, time.Friday}, Hour: 5, Minute: 0, Duration: 20 * time.Minute, GPM: 6},
+			{Weekdays: []time.Weekday{time.Monday, time.Wednesday, time.Friday}, Hour: 5, Minute: 20, Duration: 15 * time.Minute, GPM: 4.5},
+		},
+	}
+
+	VacationHome = Profile{
+		Name: "vacation-home", Occupants: 0, WakeHour: 8, SleepHour: 22,
+		Irrigation: []IrrigationCycle{
+			{Weekdays: []time.Weekday{time.Tuesday, time.Saturday}, Hour: 4, Minute: 30, Duration: 25 * time.Minute, GPM: 5},
+		},
+	}
+)
+
+type AnomalyKind string
+
+const (
+	// AnomalySlowLeak is a small constant flow, such as a dripping fixture.
+	AnomalySlowLeak AnomalyKind = "slow_leak"
+	// AnomalyRunningToilet is a flapper that never seals.
+	AnomalyRunningToilet AnomalyKind = "running_toilet"
+	// AnomalyBurstPipe is a sudden high flow.
+	AnomalyBurstPipe AnomalyKind = "burst_pipe"
+)
+
+// Anomaly is injected on top of the normal household usage.
+type Anomaly struct {
+	Kind     AnomalyKind
+	Start    time.Time
+	Duration time.Duration
+	GPM      float64 // Defaults depend on Kind when zero
+}
+
+func (a Anomaly) gpm() float64 {
+	if a.GPM > 0 {
+		return a.GPM
+	}
+	switch a.Kind {
+	case AnomalyRunningToilet:
+		return 0.5
+	case AnomalyBurstPipe:
+		return 12
+	default:
+		return 0.08
+	}
+}
@@ -0,0 +1,232 @@
+// Package usagegen produces deterministic, minute-level synthetic water usage
+// for a household profile, with labelled events and injected anomalies.
+//
+// The output uses the client's own types ([]goflume.UsageQuery and
+// goflume.Flow) so it can feed analytics code directly or seed a fake server
+// such as flumetest.Server.
+package usagegen
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+const datetimeLayout = "2006-01-02 15:04:05"
+
+// EventKind names the kind of a labelled event. Anomalies use their
+// AnomalyKind value.
+type EventKind string
+
+const (
+	EventShower     EventKind = "shower"
+	EventToilet     EventKind = "toilet"
+	EventFaucet     EventKind = "faucet"
+	EventIrrigation EventKind = "irrigation"
+)
+
+// Label is the ground truth for one water event in a Series.
+type Label struct {
+	Kind    EventKind
+	Start   time.Time
+	End     time.Time
+	GPM     float64
+	Gallons float64
+	Anomaly bool
+}
+
+type Generator struct {
+	Profile   Profile
+	Seed      uint64
+	Location  *time.Location // Defaults to UTC
+	Anomalies []Anomaly
+}
+
+type Series struct {
+	Start   time.Time
+	Minutes []float64 // Gallons used in each minute from Start
+	Labels  []Label
+}
+
+// Generate returns usage for every minute in [since, until).
+func (g *Generator) Generate(since, until time.Time) *Series {
+	loc := g.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	since = since.In(loc).Truncate(time.Minute)
+	until = until.In(loc)
+	n := int(until.Sub(since) / time.Minute)
+	if n < 0 {
+		n = 0
+	}
+	s := &Series{Start: since, Minutes: make([]float64, n)}
+
+	for day := startOfDay(since); day.Before(until); day = day.AddDate(0, 0, 1) {
+		// Each day gets its own stream so that changing the range does not
+		// change the events on days that are in both ranges.
+		rng := rand.New(rand.NewPCG(g.Seed, uint64(day.Unix())))
+		g.household(s, rng, day)
+		g.irrigation(s, day)
+	}
+	for _, a := range g.Anomalies {
+		s.add(Label{Kind: EventKind(a.Kind), Start: a.Start.In(loc), End: a.Start.In(loc).Add(a.Duration), GPM: a.gpm(), Anomaly: true})
+	}
+	sort.SliceStable(s.Labels, func(i, j int) bool { return s.Labels[i].Start.Before(s.Labels[j].Start) })
+	return s
+}
+
+func (g *Generator) household(s *Series, rng *rand.Rand, day time.Time) {
+	p := g.Profile
+	awake := p.SleepHour - p.WakeHour
+	if awake <= 0 {
+		awake = 16
+	}
+	for person := 0; person < p.Occupants; person++ {
+		for i := count(rng, p.ShowersPerPerson); i > 0; i-- {
+			// Most showers happen in the first two hours after waking, the
+			// rest in the evening.
+			hour := p.WakeHour + rng.IntN(2)
+			if rng.Float64() < 0.3 {
+				hour = p.SleepHour - 1 - rng.IntN(3)
+			}
+			start := at(day, hour, rng.IntN(60))
+			minutes := p.ShowerMinutes + rng.IntN(5) - 2
+			if minutes < 2 {
+				minutes = 2
+			}
+			s.add(Label{Kind: EventShower, Start: start, End: start.Add(time.Duration(minutes) * time.Minute), GPM: p.ShowerGPM * (0.9 + 0.2*rng.Float64())})
+		}
+		for i := count(rng, p.FlushesPerPerson); i > 0; i-- {
+			start := at(day, p.WakeHour, rng.IntN(awake*60))
+			s.add(Label{Kind: EventToilet, Start: start, End: start.Add(time.Minute), GPM: p.FlushGallons})
+		}
+		for i := count(rng, p.FaucetUsesPerPerson); i > 0; i-- {
+			start := at(day, p.WakeHour, rng.IntN(awake*60))
+			minutes := 1 + rng.IntN(3)
+			s.add(Label{Kind: EventFaucet, Start: start, End: start.Add(time.Duration(minutes) * time.Minute), GPM: p.FaucetGPM * (0.5 + rng.Float64())})
+		}
+	}
+}
+
+func (g *Generator) irrigation(s *Series, day time.Time) {
+	for _, c := range g.Profile.Irrigation {
+		for _, wd := range c.Weekdays {
+			if day.Weekday() != wd {
+				continue
+			}
+			start := at(day, c.Hour, c.Minute)
+			s.add(Label{Kind: EventIrrigation, Start: start, End: start.Add(c.Duration), GPM: c.GPM})
+		}
+	}
+}
+
+// add spreads an event over the minutes it covers and records its label with
+// the volume that falls inside the series.
+func (s *Series) add(l Label) {
+	end := s.Start.Add(time.Duration(len(s.Minutes)) * time.Minute)
+	if !l.End.After(s.Start) || !l.Start.Before(end) {
+		return
+	}
+	for t := l.Start.Truncate(time.Minute); t.Before(l.End); t = t.Add(time.Minute) {
+		i := int(t.Sub(s.Start) / time.Minute)
+		if i < 0 || i >= len(s.Minutes) {
+			continue
+		}
+		s.Minutes[i] += l.GPM
+		l.Gallons += l.GPM
+	}
+	s.Labels = append(s.Labels, l)
+}
+
+// count draws an integer with the given mean.
+func count(rng *rand.Rand, mean float64) int {
+	n := int(mean)
+	if rng.Float64() < mean-float64(n) {
+		n++
+	}
+	return n
+}
+
+// at returns the wall clock time hour:minute on day, so that events keep
+// their local time on days with a daylight saving change. Minutes past 59
+// carry into the following hours.
+func at(day time.Time, hour, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func (s *Series) Total() float64 {
+	total := 0.0
+	for _, v := range s.Minutes {
+		total += v
+	}
+	return total
+}
+
+// Usage returns minute readings as whole gallons. Fractions are carried over
+// to later minutes so that the total matches the underlying series.
+func (s *Series) Usage() []goflume.UsageQuery {
+	out := make([]goflume.UsageQuery, len(s.Minutes))
+	cumulative, emitted := 0.0, 0
+	for i, v := range s.Minutes {
+		cumulative += v
+		value := int(math.Round(cumulative)) - emitted
+		emitted += value
+		out[i] = goflume.UsageQuery{Value: value, Datetime: s.Start.Add(time.Duration(i) * time.Minute).Format(datetimeLayout)}
+	}
+	return out
+}
+
+// FlowAt returns the reading GetCurrentFlow would report at t.
+func (s *Series) FlowAt(t time.Time) goflume.Flow {
+	i := int(t.Sub(s.Start) / time.Minute)
+	gpm := 0.0
+	if t.After(s.Start) || t.Equal(s.Start) {
+		if i < len(s.Minutes) {
+			gpm = s.Minutes[i]
+		}
+	}
+	return goflume.Flow{Active: gpm > 0, GPM: math.Round(gpm*100) / 100, Datetime: t.In(s.Start.Location()).Format(datetimeLayout)}
+}
+
+// Flows returns one reading per minute of the series.
+func (s *Series) Flows() []goflume.Flow {
+	out := make([]goflume.Flow, len(s.Minutes))
+	for i := range s.Minutes {
+		out[i] = s.FlowAt(s.Start.Add(time.Duration(i) * time.Minute))
+	}
+	return out
+}
+
+// LabelsAt returns the events active at t.
+func (s *Series) LabelsAt(t time.Time) []Label {
+	var out []Label
+	for _, l := range s.Labels {
+		if !t.Before(l.Start) && t.Before(l.End) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Server is a fake Flume server that a Series can be attached to.
+// *flumetest.Server implements it.
+type Server interface {
+	SetUsage(deviceID string, usage []goflume.UsageQuery)
+	SetFlowSource(deviceID string, source func(time.Time) goflume.Flow)
+}
+
+// Attach serves the series from a fake server: minute readings back the usage
+// query endpoint and the current flow follows the server's clock. The
+// generator's Location should match the TZ of the device's location.
+func (s *Series) Attach(srv Server, deviceID string) {
+	srv.SetUsage(deviceID, s.Usage())
+	srv.SetFlowSource(deviceID, s.FlowAt)
+}
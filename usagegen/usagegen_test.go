@@ -0,0 +1,160 @@
+package usagegen
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+	"github.com/401unauthorized/go-flume/flumetest"
+)
+
+var day = time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC) // a Monday
+
+func TestGenerate_deterministic(t *testing.T) {
+	g := &Generator{Profile: FamilyHome, Seed: 42}
+	a := g.Generate(day, day.AddDate(0, 0, 2))
+	b := g.Generate(day, day.AddDate(0, 0, 2))
+	if !reflect.DeepEqual(a, b) {
+		t.Error("same seed produced different series")
+	}
+	c := (&Generator{Profile: FamilyHome, Seed: 43}).Generate(day, day.AddDate(0, 0, 2))
+	if reflect.DeepEqual(a.Minutes, c.Minutes) {
+		t.Error("different seeds produced identical series")
+	}
+	// The second day must not depend on whether the first day was generated.
+	second := g.Generate(day.AddDate(0, 0, 1), day.AddDate(0, 0, 2))
+	if !reflect.DeepEqual(second.Minutes, a.Minutes[24*60:]) {
+		t.Error("a day's usage depends on the requested range")
+	}
+}
+
+func TestGenerate_labelsMatchVolume(t *testing.T) {
+	s := (&Generator{Profile: FamilyHome, Seed: 1}).Generate(day, day.AddDate(0, 0, 1))
+	if len(s.Minutes) != 24*60 {
+		t.Fatalf("expected 1440 minutes, got %d", len(s.Minutes))
+	}
+	labelled := 0.0
+	kinds := map[EventKind]int{}
+	for _, l := range s.Labels {
+		labelled += l.Gallons
+		kinds[l.Kind]++
+	}
+	if math.Abs(labelled-s.Total()) > 1e-6 {
+		t.Errorf("labels account for %.2f gallons, series has %.2f", labelled, s.Total())
+	}
+	for _, k := range []EventKind{EventShower, EventToilet, EventFaucet, EventIrrigation} {
+		if kinds[k] == 0 {
+			t.Errorf("expected at least one %s event on a Monday for a family home", k)
+		}
+	}
+	if s.Total() < 100 || s.Total() > 600 {
+		t.Errorf("unrealistic daily usage for a family of four: %.1f gallons", s.Total())
+	}
+}
+
+func TestGenerate_daylightSaving(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	profile := FamilyHome
+	profile.Irrigation = []IrrigationCycle{{Weekdays: []time.Weekday{time.Sunday}, Hour: 6, Duration: 20 * time.Minute, GPM: 3}}
+	g := &Generator{Profile: profile, Seed: 3, Location: ny}
+	// Clocks spring forward on 2026-03-08 and fall back on 2026-11-01,
+	// both Sundays.
+	for _, d := range []time.Time{time.Date(2026, 3, 8, 0, 0, 0, 0, ny), time.Date(2026, 11, 1, 0, 0, 0, 0, ny)} {
+		s := g.Generate(d, d.AddDate(0, 0, 1))
+		irrigated := false
+		for _, l := range s.Labels {
+			local := l.Start.In(ny)
+			switch l.Kind {
+			case EventIrrigation:
+				irrigated = true
+				if local.Hour() != 6 || local.Minute() != 0 {
+					t.Errorf("%s: irrigation starts at %s, want 06:00", d.Format("2006-01-02"), local.Format("15:04"))
+				}
+			case EventToilet, EventFaucet:
+				if local.Hour() < profile.WakeHour || local.Hour() >= profile.SleepHour {
+					t.Errorf("%s: %s at %s outside waking hours", d.Format("2006-01-02"), l.Kind, local.Format("15:04"))
+				}
+			}
+		}
+		if !irrigated {
+			t.Errorf("%s: no irrigation", d.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestGenerate_anomalies(t *testing.T) {
+	leakStart := day.Add(2 * time.Hour)
+	g := &Generator{Profile: VacationHome, Seed: 7, Anomalies: []Anomaly{
+		{Kind: AnomalySlowLeak, Start: leakStart, Duration: 3 * time.Hour},
+	}}
+	s := g.Generate(day, day.AddDate(0, 0, 1))
+	var leak *Label
+	for i := range s.Labels {
+		if s.Labels[i].Anomaly {
+			leak = &s.Labels[i]
+		}
+	}
+	if leak == nil || leak.Kind != EventKind(AnomalySlowLeak) || math.Abs(leak.Gallons-0.08*180) > 1e-6 {
+		t.Fatalf("unexpected leak label: %+v", leak)
+	}
+	if f := s.FlowAt(leakStart.Add(time.Hour)); !f.Active || f.GPM != 0.08 {
+		t.Errorf("expected leak flow, got %+v", f)
+	}
+	if got := s.LabelsAt(leakStart.Add(time.Minute)); len(got) != 1 || !got[0].Anomaly {
+		t.Errorf("unexpected labels at leak time: %+v", got)
+	}
+}
+
+func TestUsage_carriesFractions(t *testing.T) {
+	s := &Series{Start: day, Minutes: []float64{0.4, 0.4, 0.4, 0.4, 0.4}}
+	var got []int
+	for _, u := range s.Usage() {
+		got = append(got, u.Value)
+	}
+	if !reflect.DeepEqual(got, []int{0, 1, 0, 1, 0}) {
+		t.Errorf("unexpected whole-gallon readings: %v", got)
+	}
+	if s.Usage()[1].Datetime != "2026-03-02 00:01:00" {
+		t.Errorf("unexpected datetime: %s", s.Usage()[1].Datetime)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	now := time.Date(2026, 3, 2, 5, 10, 0, 0, loc) // during Monday irrigation
+	srv := flumetest.NewServer(flumetest.WithClock(func() time.Time { return now }))
+	defer srv.Close()
+	device := srv.State().Devices[0].ID
+
+	g := &Generator{Profile: FamilyHome, Seed: 3, Location: loc}
+	s := g.Generate(time.Date(2026, 3, 2, 0, 0, 0, 0, loc), time.Date(2026, 3, 3, 0, 0, 0, 0, loc))
+	s.Attach(srv, device)
+
+	c := srv.Client()
+	ctx := context.Background()
+	if err := c.Authenticate(ctx, flumetest.Username, flumetest.Password); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	flow, err := c.GetCurrentFlow(ctx, device)
+	if err != nil {
+		t.Fatalf("current flow: %v", err)
+	}
+	if !flow.Data[0].Active || flow.Data[0].GPM < 6 {
+		t.Errorf("expected irrigation flow, got %+v", flow.Data[0])
+	}
+	usage, err := c.QueryUsage(ctx, device, goflume.QueryUsageRequestBody{
+		Bucket: "DAY", SinceDatetime: "2026-03-02 00:00:00", UntilDatetime: "2026-03-02 23:59:59",
+	})
+	if err != nil {
+		t.Fatalf("query usage: %v", err)
+	}
+	if len(usage.Data) != 1 || usage.Data[0].Value != int(math.Round(s.Total())) {
+		t.Errorf("expected daily total %.0f, got %+v", s.Total(), usage.Data)
+	}
+}
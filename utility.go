@@ -9,8 +9,20 @@ type APIResponseEnvelope struct {
 	Detailed    string `json:"detailed"`
 	Count       int    `json:"count"`
 	Pagination  string `json:"pagination"`
+
+	// Simulated is set when the request was not sent because the client is
+	// in dry-run mode.
+	Simulated bool `json:"-"`
+}
+
+// Envelope gives generic code access to the envelope embedded in every
+// response type.
+func (e *APIResponseEnvelope) Envelope() *APIResponseEnvelope {
+	return e
 }
 
+func (e *APIResponseEnvelope) markSimulated() { e.Simulated = true }
+
 type Pagination struct {
 	Next string `json:"next"`
 	Prev string `json:"prev"`
@@ -25,4 +37,28 @@ type APIResponseEnvelopePagination struct {
 	Detailed    string     `json:"detailed"`
 	Count       int        `json:"count"`
 	Pagination  Pagination `json:"pagination"`
+
+	// Simulated is set when the request was not sent because the client is
+	// in dry-run mode.
+	Simulated bool `json:"-"`
+}
+
+func (e *APIResponseEnvelopePagination) markSimulated() { e.Simulated = true }
+
+// CollectPages reads every item of a paginated listing. It calls page with
+// pageSize as the limit and a growing offset until a page comes back short.
+func CollectPages[T any](pageSize int32, page func(limit, offset *int32) ([]T, error)) ([]T, error) {
+	var all []T
+	limit := pageSize
+	for offset := int32(0); ; offset += limit {
+		o := offset
+		items, err := page(&limit, &o)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		if len(items) < int(limit) {
+			return all, nil
+		}
+	}
 }
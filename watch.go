@@ -0,0 +1,255 @@
+package goflume
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FlowEventType tells what a FlowEvent reports.
+type FlowEventType int
+
+const (
+	// FlowReading is sent for every successful poll of a device.
+	FlowReading FlowEventType = iota + 1
+	// FlowStarted is sent when a device starts reporting active flow.
+	FlowStarted
+	// FlowStopped is sent when the flow ends, with its duration and volume.
+	FlowStopped
+	// DeviceUnreachable is sent when polling a device starts failing. The
+	// next FlowReading means it recovered.
+	DeviceUnreachable
+	// RoundDone is sent after every round of polls when the watcher's
+	// RoundEvents is set.
+	RoundDone
+)
+
+func (t FlowEventType) String() string {
+	switch t {
+	case FlowReading:
+		return "reading"
+	case FlowStarted:
+		return "flow_started"
+	case FlowStopped:
+		return "flow_stopped"
+	case DeviceUnreachable:
+		return "device_unreachable"
+	case RoundDone:
+		return "round_done"
+	}
+	return fmt.Sprintf("FlowEventType(%d)", int(t))
+}
+
+// FlowEvent is sent by a FlowWatcher.
+type FlowEvent struct {
+	Type     FlowEventType
+	DeviceID string
+	Time     time.Time
+	Flow     Flow // the reading, for FlowReading, FlowStarted and FlowStopped
+
+	// Duration and Gallons describe the current flow for FlowReading and
+	// the finished one for FlowStopped. Gallons is estimated from the
+	// readings, so it is only as good as the poll interval.
+	Duration time.Duration
+	Gallons  float64
+
+	Err  error         // why the device is unreachable
+	Next time.Duration // wait before the next round, for RoundDone
+}
+
+// FlowWatcher polls the current flow of one or more devices and turns the
+// readings into events. It polls every ActiveInterval while any device
+// reports flow and every IdleInterval otherwise, and never spends more than
+// its Budget allows.
+type FlowWatcher struct {
+	Client *Client
+	// DeviceIDs are the devices to poll. When empty every water sensor of
+	// the account is polled.
+	DeviceIDs []string
+
+	// ActiveInterval defaults to 15 seconds and IdleInterval to 2 minutes.
+	ActiveInterval time.Duration
+	IdleInterval   time.Duration
+
+	// Budget is shared with other pollers of the same account. When nil
+	// the watcher uses its own budget of DefaultRequestLimit per hour.
+	Budget *RequestBudget
+
+	// RoundEvents adds a RoundDone event after every round, for consumers
+	// that act once per round rather than per device.
+	RoundEvents bool
+
+	// Now and After replace time.Now and time.After.
+	Now   func() time.Time
+	After func(time.Duration) <-chan time.Time
+}
+
+// waterSensorType is the Device.Type of a flow sensor; bridges report none.
+const waterSensorType = 2
+
+// flowState tracks one device between polls.
+type flowState struct {
+	id          string
+	flow        Flow
+	since, last time.Time
+	gallons     float64
+	unreachable bool
+}
+
+// Watch starts polling and returns the event channel, which is closed once
+// ctx is done.
+func (w *FlowWatcher) Watch(ctx context.Context) (<-chan FlowEvent, error) {
+	if w.Client == nil {
+		return nil, errors.New("flow watcher needs a client")
+	}
+	if w.Budget == nil {
+		w.Budget = &RequestBudget{Now: w.Now}
+	}
+	ids := w.DeviceIDs
+	if len(ids) == 0 {
+		if !w.reserve(ctx) {
+			return nil, ctx.Err()
+		}
+		resp, err := w.Client.GetDevices(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list devices: %w", err)
+		}
+		for _, d := range resp.Data {
+			if d.Type == waterSensorType {
+				ids = append(ids, d.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, errors.New("no water sensor found on this account")
+		}
+	}
+
+	events := make(chan FlowEvent)
+	go func() {
+		defer close(events)
+		states := make([]*flowState, len(ids))
+		for i, id := range ids {
+			states[i] = &flowState{id: id}
+		}
+		for {
+			active := false
+			for _, s := range states {
+				if !w.reserve(ctx) {
+					return
+				}
+				resp, err := w.Client.GetCurrentFlow(ctx, s.id)
+				if ctx.Err() != nil {
+					return
+				}
+				if err == nil && len(resp.Data) == 0 {
+					err = errors.New("no flow data")
+				}
+				var out []FlowEvent
+				if err != nil {
+					out = s.fail(err, w.now())
+				} else {
+					out = s.observe(resp.Data[0], w.now())
+				}
+				for _, e := range out {
+					select {
+					case events <- e:
+					case <-ctx.Done():
+						return
+					}
+				}
+				active = active || s.flow.Active
+			}
+
+			interval := w.IdleInterval
+			if interval <= 0 {
+				interval = 2 * time.Minute
+			}
+			if active {
+				interval = w.ActiveInterval
+				if interval <= 0 {
+					interval = 15 * time.Second
+				}
+			}
+			next := w.Budget.Pace(len(states), interval, active)
+			if w.RoundEvents {
+				select {
+				case events <- FlowEvent{Type: RoundDone, Time: w.now(), Next: next}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.after(next):
+			}
+		}
+	}()
+	return events, nil
+}
+
+// reserve blocks until the budget grants one request or ctx ends.
+func (w *FlowWatcher) reserve(ctx context.Context) bool {
+	return w.Budget.reserve(ctx, w.after)
+}
+
+func (w *FlowWatcher) now() time.Time {
+	if w.Now != nil {
+		return w.Now()
+	}
+	return time.Now()
+}
+
+func (w *FlowWatcher) after(d time.Duration) <-chan time.Time {
+	if w.After != nil {
+		return w.After(d)
+	}
+	return time.After(d)
+}
+
+// fail records a poll that failed. Nothing is known about the flow while
+// the device is unreachable, so a current flow ends at the last reading
+// rather than running on through the outage; if the device still flows once
+// it answers again, a new flow starts then.
+func (s *flowState) fail(err error, now time.Time) []FlowEvent {
+	if s.unreachable {
+		return nil
+	}
+	s.unreachable = true
+	var out []FlowEvent
+	if s.flow.Active {
+		out = append(out, FlowEvent{Type: FlowStopped, DeviceID: s.id, Time: s.last,
+			Duration: s.last.Sub(s.since), Gallons: s.gallons})
+		s.flow, s.since, s.gallons = Flow{}, time.Time{}, 0
+	}
+	return append(out, FlowEvent{Type: DeviceUnreachable, DeviceID: s.id, Time: now, Err: err})
+}
+
+// observe folds a reading taken at now into the state and returns the
+// events it causes. Volume is estimated with the trapezoidal rule between
+// readings.
+func (s *flowState) observe(f Flow, now time.Time) []FlowEvent {
+	s.unreachable = false
+	var out []FlowEvent
+	minutes := now.Sub(s.last).Minutes()
+	switch {
+	case f.Active && !s.flow.Active:
+		s.since, s.gallons = now, 0
+		out = append(out, FlowEvent{Type: FlowStarted, DeviceID: s.id, Time: now, Flow: f})
+	case f.Active:
+		s.gallons += (s.flow.GPM + f.GPM) / 2 * minutes
+	case s.flow.Active:
+		// The flow ended somewhere since the last reading.
+		s.gallons += s.flow.GPM / 2 * minutes
+		out = append(out, FlowEvent{Type: FlowStopped, DeviceID: s.id, Time: now, Flow: f,
+			Duration: now.Sub(s.since), Gallons: s.gallons})
+		s.since, s.gallons = time.Time{}, 0
+	}
+	s.flow, s.last = f, now
+	reading := FlowEvent{Type: FlowReading, DeviceID: s.id, Time: now, Flow: f}
+	if f.Active {
+		reading.Duration, reading.Gallons = now.Sub(s.since), s.gallons
+	}
+	return append(out, reading)
+}
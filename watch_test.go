@@ -0,0 +1,198 @@
+package goflume
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// watchClock advances only when the watcher waits on it.
+type watchClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *watchClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *watchClock) after(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.t
+	c.mu.Unlock()
+	return ch
+}
+
+// newWatchClient serves the current flow of each device from flow, or an
+// error when flow returns one.
+func newWatchClient(devices string, flow func(device string) (Flow, error)) *Client {
+	return &Client{
+		BaseURL: "http://flume.test",
+		JWT:     JWTPayload{UserID: 1},
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, status := devices, http.StatusOK
+			if parts := strings.Split(r.URL.Path, "/"); strings.HasSuffix(r.URL.Path, "/query/active") {
+				f, err := flow(parts[4])
+				if err != nil {
+					body, status = `{"success":false,"message":"unavailable"}`, http.StatusServiceUnavailable
+				} else {
+					body = fmt.Sprintf(`{"success":true,"data":[{"active":%t,"gpm":%g}]}`, f.Active, f.GPM)
+				}
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+		})},
+	}
+}
+
+func collectEvents(t *testing.T, events <-chan FlowEvent, until func(FlowEvent) bool) []FlowEvent {
+	t.Helper()
+	var got []FlowEvent
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("event channel closed early")
+			}
+			got = append(got, e)
+			if until(e) {
+				return got
+			}
+		case <-timeout:
+			t.Fatalf("timed out after %d events", len(got))
+		}
+	}
+}
+
+func TestFlowWatcher_startStop(t *testing.T) {
+	clock := &watchClock{t: time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)}
+	start := clock.now()
+	c := newWatchClient("", func(string) (Flow, error) {
+		elapsed := clock.now().Sub(start)
+		return Flow{Active: elapsed >= time.Minute && elapsed < 5*time.Minute, GPM: 2}, nil
+	})
+	w := &FlowWatcher{Client: c, DeviceIDs: []string{"d1"}, ActiveInterval: time.Minute, IdleInterval: 2 * time.Minute,
+		Now: clock.now, After: clock.after}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := w.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := collectEvents(t, events, func(e FlowEvent) bool { return e.Type == FlowStopped })
+	var types []string
+	for _, e := range got {
+		types = append(types, fmt.Sprintf("%s@%s", e.Type, e.Time.Sub(start)))
+	}
+	want := "reading@0s flow_started@2m0s reading@2m0s reading@3m0s reading@4m0s flow_stopped@5m0s"
+	if strings.Join(types, " ") != want {
+		t.Fatalf("events = %s\nwant     %s", strings.Join(types, " "), want)
+	}
+	if r := got[4]; r.Duration != 2*time.Minute || r.Gallons != 4 {
+		t.Errorf("last reading: duration %v, gallons %v", r.Duration, r.Gallons)
+	}
+	if s := got[5]; s.Duration != 3*time.Minute || s.Gallons != 5 || s.DeviceID != "d1" {
+		t.Errorf("stopped: %+v", s)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestFlowWatcher_unreachableAndDiscovery(t *testing.T) {
+	clock := &watchClock{t: time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)}
+	failures := 3
+	var mu sync.Mutex
+	c := newWatchClient(`{"success":true,"data":[{"id":"bridge","type":1},{"id":"sensor","type":2}]}`, func(id string) (Flow, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if id != "sensor" {
+			t.Errorf("polled %s", id)
+		}
+		if failures > 0 {
+			failures--
+			return Flow{}, fmt.Errorf("down")
+		}
+		return Flow{}, nil
+	})
+	w := &FlowWatcher{Client: c, Now: clock.now, After: clock.after}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := w.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := collectEvents(t, events, func(e FlowEvent) bool { return e.Type == FlowReading })
+	if len(got) != 2 || got[0].Type != DeviceUnreachable || got[0].Err == nil || got[0].DeviceID != "sensor" {
+		t.Errorf("events = %+v", got)
+	}
+	if spent := DefaultRequestLimit - w.Budget.Remaining(); spent != 5 {
+		t.Errorf("spent %d requests, want 5 (devices and four polls)", spent)
+	}
+}
+
+func TestFlowWatcher_unreachableEndsFlow(t *testing.T) {
+	clock := &watchClock{t: time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)}
+	start := clock.now()
+	// The device flows throughout but does not answer from 2m to 4m.
+	c := newWatchClient("", func(string) (Flow, error) {
+		if elapsed := clock.now().Sub(start); elapsed >= 2*time.Minute && elapsed < 4*time.Minute {
+			return Flow{}, fmt.Errorf("down")
+		}
+		return Flow{Active: true, GPM: 2}, nil
+	})
+	w := &FlowWatcher{Client: c, DeviceIDs: []string{"d1"}, ActiveInterval: time.Minute, RoundEvents: true,
+		Now: clock.now, After: clock.after}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := w.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := collectEvents(t, events, func(e FlowEvent) bool { return e.Type == FlowReading && e.Time.Sub(start) == 4*time.Minute })
+	var types []string
+	for _, e := range got {
+		types = append(types, fmt.Sprintf("%s@%s", e.Type, e.Time.Sub(start)))
+	}
+	want := "flow_started@0s reading@0s round_done@0s reading@1m0s round_done@1m0s " +
+		"flow_stopped@1m0s device_unreachable@2m0s round_done@2m0s flow_started@4m0s reading@4m0s"
+	if strings.Join(types, " ") != want {
+		t.Fatalf("events = %s\nwant     %s", strings.Join(types, " "), want)
+	}
+	if s := got[5]; s.Duration != time.Minute || s.Gallons != 2 {
+		t.Errorf("stopped: %+v", s)
+	}
+	if r := got[len(got)-1]; r.Duration != 0 || r.Gallons != 0 {
+		t.Errorf("flow after the outage did not start over: %+v", r)
+	}
+	if d := got[2]; d.DeviceID != "" || d.Next != time.Minute {
+		t.Errorf("round done: %+v", d)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestFlowWatcher_noSensor(t *testing.T) {
+	c := newWatchClient(`{"success":true,"data":[{"id":"bridge","type":1}]}`, nil)
+	if _, err := (&FlowWatcher{Client: c}).Watch(context.Background()); err == nil {
+		t.Error("expected an error without water sensors")
+	}
+}
@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+// Event types.
+const (
+	LeakOpened           = "flume.leak.opened"
+	LeakClosed           = "flume.leak.closed"
+	NotificationReceived = "flume.notification"
+	UsageAlertTriggered  = "flume.usage_alert"
+	BudgetCrossed        = "flume.budget.threshold_crossed"
+	DeviceOffline        = "flume.device.offline"
+	DeviceOnline         = "flume.device.online"
+	// DeviceUnreachable is a device the FlowWatcher could not poll, which
+	// is about the API as much as the device.
+	DeviceUnreachable = "flume.device.unreachable"
+)
+
+// FromLeakIncident returns a LeakOpened or LeakClosed event, depending on
+// whether the incident is still open.
+func FromLeakIncident(inc goflume.LeakIncident) Event {
+	e := Event{Type: LeakOpened, Subject: inc.DeviceID, Time: inc.Opened, Data: inc}
+	if !inc.Open() {
+		e.Type, e.Time = LeakClosed, *inc.End
+	}
+	e.ID = fmt.Sprintf("leak-%s-%d-%s", inc.DeviceID, inc.Start.Unix(), e.Type[len("flume.leak."):])
+	return e
+}
+
+// FromNotification returns a NotificationReceived event.
+func FromNotification(n goflume.Notification) Event {
+	return Event{
+		ID:      "notification-" + strconv.Itoa(n.ID),
+		Type:    NotificationReceived,
+		Subject: n.DeviceID,
+		Time:    parseAPITime(n.CreatedDatetime),
+		Data:    n,
+	}
+}
+
+// FromUsageAlert returns a UsageAlertTriggered event.
+func FromUsageAlert(a goflume.UsageAlert) Event {
+	return Event{
+		ID:      "usage-alert-" + strconv.Itoa(a.ID),
+		Type:    UsageAlertTriggered,
+		Subject: a.DeviceID,
+		Time:    parseAPITime(a.TriggeredDatetime),
+		Data:    a,
+	}
+}
+
+// FromEnrichedUsageAlert returns a UsageAlertTriggered event that carries
+// the usage behind the alert.
+func FromEnrichedUsageAlert(a goflume.EnrichedUsageAlert) Event {
+	e := FromUsageAlert(a.UsageAlert)
+	e.Data = a
+	return e
+}
+
+// FromFlowEvent returns a DeviceUnreachable event for the FlowEvent of the
+// same type. The other flow events are too frequent for webhooks and
+// report false; feed them to a LeakDetector instead.
+func FromFlowEvent(fe goflume.FlowEvent) (Event, bool) {
+	if fe.Type != goflume.DeviceUnreachable {
+		return Event{}, false
+	}
+	data := struct {
+		DeviceID string `json:"device_id"`
+		Error    string `json:"error,omitempty"`
+	}{DeviceID: fe.DeviceID}
+	if fe.Err != nil {
+		data.Error = fe.Err.Error()
+	}
+	return Event{Type: DeviceUnreachable, Subject: fe.DeviceID, Time: fe.Time, Data: data}, true
+}
+
+// BudgetCrossing is the data of a BudgetCrossed event.
+type BudgetCrossing struct {
+	DeviceID  string         `json:"device_id"`
+	Budget    goflume.Budget `json:"budget"`
+	Threshold int            `json:"threshold"` // percent of the budget value
+	Percent   float64        `json:"percent"`   // of the budget value used
+}
+
+// DeviceStatus is the data of DeviceOffline and DeviceOnline events.
+type DeviceStatus struct {
+	Device goflume.Device `json:"device"`
+}
+
+// Tracker turns successive readings of budgets and devices into events. The
+// first reading of each only sets the baseline. It is safe for concurrent
+// use.
+type Tracker struct {
+	// Now replaces time.Now.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	budgets   map[string]goflume.Budget // by device ID and budget ID
+	connected map[string]bool
+}
+
+// Budgets returns a BudgetCrossed event for every threshold of a budget of
+// the device that its actual usage reached since the previous reading. A
+// drop in usage starts a new period and crosses nothing.
+func (t *Tracker) Budgets(deviceID string, budgets []goflume.Budget) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.budgets == nil {
+		t.budgets = map[string]goflume.Budget{}
+	}
+	var out []Event
+	for _, b := range budgets {
+		key := deviceID + "/" + strconv.Itoa(b.ID)
+		prev, seen := t.budgets[key]
+		t.budgets[key] = b
+		if !seen || b.Value <= 0 || b.Actual <= prev.Actual {
+			continue
+		}
+		before, after := percent(prev.Actual, b.Value), percent(b.Actual, b.Value)
+		now := t.now()
+		for _, th := range b.Thresholds {
+			if before < float64(th) && after >= float64(th) {
+				out = append(out, Event{
+					ID:      fmt.Sprintf("budget-%s-%d-%d-%d", deviceID, b.ID, th, now.Unix()),
+					Type:    BudgetCrossed,
+					Subject: deviceID,
+					Time:    now,
+					Data:    BudgetCrossing{DeviceID: deviceID, Budget: b, Threshold: th, Percent: after},
+				})
+			}
+		}
+	}
+	return out
+}
+
+// Devices returns a DeviceOffline or DeviceOnline event for every device
+// whose connection changed since the previous reading.
+func (t *Tracker) Devices(devices []goflume.Device) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.connected == nil {
+		t.connected = map[string]bool{}
+	}
+	var out []Event
+	for _, d := range devices {
+		prev, seen := t.connected[d.ID]
+		t.connected[d.ID] = d.Connected
+		if !seen || prev == d.Connected {
+			continue
+		}
+		typ := DeviceOffline
+		if d.Connected {
+			typ = DeviceOnline
+		}
+		out = append(out, Event{Type: typ, Subject: d.ID, Time: t.now(), Data: DeviceStatus{Device: d}})
+	}
+	return out
+}
+
+func (t *Tracker) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+func percent(actual, value int) float64 {
+	return float64(actual) * 100 / float64(value)
+}
+
+// NotificationHandler returns a handler for NotificationPoller.Handle that
+// sends every notification. A delivery given up on is returned, so the
+// poller tries it again later.
+func (d *Dispatcher) NotificationHandler() goflume.NotificationHandler {
+	return func(ctx context.Context, n goflume.Notification) error {
+		return d.Send(ctx, FromNotification(n))
+	}
+}
+
+// ForwardFlow reads the events of a FlowWatcher until the channel closes or
+// ctx ends and sends the DeviceUnreachable ones. With a non-nil detector,
+// the readings are also checked for leaks and its incidents are sent as
+// they open and close. Deliveries run in the background so that the
+// watcher is never held up; ForwardFlow waits for them before returning.
+func (d *Dispatcher) ForwardFlow(ctx context.Context, events <-chan goflume.FlowEvent, leaks *goflume.LeakDetector) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	send := func(e Event) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Failures already went to OnError and the dead-letter file.
+			_ = d.Send(ctx, e)
+		}()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case fe, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if e, ok := FromFlowEvent(fe); ok {
+				send(e)
+			}
+			if leaks != nil {
+				if inc := leaks.ObserveFlow(fe); inc != nil {
+					send(FromLeakIncident(*inc))
+				}
+			}
+		}
+	}
+}
+
+// parseAPITime parses the timestamps of notifications and usage alerts,
+// which are UTC. It returns the zero time, and so the time of sending, for
+// anything else.
+func parseAPITime(s string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,450 @@
+// Package webhook delivers Flume events to HTTP endpoints.
+//
+// Events come from the polling components of the client: leak incidents,
+// notifications, usage alerts, budget threshold crossings and device
+// connectivity changes (see events.go for the constructors). A Dispatcher
+// posts each event to every endpoint whose filter accepts it, signs the
+// body with HMAC-SHA256, retries failed deliveries with exponential backoff
+// and appends the deliveries it gives up on to a dead-letter file.
+//
+//	d := &webhook.Dispatcher{
+//		Endpoints:  []webhook.Endpoint{{URL: "https://example.com/hook", Secret: secret, Types: []string{"flume.leak.*"}}},
+//		DeadLetter: "/var/lib/flume/dead-letter.jsonl",
+//	}
+//	err := d.Send(ctx, webhook.FromLeakIncident(incident))
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers set on every delivery.
+const (
+	HeaderSignature = "X-Flume-Signature" // sha256=<hex HMAC of "<timestamp>.<body>">
+	HeaderTimestamp = "X-Flume-Timestamp" // Unix seconds
+	HeaderEvent     = "X-Flume-Event"     // the event type
+	HeaderDelivery  = "X-Flume-Delivery"  // the event ID
+)
+
+// Event is something worth telling an endpoint about.
+type Event struct {
+	// ID identifies the event, so receivers can drop duplicates of a
+	// retried delivery. Send fills in a random one when empty.
+	ID      string
+	Type    string
+	Source  string // defaults to "go-flume"
+	Subject string // the device or location the event is about
+	Time    time.Time
+	Data    any // encoded as JSON
+}
+
+// Endpoint is a receiver of events.
+type Endpoint struct {
+	// Name identifies the endpoint in errors and the dead-letter file.
+	// Defaults to the URL.
+	Name   string
+	URL    string
+	Secret string // HMAC-SHA256 key; deliveries are unsigned without one
+	// CloudEvents sends events in the CloudEvents 1.0 structured JSON
+	// format instead of the plain one.
+	CloudEvents bool
+	// Headers are added to every delivery. They cannot replace the
+	// content type, event or signature headers.
+	Headers map[string]string
+
+	// Types limits the endpoint to these event types. An entry ending in
+	// ".*" matches every type under that prefix. Empty matches all.
+	Types []string
+	// Filter, when set, must also accept the event.
+	Filter func(Event) bool
+}
+
+// Accepts reports whether the endpoint wants e.
+func (ep Endpoint) Accepts(e Event) bool {
+	if len(ep.Types) > 0 {
+		ok := false
+		for _, t := range ep.Types {
+			if t == e.Type || strings.HasSuffix(t, ".*") && strings.HasPrefix(e.Type, strings.TrimSuffix(t, "*")) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return ep.Filter == nil || ep.Filter(e)
+}
+
+func (ep Endpoint) name() string {
+	if ep.Name != "" {
+		return ep.Name
+	}
+	return ep.URL
+}
+
+// Dispatcher delivers events to its endpoints. It is safe for concurrent
+// use.
+type Dispatcher struct {
+	Endpoints  []Endpoint
+	HTTPClient *http.Client
+
+	// MaxAttempts is how often a delivery is tried, 6 by default. The
+	// first retry waits RetryDelay, one second by default, and each later
+	// one twice as long up to MaxRetryDelay, five minutes by default. A
+	// Retry-After header of the endpoint can make the wait longer.
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// SendTimeout bounds a whole Send, retries included, so that a slow
+	// endpoint cannot hold up the caller for long. Deliveries still
+	// running then are given up. Defaults to one minute; a negative value
+	// waits for every delivery to finish.
+	SendTimeout time.Duration
+
+	// DeadLetter is a JSON-lines file that receives the deliveries given
+	// up on. They are dropped when empty.
+	DeadLetter string
+	// OnError receives every failed attempt.
+	OnError func(error)
+
+	// Now and After replace time.Now and time.After.
+	Now   func() time.Time
+	After func(time.Duration) <-chan time.Time
+
+	mu sync.Mutex // serializes dead-letter writes
+}
+
+// DeliveryError reports a failed attempt to deliver an event.
+type DeliveryError struct {
+	Endpoint string
+	EventID  string
+	Attempt  int
+	Final    bool // no more attempts will be made
+	Err      error
+}
+
+func (e *DeliveryError) Error() string {
+	msg := fmt.Sprintf("deliver event %s to %s (attempt %d): %v", e.EventID, e.Endpoint, e.Attempt, e.Err)
+	if e.Final {
+		msg += "; giving up"
+	}
+	return msg
+}
+
+func (e *DeliveryError) Unwrap() error { return e.Err }
+
+// DeadLetter is a line of the dead-letter file.
+type DeadLetter struct {
+	Time     time.Time       `json:"time"`
+	Endpoint string          `json:"endpoint"`
+	URL      string          `json:"url"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// Send delivers e to every endpoint that accepts it, in parallel, and waits
+// until each delivery succeeded or was given up, at most SendTimeout. It
+// returns the final errors, joined. Callers that must not wait at all, like
+// ForwardFlow, run it in a goroutine.
+func (d *Dispatcher) Send(ctx context.Context, e Event) error {
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Source == "" {
+		e.Source = "go-flume"
+	}
+	if e.Time.IsZero() {
+		e.Time = d.now()
+	}
+	if timeout := d.sendTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, ep := range d.Endpoints {
+		if !ep.Accepts(e) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.deliver(ctx, ep, e); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// deliver posts e to ep until it succeeds, fails permanently or runs out of
+// attempts.
+func (d *Dispatcher) deliver(ctx context.Context, ep Endpoint, e Event) error {
+	body, err := encode(ep, e)
+	if err != nil {
+		return &DeliveryError{Endpoint: ep.name(), EventID: e.ID, Final: true, Err: err}
+	}
+	delay := d.retryDelay()
+	for attempt := 1; ; attempt++ {
+		wait, err := d.post(ctx, ep, e, body)
+		if err == nil {
+			return nil
+		}
+		final := wait < 0 || attempt >= d.maxAttempts() || ctx.Err() != nil
+		derr := &DeliveryError{Endpoint: ep.name(), EventID: e.ID, Attempt: attempt, Final: final, Err: err}
+		d.report(derr)
+		if final {
+			if dlErr := d.deadLetter(ep, attempt, err, body); dlErr != nil {
+				d.report(fmt.Errorf("dead letter: %w", dlErr))
+			}
+			return derr
+		}
+		select {
+		case <-ctx.Done():
+		case <-d.after(max(delay, wait)):
+		}
+		delay = min(2*delay, d.maxRetryDelay())
+	}
+}
+
+// post makes one attempt. On failure it returns how long the endpoint asked
+// to wait, or a negative duration when retrying is pointless.
+func (d *Dispatcher) post(ctx context.Context, ep Endpoint, e Event, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	for k, v := range ep.Headers {
+		req.Header.Set(k, v)
+	}
+	if ep.CloudEvents {
+		req.Header.Set("Content-Type", "application/cloudevents+json")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "go-flume-webhook")
+	req.Header.Set(HeaderEvent, e.Type)
+	req.Header.Set(HeaderDelivery, e.ID)
+	if ep.Secret != "" {
+		ts := strconv.FormatInt(d.now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(ep.Secret, ts, body))
+	} else {
+		req.Header.Del(HeaderTimestamp)
+		req.Header.Del(HeaderSignature)
+	}
+
+	hc := d.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 300 {
+		return 0, nil
+	}
+	err = fmt.Errorf("endpoint answered %s", resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		if s, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && s > 0 {
+			return time.Duration(s) * time.Second, err
+		}
+		return 0, err
+	case resp.StatusCode == http.StatusRequestTimeout:
+		return 0, err
+	}
+	// Other client errors will not go away by retrying.
+	return -1, err
+}
+
+func (d *Dispatcher) deadLetter(ep Endpoint, attempts int, cause error, body []byte) error {
+	if d.DeadLetter == "" {
+		return nil
+	}
+	line, err := json.Marshal(DeadLetter{
+		Time: d.now(), Endpoint: ep.name(), URL: ep.URL, Attempts: attempts, Error: cause.Error(), Body: body,
+	})
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(d.DeadLetter), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(d.DeadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadDeadLetters returns the entries of a dead-letter file, so they can be
+// inspected or sent again.
+func ReadDeadLetters(path string) ([]DeadLetter, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []DeadLetter
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var dl DeadLetter
+		if err := json.Unmarshal(line, &dl); err != nil {
+			return out, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		out = append(out, dl)
+	}
+	return out, nil
+}
+
+// encode serializes e in the format of the endpoint.
+func encode(ep Endpoint, e Event) ([]byte, error) {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s data: %w", e.Type, err)
+	}
+	if ep.CloudEvents {
+		return json.Marshal(struct {
+			SpecVersion     string          `json:"specversion"`
+			ID              string          `json:"id"`
+			Source          string          `json:"source"`
+			Type            string          `json:"type"`
+			Subject         string          `json:"subject,omitempty"`
+			Time            string          `json:"time"`
+			DataContentType string          `json:"datacontenttype"`
+			Data            json.RawMessage `json:"data"`
+		}{"1.0", e.ID, e.Source, e.Type, e.Subject, e.Time.UTC().Format(time.RFC3339Nano), "application/json", data})
+	}
+	return json.Marshal(struct {
+		ID      string          `json:"id"`
+		Type    string          `json:"type"`
+		Source  string          `json:"source"`
+		Subject string          `json:"subject,omitempty"`
+		Time    time.Time       `json:"time"`
+		Data    json.RawMessage `json:"data"`
+	}{e.ID, e.Type, e.Source, e.Subject, e.Time.UTC(), data})
+}
+
+// Sign returns the signature header value of a body sent at timestamp (Unix
+// seconds).
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery on the receiving side. It
+// rejects deliveries signed more than tolerance away from now, to stop
+// replays; a zero tolerance skips that check.
+func Verify(secret string, header http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	ts := header.Get(HeaderTimestamp)
+	sig := header.Get(HeaderSignature)
+	if ts == "" || sig == "" {
+		return errors.New("webhook: missing signature")
+	}
+	if !hmac.Equal([]byte(sig), []byte(Sign(secret, ts, body))) {
+		return errors.New("webhook: bad signature")
+	}
+	if tolerance > 0 {
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return fmt.Errorf("webhook: bad timestamp %q", ts)
+		}
+		if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+			return errors.New("webhook: signature timestamp out of tolerance")
+		}
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (d *Dispatcher) report(err error) {
+	if d.OnError != nil {
+		d.OnError(err)
+	}
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts > 0 {
+		return d.MaxAttempts
+	}
+	return 6
+}
+
+func (d *Dispatcher) retryDelay() time.Duration {
+	if d.RetryDelay > 0 {
+		return d.RetryDelay
+	}
+	return time.Second
+}
+
+func (d *Dispatcher) maxRetryDelay() time.Duration {
+	if d.MaxRetryDelay > 0 {
+		return d.MaxRetryDelay
+	}
+	return 5 * time.Minute
+}
+
+func (d *Dispatcher) sendTimeout() time.Duration {
+	if d.SendTimeout != 0 {
+		return d.SendTimeout
+	}
+	return time.Minute
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+func (d *Dispatcher) after(dur time.Duration) <-chan time.Time {
+	if d.After != nil {
+		return d.After(dur)
+	}
+	return time.After(dur)
+}
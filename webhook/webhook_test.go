@@ -0,0 +1,395 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	goflume "github.com/401unauthorized/go-flume"
+)
+
+var now = time.Date(2026, 9, 15, 10, 30, 0, 0, time.UTC)
+
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records deliveries and answers them with the next status of
+// statuses, then 204.
+type receiver struct {
+	*httptest.Server
+	mu         sync.Mutex
+	deliveries []delivery
+	statuses   []int
+	retryAfter string // sent with 429 answers
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.deliveries = append(r.deliveries, delivery{req.Header.Clone(), body})
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		if status == http.StatusTooManyRequests && r.retryAfter != "" {
+			w.Header().Set("Retry-After", r.retryAfter)
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) got() []delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]delivery(nil), r.deliveries...)
+}
+
+// instant returns an After that fires at once and records the waits.
+func instant(waits *[]time.Duration) func(time.Duration) <-chan time.Time {
+	var mu sync.Mutex
+	return func(d time.Duration) <-chan time.Time {
+		mu.Lock()
+		*waits = append(*waits, d)
+		mu.Unlock()
+		ch := make(chan time.Time, 1)
+		ch <- now
+		return ch
+	}
+}
+
+func TestSendSignsAndFormats(t *testing.T) {
+	plain, ce := newReceiver(t), newReceiver(t)
+	d := &Dispatcher{
+		Endpoints: []Endpoint{
+			{URL: plain.URL, Secret: "s3cret", Headers: map[string]string{"Authorization": "Bearer x",
+				HeaderEvent: "spoofed", HeaderSignature: "sha256=00"}},
+			{URL: ce.URL, CloudEvents: true, Headers: map[string]string{HeaderSignature: "sha256=00"}},
+		},
+		Now: func() time.Time { return now },
+	}
+	n := goflume.Notification{ID: 7, DeviceID: "d1", Type: 4, Message: "High flow", CreatedDatetime: "2026-09-15T10:00:00.000Z"}
+	if err := d.Send(context.Background(), FromNotification(n)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := plain.got()
+	if len(got) != 1 {
+		t.Fatalf("plain deliveries = %d", len(got))
+	}
+	h := got[0].header
+	if err := Verify("s3cret", h, got[0].body, now.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Error(err)
+	}
+	if err := Verify("wrong", h, got[0].body, now, 0); err == nil {
+		t.Error("wrong secret verified")
+	}
+	if err := Verify("s3cret", h, got[0].body, now.Add(time.Hour), 5*time.Minute); err == nil {
+		t.Error("stale signature verified")
+	}
+	if h.Get(HeaderEvent) != NotificationReceived || h.Get(HeaderDelivery) != "notification-7" ||
+		h.Get("Content-Type") != "application/json" || h.Get("Authorization") != "Bearer x" {
+		t.Errorf("headers %v", h)
+	}
+	var body struct {
+		ID, Type, Source, Subject string
+		Time                      time.Time
+		Data                      goflume.Notification
+	}
+	if err := json.Unmarshal(got[0].body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ID != "notification-7" || body.Source != "go-flume" || body.Subject != "d1" ||
+		!body.Time.Equal(time.Date(2026, 9, 15, 10, 0, 0, 0, time.UTC)) || body.Data != n {
+		t.Errorf("body %+v", body)
+	}
+
+	got = ce.got()
+	if len(got) != 1 {
+		t.Fatalf("cloudevents deliveries = %d", len(got))
+	}
+	if got[0].header.Get(HeaderSignature) != "" {
+		t.Error("signed without a secret")
+	}
+	if ct := got[0].header.Get("Content-Type"); ct != "application/cloudevents+json" {
+		t.Errorf("content type %q", ct)
+	}
+	var ev map[string]any
+	if err := json.Unmarshal(got[0].body, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev["specversion"] != "1.0" || ev["type"] != NotificationReceived || ev["id"] != "notification-7" ||
+		ev["time"] != "2026-09-15T10:00:00Z" || ev["datacontenttype"] != "application/json" {
+		t.Errorf("cloudevent %v", ev)
+	}
+	if data, _ := ev["data"].(map[string]any); data["message"] != "High flow" {
+		t.Errorf("cloudevent data %v", ev["data"])
+	}
+}
+
+func TestSendFilters(t *testing.T) {
+	leaks, all, d1 := newReceiver(t), newReceiver(t), newReceiver(t)
+	d := &Dispatcher{Endpoints: []Endpoint{
+		{URL: leaks.URL, Types: []string{"flume.leak.*"}},
+		{URL: all.URL},
+		{URL: d1.URL, Types: []string{UsageAlertTriggered, LeakOpened}, Filter: func(e Event) bool { return e.Subject == "d1" }},
+	}}
+	end := now
+	events := []Event{
+		FromLeakIncident(goflume.LeakIncident{DeviceID: "d1", Start: now.Add(-time.Hour), Opened: now}),
+		FromLeakIncident(goflume.LeakIncident{DeviceID: "d2", Start: now.Add(-time.Hour), Opened: now, End: &end}),
+		FromUsageAlert(goflume.UsageAlert{ID: 3, DeviceID: "d2"}),
+	}
+	for _, e := range events {
+		if err := d.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	types := func(r *receiver) []string {
+		var out []string
+		for _, d := range r.got() {
+			out = append(out, d.header.Get(HeaderEvent))
+		}
+		return out
+	}
+	if got := types(leaks); len(got) != 2 || got[0] != LeakOpened || got[1] != LeakClosed {
+		t.Errorf("leak endpoint got %v", got)
+	}
+	if got := types(all); len(got) != 3 {
+		t.Errorf("catch-all endpoint got %v", got)
+	}
+	if got := types(d1); len(got) != 1 || got[0] != LeakOpened {
+		t.Errorf("d1 endpoint got %v", got)
+	}
+}
+
+func TestSendRetriesAndDeadLetters(t *testing.T) {
+	flaky := newReceiver(t, 500, 503, 200)
+	broken := newReceiver(t, 500, 500, 500, 500)
+	rejecting := newReceiver(t, 400)
+	dead := filepath.Join(t.TempDir(), "dead", "letters.jsonl")
+	var waits []time.Duration
+	var mu sync.Mutex
+	var reported []*DeliveryError
+	d := &Dispatcher{
+		Endpoints: []Endpoint{
+			{Name: "flaky", URL: flaky.URL},
+			{Name: "broken", URL: broken.URL},
+			{Name: "rejecting", URL: rejecting.URL},
+		},
+		MaxAttempts: 3,
+		RetryDelay:  time.Second,
+		DeadLetter:  dead,
+		Now:         func() time.Time { return now },
+		After:       instant(&waits),
+		OnError: func(err error) {
+			var de *DeliveryError
+			if errors.As(err, &de) {
+				mu.Lock()
+				reported = append(reported, de)
+				mu.Unlock()
+			}
+		},
+	}
+	err := d.Send(context.Background(), Event{ID: "e1", Type: DeviceOffline, Data: map[string]string{"device_id": "d1"}})
+	if err == nil {
+		t.Fatal("no error for the failed endpoints")
+	}
+	if n := len(flaky.got()); n != 3 {
+		t.Errorf("flaky endpoint tried %d times", n)
+	}
+	if n := len(broken.got()); n != 3 {
+		t.Errorf("broken endpoint tried %d times", n)
+	}
+	if n := len(rejecting.got()); n != 1 {
+		t.Errorf("rejecting endpoint tried %d times", n)
+	}
+	finals := 0
+	for _, de := range reported {
+		if de.Final {
+			finals++
+		}
+	}
+	if len(reported) != 6 || finals != 2 {
+		t.Errorf("reported %d errors, %d final", len(reported), finals)
+	}
+	if len(waits) != 4 {
+		t.Errorf("waits %v", waits)
+	}
+
+	letters, err := ReadDeadLetters(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("dead letters %+v", letters)
+	}
+	byName := map[string]DeadLetter{}
+	for _, dl := range letters {
+		byName[dl.Endpoint] = dl
+	}
+	if dl := byName["broken"]; dl.Attempts != 3 || dl.URL != broken.URL || !dl.Time.Equal(now) {
+		t.Errorf("broken dead letter %+v", dl)
+	}
+	if dl := byName["rejecting"]; dl.Attempts != 1 || dl.Error != "endpoint answered 400 Bad Request" {
+		t.Errorf("rejecting dead letter %+v", dl)
+	}
+	var body struct{ ID string }
+	if err := json.Unmarshal(byName["broken"].Body, &body); err != nil || body.ID != "e1" {
+		t.Errorf("dead letter body %s", byName["broken"].Body)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	release := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	t.Cleanup(stuck.Close)
+	t.Cleanup(func() { close(release) })
+	dead := filepath.Join(t.TempDir(), "letters.jsonl")
+	d := &Dispatcher{
+		Endpoints:   []Endpoint{{Name: "stuck", URL: stuck.URL}},
+		SendTimeout: 100 * time.Millisecond,
+		DeadLetter:  dead,
+		Now:         func() time.Time { return now },
+	}
+	start := time.Now()
+	if err := d.Send(context.Background(), Event{ID: "e1", Type: DeviceOffline}); err == nil {
+		t.Fatal("no error for the stuck endpoint")
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("Send took %s", took)
+	}
+	letters, err := ReadDeadLetters(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Endpoint != "stuck" {
+		t.Errorf("dead letters %+v", letters)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := newReceiver(t, 500, 429, 500, 500, 500)
+	r.retryAfter = "10" // longer than the backoff
+	var waits []time.Duration
+	d := &Dispatcher{
+		Endpoints:     []Endpoint{{URL: r.URL}},
+		RetryDelay:    time.Second,
+		MaxRetryDelay: 3 * time.Second,
+		After:         instant(&waits),
+	}
+	if err := d.Send(context.Background(), Event{Type: DeviceOffline}); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{time.Second, 10 * time.Second, 3 * time.Second, 3 * time.Second, 3 * time.Second}
+	if len(waits) != len(want) {
+		t.Fatalf("waits %v, want %v", waits, want)
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("waits %v, want %v", waits, want)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := &Tracker{Now: func() time.Time { return now }}
+	b := goflume.Budget{ID: 1, Name: "Monthly", Value: 1000, Thresholds: []int{50, 80, 100}, Actual: 400}
+	if ev := tr.Budgets("d1", []goflume.Budget{b}); len(ev) != 0 {
+		t.Errorf("baseline gave %v", ev)
+	}
+	b.Actual = 850
+	ev := tr.Budgets("d1", []goflume.Budget{b})
+	if len(ev) != 2 {
+		t.Fatalf("crossings %v", ev)
+	}
+	for i, th := range []int{50, 80} {
+		c := ev[i].Data.(BudgetCrossing)
+		if ev[i].Type != BudgetCrossed || c.Threshold != th || c.Percent != 85 || c.DeviceID != "d1" {
+			t.Errorf("crossing %d: %+v", i, ev[i])
+		}
+	}
+	b.Actual = 870
+	if ev := tr.Budgets("d1", []goflume.Budget{b}); len(ev) != 0 {
+		t.Errorf("no new threshold gave %v", ev)
+	}
+	b.Actual = 10 // a new month
+	if ev := tr.Budgets("d1", []goflume.Budget{b}); len(ev) != 0 {
+		t.Errorf("reset gave %v", ev)
+	}
+	b.Actual = 600
+	if ev := tr.Budgets("d1", []goflume.Budget{b}); len(ev) != 1 || ev[0].Data.(BudgetCrossing).Threshold != 50 {
+		t.Errorf("new period crossings %v", ev)
+	}
+
+	devs := []goflume.Device{{ID: "d1", Connected: true}, {ID: "d2", Connected: true}}
+	if ev := tr.Devices(devs); len(ev) != 0 {
+		t.Errorf("baseline gave %v", ev)
+	}
+	devs[1].Connected = false
+	ev = tr.Devices(devs)
+	if len(ev) != 1 || ev[0].Type != DeviceOffline || ev[0].Subject != "d2" {
+		t.Errorf("offline %v", ev)
+	}
+	devs[1].Connected = true
+	if ev := tr.Devices(devs); len(ev) != 1 || ev[0].Type != DeviceOnline {
+		t.Errorf("online %v", ev)
+	}
+}
+
+func TestForwardFlow(t *testing.T) {
+	r := newReceiver(t)
+	d := &Dispatcher{Endpoints: []Endpoint{{URL: r.URL}}}
+	leaks := &goflume.LeakDetector{Thresholds: []goflume.LeakThreshold{{GPM: 0.5, For: 30 * time.Minute}}}
+	events := make(chan goflume.FlowEvent)
+	done := make(chan error)
+	go func() { done <- d.ForwardFlow(context.Background(), events, leaks) }()
+
+	reading := func(at time.Time, gpm float64) goflume.FlowEvent {
+		return goflume.FlowEvent{Type: goflume.FlowReading, DeviceID: "d1", Time: at, Flow: goflume.Flow{Active: gpm > 0, GPM: gpm}}
+	}
+	events <- reading(now, 1)
+	events <- reading(now.Add(20*time.Minute), 1)
+	events <- reading(now.Add(40*time.Minute), 1) // opens
+	events <- goflume.FlowEvent{Type: goflume.DeviceUnreachable, DeviceID: "d1", Time: now.Add(45 * time.Minute), Err: errors.New("timeout")}
+	events <- reading(now.Add(50*time.Minute), 0) // closes
+	close(events)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]int{}
+	for _, del := range r.got() {
+		got[del.header.Get(HeaderEvent)]++
+	}
+	if len(got) != 3 || got[LeakOpened] != 1 || got[LeakClosed] != 1 || got[DeviceUnreachable] != 1 {
+		t.Errorf("delivered %v", got)
+	}
+}
+
+func TestNotificationHandler(t *testing.T) {
+	r := newReceiver(t, 400)
+	d := &Dispatcher{Endpoints: []Endpoint{{URL: r.URL}}}
+	h := d.NotificationHandler()
+	if err := h(context.Background(), goflume.Notification{ID: 1}); err == nil {
+		t.Error("rejected delivery not returned")
+	}
+	if err := h(context.Background(), goflume.Notification{ID: 2}); err != nil {
+		t.Error(err)
+	}
+}